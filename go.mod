@@ -17,7 +17,7 @@ exclude (
 
 require (
 	github.com/Azure/go-ansiterm v0.0.0-20170629204627-19f72df4d05d // indirect
-	github.com/BurntSushi/toml v0.3.1 // indirect
+	github.com/BurntSushi/toml v0.3.1
 	github.com/Microsoft/go-winio v0.4.12 // indirect
 	github.com/Microsoft/hcsshim v0.6.3 // indirect
 	github.com/Microsoft/opengcs v0.3.9 // indirect
@@ -38,13 +38,14 @@ require (
 	github.com/docker/go-connections v0.4.0
 	github.com/docker/go-events v0.0.0-20190806004212-e31b211e4f1c // indirect
 	github.com/docker/go-metrics v0.0.1 // indirect
-	github.com/docker/go-units v0.3.2-0.20170127094116-9e638d38cf69 // indirect
+	github.com/docker/go-units v0.3.2-0.20170127094116-9e638d38cf69
 	github.com/docker/libkv v0.2.1 // indirect
 	github.com/docker/libnetwork v0.8.0-dev.2.0.20170816163629-5b28c0ec9823 // indirect
 	github.com/docker/libtrust v0.0.0-20150526203908-9cbd2a1374f4 // indirect
 	github.com/docker/swarmkit v1.12.1-0.20170818183140-ddb4539f883b // indirect
 	github.com/fsnotify/fsnotify v1.4.7 // indirect
 	github.com/go-check/check v0.0.0-20190902080502-41f04d3bba15 // indirect
+	github.com/go-git/go-git/v5 v5.2.0
 	github.com/godbus/dbus v4.1.0+incompatible // indirect
 	github.com/golang/groupcache v0.0.0-20190702054246-869f871628b6 // indirect
 	github.com/google/uuid v1.1.1 // indirect
@@ -84,7 +85,8 @@ require (
 	github.com/vishvananda/netns v0.0.0-20190625233234-7109fa855b0f // indirect
 	github.com/xiang90/probing v0.0.0-20190116061207-43a291ad63a2 // indirect
 	go.etcd.io/bbolt v1.3.3 // indirect
-	golang.org/x/net v0.0.0-20190613194153-d28f0bde5980
+	golang.org/x/crypto v0.0.0-20200302210943-78000ba7a073
+	golang.org/x/net v0.0.0-20200301022130-244492dfa37a
 	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4 // indirect
 	google.golang.org/grpc v1.23.1 // indirect
 	gotest.tools v2.2.0+incompatible // indirect
@@ -76,3 +76,14 @@ const (
 	// DEPRECATED - use DestinationLabel instead.
 	DeprecatedDestinationLabel = "io.s2i.destination"
 )
+
+// Docker volume label constants
+const (
+	// CacheMountLabel is the Docker volume LABEL holding the name of the cache
+	// mount an S2I managed cache volume was created for.
+	CacheMountLabel = DefaultNamespace + "cache-mount"
+
+	// CacheMountScopeLabel is the Docker volume LABEL holding the scope of an
+	// S2I managed cache volume.
+	CacheMountScopeLabel = DefaultNamespace + "cache-mount.scope"
+)
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"sort"
 	"strings"
 	"text/tabwriter"
 
@@ -27,10 +28,22 @@ func Config(client docker.Client, config *api.Config) string {
 			describeBuilderImage(client, config, out)
 			describeRuntimeImage(config, out)
 		}
-		fmt.Fprintf(out, "Source:\t%s\n", config.Source)
+		if config.Source != nil {
+			fmt.Fprintf(out, "Source:\t%s\n", config.Source.StringNoCredentials())
+		}
 		if len(config.ContextDir) > 0 {
 			fmt.Fprintf(out, "Context Directory:\t%s\n", config.ContextDir)
 		}
+		if config.IncludeUncommitted {
+			fmt.Fprintf(out, "Include Uncommitted Changes:\t%s\n", printBool(config.IncludeUncommitted))
+		}
+		if len(config.GitSubmodules.Include) > 0 {
+			fmt.Fprintf(out, "Submodules Included:\t%s\n", strings.Join(config.GitSubmodules.Include, ","))
+		}
+		if len(config.GitSubmodules.Exclude) > 0 {
+			fmt.Fprintf(out, "Submodules Excluded:\t%s\n", strings.Join(config.GitSubmodules.Exclude, ","))
+		}
+		describeGitAuthentication(config, out)
 		fmt.Fprintf(out, "Output Image Tag:\t%s\n", config.Tag)
 		printEnv(out, config.Environment)
 		if len(config.EnvironmentFile) > 0 {
@@ -40,10 +53,29 @@ func Config(client docker.Client, config *api.Config) string {
 		fmt.Fprintf(out, "Incremental Build:\t%s\n", printBool(config.Incremental))
 		if config.Incremental {
 			fmt.Fprintf(out, "Incremental Image Pull User:\t%s\n", config.IncrementalAuthentication.Username)
+			if len(config.IncrementalCacheImage) > 0 {
+				fmt.Fprintf(out, "Incremental Cache Image:\t%s\n", config.IncrementalCacheImage)
+			}
+			if len(config.IncrementalCacheDir) > 0 {
+				fmt.Fprintf(out, "Incremental Cache:\t%s\n", config.IncrementalCacheDir)
+			}
 		}
 		fmt.Fprintf(out, "Remove Old Build:\t%s\n", printBool(config.RemovePreviousImage))
 		fmt.Fprintf(out, "Builder Pull Policy:\t%s\n", config.BuilderPullPolicy)
 		fmt.Fprintf(out, "Previous Image Pull Policy:\t%s\n", config.PreviousImagePullPolicy)
+		if config.PullParallelism > 0 {
+			fmt.Fprintf(out, "Pull Parallelism:\t%d\n", config.PullParallelism)
+		}
+		for _, rule := range config.RegistryRules {
+			fmt.Fprintf(out, "Registry Rule:\t%s\n", rule)
+		}
+		if config.DockerRetry != nil {
+			if config.DockerRetry.Backoff {
+				fmt.Fprintf(out, "Docker Retries:\t%d, starting after %s with backoff\n", config.DockerRetry.Retries, config.DockerRetry.Delay)
+			} else {
+				fmt.Fprintf(out, "Docker Retries:\t%d, %s apart\n", config.DockerRetry.Retries, config.DockerRetry.Delay)
+			}
+		}
 		fmt.Fprintf(out, "Quiet:\t%s\n", printBool(config.Quiet))
 		fmt.Fprintf(out, "Layered Build:\t%s\n", printBool(config.LayeredBuild))
 		if len(config.Destination) > 0 {
@@ -52,9 +84,33 @@ func Config(client docker.Client, config *api.Config) string {
 		if len(config.CallbackURL) > 0 {
 			fmt.Fprintf(out, "Callback URL:\t%s\n", config.CallbackURL)
 		}
+		if len(config.PostCommitCommand) > 0 {
+			fmt.Fprintf(out, "Post-commit Command:\t%s\n", config.PostCommitCommand)
+		}
+		if len(config.VerifyURL) > 0 {
+			fmt.Fprintf(out, "Verify URL:\t%s (timeout %s)\n", config.VerifyURL, config.VerifyTimeout)
+			fmt.Fprintf(out, "Remove Unverified Image:\t%t\n", config.RemoveUnverifiedImage)
+		} else if len(config.PostCommitCommand) > 0 {
+			fmt.Fprintf(out, "Remove Unverified Image:\t%t\n", config.RemoveUnverifiedImage)
+		}
+		if config.KeepFailedContainer {
+			fmt.Fprintf(out, "Keep Failed Container:\t%t\n", config.KeepFailedContainer)
+		}
+		if config.DebugShell {
+			fmt.Fprintf(out, "Debug Shell:\t%t\n", config.DebugShell)
+		}
 		if len(config.ScriptsURL) > 0 {
 			fmt.Fprintf(out, "S2I Scripts URL:\t%s\n", config.ScriptsURL)
 		}
+		if len(config.ScriptDownload.Auth) > 0 {
+			fmt.Fprintf(out, "S2I Scripts Auth:\t%s\n", config.ScriptDownload.Auth.String())
+		}
+		if len(config.ScriptDownload.CacheDir) > 0 {
+			fmt.Fprintf(out, "S2I Scripts Cache:\t%s\n", config.ScriptDownload.CacheDir)
+		}
+		if config.ScriptDownload.Offline {
+			fmt.Fprintf(out, "S2I Scripts Offline:\t%t\n", config.ScriptDownload.Offline)
+		}
 		if len(config.WorkingDir) > 0 {
 			fmt.Fprintf(out, "Workdir:\t%s\n", config.WorkingDir)
 		}
@@ -87,6 +143,103 @@ func Config(client docker.Client, config *api.Config) string {
 			}
 			fmt.Fprintf(out, "Bind mounts:\t%s\n", strings.Join(result, ","))
 		}
+		if len(config.CacheMounts) > 0 {
+			result := []string{}
+			for _, m := range config.CacheMounts {
+				result = append(result, fmt.Sprintf("%s->%s", m.Name, m.Destination))
+			}
+			fmt.Fprintf(out, "Cache mounts:\t%s\n", strings.Join(result, ","))
+			if len(config.CacheMountScope) > 0 {
+				fmt.Fprintf(out, "Cache mount scope:\t%s\n", config.CacheMountScope)
+			}
+		}
+		describeCGroupLimits(config.CGroupLimits, out)
+		if config.BuildKit {
+			fmt.Fprintf(out, "BuildKit:\t%s\n", printBool(config.BuildKit))
+		}
+		if len(config.DockerfileContext) > 0 {
+			fmt.Fprintf(out, "Dockerfile Build Context:\t%s\n", config.DockerfileContext)
+		}
+		if config.BuildDockerfile {
+			fmt.Fprintf(out, "Build Dockerfile:\t%s\n", printBool(config.BuildDockerfile))
+		}
+		return nil
+	})
+
+	if err != nil {
+		fmt.Printf("error: %v", err)
+	}
+	return out
+}
+
+// InstalledScripts returns the source each script was installed from, and why
+// the sources tried before were skipped, in nice readable, tabbed format.
+func InstalledScripts(results []api.InstallResult) string {
+	out, err := tabbedString(func(out io.Writer) error {
+		for _, r := range results {
+			if r.Error != nil {
+				fmt.Fprintf(out, "S2I Script %s:\terror: %v\n", r.Script, r.Error)
+			} else {
+				fmt.Fprintf(out, "S2I Script %s:\t%s (%s)\n", r.Script, r.URL, r.Source)
+			}
+			for _, skipped := range r.SkippedSources {
+				fmt.Fprintf(out, "\tskipped %s: %s\n", skipped.Source, skipped.Reason)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("error: %v", err)
+	}
+	return out
+}
+
+// Plan returns the BuildPlan object in nice readable, tabbed format.
+func Plan(plan *api.BuildPlan) string {
+	out, err := tabbedString(func(out io.Writer) error {
+		describeImagePlan("Builder Image", plan.BuilderImage, out)
+		if plan.RuntimeImage != nil {
+			describeImagePlan("Runtime Image", *plan.RuntimeImage, out)
+		}
+		fmt.Fprintf(out, "Strategy:\t%s\n", plan.Strategy)
+		if plan.Source != nil {
+			fmt.Fprintf(out, "Source:\t%s (%s)\n", plan.Source.URL, plan.Source.Downloader)
+			if len(plan.Source.ContextDir) > 0 {
+				fmt.Fprintf(out, "Context Directory:\t%s\n", plan.Source.ContextDir)
+			}
+		}
+		for _, script := range plan.Scripts {
+			kind := "optional"
+			if script.Required {
+				kind = "required"
+			}
+			switch {
+			case len(script.Error) > 0:
+				fmt.Fprintf(out, "Script %s (%s):\terror: %s\n", script.Name, kind, script.Error)
+			case len(script.Digest) > 0:
+				fmt.Fprintf(out, "Script %s (%s):\t%s (%s)\n", script.Name, kind, script.URL, script.Digest)
+			default:
+				fmt.Fprintf(out, "Script %s (%s):\t%s\n", script.Name, kind, script.URL)
+			}
+		}
+		if len(plan.AssembleUser) > 0 {
+			fmt.Fprintf(out, "Assemble User:\t%s\n", plan.AssembleUser)
+		}
+		if len(plan.AllowedUIDs) > 0 {
+			fmt.Fprintf(out, "Allowed UIDs:\t%s (%s)\n", plan.AllowedUIDs, plan.UserCheck)
+		}
+		fmt.Fprintf(out, "Environment:\t%s\n", strings.Join(plan.Environment, ","))
+		for _, injection := range plan.Injections {
+			truncated := "kept"
+			if injection.Truncated {
+				truncated = "truncated after assemble"
+			}
+			fmt.Fprintf(out, "Injection:\t%s->%s (%s)\n", injection.Source, injection.Destination, truncated)
+		}
+		printLabels(out, plan.Labels)
+		for _, note := range plan.Notes {
+			fmt.Fprintf(out, "Note:\t%s\n", note)
+		}
 		return nil
 	})
 
@@ -96,6 +249,21 @@ func Config(client docker.Client, config *api.Config) string {
 	return out
 }
 
+func describeImagePlan(name string, image api.ImagePlan, out io.Writer) {
+	decision := "use the local image"
+	switch {
+	case len(image.Error) > 0:
+		decision = "error: " + image.Error
+	case image.Pull && len(image.Location) > 0:
+		decision = "pull from " + image.Location
+	case image.Pull:
+		decision = "pull"
+	case !image.Present:
+		decision = "not inspected"
+	}
+	fmt.Fprintf(out, "%s:\t%s (pull policy %s, %s)\n", name, image.Name, image.PullPolicy, decision)
+}
+
 func describeBuilderImage(client docker.Client, config *api.Config, out io.Writer) {
 	c := &api.Config{
 		DockerConfig:              config.DockerConfig,
@@ -104,8 +272,9 @@ func describeBuilderImage(client docker.Client, config *api.Config, out io.Write
 		BuilderPullPolicy:         config.BuilderPullPolicy,
 		Tag:                       config.Tag,
 		IncrementalAuthentication: config.IncrementalAuthentication,
+		RegistryRules:             config.RegistryRules,
 	}
-	dkr := docker.New(client, c.PullAuthentication)
+	dkr := docker.NewForConfig(client, c.PullAuthentication, c, nil)
 	builderImage, err := docker.GetBuilderImage(dkr, c)
 	if err == nil {
 		build.GenerateConfigFromLabels(c, builderImage)
@@ -136,6 +305,64 @@ func describeRuntimeImage(config *api.Config, out io.Writer) {
 	}
 }
 
+func describeGitAuthentication(config *api.Config, out io.Writer) {
+	auth := config.GitAuthentication
+	if len(auth.SSHPrivateKey) > 0 {
+		fmt.Fprintf(out, "Git SSH Key:\t%s\n", auth.SSHPrivateKey)
+	}
+	if len(auth.SSHKnownHosts) > 0 {
+		fmt.Fprintf(out, "Git SSH Known Hosts:\t%s\n", auth.SSHKnownHosts)
+	}
+	if len(auth.SSHHostKeyPolicy) > 0 {
+		fmt.Fprintf(out, "Git SSH Host Key Policy:\t%s\n", auth.SSHHostKeyPolicy)
+	}
+	if len(auth.Username) > 0 {
+		fmt.Fprintf(out, "Git User:\t%s\n", auth.Username)
+	}
+	if len(auth.NetrcFile) > 0 {
+		fmt.Fprintf(out, "Git Netrc File:\t%s\n", auth.NetrcFile)
+	}
+	if len(auth.CABundle) > 0 {
+		fmt.Fprintf(out, "Git CA Bundle:\t%s\n", auth.CABundle)
+	}
+}
+
+func describeCGroupLimits(limits *api.CGroupLimits, out io.Writer) {
+	if limits == nil {
+		return
+	}
+	if limits.MemoryLimitBytes != 0 {
+		fmt.Fprintf(out, "Memory Limit:\t%d\n", limits.MemoryLimitBytes)
+	}
+	if limits.MemorySwap != 0 {
+		fmt.Fprintf(out, "Memory+Swap Limit:\t%d\n", limits.MemorySwap)
+	}
+	if limits.CPUShares != 0 {
+		fmt.Fprintf(out, "CPU Shares:\t%d\n", limits.CPUShares)
+	}
+	if limits.CPUQuota != 0 {
+		fmt.Fprintf(out, "CPU Quota:\t%d/%d\n", limits.CPUQuota, limits.CPUPeriod)
+	}
+	if limits.PidsLimit != 0 {
+		fmt.Fprintf(out, "Pids Limit:\t%d\n", limits.PidsLimit)
+	}
+	if len(limits.Ulimits) > 0 {
+		fmt.Fprintf(out, "Ulimits:\t%s\n", limits.Ulimits.String())
+	}
+	if len(limits.Tmpfs) > 0 {
+		fmt.Fprintf(out, "Tmpfs Mounts:\t%s\n", limits.Tmpfs.String())
+	}
+	if limits.ShmSize != 0 {
+		fmt.Fprintf(out, "Shm Size:\t%d\n", limits.ShmSize)
+	}
+	if limits.BlkioWeight != 0 {
+		fmt.Fprintf(out, "Block IO Weight:\t%d\n", limits.BlkioWeight)
+	}
+	if len(limits.Parent) > 0 {
+		fmt.Fprintf(out, "CGroup Parent:\t%s\n", limits.Parent)
+	}
+}
+
 func printEnv(out io.Writer, env api.EnvironmentList) {
 	result := []string{}
 	for _, e := range env {
@@ -149,6 +376,7 @@ func printLabels(out io.Writer, labels map[string]string) {
 	for k, v := range labels {
 		result = append(result, fmt.Sprintf("%s=%q", k, v))
 	}
+	sort.Strings(result)
 	fmt.Fprintf(out, "Labels:\t%s\n", strings.Join(result, ","))
 }
 
@@ -12,6 +12,10 @@ func RecordStageAndStepInfo(stages []StageInfo, stageName StageName, stepName St
 	// If the stage already exists  update the endTime and Duration, and append the new step.
 	for stageKey, stageVal := range stages {
 		if stageVal.Name == stageName {
+			// the stage may have been added by RecordStageResourceUsage
+			if stages[stageKey].StartTime.IsZero() {
+				stages[stageKey].StartTime = startTime
+			}
 			stages[stageKey].DurationMilliseconds = endTime.Sub(stages[stageKey].StartTime).Nanoseconds() / int64(time.Millisecond)
 			if len(stages[stageKey].Steps) == 0 {
 				stages[stageKey].Steps = make([]StepInfo, 0)
@@ -40,3 +44,22 @@ func RecordStageAndStepInfo(stages []StageInfo, stageName StageName, stepName St
 	})
 	return stages
 }
+
+// RecordStageResourceUsage adds the resource usage of a container run in the
+// stage to the stage, which is added if it doesn't exist yet.
+func RecordStageResourceUsage(stages []StageInfo, stageName StageName, usage ResourceUsage) []StageInfo {
+	for stageKey, stageVal := range stages {
+		if stageVal.Name == stageName {
+			if stages[stageKey].ResourceUsage == nil {
+				stages[stageKey].ResourceUsage = &ResourceUsage{}
+			}
+			stages[stageKey].ResourceUsage.Add(usage)
+			return stages
+		}
+	}
+	return append(stages, StageInfo{
+		Name:          stageName,
+		Steps:         make([]StepInfo, 0),
+		ResourceUsage: &usage,
+	})
+}
@@ -4,7 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -38,6 +41,9 @@ const (
 	// DefaultPreviousImagePullPolicy specifies policy for pulling the previously
 	// build Docker image when doing incremental build
 	DefaultPreviousImagePullPolicy = PullIfNotPresent
+
+	// DefaultPullParallelism is the default number of images pulled concurrently.
+	DefaultPullParallelism = 3
 )
 
 // Config contains essential fields for performing build.
@@ -102,10 +108,35 @@ type Config struct {
 	// PreserveWorkingDir describes if working directory should be left after processing.
 	PreserveWorkingDir bool
 
+	// KeepFailedContainer keeps the container which ran a failed assemble script,
+	// along with the working directory holding the sources and scripts it had.
+	KeepFailedContainer bool
+
+	// DebugShell starts an interactive shell in an image committed from the container
+	// which ran a failed assemble script, with the same environment, user and mounts.
+	DebugShell bool
+
 	// IgnoreSubmodules determines whether we will attempt to pull in submodules
 	// (via --recursive or submodule init)
 	IgnoreSubmodules bool
 
+	// GitSubmodules selects the submodules which are updated after the source
+	// repository is checked out, unless IgnoreSubmodules is set.
+	GitSubmodules git.SubmoduleConfig
+
+	// IncludeUncommitted builds the working tree of a local git repository,
+	// including uncommitted changes and untracked files which are not ignored,
+	// instead of its committed HEAD.
+	IncludeUncommitted bool
+
+	// GitAuthentication holds the credentials and transport settings used when
+	// cloning the source repository and its submodules.
+	GitAuthentication git.AuthConfig
+
+	// GitLFS selects the Git LFS objects fetched after the source repository is
+	// checked out, if it uses Git LFS.
+	GitLFS git.LFSConfig
+
 	// Source URL describing the location of sources used to build the result image.
 	Source *git.URL
 
@@ -126,6 +157,18 @@ type Config struct {
 	// artifacts. Tag is used by default if this is not set.
 	IncrementalFromTag string
 
+	// IncrementalCacheDir is a directory in which the artifacts saved by the
+	// save-artifacts script are stored, keyed by builder image and tag. When
+	// set, incremental builds restore the artifacts from this directory instead
+	// of the previous image, and refresh them from the new image after commit.
+	IncrementalCacheDir string
+
+	// IncrementalCacheImage is the image to which the builder container of an
+	// incremental build with a runtime image is committed. The image built
+	// with a runtime image doesn't contain the build artifacts, so the next
+	// build saves them from this image instead.
+	IncrementalCacheImage string
+
 	// RemovePreviousImage describes if previous image should be removed after successful build.
 	// This applies only to incremental builds.
 	RemovePreviousImage bool
@@ -143,8 +186,24 @@ type Config struct {
 	// CallbackURL is a URL which is called upon successful build to inform about that fact.
 	CallbackURL string
 
+	// PostCommitCommand is a shell command run in a container of the committed image to
+	// verify it. The build fails if the command exits with a non-zero code.
+	PostCommitCommand string
+
+	// VerifyURL is an HTTP URL polled while the committed image runs its default command,
+	// to verify it. Its host is replaced with the address the port of the URL is published
+	// on. The build fails if the URL doesn't respond with a 2xx or 3xx status in VerifyTimeout.
+	VerifyURL string
+
+	// VerifyTimeout is how long VerifyURL is polled for.
+	VerifyTimeout time.Duration
+
+	// RemoveUnverifiedImage removes the committed image if its verification failed.
+	RemoveUnverifiedImage bool
+
 	// ScriptsURL is a URL describing where to fetch the S2I scripts from during build process.
 	// This url can be a reference within the builder image if the scheme is specified as image://
+	// or within a separate image if the scheme is specified as docker://
 	ScriptsURL string
 
 	// Destination specifies a location where the untar operation will place its artifacts.
@@ -204,6 +263,10 @@ type Config struct {
 	// to use when downloading scripts
 	ScriptDownloadProxyConfig *ProxyConfig
 
+	// ScriptDownload configures the authentication, caching and retries used
+	// when downloading scripts from HTTP(S) URLs.
+	ScriptDownload ScriptDownloadConfig
+
 	// ExcludeRegExp contains a string representation of the regular expression desired for
 	// deciding which files to exclude from the tar stream
 	ExcludeRegExp string
@@ -221,6 +284,16 @@ type Config struct {
 	// build.
 	BuildVolumes []string
 
+	// CacheMounts specifies the named cache volumes mounted read-write into the
+	// container running assemble. The volumes are created and reused by S2I,
+	// and their content is never committed to the resulting image.
+	CacheMounts CacheMountList
+
+	// CacheMountScope is the scope the cache volumes are shared in, e.g. the
+	// name of a project. The volumes are shared by all builds using the same
+	// builder image if it is empty.
+	CacheMountScope string
+
 	// Labels specify labels and their values to be applied to the resulting image. Label keys
 	// must have non-zero length. The labels defined here override generated labels in case
 	// they have the same name.
@@ -238,9 +311,23 @@ type Config struct {
 	KeepSymlinks bool
 
 	// AsDockerfile indicates the path where the Dockerfile should be written instead of building
-	// a new image.
+	// a new image. With "-", the Dockerfile is written to a temporary directory and its build
+	// context to the standard output, unless DockerfileContext or BuildDockerfile is set.
 	AsDockerfile string
 
+	// DockerfileContext is the path of a tar archive the complete build context of the
+	// Dockerfile written to AsDockerfile is written to, or "-" for the standard output.
+	DockerfileContext string
+
+	// BuildDockerfile builds the Dockerfile written to AsDockerfile with the Docker daemon,
+	// producing the image Tag.
+	BuildDockerfile bool
+
+	// BuildKit makes the Dockerfile written to AsDockerfile mount the injections
+	// as BuildKit secrets and the cache mounts as BuildKit caches, instead of
+	// copying the injections into the image.
+	BuildKit bool
+
 	// ImageWorkDir is the default working directory for the builder image.
 	ImageWorkDir string
 
@@ -252,6 +339,22 @@ type Config struct {
 
 	// AssembleRuntimeUser specifies the user to run the assemble-runtime script in container
 	AssembleRuntimeUser string
+
+	// PullParallelism is the number of images, out of the builder, runtime and
+	// previous images, pulled concurrently. DefaultPullParallelism is used if it
+	// is 0.
+	PullParallelism int
+
+	// DockerRetry is the policy retrying the Docker operations failing with a
+	// transient error, e.g. a restarting daemon or a registry responding with a
+	// 5xx status. The operations are retried 6 times, 5 seconds apart, if it is
+	// nil.
+	DockerRetry *RetryPolicy
+
+	// RegistryRules rewrite the references of the builder, runtime, previous
+	// and scripts images before they are pulled, e.g. to pull from a mirror,
+	// and block or mark registries as insecure.
+	RegistryRules []RegistryRule
 }
 
 // EnvironmentSpec specifies a single environment variable.
@@ -269,6 +372,108 @@ type ProxyConfig struct {
 	HTTPSProxy *url.URL
 }
 
+// RetryPolicy configures how an operation failing with a transient error is
+// retried.
+type RetryPolicy struct {
+	// Retries is the number of times the operation is retried.
+	Retries int
+
+	// Delay is the delay before the first retry, and between the retries unless
+	// Backoff is set.
+	Delay time.Duration
+
+	// Backoff doubles the delay with each retry, up to a minute.
+	Backoff bool
+}
+
+// RegistryRule rewrites the references of the images pulled from a registry,
+// or a repository of it, modeled on the registries of registries.conf(5).
+type RegistryRule struct {
+	// Prefix is the fully qualified prefix of the references the rule applies
+	// to, e.g. docker.io or docker.io/library/ruby, or a wildcard domain, e.g.
+	// *.example.com. It defaults to Location.
+	Prefix string
+
+	// Location replaces the prefix of the references, e.g. with the one of a
+	// mirror. The references are not rewritten if it is empty.
+	Location string
+
+	// Insecure marks the registry of Location as not requiring TLS, which the
+	// Docker daemon only allows if it lists the registry as insecure too.
+	Insecure bool
+
+	// Blocked forbids pulling the images of the references.
+	Blocked bool
+}
+
+// String returns a human readable description of the rule.
+func (r RegistryRule) String() string {
+	s := r.Prefix
+	if len(r.Location) > 0 && r.Location != r.Prefix {
+		s += " -> " + r.Location
+	}
+	if r.Blocked {
+		s += " (blocked)"
+	}
+	if r.Insecure {
+		s += " (insecure)"
+	}
+	return s
+}
+
+// ScriptDownloadConfig configures how scripts are downloaded from HTTP(S) URLs.
+type ScriptDownloadConfig struct {
+	// Auth holds the credentials sent to the scripts servers, by host.
+	Auth ScriptDownloadAuthList
+
+	// CacheDir is the directory caching the downloaded scripts. The cached
+	// scripts are revalidated with the server using their ETag and
+	// Last-Modified headers. Scripts are not cached if it is empty.
+	CacheDir string
+
+	// Retries is the number of times a download failing with a network error
+	// or a 429 or 5xx status is retried.
+	Retries int
+
+	// Offline serves the scripts from CacheDir without contacting the servers.
+	Offline bool
+}
+
+// ScriptDownloadAuth holds the credentials sent to a scripts server. The
+// secrets are read from files, so they never appear in the configuration or
+// the logs.
+type ScriptDownloadAuth struct {
+	// Host is the host name, optionally followed by the port, of the server.
+	Host string
+
+	// Username is the user name used for basic authentication.
+	Username string
+
+	// PasswordFile is the path to a file with the password used for basic
+	// authentication.
+	PasswordFile string
+
+	// TokenFile is the path to a file with the token sent as bearer token.
+	TokenFile string
+}
+
+// ScriptDownloadAuthList contains the credentials of several scripts servers.
+type ScriptDownloadAuthList []ScriptDownloadAuth
+
+// CacheMount is a named cache volume mounted into the container running
+// assemble.
+type CacheMount struct {
+	// Name identifies the cache volume within its scope.
+	Name string
+
+	// Destination is the absolute path the cache volume is mounted at.
+	Destination string
+}
+
+// CacheMountList contains the cache volumes mounted into the container running
+// assemble.
+type CacheMountList []CacheMount
+
 // CGroupLimits holds limits used to constrain container resources.
 type CGroupLimits struct {
 	MemoryLimitBytes int64
@@ -277,8 +482,49 @@ type CGroupLimits struct {
 	CPUQuota         int64
 	MemorySwap       int64
 	Parent           string
+
+	// PidsLimit is the maximum number of processes in the container, or 0 for
+	// no limit.
+	PidsLimit int64
+
+	// Ulimits are the resource limits of the processes in the container.
+	Ulimits UlimitList
+
+	// Tmpfs are the tmpfs filesystems mounted into the container.
+	Tmpfs TmpfsList
+
+	// ShmSize is the size of /dev/shm in bytes. Containers get 64MB if it is 0.
+	ShmSize int64
+
+	// BlkioWeight is the relative block IO weight of the container, between 10
+	// and 1000, or 0 for the daemon default.
+	BlkioWeight uint16
 }
 
+// Ulimit is a resource limit of the processes in a container.
+type Ulimit struct {
+	// Name is the name of the limit, e.g. nofile.
+	Name string
+	// Soft is the soft limit.
+	Soft int64
+	// Hard is the hard limit.
+	Hard int64
+}
+
+// UlimitList contains the resource limits of the processes in a container.
+type UlimitList []Ulimit
+
+// TmpfsMount is a tmpfs filesystem mounted into a container.
+type TmpfsMount struct {
+	// Destination is the absolute path the filesystem is mounted at.
+	Destination string
+	// Options are the comma-separated mount options, e.g. size=64m.
+	Options string
+}
+
+// TmpfsList contains the tmpfs filesystems mounted into a container.
+type TmpfsList []TmpfsMount
+
 // VolumeSpec represents a single volume mount point.
 type VolumeSpec struct {
 	// Source is a reference to the volume source.
@@ -354,6 +600,17 @@ type Result struct {
 
 	// BuildInfo holds information about the result of a build.
 	BuildInfo BuildInfo
+
+	// SourceInfo describes the source the image was built from, including the
+	// revisions of its submodules.
+	SourceInfo *git.SourceInfo
+
+	// Scripts describes the source each script was installed from, and why the
+	// sources tried before were skipped.
+	Scripts []InstallResult
+
+	// PulledImages are the images successfully pulled by the build.
+	PulledImages []string
 }
 
 // BuildInfo contains information about the build process.
@@ -380,6 +637,49 @@ type StageInfo struct {
 
 	// Steps contains details about each build step within a build stage.
 	Steps []StepInfo
+
+	// ResourceUsage describes the resources used by the containers run in this
+	// stage, if they were sampled.
+	ResourceUsage *ResourceUsage
+}
+
+// ResourceUsage describes the resources used by the containers of a build
+// stage, as sampled from their stats while they ran.
+type ResourceUsage struct {
+	// PeakMemoryBytes is the highest memory usage of a container.
+	PeakMemoryBytes uint64
+
+	// CPUTimeNanoseconds is the CPU time used by the containers.
+	CPUTimeNanoseconds uint64
+
+	// BlockIOReadBytes is the number of bytes the containers read from block
+	// devices.
+	BlockIOReadBytes uint64
+
+	// BlockIOWriteBytes is the number of bytes the containers wrote to block
+	// devices.
+	BlockIOWriteBytes uint64
+
+	// NetworkReceivedBytes is the number of bytes the containers received over
+	// the network.
+	NetworkReceivedBytes uint64
+
+	// NetworkSentBytes is the number of bytes the containers sent over the
+	// network.
+	NetworkSentBytes uint64
+}
+
+// Add adds the usage of another container to the usage, keeping the highest
+// peak memory usage.
+func (u *ResourceUsage) Add(other ResourceUsage) {
+	if other.PeakMemoryBytes > u.PeakMemoryBytes {
+		u.PeakMemoryBytes = other.PeakMemoryBytes
+	}
+	u.CPUTimeNanoseconds += other.CPUTimeNanoseconds
+	u.BlockIOReadBytes += other.BlockIOReadBytes
+	u.BlockIOWriteBytes += other.BlockIOWriteBytes
+	u.NetworkReceivedBytes += other.NetworkReceivedBytes
+	u.NetworkSentBytes += other.NetworkSentBytes
 }
 
 // StageName is the identifier for each build stage.
@@ -393,6 +693,9 @@ const (
 	//StageAssemble runs the assemble steps.
 	StageAssemble StageName = "Assemble"
 
+	// StageAssembleRuntime runs the assemble-runtime script in the runtime image.
+	StageAssembleRuntime StageName = "AssembleRuntime"
+
 	// StageBuild builds the source.
 	StageBuild StageName = "Build"
 
@@ -401,6 +704,9 @@ const (
 
 	// StageRetrieve retrieves artifacts.
 	StageRetrieve StageName = "RetrieveArtifacts"
+
+	// StageVerify verifies the committed image.
+	StageVerify StageName = "VerifyImage"
 )
 
 // StepInfo contains details about a build step.
@@ -432,14 +738,27 @@ const (
 	// StepAssembleBuildScripts runs the assemble scripts.
 	StepAssembleBuildScripts StepName = "AssembleBuildScripts"
 
+	// StepAssembleRuntimeScript runs the assemble-runtime script.
+	StepAssembleRuntimeScript StepName = "AssembleRuntimeScript"
+
 	// StepBuildDockerImage builds the Docker image for layered builds.
 	StepBuildDockerImage StepName = "BuildDockerImage"
 
 	// StepCommitContainer commits the container to the builder image.
 	StepCommitContainer StepName = "CommitContainer"
 
+	// StepRunPostCommitCommand runs the post-commit command in the committed image.
+	StepRunPostCommitCommand StepName = "RunPostCommitCommand"
+
+	// StepVerifyURL polls the verification URL of the committed image.
+	StepVerifyURL StepName = "VerifyURL"
+
 	// StepRetrievePreviousArtifacts restores archived artifacts from the previous build.
 	StepRetrievePreviousArtifacts StepName = "RetrievePreviousArtifacts"
+
+	// StepStoreArtifactsCache stores the artifacts of the new image in the
+	// incremental artifacts cache.
+	StepStoreArtifactsCache StepName = "StoreArtifactsCache"
 )
 
 // StepFailureReason holds the type of failure that occurred during the build
@@ -465,6 +784,10 @@ type InstallResult struct {
 	// URL describes from where the script was taken
 	URL string
 
+	// Digest is the pinned SHA-256 digest, as sha256:<hex>, the downloaded
+	// script was verified against. It is empty if the script is not pinned.
+	Digest string
+
 	// Downloaded describes if download operation happened, this will be true for
 	// external scripts, but false for scripts from inside the image
 	Downloaded bool
@@ -478,6 +801,130 @@ type InstallResult struct {
 	// FailedSources is a list of sources that were attempted but failed
 	// when downloading this script
 	FailedSources []string
+
+	// Source is the name of the source the script was installed from, e.g.
+	// "source handler". It is empty if the script was not installed.
+	Source string
+
+	// SkippedSources are the sources tried before Source, or all the sources
+	// if the script was not installed, along with why they were skipped.
+	SkippedSources []SkippedScriptSource
+}
+
+// SkippedScriptSource describes why a script was not installed from a source.
+type SkippedScriptSource struct {
+	// Source is the name of the source, e.g. "script URL handler".
+	Source string
+
+	// Reason describes why the script was not installed from the source.
+	Reason string
+}
+
+// BuildPlan describes what a build would use, resolved without running any
+// container. It is printed by s2i build --dry-run, hence the JSON tags.
+type BuildPlan struct {
+	// BuilderImage is the builder image and whether it would be pulled.
+	BuilderImage ImagePlan `json:"builderImage"`
+
+	// RuntimeImage is the runtime image and whether it would be pulled, if any.
+	RuntimeImage *ImagePlan `json:"runtimeImage,omitempty"`
+
+	// Strategy is the build strategy: sti, onbuild or dockerfile.
+	Strategy string `json:"strategy"`
+
+	// Source is the application source and how it would be downloaded.
+	Source *SourcePlan `json:"source,omitempty"`
+
+	// Scripts are the required and optional scripts and where they would come from.
+	Scripts []ScriptPlan `json:"scripts"`
+
+	// AssembleUser is the user assemble would run as, empty for the user of the
+	// builder image.
+	AssembleUser string `json:"assembleUser,omitempty"`
+
+	// AllowedUIDs is the range of user IDs allowed to run the builder image.
+	AllowedUIDs string `json:"allowedUIDs,omitempty"`
+
+	// UserCheck is the result of checking the users of the builder image
+	// against AllowedUIDs.
+	UserCheck string `json:"userCheck,omitempty"`
+
+	// Environment is the environment of assemble, after merging the environment
+	// file of the sources.
+	Environment []string `json:"environment"`
+
+	// Injections are the directories injected into the assemble container.
+	Injections []InjectionPlan `json:"injections,omitempty"`
+
+	// Labels are the labels of the output image known before assemble runs.
+	Labels map[string]string `json:"labels"`
+
+	// Notes are what could not be resolved without running the build.
+	Notes []string `json:"notes,omitempty"`
+}
+
+// ImagePlan describes an image used by a build.
+type ImagePlan struct {
+	// Name is the name of the image.
+	Name string `json:"name"`
+
+	// PullPolicy is the policy deciding whether the image is pulled.
+	PullPolicy PullPolicy `json:"pullPolicy"`
+
+	// Present is true if the image is present locally.
+	Present bool `json:"present"`
+
+	// Pull is true if the image would be pulled.
+	Pull bool `json:"pull"`
+
+	// Location is the reference the image would be pulled from, if the
+	// registry rules rewrite its name.
+	Location string `json:"location,omitempty"`
+
+	// Error is why the image would not be available, if it wouldn't.
+	Error string `json:"error,omitempty"`
+}
+
+// SourcePlan describes the application source of a build.
+type SourcePlan struct {
+	// URL is the URL of the source, without credentials.
+	URL string `json:"url"`
+
+	// ContextDir is the sub-directory of the source with the application.
+	ContextDir string `json:"contextDir,omitempty"`
+
+	// Downloader is how the source would be downloaded.
+	Downloader string `json:"downloader"`
+}
+
+// ScriptPlan describes where a script of a build would come from.
+type ScriptPlan struct {
+	// Name is the name of the script.
+	Name string `json:"name"`
+
+	// Required is true if the build fails without the script.
+	Required bool `json:"required"`
+
+	// URL is where the script would be installed from.
+	URL string `json:"url,omitempty"`
+
+	// Digest is the digest the script is pinned to, if any.
+	Digest string `json:"digest,omitempty"`
+
+	// Error is why the script could not be resolved.
+	Error string `json:"error,omitempty"`
+}
+
+// InjectionPlan describes a directory injected into the assemble container.
+type InjectionPlan struct {
+	// Source is the directory on the host.
+	Source string `json:"source"`
+
+	// Destination is the directory in the container.
+	Destination string `json:"destination"`
+
+	// Truncated is true if the injected files are truncated after assemble ran.
+	Truncated bool `json:"truncated"`
 }
 
 // DockerNetworkMode specifies the network mode setting for the docker container
@@ -623,6 +1070,162 @@ func (e *EnvironmentList) Type() string {
 	return "string"
 }
 
+// Set implements the Set() function of pflags.Value interface. The value has
+// the format host=HOST,username=USER,password-file=PATH or
+// host=HOST,token-file=PATH.
+func (l *ScriptDownloadAuthList) Set(value string) error {
+	auth := ScriptDownloadAuth{}
+	for _, field := range strings.Split(value, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || len(parts[1]) == 0 {
+			return fmt.Errorf("invalid scripts authentication %q, must be host=HOST,username=USER,password-file=PATH or host=HOST,token-file=PATH", value)
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case "host":
+			auth.Host = parts[1]
+		case "username":
+			auth.Username = parts[1]
+		case "password-file":
+			auth.PasswordFile = parts[1]
+		case "token-file":
+			auth.TokenFile = parts[1]
+		default:
+			return fmt.Errorf("unknown field %q in scripts authentication %q", parts[0], value)
+		}
+	}
+	if len(auth.Host) == 0 {
+		return fmt.Errorf("missing host in scripts authentication %q", value)
+	}
+	basic := len(auth.Username) > 0 && len(auth.PasswordFile) > 0
+	token := len(auth.TokenFile) > 0 && len(auth.Username) == 0 && len(auth.PasswordFile) == 0
+	if !basic && !token {
+		return fmt.Errorf("scripts authentication %q must specify either a username and password-file or a token-file", value)
+	}
+	*l = append(*l, auth)
+	return nil
+}
+
+// String implements the String() function of pflags.Value interface.
+func (l *ScriptDownloadAuthList) String() string {
+	result := []string{}
+	for _, a := range *l {
+		result = append(result, a.Host)
+	}
+	return strings.Join(result, ",")
+}
+
+// Type implements the Type() function of pflags.Value interface.
+func (l *ScriptDownloadAuthList) Type() string {
+	return "string"
+}
+
+// cacheMountNameRegexp matches the names allowed for cache mounts, which are
+// part of the names of the Docker volumes.
+var cacheMountNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Set implements the Set() function of pflags.Value interface. The value has
+// the format name:/container/path.
+func (l *CacheMountList) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return fmt.Errorf("invalid cache mount %q, must be name:/container/path", value)
+	}
+	if !cacheMountNameRegexp.MatchString(parts[0]) {
+		return fmt.Errorf("invalid cache mount name %q, must consist of alphanumeric characters, '_', '.' or '-'", parts[0])
+	}
+	if !path.IsAbs(parts[1]) {
+		return fmt.Errorf("invalid cache mount %q, the container path must be absolute", value)
+	}
+	*l = append(*l, CacheMount{Name: parts[0], Destination: path.Clean(parts[1])})
+	return nil
+}
+
+// String implements the String() function of pflags.Value interface.
+func (l *CacheMountList) String() string {
+	result := []string{}
+	for _, m := range *l {
+		result = append(result, m.Name+":"+m.Destination)
+	}
+	return strings.Join(result, ",")
+}
+
+// Type implements the Type() function of pflags.Value interface.
+func (l *CacheMountList) Type() string {
+	return "string"
+}
+
+// Set implements the Set() function of pflags.Value interface. The value has
+// the format name=soft[:hard], where the hard limit defaults to the soft one.
+func (l *UlimitList) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return fmt.Errorf("invalid ulimit %q, must be name=soft[:hard]", value)
+	}
+	limits := strings.SplitN(parts[1], ":", 2)
+	soft, err := strconv.ParseInt(limits[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid ulimit %q: %v", value, err)
+	}
+	hard := soft
+	if len(limits) == 2 {
+		if hard, err = strconv.ParseInt(limits[1], 10, 64); err != nil {
+			return fmt.Errorf("invalid ulimit %q: %v", value, err)
+		}
+	}
+	if soft > hard {
+		return fmt.Errorf("invalid ulimit %q, the soft limit is greater than the hard limit", value)
+	}
+	*l = append(*l, Ulimit{Name: parts[0], Soft: soft, Hard: hard})
+	return nil
+}
+
+// String implements the String() function of pflags.Value interface.
+func (l *UlimitList) String() string {
+	result := []string{}
+	for _, u := range *l {
+		result = append(result, fmt.Sprintf("%s=%d:%d", u.Name, u.Soft, u.Hard))
+	}
+	return strings.Join(result, ",")
+}
+
+// Type implements the Type() function of pflags.Value interface.
+func (l *UlimitList) Type() string {
+	return "string"
+}
+
+// Set implements the Set() function of pflags.Value interface. The value has
+// the format /container/path[:options].
+func (l *TmpfsList) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if !path.IsAbs(parts[0]) {
+		return fmt.Errorf("invalid tmpfs mount %q, the container path must be absolute", value)
+	}
+	mount := TmpfsMount{Destination: path.Clean(parts[0])}
+	if len(parts) == 2 {
+		mount.Options = parts[1]
+	}
+	*l = append(*l, mount)
+	return nil
+}
+
+// String implements the String() function of pflags.Value interface.
+func (l *TmpfsList) String() string {
+	result := []string{}
+	for _, m := range *l {
+		if len(m.Options) > 0 {
+			result = append(result, m.Destination+":"+m.Options)
+		} else {
+			result = append(result, m.Destination)
+		}
+	}
+	return strings.Join(result, ",")
+}
+
+// Type implements the Type() function of pflags.Value interface.
+func (l *TmpfsList) Type() string {
+	return "string"
+}
+
 // AsBinds converts the list of volume definitions to go-dockerclient compatible
 // list of bind mounts.
 func (l *VolumeList) AsBinds() []string {
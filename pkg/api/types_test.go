@@ -82,3 +82,119 @@ func TestEnvironmentSet(t *testing.T) {
 		}
 	}
 }
+
+func TestScriptDownloadAuthSet(t *testing.T) {
+	table := map[string][]ScriptDownloadAuth{
+		"host=example.com,token-file=/token":                          {{Host: "example.com", TokenFile: "/token"}},
+		"host=example.com:8443,username=user,password-file=/password": {{Host: "example.com:8443", Username: "user", PasswordFile: "/password"}},
+		"host=example.com,username=user":                              {},
+		"host=example.com,username=user,token-file=/token":            {},
+		"token-file=/token":                                           {},
+		"host=example.com,token=secret":                               {},
+		"host=":                                                       {},
+	}
+
+	for v, expected := range table {
+		got := ScriptDownloadAuthList{}
+		err := got.Set(v)
+		if len(expected) == 0 {
+			if err == nil {
+				t.Errorf("Expected error for scripts authentication %q", v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for scripts authentication %q: %v", v, err)
+			continue
+		}
+		if !reflect.DeepEqual([]ScriptDownloadAuth(got), expected) {
+			t.Errorf("On test %s, got %#v, expected %#v", v, got, expected)
+		}
+	}
+}
+
+func TestCacheMountSet(t *testing.T) {
+	table := map[string][]CacheMount{
+		"maven:/opt/app-root/src/.m2": {{Name: "maven", Destination: "/opt/app-root/src/.m2"}},
+		"npm-cache:/root/.npm/":       {{Name: "npm-cache", Destination: "/root/.npm"}},
+		"maven":                       {},
+		"maven:relative/path":         {},
+		":/root/.m2":                  {},
+		"../escape:/root/.m2":         {},
+		"name with spaces:/root/.m2":  {},
+	}
+
+	for v, expected := range table {
+		got := CacheMountList{}
+		err := got.Set(v)
+		if len(expected) == 0 {
+			if err == nil {
+				t.Errorf("Expected error for cache mount %q", v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for cache mount %q: %v", v, err)
+			continue
+		}
+		if !reflect.DeepEqual([]CacheMount(got), expected) {
+			t.Errorf("On test %s, got %#v, expected %#v", v, got, expected)
+		}
+	}
+}
+
+func TestUlimitSet(t *testing.T) {
+	table := map[string][]Ulimit{
+		"nofile=1024:4096": {{Name: "nofile", Soft: 1024, Hard: 4096}},
+		"nproc=512":        {{Name: "nproc", Soft: 512, Hard: 512}},
+		"nofile=4096:1024": {},
+		"nofile=lots":      {},
+		"nofile":           {},
+		"=1024":            {},
+	}
+
+	for v, expected := range table {
+		got := UlimitList{}
+		err := got.Set(v)
+		if len(expected) == 0 {
+			if err == nil {
+				t.Errorf("Expected error for ulimit %q", v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for ulimit %q: %v", v, err)
+			continue
+		}
+		if !reflect.DeepEqual([]Ulimit(got), expected) {
+			t.Errorf("On test %s, got %#v, expected %#v", v, got, expected)
+		}
+	}
+}
+
+func TestTmpfsSet(t *testing.T) {
+	table := map[string][]TmpfsMount{
+		"/tmp":                     {{Destination: "/tmp"}},
+		"/run/:size=64m,mode=1777": {{Destination: "/run", Options: "size=64m,mode=1777"}},
+		"tmp:size=64m":             {},
+		"":                         {},
+	}
+
+	for v, expected := range table {
+		got := TmpfsList{}
+		err := got.Set(v)
+		if len(expected) == 0 {
+			if err == nil {
+				t.Errorf("Expected error for tmpfs mount %q", v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for tmpfs mount %q: %v", v, err)
+			continue
+		}
+		if !reflect.DeepEqual([]TmpfsMount(got), expected) {
+			t.Errorf("On test %s, got %#v, expected %#v", v, got, expected)
+		}
+	}
+}
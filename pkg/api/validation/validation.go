@@ -7,6 +7,7 @@ import (
 	"github.com/docker/distribution/reference"
 
 	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/scm/git"
 )
 
 // ValidateConfig returns a list of error from validation.
@@ -26,6 +27,9 @@ func ValidateConfig(config *api.Config) []Error {
 	if config.DockerNetworkMode != "" && !validateDockerNetworkMode(config.DockerNetworkMode) {
 		allErrs = append(allErrs, NewFieldInvalidValue("dockerNetworkMode"))
 	}
+	if !git.IsValidSSHHostKeyPolicy(config.GitAuthentication.SSHHostKeyPolicy) {
+		allErrs = append(allErrs, NewFieldInvalidValue("gitAuthentication.sshHostKeyPolicy"))
+	}
 	if config.Labels != nil {
 		for k := range config.Labels {
 			if len(k) == 0 {
@@ -33,6 +37,19 @@ func ValidateConfig(config *api.Config) []Error {
 			}
 		}
 	}
+	names := map[string]bool{}
+	destinations := map[string]bool{}
+	for _, m := range config.CacheMounts {
+		if names[m.Name] || destinations[m.Destination] {
+			allErrs = append(allErrs, NewFieldInvalidValueWithReason("cacheMounts", fmt.Sprintf("duplicate cache mount %s:%s", m.Name, m.Destination)))
+		}
+		names[m.Name] = true
+		destinations[m.Destination] = true
+	}
+	if config.CGroupLimits != nil {
+		allErrs = append(allErrs, validateCGroupLimits(config.CGroupLimits)...)
+	}
+	allErrs = append(allErrs, validateIncremental(config)...)
 	if config.Tag != "" {
 		if err := validateDockerReference(config.Tag); err != nil {
 			allErrs = append(allErrs, NewFieldInvalidValueWithReason("tag", err.Error()))
@@ -41,6 +58,45 @@ func ValidateConfig(config *api.Config) []Error {
 	return allErrs
 }
 
+// validateCGroupLimits checks that the limits are in the ranges accepted by the
+// Docker daemon.
+func validateCGroupLimits(limits *api.CGroupLimits) []Error {
+	allErrs := []Error{}
+	if limits.CPUPeriod != 0 && (limits.CPUPeriod < 1000 || limits.CPUPeriod > 1000000) {
+		allErrs = append(allErrs, NewFieldInvalidValueWithReason("cgroupLimits.cpuPeriod", "must be between 1000 and 1000000 microseconds"))
+	}
+	if limits.CPUQuota != 0 && limits.CPUQuota < 1000 {
+		allErrs = append(allErrs, NewFieldInvalidValueWithReason("cgroupLimits.cpuQuota", "must be at least 1000 microseconds"))
+	}
+	if limits.BlkioWeight != 0 && (limits.BlkioWeight < 10 || limits.BlkioWeight > 1000) {
+		allErrs = append(allErrs, NewFieldInvalidValueWithReason("cgroupLimits.blkioWeight", "must be between 10 and 1000"))
+	}
+	if limits.PidsLimit < -1 {
+		allErrs = append(allErrs, NewFieldInvalidValueWithReason("cgroupLimits.pidsLimit", "must be -1 for no limit or positive"))
+	}
+	if limits.ShmSize < 0 {
+		allErrs = append(allErrs, NewFieldInvalidValueWithReason("cgroupLimits.shmSize", "must not be negative"))
+	}
+	return allErrs
+}
+
+// validateIncremental checks that an incremental build with a runtime image
+// has a cache for its artifacts, and that the caches are used together with
+// the options they require.
+func validateIncremental(config *api.Config) []Error {
+	allErrs := []Error{}
+	if config.Incremental && len(config.RuntimeImage) > 0 && len(config.IncrementalCacheImage) == 0 && len(config.IncrementalCacheDir) == 0 {
+		allErrs = append(allErrs, NewFieldInvalidValueWithReason("incremental", "an incremental build with a runtime image requires an incremental cache image or directory"))
+	}
+	if len(config.IncrementalCacheImage) > 0 && (len(config.RuntimeImage) == 0 || len(config.IncrementalCacheDir) > 0) {
+		allErrs = append(allErrs, NewFieldInvalidValueWithReason("incrementalCacheImage", "can only be used with a runtime image and without an incremental cache directory"))
+	}
+	if len(config.IncrementalCacheDir) > 0 && (!config.Incremental || len(config.Tag) == 0) {
+		allErrs = append(allErrs, NewFieldInvalidValueWithReason("incrementalCacheDir", "requires an incremental build and a tag"))
+	}
+	return allErrs
+}
+
 // validateDockerNetworkMode checks wether the network mode conforms to the docker remote API specification (v1.19)
 // Supported values are: bridge, host, container:<name|id>, and netns:/proc/<pid>/ns/net
 func validateDockerNetworkMode(mode api.DockerNetworkMode) bool {
@@ -32,6 +32,16 @@ func TestValidation(t *testing.T) {
 			},
 			[]Error{{Type: ErrorInvalidValue, Field: "dockerNetworkMode"}},
 		},
+		{
+			&api.Config{
+				Source:            git.MustParse("git@github.com:openshift/source"),
+				BuilderImage:      "openshift/builder",
+				DockerConfig:      &api.DockerConfig{Endpoint: "/var/run/docker.socket"},
+				BuilderPullPolicy: api.DefaultBuilderPullPolicy,
+				GitAuthentication: git.AuthConfig{SSHHostKeyPolicy: "ask"},
+			},
+			[]Error{{Type: ErrorInvalidValue, Field: "gitAuthentication.sshHostKeyPolicy"}},
+		},
 		{
 			&api.Config{
 				Source:            git.MustParse("http://github.com/openshift/source"),
@@ -92,6 +102,66 @@ func TestValidation(t *testing.T) {
 			},
 			[]Error{{Type: ErrorInvalidValue, Field: "labels"}},
 		},
+		{
+			&api.Config{
+				Source:            git.MustParse("http://github.com/openshift/source"),
+				BuilderImage:      "openshift/builder",
+				DockerConfig:      &api.DockerConfig{Endpoint: "/var/run/docker.socket"},
+				BuilderPullPolicy: api.DefaultBuilderPullPolicy,
+				CGroupLimits:      &api.CGroupLimits{CPUQuota: 50000, CPUPeriod: 100000, BlkioWeight: 500, PidsLimit: -1},
+			},
+			[]Error{},
+		},
+		{
+			&api.Config{
+				Source:            git.MustParse("http://github.com/openshift/source"),
+				BuilderImage:      "openshift/builder",
+				DockerConfig:      &api.DockerConfig{Endpoint: "/var/run/docker.socket"},
+				BuilderPullPolicy: api.DefaultBuilderPullPolicy,
+				CGroupLimits:      &api.CGroupLimits{CPUQuota: 500, BlkioWeight: 5},
+			},
+			[]Error{
+				{Type: ErrorInvalidValue, Field: "cgroupLimits.cpuQuota", Reason: "must be at least 1000 microseconds"},
+				{Type: ErrorInvalidValue, Field: "cgroupLimits.blkioWeight", Reason: "must be between 10 and 1000"},
+			},
+		},
+		{
+			&api.Config{
+				Source:            git.MustParse("http://github.com/openshift/source"),
+				BuilderImage:      "openshift/builder",
+				DockerConfig:      &api.DockerConfig{Endpoint: "/var/run/docker.socket"},
+				BuilderPullPolicy: api.DefaultBuilderPullPolicy,
+				Incremental:       true,
+				RuntimeImage:      "openshift/runtime",
+			},
+			[]Error{{Type: ErrorInvalidValue, Field: "incremental", Reason: "an incremental build with a runtime image requires an incremental cache image or directory"}},
+		},
+		{
+			&api.Config{
+				Source:                git.MustParse("http://github.com/openshift/source"),
+				BuilderImage:          "openshift/builder",
+				DockerConfig:          &api.DockerConfig{Endpoint: "/var/run/docker.socket"},
+				BuilderPullPolicy:     api.DefaultBuilderPullPolicy,
+				Incremental:           true,
+				RuntimeImage:          "openshift/runtime",
+				IncrementalCacheImage: "openshift/cache",
+			},
+			[]Error{},
+		},
+		{
+			&api.Config{
+				Source:                git.MustParse("http://github.com/openshift/source"),
+				BuilderImage:          "openshift/builder",
+				DockerConfig:          &api.DockerConfig{Endpoint: "/var/run/docker.socket"},
+				BuilderPullPolicy:     api.DefaultBuilderPullPolicy,
+				IncrementalCacheImage: "openshift/cache",
+				IncrementalCacheDir:   "/var/cache/s2i",
+			},
+			[]Error{
+				{Type: ErrorInvalidValue, Field: "incrementalCacheImage", Reason: "can only be used with a runtime image and without an incremental cache directory"},
+				{Type: ErrorInvalidValue, Field: "incrementalCacheDir", Reason: "requires an incremental build and a tag"},
+			},
+		},
 	}
 	for _, test := range testCases {
 		result := ValidateConfig(test.value)
@@ -2,6 +2,7 @@ package build
 
 import (
 	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/docker"
 	"github.com/openshift/source-to-image/pkg/scm/git"
 )
 
@@ -70,4 +71,7 @@ type LayeredDockerBuilder interface {
 // alter the behavior of a strategy.
 type Overrides struct {
 	Downloader Downloader
+	// Session is shared by the Docker implementations of the build, a new one
+	// is used by each strategy if it is nil.
+	Session *docker.Session
 }
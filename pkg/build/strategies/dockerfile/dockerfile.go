@@ -1,26 +1,35 @@
 package dockerfile
 
 import (
+	"archive/tar"
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/openshift/source-to-image/pkg/api"
 	"github.com/openshift/source-to-image/pkg/api/constants"
 	"github.com/openshift/source-to-image/pkg/build"
+	"github.com/openshift/source-to-image/pkg/cache"
+	"github.com/openshift/source-to-image/pkg/docker"
 	s2ierr "github.com/openshift/source-to-image/pkg/errors"
 	"github.com/openshift/source-to-image/pkg/ignore"
 	"github.com/openshift/source-to-image/pkg/scm"
 	"github.com/openshift/source-to-image/pkg/scm/downloaders/file"
 	"github.com/openshift/source-to-image/pkg/scm/git"
 	"github.com/openshift/source-to-image/pkg/scripts"
+	s2itar "github.com/openshift/source-to-image/pkg/tar"
 	"github.com/openshift/source-to-image/pkg/util"
 	"github.com/openshift/source-to-image/pkg/util/fs"
 	utillog "github.com/openshift/source-to-image/pkg/util/log"
+	"github.com/openshift/source-to-image/pkg/util/registries"
 	utilstatus "github.com/openshift/source-to-image/pkg/util/status"
 	"github.com/openshift/source-to-image/pkg/util/user"
 )
@@ -52,25 +61,36 @@ type Dockerfile struct {
 	sourceInfo       *git.SourceInfo
 	result           *api.Result
 	ignorer          build.Ignorer
+	tar              s2itar.Tar
+	// docker is used to read the default runtime artifacts mapping from the
+	// runtime image, it is nil when no Docker client is available.
+	docker docker.Docker
 }
 
-// New creates a Dockerfile builder.
-func New(config *api.Config, fs fs.FileSystem) (*Dockerfile, error) {
+// New creates a Dockerfile builder. The client may be nil, in which case the
+// runtime artifacts of builds using a runtime image must be given explicitly,
+// and the Dockerfile cannot be built.
+func New(client docker.Client, config *api.Config, fs fs.FileSystem, overrides build.Overrides) (*Dockerfile, error) {
+	var d docker.Docker
+	if client != nil {
+		d = docker.NewForConfig(client, config.RuntimeAuthentication, config, overrides.Session)
+	}
 	return &Dockerfile{
-		fs: fs,
+		docker: d,
+		fs:     fs,
 		// where we will get the assemble/run scripts from on the host machine,
 		// if any are provided.
 		uploadScriptsDir: constants.UploadScripts,
 		uploadSrcDir:     constants.Source,
 		result:           &api.Result{},
 		ignorer:          &ignore.DockerIgnorer{},
+		tar:              s2itar.New(fs),
 	}, nil
 }
 
 // Build produces a Dockerfile that when run with the correct filesystem
 // context, will produce the application image.
 func (builder *Dockerfile) Build(config *api.Config) (*api.Result, error) {
-
 	// Handle defaulting of the configuration that is unique to the dockerfile strategy
 	if strings.HasSuffix(config.AsDockerfile, string(os.PathSeparator)) {
 		config.AsDockerfile = config.AsDockerfile + "Dockerfile"
@@ -83,6 +103,20 @@ func (builder *Dockerfile) Build(config *api.Config) (*api.Result, error) {
 		return builder.result, s2ierr.NewUserNotAllowedError(config.AssembleUser, false)
 	}
 
+	if config.AsDockerfile == "-" {
+		// only the build context is of interest, build it in a temporary directory
+		dir, err := builder.fs.CreateWorkingDirectory()
+		if err != nil {
+			builder.setFailureReason(utilstatus.ReasonFSOperationFailed, utilstatus.ReasonMessageFSOperationFailed)
+			return builder.result, err
+		}
+		defer builder.fs.RemoveDirectory(dir)
+		config.AsDockerfile = filepath.Join(dir, "Dockerfile")
+		if len(config.DockerfileContext) == 0 && !config.BuildDockerfile {
+			config.DockerfileContext = "-"
+		}
+	}
+
 	dir, _ := filepath.Split(config.AsDockerfile)
 	if len(dir) == 0 {
 		dir = "."
@@ -99,11 +133,30 @@ func (builder *Dockerfile) Build(config *api.Config) (*api.Result, error) {
 		return builder.result, err
 	}
 
+	if len(config.RuntimeImage) > 0 {
+		if err := builder.resolveRuntimeArtifacts(config); err != nil {
+			return builder.result, err
+		}
+	}
+
 	if err := builder.CreateDockerfile(config); err != nil {
 		builder.setFailureReason(utilstatus.ReasonDockerfileCreateFailed, utilstatus.ReasonMessageDockerfileCreateFailed)
 		return builder.result, err
 	}
 
+	if len(config.DockerfileContext) > 0 {
+		if err := builder.writeContext(config); err != nil {
+			builder.setFailureReason(utilstatus.ReasonGenericS2IBuildFailed, utilstatus.ReasonMessageGenericS2iBuildFailed)
+			return builder.result, err
+		}
+	}
+
+	if config.BuildDockerfile {
+		if err := builder.buildImage(config); err != nil {
+			return builder.result, err
+		}
+	}
+
 	builder.result.Success = true
 
 	return builder.result, nil
@@ -133,11 +186,60 @@ func (builder *Dockerfile) CreateDockerfile(config *api.Config) error {
 		providedScripts = scanScripts(filepath.Join(config.WorkingDir, builder.uploadScriptsDir))
 	}
 
+	log.V(4).Infof("Processing injected inputs: %#v", config.Injections)
+	config.Injections = util.FixInjectionsWithRelativePath(config.ImageWorkDir, config.Injections)
+	log.V(4).Infof("Processed injected inputs: %#v", config.Injections)
+
+	var secrets []secretMount
+	if config.BuildKit {
+		var err error
+		if secrets, err = builder.secretMounts(config.Injections); err != nil {
+			return err
+		}
+		buffer.WriteString("# syntax=docker/dockerfile:1\n")
+		for i, secret := range secrets {
+			if i == 0 {
+				buffer.WriteString("# Build with: ")
+			} else {
+				buffer.WriteString(" \\\n#             ")
+			}
+			buffer.WriteString(fmt.Sprintf("--secret id=%s,src=%s", secret.id, sanitize(filepath.ToSlash(secret.source))))
+		}
+		if len(secrets) > 0 {
+			buffer.WriteString("\n")
+		}
+	} else if len(config.CacheMounts) > 0 {
+		log.Warning("Cache mounts are only supported in Dockerfiles with --buildkit, ignoring them")
+	}
+
+	if len(config.RuntimeImage) > 0 && len(config.RuntimeArtifacts) == 0 {
+		return errors.New("no runtime artifacts to copy were specified")
+	}
+
+	// the stages are based on the images the registry rules rewrite, which the
+	// other strategies pull
+	builderImage, err := fromImage(config, config.BuilderImage)
+	if err != nil {
+		return err
+	}
+	runtimeImage, err := fromImage(config, config.RuntimeImage)
+	if err != nil {
+		return err
+	}
+
 	if config.Incremental {
 		imageTag := util.FirstNonEmpty(config.IncrementalFromTag, config.Tag)
+		if len(config.RuntimeImage) > 0 {
+			// the application image is based on the runtime image, which
+			// cannot save the artifacts of the builder image
+			imageTag = config.IncrementalCacheImage
+		}
 		if len(imageTag) == 0 {
 			return errors.New("Image tag is missing for incremental build")
 		}
+		if imageTag, err = fromImage(config, imageTag); err != nil {
+			return err
+		}
 		// Incremental builds run via a multistage Dockerfile
 		buffer.WriteString(fmt.Sprintf("FROM %s as cached\n", imageTag))
 		var artifactsScript string
@@ -161,27 +263,20 @@ func (builder *Dockerfile) CreateDockerfile(config *api.Config) error {
 		buffer.WriteString(fmt.Sprintf("RUN if [ -s %[1]s ]; then %[1]s > %[2]s; else touch %[2]s; fi\n", artifactsScript, artifactsTar))
 	}
 
-	// main stage of the Dockerfile
-	buffer.WriteString(fmt.Sprintf("FROM %s\n", config.BuilderImage))
-
-	imageLabels := util.GenerateOutputImageLabels(builder.sourceInfo, config)
+	imageLabels := util.GenerateOutputImageLabels(builder.sourceInfo, builder.result.Scripts, config)
 	for k, v := range config.Labels {
 		imageLabels[k] = v
 	}
-	if len(imageLabels) > 0 {
-		first := true
-		buffer.WriteString("LABEL ")
-		for k, v := range imageLabels {
-			if !first {
-				buffer.WriteString(fmt.Sprintf(" \\\n      "))
-			}
-			buffer.WriteString(fmt.Sprintf("%q=%q", k, v))
-			first = false
-		}
-		buffer.WriteString("\n")
-	}
-
 	env := createBuildEnvironment(config.WorkingDir, config.Environment)
+
+	// main stage of the Dockerfile, or the builder stage when the application
+	// image is based on a runtime image
+	if len(config.RuntimeImage) > 0 {
+		buffer.WriteString(fmt.Sprintf("FROM %s as builder\n", builderImage))
+	} else {
+		buffer.WriteString(fmt.Sprintf("FROM %s\n", builderImage))
+		writeLabels(&buffer, imageLabels)
+	}
 	buffer.WriteString(fmt.Sprintf("%s", env))
 
 	// run as root to COPY and chown source content
@@ -210,15 +305,17 @@ func (builder *Dockerfile) CreateDockerfile(config *api.Config) error {
 	buffer.WriteString(fmt.Sprintf("COPY %s %s\n", sanitize(filepath.ToSlash(builder.uploadSrcDir)), sourceDest))
 	chownList = append(chownList, sourceDest)
 
-	// add injections
-	log.V(4).Infof("Processing injected inputs: %#v", config.Injections)
-	config.Injections = util.FixInjectionsWithRelativePath(config.ImageWorkDir, config.Injections)
-	log.V(4).Infof("Processed injected inputs: %#v", config.Injections)
-
-	if len(config.Injections) > 0 {
+	// add injections, the BuildKit secrets are mounted when running assemble
+	copiedInjections := api.VolumeList{}
+	for _, injection := range config.Injections {
+		if !config.BuildKit || injection.Keep {
+			copiedInjections = append(copiedInjections, injection)
+		}
+	}
+	if len(copiedInjections) > 0 {
 		buffer.WriteString("# Copying in injected content\n")
 	}
-	for _, injection := range config.Injections {
+	for _, injection := range copiedInjections {
 		src := sanitize(filepath.ToSlash(filepath.Join(constants.Injections, injection.Source)))
 		dest := sanitize(filepath.ToSlash(injection.Destination))
 		buffer.WriteString(fmt.Sprintf("COPY %s %s\n", src, dest))
@@ -246,15 +343,19 @@ func (builder *Dockerfile) CreateDockerfile(config *api.Config) error {
 		buffer.WriteString(fmt.Sprintf("    rm %s\n", artifactsTar))
 	}
 
+	run := "RUN "
+	if config.BuildKit {
+		run += createMounts(config, secrets, imageUser)
+	}
 	if _, provided := providedScripts[constants.Assemble]; provided {
-		buffer.WriteString(fmt.Sprintf("RUN %s\n", sanitize(filepath.ToSlash(filepath.Join(scriptsDestDir, "assemble")))))
+		buffer.WriteString(fmt.Sprintf("%s%s\n", run, sanitize(filepath.ToSlash(filepath.Join(scriptsDestDir, "assemble")))))
 	} else {
 		buffer.WriteString(fmt.Sprintf("# Assemble script sourced from builder image based on user input or image metadata.\n"))
 		buffer.WriteString(fmt.Sprintf("# If this file does not exist in the image, the build will fail.\n"))
-		buffer.WriteString(fmt.Sprintf("RUN %s\n", sanitize(filepath.ToSlash(filepath.Join(imageScriptsDir, "assemble")))))
+		buffer.WriteString(fmt.Sprintf("%s%s\n", run, sanitize(filepath.ToSlash(filepath.Join(imageScriptsDir, "assemble")))))
 	}
 
-	filesToDelete, err := util.ListFilesToTruncate(builder.fs, config.Injections)
+	filesToDelete, err := util.ListFilesToTruncate(builder.fs, copiedInjections)
 	if err != nil {
 		return err
 	}
@@ -273,7 +374,9 @@ func (builder *Dockerfile) CreateDockerfile(config *api.Config) error {
 		buffer.WriteString("\n")
 	}
 
-	if _, provided := providedScripts[constants.Run]; provided {
+	if len(config.RuntimeImage) > 0 {
+		builder.writeRuntimeStage(&buffer, config, runtimeImage, providedScripts, imageLabels, env)
+	} else if _, provided := providedScripts[constants.Run]; provided {
 		buffer.WriteString(fmt.Sprintf("CMD %s\n", sanitize(filepath.ToSlash(filepath.Join(scriptsDestDir, "run")))))
 	} else {
 		buffer.WriteString(fmt.Sprintf("# Run script sourced from builder image based on user input or image metadata.\n"))
@@ -288,6 +391,283 @@ func (builder *Dockerfile) CreateDockerfile(config *api.Config) error {
 	return nil
 }
 
+// secretMount is an injected file mounted as a BuildKit secret.
+type secretMount struct {
+	id     string
+	source string
+	target string
+}
+
+// secretMounts returns the BuildKit secrets of the files of the injections
+// which are not kept in the image.
+func (builder *Dockerfile) secretMounts(injections api.VolumeList) ([]secretMount, error) {
+	secrets := []secretMount{}
+	for _, injection := range injections {
+		if injection.Keep {
+			continue
+		}
+		targets, err := util.ListFiles(builder.fs, injection)
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range targets {
+			rel := strings.TrimPrefix(target, filepath.ToSlash(injection.Destination))
+			secrets = append(secrets, secretMount{
+				id:     fmt.Sprintf("s2i-injection-%d", len(secrets)+1),
+				source: filepath.Join(injection.Source, filepath.FromSlash(rel)),
+				target: target,
+			})
+		}
+	}
+	return secrets, nil
+}
+
+// createMounts returns the BuildKit mount options of the RUN instruction of the
+// assemble script, for the secrets and the cache mounts.
+func createMounts(config *api.Config, secrets []secretMount, imageUser string) string {
+	mounts := []string{}
+	for _, secret := range secrets {
+		mounts = append(mounts, fmt.Sprintf("--mount=type=secret,id=%s,target=%s%s", secret.id, sanitize(secret.target), mountOwner(imageUser, "0444")))
+	}
+	scope := util.FirstNonEmpty(config.CacheMountScope, config.BuilderImage)
+	for _, m := range config.CacheMounts {
+		mounts = append(mounts, fmt.Sprintf("--mount=type=cache,id=%s,target=%s,sharing=locked%s", cache.VolumeName(scope, m.Name), sanitize(m.Destination), mountOwner(imageUser, "0777")))
+	}
+	var result string
+	for _, mount := range mounts {
+		result += mount + " \\\n    "
+	}
+	return result
+}
+
+// mountOwner returns the options giving the user access to a BuildKit mount,
+// which is owned by root: the mount is owned by numeric users, and gets the
+// mode for named ones.
+func mountOwner(imageUser, mode string) string {
+	uid := strings.SplitN(imageUser, ":", 2)[0]
+	if len(uid) == 0 || uid == "root" || uid == "0" {
+		return ""
+	}
+	if _, err := strconv.Atoi(uid); err == nil {
+		return ",uid=" + uid
+	}
+	return ",mode=" + mode
+}
+
+// writeRuntimeStage writes the stage of the Dockerfile which copies the runtime
+// artifacts from the builder stage into the runtime image, and runs the
+// assemble-runtime script.
+func (builder *Dockerfile) writeRuntimeStage(buffer *bytes.Buffer, config *api.Config, runtimeImage string, providedScripts map[string]bool, imageLabels map[string]string, env string) {
+	buffer.WriteString(fmt.Sprintf("FROM %s\n", runtimeImage))
+	writeLabels(buffer, imageLabels)
+	buffer.WriteString(fmt.Sprintf("%s", env))
+
+	// relative destinations are resolved against the working directory of the
+	// runtime image, like the artifacts uploaded by the s2i build
+	buffer.WriteString("# Copying in runtime artifacts from the builder stage\n")
+	for _, artifact := range config.RuntimeArtifacts {
+		src := sanitize(filepath.ToSlash(artifact.Source))
+		dest := sanitize(path.Join(filepath.ToSlash(artifact.Destination), path.Base(src)))
+		buffer.WriteString(fmt.Sprintf("COPY --from=builder %s %s\n", src, dest))
+	}
+
+	runtimeScriptsDir := defaultScriptsDir
+	if strings.HasPrefix(config.ScriptsURL, "image://") {
+		runtimeScriptsDir = strings.TrimPrefix(config.ScriptsURL, "image://")
+	}
+	scriptsDest := "scripts"
+	for _, script := range []string{constants.AssembleRuntime, constants.Run} {
+		if _, provided := providedScripts[script]; provided {
+			uploadScript := sanitize(filepath.ToSlash(filepath.Join(builder.uploadScriptsDir, script)))
+			buffer.WriteString(fmt.Sprintf("COPY %s %s/%s\n", uploadScript, scriptsDest, script))
+		}
+	}
+
+	if len(config.AssembleRuntimeUser) > 0 {
+		buffer.WriteString(fmt.Sprintf("USER %s\n", sanitize(config.AssembleRuntimeUser)))
+	}
+	if _, provided := providedScripts[constants.AssembleRuntime]; provided {
+		buffer.WriteString(fmt.Sprintf("RUN %s/%s\n", scriptsDest, constants.AssembleRuntime))
+	} else {
+		buffer.WriteString(fmt.Sprintf("# Assemble-runtime script sourced from runtime image based on user input or the default location.\n"))
+		buffer.WriteString(fmt.Sprintf("# If this file does not exist in the image, the build will fail.\n"))
+		buffer.WriteString(fmt.Sprintf("RUN %s\n", sanitize(path.Join(filepath.ToSlash(runtimeScriptsDir), constants.AssembleRuntime))))
+	}
+
+	if _, provided := providedScripts[constants.Run]; provided {
+		buffer.WriteString(fmt.Sprintf("CMD %s/%s\n", scriptsDest, constants.Run))
+	} else {
+		buffer.WriteString(fmt.Sprintf("# Run script sourced from runtime image based on user input or the default location.\n"))
+		buffer.WriteString(fmt.Sprintf("# If this file does not exist in the image, the build will fail.\n"))
+		buffer.WriteString(fmt.Sprintf("CMD %s\n", sanitize(path.Join(filepath.ToSlash(runtimeScriptsDir), constants.Run))))
+	}
+}
+
+// resolveRuntimeArtifacts reads the runtime artifacts mapping from the
+// assemble-input-files label of the runtime image if none was specified, and
+// validates it.
+func (builder *Dockerfile) resolveRuntimeArtifacts(config *api.Config) error {
+	if len(config.RuntimeArtifacts) == 0 {
+		if builder.docker == nil {
+			builder.setFailureReason(utilstatus.ReasonGenericS2IBuildFailed, utilstatus.ReasonMessageGenericS2iBuildFailed)
+			return errors.New("no runtime artifacts to copy were specified")
+		}
+		if err := docker.GetRuntimeImage(builder.docker, config); err != nil {
+			builder.setFailureReason(utilstatus.ReasonPullRuntimeImageFailed, utilstatus.ReasonMessagePullRuntimeImageFailed)
+			return fmt.Errorf("could not read the runtime artifacts mapping of image %q, specify the artifacts with --runtime-artifact: %v", config.RuntimeImage, err)
+		}
+		mapping, err := builder.docker.GetAssembleInputFiles(config.RuntimeImage)
+		if err != nil {
+			builder.setFailureReason(utilstatus.ReasonInvalidArtifactsMapping, utilstatus.ReasonMessageInvalidArtifactsMapping)
+			return err
+		}
+		if len(mapping) == 0 {
+			builder.setFailureReason(utilstatus.ReasonGenericS2IBuildFailed, utilstatus.ReasonMessageGenericS2iBuildFailed)
+			return errors.New("no runtime artifacts to copy were specified")
+		}
+		for _, value := range strings.Split(mapping, ";") {
+			if err := config.RuntimeArtifacts.Set(value); err != nil {
+				builder.setFailureReason(utilstatus.ReasonGenericS2IBuildFailed, utilstatus.ReasonMessageGenericS2iBuildFailed)
+				return fmt.Errorf("could not parse %q label with value %q on image %q: %v",
+					constants.AssembleInputFilesLabel, mapping, config.RuntimeImage, err)
+			}
+		}
+	}
+
+	for _, volumeSpec := range config.RuntimeArtifacts {
+		var volumeErr error
+		switch {
+		case !path.IsAbs(filepath.ToSlash(volumeSpec.Source)):
+			volumeErr = fmt.Errorf("invalid runtime artifacts mapping: %q -> %q: source must be an absolute path", volumeSpec.Source, volumeSpec.Destination)
+		case path.IsAbs(volumeSpec.Destination):
+			volumeErr = fmt.Errorf("invalid runtime artifacts mapping: %q -> %q: destination must be a relative path", volumeSpec.Source, volumeSpec.Destination)
+		case strings.HasPrefix(volumeSpec.Destination, ".."):
+			volumeErr = fmt.Errorf("invalid runtime artifacts mapping: %q -> %q: destination cannot start with '..'", volumeSpec.Source, volumeSpec.Destination)
+		default:
+			continue
+		}
+		builder.setFailureReason(utilstatus.ReasonInvalidArtifactsMapping, utilstatus.ReasonMessageInvalidArtifactsMapping)
+		return volumeErr
+	}
+	return nil
+}
+
+// writeContext writes the build context of the Dockerfile, the Dockerfile and
+// the upload directory it copies files from, as a tar archive to the
+// DockerfileContext file or to the standard output.
+func (builder *Dockerfile) writeContext(config *api.Config) error {
+	var w io.Writer = os.Stdout
+	if config.DockerfileContext != "-" {
+		f, err := os.Create(config.DockerfileContext)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := builder.createContext(config, w); err != nil {
+		return fmt.Errorf("could not write the build context: %v", err)
+	}
+	log.V(2).Infof("Wrote the build context to %s", config.DockerfileContext)
+	return nil
+}
+
+// createContext writes the build context of the Dockerfile as a tar stream to
+// w. The Dockerfile is always named Dockerfile in the context, which is what
+// docker build expects by default.
+func (builder *Dockerfile) createContext(config *api.Config, w io.Writer) error {
+	tarWriter := tar.NewWriter(w)
+	dockerfile, err := ioutil.ReadFile(config.AsDockerfile)
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Name:    "Dockerfile",
+		Mode:    0644,
+		Size:    int64(len(dockerfile)),
+		ModTime: time.Now(),
+		Format:  tar.FormatPAX,
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tarWriter.Write(dockerfile); err != nil {
+		return err
+	}
+	uploadDir := filepath.Join(config.WorkingDir, "upload")
+	if err := builder.tar.CreateTarStreamToTarWriter(uploadDir, true, tarWriter, nil); err != nil {
+		return err
+	}
+	return tarWriter.Close()
+}
+
+// outputFor returns the writer of the output of the build, e.g. of git and
+// docker build. It is the standard error when the build context is written to
+// the standard output, to keep the context clean.
+func outputFor(config *api.Config) io.Writer {
+	if config.DockerfileContext == "-" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// buildImage builds the Dockerfile with the Docker daemon and records the ID of
+// the resulting image.
+func (builder *Dockerfile) buildImage(config *api.Config) error {
+	if builder.docker == nil {
+		builder.setFailureReason(utilstatus.ReasonGenericS2IBuildFailed, utilstatus.ReasonMessageGenericS2iBuildFailed)
+		return errors.New("building the Dockerfile requires a Docker client")
+	}
+	if len(config.Tag) == 0 {
+		builder.setFailureReason(utilstatus.ReasonGenericS2IBuildFailed, utilstatus.ReasonMessageGenericS2iBuildFailed)
+		return errors.New("building the Dockerfile requires a tag")
+	}
+
+	contextReader, contextWriter := io.Pipe()
+	go func() {
+		contextWriter.CloseWithError(builder.createContext(config, contextWriter))
+	}()
+	defer contextReader.Close()
+
+	outReader, outWriter := io.Pipe()
+	copied := make(chan struct{})
+	go func() {
+		_, err := io.Copy(outputFor(config), outReader)
+		outReader.CloseWithError(err)
+		close(copied)
+	}()
+	defer func() {
+		// BuildImage closes its output only once the daemon responded
+		outWriter.Close()
+		<-copied
+	}()
+
+	opts := docker.BuildImageOptions{
+		Name:         config.Tag,
+		Stdin:        contextReader,
+		Stdout:       outWriter,
+		CGroupLimits: config.CGroupLimits,
+	}
+
+	log.V(2).Infof("Building the Dockerfile %s", config.AsDockerfile)
+	startTime := time.Now()
+	err := builder.docker.BuildImage(opts)
+	builder.result.BuildInfo.Stages = api.RecordStageAndStepInfo(builder.result.BuildInfo.Stages, api.StageBuild, api.StepBuildDockerImage, startTime, time.Now())
+	if err != nil {
+		builder.setFailureReason(utilstatus.ReasonDockerImageBuildFailed, utilstatus.ReasonMessageDockerImageBuildFailed)
+		return err
+	}
+
+	imageID, err := builder.docker.GetImageID(config.Tag)
+	if err != nil {
+		builder.setFailureReason(utilstatus.ReasonGenericS2IBuildFailed, utilstatus.ReasonMessageGenericS2iBuildFailed)
+		return err
+	}
+	builder.result.ImageID = imageID
+	log.V(3).Infof("Successfully built %s", config.Tag)
+	return nil
+}
+
 // Prepare prepares the source code and tar for build.
 // NOTE: this func serves both the sti and onbuild strategies, as the OnBuild
 // struct Build func leverages the STI struct Prepare func directly below.
@@ -314,11 +694,11 @@ func (builder *Dockerfile) Prepare(config *api.Config) error {
 	// Default - install scripts specified by image metadata.
 	// Typically this will point to an image:// URL, and no scripts are downloaded.
 	// However, this is not guaranteed.
-	builder.installScripts(config.ImageScriptsURL, config)
+	imageScripts := builder.installScripts(config.ImageScriptsURL, config)
 
 	// Fetch sources, since their .s2i/bin might contain s2i scripts which override defaults.
 	if config.Source != nil {
-		downloader, err := scm.DownloaderForSource(builder.fs, config.Source, config.ForceCopy)
+		downloader, err := scm.DownloaderForSource(builder.fs, config.Source, config.ForceCopy, config.IncludeUncommitted, &config.GitAuthentication, outputFor(config))
 		if err != nil {
 			builder.setFailureReason(utilstatus.ReasonFetchSourceFailed, utilstatus.ReasonMessageFetchSourceFailed)
 			return err
@@ -334,11 +714,13 @@ func (builder *Dockerfile) Prepare(config *api.Config) error {
 		if config.SourceInfo != nil {
 			builder.sourceInfo = config.SourceInfo
 		}
+		builder.result.SourceInfo = builder.sourceInfo
 	}
 
 	// Install scripts provided by user, overriding all others.
 	// This _could_ be an image:// URL, which would override any scripts above.
 	urlScripts := builder.installScripts(config.ScriptsURL, config)
+	builder.result.Scripts = installedScripts(imageScripts, urlScripts)
 	// If a ScriptsURL was specified, but no scripts were downloaded from it, throw an error
 	if len(config.ScriptsURL) > 0 {
 		failedCount := 0
@@ -358,6 +740,10 @@ func (builder *Dockerfile) Prepare(config *api.Config) error {
 
 	// Stage any injection(secrets) content into the working dir so the dockerfile can reference it.
 	for i, injection := range config.Injections {
+		if config.BuildKit && !injection.Keep {
+			// mounted as BuildKit secrets, they never enter the build context
+			continue
+		}
 		// strip the C: from windows paths because it's not valid in the middle of a path
 		// like upload/injections/C:/tempdir/injection1
 		trimmedSrc := strings.TrimPrefix(injection.Source, filepath.VolumeName(injection.Source))
@@ -386,6 +772,7 @@ func (builder *Dockerfile) installScripts(scriptsURL string, config *api.Config)
 		"",
 		scriptsURL,
 		config.ScriptDownloadProxyConfig,
+		&config.ScriptDownload,
 		nil,
 		api.AuthConfig{},
 		builder.fs,
@@ -393,7 +780,58 @@ func (builder *Dockerfile) installScripts(scriptsURL string, config *api.Config)
 
 	// all scripts are optional, we trust the image contains scripts if we don't find them
 	// in the source repo.
-	return scriptInstaller.InstallOptional(append(scripts.RequiredScripts, scripts.OptionalScripts...), config.WorkingDir)
+	names := append(append([]string{}, scripts.RequiredScripts...), scripts.OptionalScripts...)
+	if len(config.RuntimeImage) > 0 {
+		names = append(names, constants.AssembleRuntime)
+	}
+	return scriptInstaller.InstallOptional(names, config.WorkingDir)
+}
+
+// installedScripts returns the source each script was installed from, given
+// the results of installing the scripts from the image scripts URL, which are
+// overridden by the results of installing them from the sources or the scripts
+// URL.
+func installedScripts(imageScripts, urlScripts []api.InstallResult) []api.InstallResult {
+	results := make([]api.InstallResult, len(urlScripts))
+	for i, r := range urlScripts {
+		results[i] = r
+		if r.Error == nil || i >= len(imageScripts) || imageScripts[i].Error != nil {
+			continue
+		}
+		// the sources tried last were skipped first, some of them by both
+		results[i] = imageScripts[i]
+		results[i].SkippedSources = append([]api.SkippedScriptSource{}, r.SkippedSources...)
+		for _, skipped := range imageScripts[i].SkippedSources {
+			if !includesSkippedSource(results[i].SkippedSources, skipped) {
+				results[i].SkippedSources = append(results[i].SkippedSources, skipped)
+			}
+		}
+	}
+	return results
+}
+
+// includesSkippedSource returns whether the source was skipped for the same
+// reason.
+func includesSkippedSource(sources []api.SkippedScriptSource, source api.SkippedScriptSource) bool {
+	for _, s := range sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// fromImage returns the reference a stage of the Dockerfile is based on, which
+// the registry rules rewrite, or an error if they block its registry.
+func fromImage(config *api.Config, name string) (string, error) {
+	if len(name) == 0 {
+		return "", nil
+	}
+	location, rule := registries.Resolve(config.RegistryRules, name)
+	if rule != nil && rule.Blocked {
+		return "", fmt.Errorf("the registry %s of the image %s is blocked", rule.Prefix, name)
+	}
+	return location, nil
 }
 
 // setFailureReason sets the builder's failure reason with the given reason and message.
@@ -433,28 +871,33 @@ func scanScripts(name string) map[string]bool {
 		return scriptsMap
 	}
 
-	assembleProvided := false
-	runProvided := false
-	saveArtifactsProvided := false
 	for _, f := range items {
 		log.V(2).Infof("found override script file %s", f.Name())
-		if f.Name() == constants.Run {
-			runProvided = true
-			scriptsMap[constants.Run] = true
-		} else if f.Name() == constants.Assemble {
-			assembleProvided = true
-			scriptsMap[constants.Assemble] = true
-		} else if f.Name() == constants.SaveArtifacts {
-			saveArtifactsProvided = true
-			scriptsMap[constants.SaveArtifacts] = true
-		}
-		if runProvided && assembleProvided && saveArtifactsProvided {
-			break
+		switch f.Name() {
+		case constants.Run, constants.Assemble, constants.SaveArtifacts, constants.AssembleRuntime:
+			scriptsMap[f.Name()] = true
 		}
 	}
 	return scriptsMap
 }
 
+// writeLabels writes a LABEL instruction setting the labels, if any.
+func writeLabels(buffer *bytes.Buffer, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	first := true
+	buffer.WriteString("LABEL ")
+	for k, v := range labels {
+		if !first {
+			buffer.WriteString(fmt.Sprintf(" \\\n      "))
+		}
+		buffer.WriteString(fmt.Sprintf("%q=%q", k, v))
+		first = false
+	}
+	buffer.WriteString("\n")
+}
+
 func includes(arr []string, str string) bool {
 	for _, s := range arr {
 		if s == str {
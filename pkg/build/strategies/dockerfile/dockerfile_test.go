@@ -1,14 +1,21 @@
 package dockerfile
 
 import (
+	"archive/tar"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/openshift/source-to-image/pkg/api"
 	"github.com/openshift/source-to-image/pkg/api/constants"
+	"github.com/openshift/source-to-image/pkg/build"
+	"github.com/openshift/source-to-image/pkg/cache"
+	"github.com/openshift/source-to-image/pkg/docker"
 	"github.com/openshift/source-to-image/pkg/util/fs"
 )
 
@@ -183,7 +190,7 @@ func TestInstallScripts(t *testing.T) {
 				}
 				tc.url = fmt.Sprintf("file://%s", filepath.ToSlash(tempDir))
 			}
-			builder, _ := New(config, fileSystem)
+			builder, _ := New(nil, config, fileSystem, build.Overrides{})
 			results := builder.installScripts(tc.url, config)
 			for _, script := range results {
 				expectErr := tc.scriptErrs[script.Script]
@@ -198,6 +205,350 @@ func TestInstallScripts(t *testing.T) {
 	}
 }
 
+func TestInstalledScripts(t *testing.T) {
+	skippedURL := api.SkippedScriptSource{Source: "script URL handler", Reason: "not found"}
+	skippedSource := api.SkippedScriptSource{Source: "source handler", Reason: "not found at <source-dir>/.s2i/bin/run"}
+	imageScripts := []api.InstallResult{
+		{Script: constants.Assemble, URL: "image:///usr/libexec/s2i/assemble", Source: "image URL handler"},
+		{Script: constants.Run, URL: "image:///usr/libexec/s2i/run", Source: "image URL handler", SkippedSources: []api.SkippedScriptSource{skippedSource}},
+		{Script: constants.SaveArtifacts, Error: os.ErrNotExist},
+	}
+	urlScripts := []api.InstallResult{
+		{Script: constants.Assemble, URL: "<source-dir>/.s2i/bin/assemble", Source: "source handler"},
+		{Script: constants.Run, Error: os.ErrNotExist, SkippedSources: []api.SkippedScriptSource{skippedURL, skippedSource}},
+		{Script: constants.SaveArtifacts, Error: os.ErrNotExist},
+	}
+	expected := []api.InstallResult{
+		urlScripts[0],
+		{Script: constants.Run, URL: "image:///usr/libexec/s2i/run", Source: "image URL handler", SkippedSources: []api.SkippedScriptSource{skippedURL, skippedSource}},
+		urlScripts[2],
+	}
+	if got := installedScripts(imageScripts, urlScripts); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected installed scripts %#v, got %#v", expected, got)
+	}
+}
+
+func TestCreateDockerfileRuntimeImage(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "s2i-dockerfile-runtime")
+	if err != nil {
+		t.Fatalf("failed to create working dir: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+	fileSystem := fs.NewFileSystem()
+	for _, v := range workingDirs {
+		if err := fileSystem.MkdirAllWithPermissions(filepath.Join(workDir, v), 0755); err != nil {
+			t.Fatalf("failed to create working dir: %v", err)
+		}
+	}
+	if err := createTestScript(filepath.Join(workDir, constants.UploadScripts), constants.AssembleRuntime); err != nil {
+		t.Fatalf("failed to write %s script: %v", constants.AssembleRuntime, err)
+	}
+
+	config := &api.Config{
+		BuilderImage:        "builder-image",
+		RuntimeImage:        "runtime-image",
+		RuntimeArtifacts:    api.VolumeList{{Source: "/opt/app-root/app.jar", Destination: "."}, {Source: "/opt/app-root/lib", Destination: "deps"}},
+		AssembleUser:        "1001",
+		AssembleRuntimeUser: "1002",
+		Labels:              map[string]string{"app": "test"},
+		WorkingDir:          workDir,
+		AsDockerfile:        filepath.Join(workDir, "Dockerfile"),
+	}
+	builder, _ := New(nil, config, fileSystem, build.Overrides{})
+	if err := builder.CreateDockerfile(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := ioutil.ReadFile(config.AsDockerfile)
+	if err != nil {
+		t.Fatalf("unable to read the Dockerfile: %v", err)
+	}
+	dockerfile := string(data)
+
+	stages := strings.Split(dockerfile, "FROM runtime-image\n")
+	if len(stages) != 2 {
+		t.Fatalf("expected a runtime stage, got:\n%s", dockerfile)
+	}
+	for _, s := range []string{"FROM builder-image as builder\n", "RUN /usr/libexec/s2i/assemble\n"} {
+		if !strings.Contains(stages[0], s) {
+			t.Errorf("expected the builder stage to contain %q, got:\n%s", s, stages[0])
+		}
+	}
+	if strings.Contains(stages[0], "LABEL") || strings.Contains(stages[0], "CMD") {
+		t.Errorf("expected the builder stage not to set labels or the command, got:\n%s", stages[0])
+	}
+	for _, s := range []string{
+		"\"app\"=\"test\"",
+		"COPY --from=builder /opt/app-root/app.jar app.jar\n",
+		"COPY --from=builder /opt/app-root/lib deps/lib\n",
+		"COPY upload/scripts/assemble-runtime scripts/assemble-runtime\n",
+		"USER 1002\nRUN scripts/assemble-runtime\n",
+		"CMD /usr/libexec/s2i/run\n",
+	} {
+		if !strings.Contains(stages[1], s) {
+			t.Errorf("expected the runtime stage to contain %q, got:\n%s", s, stages[1])
+		}
+	}
+}
+
+func TestCreateDockerfileRegistryRules(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "s2i-dockerfile-registries")
+	if err != nil {
+		t.Fatalf("failed to create working dir: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+	fileSystem := fs.NewFileSystem()
+	for _, v := range workingDirs {
+		if err := fileSystem.MkdirAllWithPermissions(filepath.Join(workDir, v), 0755); err != nil {
+			t.Fatalf("failed to create working dir: %v", err)
+		}
+	}
+
+	config := &api.Config{
+		BuilderImage:     "builder-image",
+		RuntimeImage:     "quay.io/runtime-image@sha256:0123456789012345678901234567890123456789012345678901234567890123",
+		RuntimeArtifacts: api.VolumeList{{Source: "/opt/app-root/app.jar", Destination: "."}},
+		AssembleUser:     "1001",
+		WorkingDir:       workDir,
+		AsDockerfile:     filepath.Join(workDir, "Dockerfile"),
+		RegistryRules: []api.RegistryRule{
+			{Prefix: "docker.io", Location: "mirror.example.com/dockerhub"},
+			{Prefix: "quay.io", Location: "mirror.example.com/quay"},
+		},
+	}
+	builder, _ := New(nil, config, fileSystem, build.Overrides{})
+	if err := builder.CreateDockerfile(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := ioutil.ReadFile(config.AsDockerfile)
+	if err != nil {
+		t.Fatalf("unable to read the Dockerfile: %v", err)
+	}
+	for _, s := range []string{
+		"FROM mirror.example.com/dockerhub/library/builder-image:latest as builder\n",
+		"FROM mirror.example.com/quay/runtime-image@sha256:0123456789012345678901234567890123456789012345678901234567890123\n",
+	} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("expected the Dockerfile to contain %q, got:\n%s", s, data)
+		}
+	}
+
+	config.RegistryRules = []api.RegistryRule{{Prefix: "quay.io", Blocked: true}}
+	if err := builder.CreateDockerfile(config); err == nil {
+		t.Errorf("expected the blocked runtime image to fail")
+	}
+}
+
+func TestCreateDockerfileBuildKit(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "s2i-dockerfile-buildkit")
+	if err != nil {
+		t.Fatalf("failed to create working dir: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+	secretsDir := filepath.Join(workDir, "secrets")
+	if err := os.MkdirAll(filepath.Join(secretsDir, "nested"), 0700); err != nil {
+		t.Fatalf("failed to create secrets dir: %v", err)
+	}
+	for _, name := range []string{"token", "nested/ca.crt"} {
+		if err := ioutil.WriteFile(filepath.Join(secretsDir, name), []byte("secret"), 0600); err != nil {
+			t.Fatalf("failed to write secret: %v", err)
+		}
+	}
+
+	config := &api.Config{
+		BuilderImage: "builder-image",
+		AssembleUser: "1001",
+		BuildKit:     true,
+		Injections: api.VolumeList{
+			{Source: secretsDir, Destination: "/etc/secrets"},
+			{Source: secretsDir, Destination: "/etc/kept", Keep: true},
+		},
+		CacheMounts:  api.CacheMountList{{Name: "maven", Destination: "/opt/app-root/src/.m2"}},
+		WorkingDir:   workDir,
+		AsDockerfile: filepath.Join(workDir, "Dockerfile"),
+	}
+	builder, _ := New(nil, config, fs.NewFileSystem(), build.Overrides{})
+	if err := builder.CreateDockerfile(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := ioutil.ReadFile(config.AsDockerfile)
+	if err != nil {
+		t.Fatalf("unable to read the Dockerfile: %v", err)
+	}
+	dockerfile := string(data)
+
+	for _, s := range []string{
+		"# syntax=docker/dockerfile:1\n# Build with: --secret id=s2i-injection-1,src=" + filepath.ToSlash(filepath.Join(secretsDir, "nested", "ca.crt")) + " \\\n" +
+			"#             --secret id=s2i-injection-2,src=" + filepath.ToSlash(filepath.Join(secretsDir, "token")) + "\n",
+		"COPY upload/injections" + filepath.ToSlash(secretsDir) + " /etc/kept\n",
+		"RUN --mount=type=secret,id=s2i-injection-1,target=/etc/secrets/nested/ca.crt,uid=1001 \\\n" +
+			"    --mount=type=secret,id=s2i-injection-2,target=/etc/secrets/token,uid=1001 \\\n" +
+			"    --mount=type=cache,id=" + cache.VolumeName("builder-image", "maven") + ",target=/opt/app-root/src/.m2,sharing=locked,uid=1001 \\\n" +
+			"    /usr/libexec/s2i/assemble\n",
+	} {
+		if !strings.Contains(dockerfile, s) {
+			t.Errorf("expected the Dockerfile to contain %q, got:\n%s", s, dockerfile)
+		}
+	}
+	for _, s := range []string{"/etc/secrets\n", "RUN rm"} {
+		if strings.Contains(dockerfile, s) {
+			t.Errorf("expected the Dockerfile not to contain %q, got:\n%s", s, dockerfile)
+		}
+	}
+}
+
+func TestDockerfileContext(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "s2i-dockerfile-context")
+	if err != nil {
+		t.Fatalf("failed to create working dir: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+	fileSystem := fs.NewFileSystem()
+	for _, v := range workingDirs {
+		if err := fileSystem.MkdirAllWithPermissions(filepath.Join(workDir, v), 0755); err != nil {
+			t.Fatalf("failed to create working dir: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(workDir, constants.Source, "app.js"), []byte("app"), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	config := &api.Config{
+		BuilderImage:      "builder-image",
+		AssembleUser:      "1001",
+		Tag:               "app",
+		WorkingDir:        workDir,
+		AsDockerfile:      filepath.Join(workDir, "MyDockerfile"),
+		DockerfileContext: filepath.Join(workDir, "context.tar"),
+		BuildDockerfile:   true,
+	}
+	builder, _ := New(nil, config, fileSystem, build.Overrides{})
+	fakeDocker := &docker.FakeDocker{GetImageIDResult: "image-id"}
+	builder.docker = fakeDocker
+	if err := builder.CreateDockerfile(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := builder.writeContext(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f, err := os.Open(config.DockerfileContext)
+	if err != nil {
+		t.Fatalf("unable to open the build context: %v", err)
+	}
+	defer f.Close()
+	names := map[string]bool{}
+	tarReader := tar.NewReader(f)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unable to read the build context: %v", err)
+		}
+		names[header.Name] = true
+	}
+	for _, name := range []string{"Dockerfile", "upload/src/app.js", "upload/scripts"} {
+		if !names[name] {
+			t.Errorf("expected the build context to contain %s, got %v", name, names)
+		}
+	}
+	if names["MyDockerfile"] || names["context.tar"] {
+		t.Errorf("expected the build context to only contain the Dockerfile and the upload directory, got %v", names)
+	}
+
+	if err := builder.buildImage(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fakeDocker.BuildImageOpts.Name != "app" || fakeDocker.GetImageIDImage != "app" {
+		t.Errorf("expected the image app to be built, got %q", fakeDocker.BuildImageOpts.Name)
+	}
+	if builder.result.ImageID != "image-id" {
+		t.Errorf("expected the result to hold the image ID, got %q", builder.result.ImageID)
+	}
+}
+
+func TestMountOwner(t *testing.T) {
+	tests := map[string]string{
+		"":          "",
+		"root":      "",
+		"0":         "",
+		"1001":      ",uid=1001",
+		"1001:0":    ",uid=1001",
+		"default":   ",mode=0444",
+		"default:0": ",mode=0444",
+	}
+	for user, expected := range tests {
+		if owner := mountOwner(user, "0444"); owner != expected {
+			t.Errorf("expected the mount options of user %q to be %q, got %q", user, expected, owner)
+		}
+	}
+}
+
+func TestResolveRuntimeArtifacts(t *testing.T) {
+	tests := []struct {
+		name      string
+		artifacts api.VolumeList
+		label     string
+		docker    bool
+		expected  api.VolumeList
+		expectErr bool
+	}{
+		{
+			name:      "explicit mapping",
+			artifacts: api.VolumeList{{Source: "/tmp/app", Destination: "bin"}},
+			expected:  api.VolumeList{{Source: "/tmp/app", Destination: "bin"}},
+		},
+		{
+			name:     "mapping from label",
+			label:    "/tmp/app.war:app;/opt/data",
+			docker:   true,
+			expected: api.VolumeList{{Source: "/tmp/app.war", Destination: "app"}, {Source: "/opt/data", Destination: "."}},
+		},
+		{
+			name:      "no docker",
+			expectErr: true,
+		},
+		{
+			name:      "no label",
+			docker:    true,
+			expectErr: true,
+		},
+		{
+			name:      "absolute destination",
+			artifacts: api.VolumeList{{Source: "/tmp/app", Destination: "/bin"}},
+			expectErr: true,
+		},
+		{
+			name:      "relative source",
+			artifacts: api.VolumeList{{Source: "tmp/app", Destination: "bin"}},
+			expectErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &api.Config{RuntimeImage: "runtime-image", RuntimeImagePullPolicy: api.PullIfNotPresent, RuntimeArtifacts: tc.artifacts}
+			builder, _ := New(nil, config, fs.NewFileSystem(), build.Overrides{})
+			if tc.docker {
+				builder.docker = &docker.FakeDocker{AssembleInputFilesResult: tc.label}
+			}
+			err := builder.resolveRuntimeArtifacts(config)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(config.RuntimeArtifacts, tc.expected) {
+				t.Errorf("expected runtime artifacts %#v, got %#v", tc.expected, config.RuntimeArtifacts)
+			}
+		})
+	}
+}
+
 func createTestScript(dir string, name string) error {
 	script := "echo \"test script\""
 	path := filepath.Join(dir, name)
@@ -48,7 +48,7 @@ func New(client docker.Client, config *api.Config, fs fs.FileSystem, scripts bui
 		return nil, err
 	}
 
-	d := docker.New(client, config.PullAuthentication)
+	d := docker.NewForConfig(client, config.PullAuthentication, config, overrides.Session)
 	tarHandler := tar.New(fs)
 	tarHandler.SetExclusionPattern(excludePattern)
 
@@ -132,7 +132,6 @@ func (builder *Layered) CreateDockerfile(config *api.Config) error {
 // success/failure details.
 func (builder *Layered) Build(config *api.Config) (*api.Result, error) {
 	buildResult := &api.Result{}
-
 	if config.HasOnBuild && config.BlockOnBuild {
 		buildResult.BuildInfo.FailureReason = utilstatus.NewFailureReason(
 			utilstatus.ReasonOnBuildForbidden,
@@ -210,7 +209,10 @@ func (builder *Layered) Build(config *api.Config) (*api.Result, error) {
 	err = builder.scripts.Execute(constants.Assemble, config.AssembleUser, builder.config)
 	buildResult.BuildInfo.Stages = api.RecordStageAndStepInfo(buildResult.BuildInfo.Stages, api.StageAssemble, api.StepAssembleBuildScripts, startTime, time.Now())
 	if err != nil {
-		buildResult.BuildInfo.FailureReason = utilstatus.NewFailureReason(
+		buildResult.BuildInfo.FailureReason = utilstatus.NewScriptFailureReason(
+			constants.Assemble,
+			err,
+			builder.config.CGroupLimits,
 			utilstatus.ReasonAssembleFailed,
 			utilstatus.ReasonMessageAssembleFailed,
 		)
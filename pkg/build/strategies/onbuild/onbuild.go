@@ -31,6 +31,8 @@ type OnBuild struct {
 	tar     tar.Tar
 	source  build.SourceHandler
 	garbage build.Cleaner
+	// installer installs the scripts while preparing the sources
+	installer *sti.STI
 }
 
 type onBuildSourceHandler struct {
@@ -41,7 +43,10 @@ type onBuildSourceHandler struct {
 
 // New returns a new instance of OnBuild builder
 func New(client docker.Client, config *api.Config, fs fs.FileSystem, overrides build.Overrides) (*OnBuild, error) {
-	dockerHandler := docker.New(client, config.PullAuthentication)
+	if overrides.Session == nil {
+		overrides.Session = docker.NewSession()
+	}
+	dockerHandler := docker.NewForConfig(client, config.PullAuthentication, config, overrides.Session)
 	builder := &OnBuild{
 		docker: dockerHandler,
 		git:    git.New(fs, cmd.NewCommandRunner()),
@@ -57,12 +62,13 @@ func New(client docker.Client, config *api.Config, fs fs.FileSystem, overrides b
 
 	downloader := overrides.Downloader
 	if downloader == nil {
-		downloader, err = scm.DownloaderForSource(builder.fs, config.Source, config.ForceCopy)
+		downloader, err = scm.DownloaderForSource(builder.fs, config.Source, config.ForceCopy, config.IncludeUncommitted, &config.GitAuthentication, nil)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	builder.installer = s
 	builder.source = onBuildSourceHandler{
 		Downloader: downloader,
 		Preparer:   s,
@@ -76,7 +82,6 @@ func New(client docker.Client, config *api.Config, fs fs.FileSystem, overrides b
 // Build executes the ONBUILD kind of build
 func (builder *OnBuild) Build(config *api.Config) (*api.Result, error) {
 	buildResult := &api.Result{}
-
 	if config.BlockOnBuild {
 		buildResult.BuildInfo.FailureReason = utilstatus.NewFailureReason(
 			utilstatus.ReasonOnBuildForbidden,
@@ -141,11 +146,13 @@ func (builder *OnBuild) Build(config *api.Config) (*api.Result, error) {
 		}
 	}
 
-	return &api.Result{
-		Success:    true,
-		WorkingDir: config.WorkingDir,
-		ImageID:    imageID,
-	}, nil
+	buildResult.Success = true
+	buildResult.WorkingDir = config.WorkingDir
+	buildResult.ImageID = imageID
+	if builder.installer != nil {
+		buildResult.Scripts = builder.installer.InstalledScripts()
+	}
+	return buildResult, nil
 }
 
 // CreateDockerfile creates the ONBUILD Dockerfile
@@ -0,0 +1,278 @@
+package strategies
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/api/constants"
+	"github.com/openshift/source-to-image/pkg/build"
+	"github.com/openshift/source-to-image/pkg/build/strategies/sti"
+	"github.com/openshift/source-to-image/pkg/docker"
+	s2ierr "github.com/openshift/source-to-image/pkg/errors"
+	"github.com/openshift/source-to-image/pkg/scm"
+	"github.com/openshift/source-to-image/pkg/scm/downloaders/empty"
+	"github.com/openshift/source-to-image/pkg/scm/downloaders/file"
+	gitdownloader "github.com/openshift/source-to-image/pkg/scm/downloaders/git"
+	"github.com/openshift/source-to-image/pkg/scm/git"
+	"github.com/openshift/source-to-image/pkg/scripts"
+	"github.com/openshift/source-to-image/pkg/util"
+	"github.com/openshift/source-to-image/pkg/util/fs"
+	utillog "github.com/openshift/source-to-image/pkg/util/log"
+	"github.com/openshift/source-to-image/pkg/util/registries"
+	"github.com/openshift/source-to-image/pkg/util/user"
+)
+
+var log = utillog.StderrLog
+
+// Plan resolves what a build of the provided config would use, without running
+// any container or pulling any image. The sources are downloaded to a temporary
+// working directory, since their .s2i directory might contain scripts, the
+// environment and labels.
+func Plan(client docker.Client, config *api.Config) (*api.BuildPlan, error) {
+	return plan(docker.New(client, config.PullAuthentication), fs.NewFileSystem(), config)
+}
+
+func plan(dkr docker.Docker, fileSystem fs.FileSystem, config *api.Config) (*api.BuildPlan, error) {
+	// the downloaders and the scripts modify the config
+	cfg := *config
+	cfg.Injections = append(api.VolumeList{}, config.Injections...)
+
+	p := &api.BuildPlan{
+		Scripts:     []api.ScriptPlan{},
+		Environment: []string{},
+		Labels:      map[string]string{},
+	}
+
+	if len(cfg.AsDockerfile) > 0 {
+		// the images are only used by the build of the generated Dockerfile
+		p.BuilderImage = api.ImagePlan{Name: cfg.BuilderImage, PullPolicy: cfg.BuilderPullPolicy}
+		if len(cfg.RuntimeImage) > 0 {
+			p.RuntimeImage = &api.ImagePlan{Name: cfg.RuntimeImage, PullPolicy: cfg.RuntimeImagePullPolicy}
+		}
+	} else {
+		p.BuilderImage = planImage(dkr, cfg.BuilderImage, cfg.BuilderPullPolicy, cfg.RegistryRules)
+		if len(cfg.RuntimeImage) > 0 {
+			runtimeImage := planImage(dkr, cfg.RuntimeImage, cfg.RuntimeImagePullPolicy, cfg.RegistryRules)
+			p.RuntimeImage = &runtimeImage
+		}
+	}
+
+	// the metadata of an image which is not present could only be read after
+	// pulling it
+	present := p.BuilderImage.Present
+	if !present && len(cfg.AsDockerfile) == 0 {
+		p.Notes = append(p.Notes, fmt.Sprintf("The builder image %s is not present locally, the scripts, labels and users defined by its metadata are not resolved", cfg.BuilderImage))
+	}
+
+	onBuild := present && dkr.IsImageOnBuild(cfg.BuilderImage)
+	p.Strategy = selectStrategy(&cfg, onBuild)
+	switch p.Strategy {
+	case strategyDockerfile:
+		p.Notes = append(p.Notes, "The images are only pulled by the build of the generated Dockerfile")
+	case strategySTI:
+		p.Notes = append(p.Notes, "The layered strategy is used instead if the builder image has no sh or tar, which is only detected by running assemble")
+	}
+
+	switch {
+	case len(cfg.AssembleUser) > 0:
+	case p.Strategy == strategyDockerfile:
+		cfg.AssembleUser = "1001"
+	case present:
+		assembleUser, err := docker.GetAssembleUser(dkr, &cfg)
+		if err != nil {
+			return nil, err
+		}
+		cfg.AssembleUser = assembleUser
+	}
+	p.AssembleUser = cfg.AssembleUser
+
+	if !cfg.AllowedUIDs.Empty() {
+		p.AllowedUIDs = cfg.AllowedUIDs.String()
+		switch {
+		case p.Strategy == strategyDockerfile:
+			// the Dockerfile strategy only checks the assemble user
+			if !user.IsUserAllowed(cfg.AssembleUser, &cfg.AllowedUIDs) {
+				p.UserCheck = s2ierr.NewUserNotAllowedError(cfg.AssembleUser, false).Error()
+			} else {
+				p.UserCheck = "allowed"
+			}
+		case !present:
+			p.UserCheck = "not checked, the builder image is not present locally"
+		default:
+			if err := docker.CheckAllowedUser(dkr, cfg.BuilderImage, cfg.AllowedUIDs, onBuild, cfg.AssembleUser); err != nil {
+				p.UserCheck = err.Error()
+			} else {
+				p.UserCheck = "allowed"
+			}
+		}
+	}
+
+	workingDir, err := fileSystem.CreateWorkingDirectory()
+	if err != nil {
+		return nil, err
+	}
+	cfg.WorkingDir = workingDir
+	if cfg.PreserveWorkingDir {
+		log.V(0).Infof("Temporary directory %q will be saved, not deleted", workingDir)
+	} else {
+		defer fileSystem.RemoveDirectory(workingDir)
+	}
+	for _, dir := range []string{constants.UploadScripts, constants.Source} {
+		if err := fileSystem.MkdirAllWithPermissions(filepath.Join(workingDir, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	var sourceInfo *git.SourceInfo
+	if cfg.Source != nil {
+		// the plan is printed to the standard output
+		downloader, err := scm.DownloaderForSource(fileSystem, cfg.Source, cfg.ForceCopy, cfg.IncludeUncommitted, &cfg.GitAuthentication, os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		p.Source = &api.SourcePlan{
+			URL:        cfg.Source.StringNoCredentials(),
+			ContextDir: cfg.ContextDir,
+			Downloader: downloaderName(downloader),
+		}
+		if sourceInfo, err = downloader.Download(&cfg); err != nil {
+			return nil, err
+		}
+		if cfg.SourceInfo != nil {
+			sourceInfo = cfg.SourceInfo
+		}
+	}
+
+	p.Scripts = planScripts(dkr, fileSystem, &cfg, p)
+
+	p.Environment = append(p.Environment, sti.CreateBuildEnvironment(workingDir, cfg.Environment)...)
+
+	for _, injection := range cfg.Injections {
+		p.Injections = append(p.Injections, api.InjectionPlan{
+			Source:      injection.Source,
+			Destination: injection.Destination,
+			Truncated:   !injection.Keep,
+		})
+	}
+
+	if present {
+		imageLabels, err := dkr.GetLabels(cfg.BuilderImage)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range imageLabels {
+			p.Labels[k] = v
+		}
+	}
+	for k, v := range util.GenerateOutputImageLabels(sourceInfo, nil, &cfg) {
+		p.Labels[k] = v
+	}
+	for k, v := range cfg.Labels {
+		p.Labels[k] = v
+	}
+	p.Notes = append(p.Notes, "The labels written by assemble to /tmp/.s2i/image_metadata.json are only known after it ran")
+
+	return p, nil
+}
+
+// planImage returns whether, and from where according to the registry rules,
+// the image would be pulled according to the policy.
+func planImage(dkr docker.Docker, name string, policy api.PullPolicy, rules []api.RegistryRule) api.ImagePlan {
+	image := api.ImagePlan{Name: name, PullPolicy: policy}
+	location, rule := registries.Resolve(rules, name)
+	if location != name {
+		image.Location = location
+	}
+	present, err := dkr.IsImageInLocalRegistry(name)
+	if err != nil {
+		image.Error = err.Error()
+		return image
+	}
+	image.Present = present
+	switch policy {
+	case api.PullAlways:
+		image.Pull = true
+	case api.PullNever:
+		if !present {
+			image.Error = fmt.Sprintf("the image is not present locally and the pull policy is %s", policy)
+		}
+	default:
+		image.Pull = !present
+	}
+	if image.Pull && rule != nil && rule.Blocked {
+		image.Error = fmt.Sprintf("the registry %s is blocked", rule.Prefix)
+	}
+	return image
+}
+
+// planScripts returns where the scripts of the strategy of the plan would come
+// from.
+func planScripts(dkr docker.Docker, fileSystem fs.FileSystem, cfg *api.Config, p *api.BuildPlan) []api.ScriptPlan {
+	// the scripts URL of an image is read from its metadata, which is only
+	// available if the image is present
+	imageDocker := func(image api.ImagePlan) docker.Docker {
+		if !image.Present {
+			return nil
+		}
+		return dkr
+	}
+	newInstaller := func(image, scriptsURL string, d docker.Docker) scripts.Installer {
+		return scripts.NewInstaller(image, scriptsURL, cfg.ScriptDownloadProxyConfig, &cfg.ScriptDownload, d, cfg.PullAuthentication, fileSystem)
+	}
+
+	plans := []api.ScriptPlan{}
+	add := func(results []api.InstallResult, required bool) {
+		for _, r := range results {
+			s := api.ScriptPlan{Name: r.Script, Required: required, URL: r.URL, Digest: r.Digest}
+			if r.Error != nil {
+				s.Error = r.Error.Error()
+			}
+			plans = append(plans, s)
+		}
+	}
+
+	switch p.Strategy {
+	case strategyDockerfile:
+		// the scripts URL overrides the sources, which override the scripts of
+		// the image
+		names := append(append([]string{}, scripts.RequiredScripts...), scripts.OptionalScripts...)
+		if len(cfg.RuntimeImage) > 0 {
+			names = append(names, constants.AssembleRuntime)
+		}
+		results := newInstaller("", cfg.ScriptsURL, nil).Resolve(names, cfg.WorkingDir)
+		for i, r := range results {
+			if r.Error != nil && len(cfg.ImageScriptsURL) > 0 {
+				results[i] = newInstaller("", cfg.ImageScriptsURL, nil).Resolve([]string{r.Script}, cfg.WorkingDir)[0]
+			}
+		}
+		add(results, false)
+	case strategyOnBuild:
+		add(newInstaller(cfg.BuilderImage, cfg.ScriptsURL, imageDocker(p.BuilderImage)).Resolve([]string{constants.Assemble, constants.Run}, cfg.WorkingDir), false)
+	default:
+		installer := newInstaller(cfg.BuilderImage, cfg.ScriptsURL, imageDocker(p.BuilderImage))
+		add(installer.Resolve(scripts.RequiredScripts, cfg.WorkingDir), true)
+		add(installer.Resolve(scripts.OptionalScripts, cfg.WorkingDir), false)
+		if p.RuntimeImage != nil {
+			runtimeInstaller := newInstaller(cfg.RuntimeImage, cfg.ScriptsURL, imageDocker(*p.RuntimeImage))
+			add(runtimeInstaller.Resolve([]string{constants.AssembleRuntime}, cfg.WorkingDir), false)
+		}
+	}
+	return plans
+}
+
+// downloaderName describes how a downloader downloads the sources.
+func downloaderName(downloader build.Downloader) string {
+	switch downloader.(type) {
+	case *gitdownloader.Clone:
+		return "git clone"
+	case *gitdownloader.WorkingTree:
+		return "git working tree"
+	case *file.File:
+		return "file copy"
+	case *empty.Noop:
+		return "none"
+	}
+	return fmt.Sprintf("%T", downloader)
+}
@@ -0,0 +1,139 @@
+package strategies
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/docker"
+	"github.com/openshift/source-to-image/pkg/scm/git"
+	"github.com/openshift/source-to-image/pkg/util/fs"
+)
+
+func TestPlan(t *testing.T) {
+	source, err := ioutil.TempDir("", "s2i-plan-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(source)
+	files := map[string]string{
+		".s2i/bin/assemble":   "#!/bin/sh\n",
+		".s2i/environment":    "FOO=bar\n",
+		"app/application.txt": "application\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(source, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]struct {
+		config           *api.Config
+		docker           *docker.FakeDocker
+		expectedStrategy string
+		expectedImage    api.ImagePlan
+		expectedScripts  []api.ScriptPlan
+	}{
+		"sti": {
+			config: &api.Config{BuilderPullPolicy: api.PullIfNotPresent},
+			docker: &docker.FakeDocker{
+				LocalRegistryResult: true,
+				DefaultURLResult:    "image:///usr/libexec/s2i",
+				Labels:              map[string]string{"io.openshift.s2i.scripts-url": "image:///usr/libexec/s2i"},
+			},
+			expectedStrategy: "sti",
+			expectedImage:    api.ImagePlan{Name: "builder", PullPolicy: api.PullIfNotPresent, Present: true},
+			expectedScripts: []api.ScriptPlan{
+				{Name: "assemble", Required: true, URL: "<source-dir>/.s2i/bin/assemble"},
+				{Name: "run", Required: true, URL: "image:///usr/libexec/s2i/run"},
+				{Name: "save-artifacts", URL: "image:///usr/libexec/s2i/save-artifacts"},
+			},
+		},
+		"onbuild": {
+			config: &api.Config{BuilderPullPolicy: api.PullAlways},
+			docker: &docker.FakeDocker{
+				LocalRegistryResult: true,
+				IsOnBuildResult:     true,
+			},
+			expectedStrategy: "onbuild",
+			expectedImage:    api.ImagePlan{Name: "builder", PullPolicy: api.PullAlways, Present: true, Pull: true},
+			expectedScripts: []api.ScriptPlan{
+				{Name: "assemble", URL: "<source-dir>/.s2i/bin/assemble"},
+				{Name: "run", Error: `script "run" not found`},
+			},
+		},
+		"missing image": {
+			config:           &api.Config{BuilderPullPolicy: api.PullNever},
+			docker:           &docker.FakeDocker{},
+			expectedStrategy: "sti",
+			expectedImage:    api.ImagePlan{Name: "builder", PullPolicy: api.PullNever, Error: "the image is not present locally and the pull policy is never"},
+			expectedScripts: []api.ScriptPlan{
+				{Name: "assemble", Required: true, URL: "<source-dir>/.s2i/bin/assemble"},
+				{Name: "run", Required: true, Error: `script "run" not found`},
+				{Name: "save-artifacts", Error: `script "save-artifacts" not found`},
+			},
+		},
+		"dockerfile": {
+			config: &api.Config{
+				BuilderPullPolicy: api.PullIfNotPresent,
+				AsDockerfile:      "/tmp/Dockerfile",
+				ImageScriptsURL:   "image:///usr/libexec/s2i",
+			},
+			docker:           &docker.FakeDocker{LocalRegistryError: os.ErrInvalid},
+			expectedStrategy: "dockerfile",
+			expectedImage:    api.ImagePlan{Name: "builder", PullPolicy: api.PullIfNotPresent},
+			expectedScripts: []api.ScriptPlan{
+				{Name: "assemble", URL: "<source-dir>/.s2i/bin/assemble"},
+				{Name: "run", URL: "image:///usr/libexec/s2i/run"},
+				{Name: "save-artifacts", URL: "image:///usr/libexec/s2i/save-artifacts"},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc.config.BuilderImage = "builder"
+			tc.config.Tag = "app"
+			tc.config.Source = git.MustParse(source)
+			tc.config.ForceCopy = true
+			tc.config.Environment = api.EnvironmentList{{Name: "BAZ", Value: "qux"}}
+			tc.config.Injections = api.VolumeList{{Source: "/secret", Destination: "/tmp/secret"}}
+
+			p, err := plan(tc.docker, fs.NewFileSystem(), tc.config)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if p.Strategy != tc.expectedStrategy {
+				t.Errorf("Expected strategy %s, got %s", tc.expectedStrategy, p.Strategy)
+			}
+			if !reflect.DeepEqual(p.BuilderImage, tc.expectedImage) {
+				t.Errorf("Expected builder image %#v, got %#v", tc.expectedImage, p.BuilderImage)
+			}
+			if p.Source == nil || p.Source.Downloader != "file copy" {
+				t.Errorf("Expected the sources to be copied, got %#v", p.Source)
+			}
+			if !reflect.DeepEqual(p.Scripts, tc.expectedScripts) {
+				t.Errorf("Expected scripts %#v, got %#v", tc.expectedScripts, p.Scripts)
+			}
+			if expected := []string{"FOO=bar", "BAZ=qux"}; !reflect.DeepEqual(p.Environment, expected) {
+				t.Errorf("Expected environment %v, got %v", expected, p.Environment)
+			}
+			if expected := []api.InjectionPlan{{Source: "/secret", Destination: "/tmp/secret", Truncated: true}}; !reflect.DeepEqual(p.Injections, expected) {
+				t.Errorf("Expected injections %#v, got %#v", expected, p.Injections)
+			}
+			if p.Labels["io.openshift.s2i.build.image"] != "builder" {
+				t.Errorf("Expected the builder image label, got %v", p.Labels)
+			}
+			if tc.config.WorkingDir != "" {
+				t.Errorf("Unexpected change of the working directory of the config to %s", tc.config.WorkingDir)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package sti
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/pkg/term"
+
+	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/api/constants"
+	dockerpkg "github.com/openshift/source-to-image/pkg/docker"
+	s2ierr "github.com/openshift/source-to-image/pkg/errors"
+)
+
+// debugShell is the shell run in the image committed from a failed container.
+const debugShell = "/bin/sh"
+
+// handleFailedContainer starts the debug shell in the container which ran a
+// failed assemble script, and keeps the container or removes it. opts are the
+// options the container was run with.
+func (builder *STI) handleFailedContainer(config *api.Config, opts dockerpkg.RunContainerOptions, containerID string) {
+	if config.DebugShell {
+		if err := builder.runDebugShell(config, opts, containerID); err != nil {
+			log.Errorf("Unable to start a debug shell in the failed container %s: %v", containerID, err)
+		}
+	}
+
+	if !config.KeepFailedContainer {
+		if err := builder.docker.RemoveContainer(containerID); err != nil {
+			log.Warningf("Unable to remove the failed container %s: %v", containerID, err)
+		}
+		return
+	}
+
+	// the working directory holds the sources and scripts uploaded to the
+	// container
+	config.PreserveWorkingDir = true
+	log.V(0).Infof("The container %s which ran the failed %s script was kept, along with the sources and scripts in %s.", containerID, constants.Assemble, config.WorkingDir)
+	log.V(0).Infof("To enter it, commit it to an image and run a shell in the image:")
+	log.V(0).Infof("    docker commit %s %s", containerID, debugImageName(containerID))
+	log.V(0).Infof("    %s", debugRunCommand(opts, debugImageName(containerID)))
+	log.V(0).Infof("To remove it, run:")
+	log.V(0).Infof("    docker rm %s", containerID)
+}
+
+// runDebugShell commits the failed container to an image and runs an
+// interactive shell in it, with the environment, user and mounts of the failed
+// container. It returns once the shell exited.
+func (builder *STI) runDebugShell(config *api.Config, opts dockerpkg.RunContainerOptions, containerID string) error {
+	image := debugImageName(containerID)
+	if _, err := builder.docker.CommitContainer(dockerpkg.CommitContainerOptions{ContainerID: containerID, Repository: image}); err != nil {
+		return err
+	}
+	if !config.KeepFailedContainer {
+		defer func() {
+			if err := builder.docker.RemoveImage(image); err != nil {
+				log.Warningf("Unable to remove the debug image %s: %v", image, err)
+			}
+		}()
+	}
+
+	shellOpts := dockerpkg.RunContainerOptions{
+		Image:           image,
+		CommandExplicit: []string{debugShell},
+		Env:             opts.Env,
+		User:            opts.User,
+		Binds:           opts.Binds,
+		NetworkMode:     opts.NetworkMode,
+		CGroupLimits:    opts.CGroupLimits,
+		CapDrop:         opts.CapDrop,
+		SecurityOpt:     opts.SecurityOpt,
+		AddHost:         opts.AddHost,
+		Stdin:           ioutil.NopCloser(os.Stdin),
+		Stdout:          nopWriteCloser{os.Stdout},
+		Stderr:          nopWriteCloser{os.Stderr},
+	}
+	if fd, isTerminal := term.GetFdInfo(os.Stdin); isTerminal {
+		state, err := term.SetRawTerminal(fd)
+		if err != nil {
+			return err
+		}
+		defer term.RestoreTerminal(fd, state)
+		shellOpts.TTY = true
+	}
+
+	log.V(0).Infof("Starting a debug shell in %s, committed from the failed container. Exit the shell to end the build.", image)
+	err := builder.docker.RunContainer(shellOpts)
+	// the exit code of the shell is the one of the last command run in it
+	if _, ok := err.(s2ierr.ContainerError); ok {
+		return nil
+	}
+	return err
+}
+
+// debugImageName returns the name of the image committed from the failed
+// container.
+func debugImageName(containerID string) string {
+	if len(containerID) > 12 {
+		containerID = containerID[:12]
+	}
+	return "s2i-debug-" + containerID
+}
+
+// debugRunCommand returns the docker command running a shell in the image
+// with the mounts of the failed container.
+func debugRunCommand(opts dockerpkg.RunContainerOptions, image string) string {
+	args := []string{"docker", "run", "-it", "--rm", "--entrypoint", debugShell}
+	for _, bind := range opts.Binds {
+		args = append(args, "-v", bind)
+	}
+	if len(opts.NetworkMode) > 0 {
+		args = append(args, fmt.Sprintf("--network=%s", opts.NetworkMode))
+	}
+	return strings.Join(append(args, image), " ")
+}
+
+// nopWriteCloser keeps RunContainer from closing the standard output and
+// error of s2i.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -2,9 +2,12 @@ package sti
 
 import (
 	"archive/tar"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -42,6 +45,11 @@ type postExecutorStepContext struct {
 	// See also: commitImageStep and reportAboutSuccessStep
 	imageID string
 
+	// Id of the builder container, which is kept until the image based on the
+	// runtime image is committed.
+	// See also: startRuntimeImageAndUploadFilesStep and storeBuilderArtifactsStep
+	builderContainerID string
+
 	// Labels that will be passed to a callback.
 	// These labels are added to the image during commit.
 	// See also: commitImageStep and STI.Build()
@@ -166,6 +174,291 @@ func (step *commitImageStep) execute(ctx *postExecutorStepContext) error {
 	return nil
 }
 
+// defaultVerifyTimeout is how long the verification URL is polled for by
+// default.
+const defaultVerifyTimeout = time.Minute
+
+// verifyImagePollInterval is how often the verification URL is polled.
+var verifyImagePollInterval = time.Second
+
+type verifyImageStep struct {
+	builder *STI
+	docker  dockerpkg.Docker
+}
+
+func (step *verifyImageStep) execute(ctx *postExecutorStepContext) error {
+	config := step.builder.config
+	if len(config.PostCommitCommand) == 0 && len(config.VerifyURL) == 0 {
+		log.V(3).Info("Skipping step: verify image")
+		return nil
+	}
+
+	log.V(3).Info("Executing step: verify image")
+	var err error
+	if len(config.PostCommitCommand) > 0 {
+		startTime := time.Now()
+		err = step.runPostCommitCommand(ctx.imageID)
+		step.builder.result.BuildInfo.Stages = api.RecordStageAndStepInfo(step.builder.result.BuildInfo.Stages, api.StageVerify, api.StepRunPostCommitCommand, startTime, time.Now())
+	}
+	if err == nil && len(config.VerifyURL) > 0 {
+		startTime := time.Now()
+		err = step.verifyURL(ctx.imageID)
+		step.builder.result.BuildInfo.Stages = api.RecordStageAndStepInfo(step.builder.result.BuildInfo.Stages, api.StageVerify, api.StepVerifyURL, startTime, time.Now())
+	}
+	if err == nil {
+		return nil
+	}
+
+	step.builder.result.BuildInfo.FailureReason = utilstatus.NewFailureReason(
+		utilstatus.ReasonVerifyImageFailed,
+		utilstatus.ReasonMessageVerifyImageFailed,
+	)
+	if config.RemoveUnverifiedImage {
+		log.V(1).Infof("Removing unverified image %s", ctx.imageID)
+		if removeErr := step.docker.RemoveImage(ctx.imageID); removeErr != nil {
+			log.V(0).Infof("error: Unable to remove unverified image: %v", removeErr)
+		}
+	}
+	return err
+}
+
+// runPostCommitCommand runs the post-commit command in a container of the
+// image, streaming its output to the log.
+func (step *verifyImageStep) runPostCommitCommand(imageID string) error {
+	config := step.builder.config
+	log.V(1).Infof("Running post-commit command %q", config.PostCommitCommand)
+
+	outReader, outWriter := io.Pipe()
+	errReader, errWriter := io.Pipe()
+	opts := dockerpkg.RunContainerOptions{
+		Image:           imageID,
+		CommandExplicit: []string{"/bin/sh", "-c", config.PostCommitCommand},
+		Stdout:          outWriter,
+		Stderr:          errWriter,
+		NetworkMode:     string(config.DockerNetworkMode),
+		CGroupLimits:    config.CGroupLimits,
+		CapDrop:         config.DropCapabilities,
+		AddHost:         config.AddHost,
+		SecurityOpt:     config.SecurityOpt,
+	}
+
+	dockerpkg.StreamContainerIO(outReader, nil, func(s string) { log.V(0).Info(s) })
+	errOutput := ""
+	c := dockerpkg.StreamContainerIO(errReader, &errOutput, func(s string) { log.Info(s) })
+
+	err := step.docker.RunContainer(opts)
+	if e, ok := err.(s2ierr.ContainerError); ok {
+		// Must wait for StreamContainerIO goroutine above to exit before reading errOutput.
+		<-c
+		return fmt.Errorf("post-commit command %q failed with exit code %d: %s", config.PostCommitCommand, e.ErrorCode, errOutput+e.Output)
+	}
+	return err
+}
+
+// verifyURL runs the default command of the image, and polls the verification
+// URL on the address its port is published on until it responds successfully.
+func (step *verifyImageStep) verifyURL(imageID string) error {
+	config := step.builder.config
+	u, err := url.Parse(config.VerifyURL)
+	if err != nil {
+		return fmt.Errorf("invalid verification URL %q: %v", config.VerifyURL, err)
+	}
+	port := u.Port()
+	if len(port) == 0 {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	// run the application like the image would be run, with its own entrypoint
+	entrypoint, err := step.docker.GetImageEntrypoint(imageID)
+	if err != nil {
+		return fmt.Errorf("could not get entrypoint of %q image: %v", imageID, err)
+	}
+
+	pollResult := make(chan error, 1)
+	opts := dockerpkg.RunContainerOptions{
+		Image:             imageID,
+		Entrypoint:        entrypoint,
+		TargetImage:       true,
+		SuppressPortsInfo: true,
+		PublishPorts:      []string{port + "/tcp"},
+		NetworkMode:       string(config.DockerNetworkMode),
+		CGroupLimits:      config.CGroupLimits,
+		CapDrop:           config.DropCapabilities,
+		AddHost:           config.AddHost,
+		SecurityOpt:       config.SecurityOpt,
+		OnStart: func(containerID string) error {
+			err := step.pollURL(containerID, u, port+"/tcp")
+			pollResult <- err
+			// the container keeps running the application, stop it
+			step.docker.KillContainer(containerID)
+			return err
+		},
+	}
+	outReader, outWriter := io.Pipe()
+	errReader, errWriter := io.Pipe()
+	opts.Stdout = outWriter
+	opts.Stderr = errWriter
+	dockerpkg.StreamContainerIO(outReader, nil, func(s string) { log.V(1).Info(s) })
+	dockerpkg.StreamContainerIO(errReader, nil, func(s string) { log.V(1).Info(s) })
+
+	err = step.docker.RunContainer(opts)
+	select {
+	case pollErr := <-pollResult:
+		if pollErr == nil {
+			// the container was killed after the URL responded
+			return nil
+		}
+		err = pollErr
+	default:
+		// the container stopped before the URL responded
+		if err == nil {
+			err = errors.New("the container exited")
+		}
+	}
+	return fmt.Errorf("verification URL %s did not respond successfully: %v", config.VerifyURL, err)
+}
+
+// pollURL polls the URL on the address the port of the container is published
+// on until it responds with a 2xx or 3xx status, or the verification timeout
+// expires.
+func (step *verifyImageStep) pollURL(containerID string, u *url.URL, port string) error {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		// a redirect is a valid response of a running application
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	timeout := step.builder.config.VerifyTimeout
+	if timeout <= 0 {
+		timeout = defaultVerifyTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	var lastErr error
+	for {
+		address, err := step.docker.GetContainerPortAddress(containerID, port)
+		if err != nil {
+			return err
+		}
+		target := *u
+		target.Host = address
+		log.V(2).Infof("Polling verification URL %s", target.String())
+		resp, err := client.Get(target.String())
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 400 {
+				log.V(1).Infof("Verification URL %s responded with status %d", step.builder.config.VerifyURL, resp.StatusCode)
+				return nil
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		lastErr = err
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %v: %v", timeout, lastErr)
+		}
+		time.Sleep(verifyImagePollInterval)
+	}
+}
+
+type storeArtifactsCacheStep struct {
+	builder *STI
+}
+
+func (step *storeArtifactsCacheStep) execute(ctx *postExecutorStepContext) error {
+	builder := step.builder
+	if builder.artifactsCache == nil || len(builder.config.Tag) == 0 || !builder.installedScripts[constants.SaveArtifacts] {
+		log.V(3).Info("Skipping step: store artifacts cache")
+		return nil
+	}
+
+	log.V(3).Info("Executing step: store artifacts cache")
+	// the image was built, so failing to cache its artifacts only makes the
+	// next build a clean one
+	if err := builder.storeCachedArtifacts(builder.config, ctx.imageID); err != nil {
+		log.Warningf("Unable to store build artifacts in %s: %v", builder.config.IncrementalCacheDir, err)
+	}
+	return nil
+}
+
+type storeBuilderArtifactsStep struct {
+	builder *STI
+	docker  dockerpkg.Docker
+}
+
+func (step *storeBuilderArtifactsStep) execute(ctx *postExecutorStepContext) error {
+	builder := step.builder
+	config := builder.config
+	if !config.Incremental || !builder.installedScripts[constants.SaveArtifacts] ||
+		(builder.artifactsCache == nil && len(config.IncrementalCacheImage) == 0) ||
+		(builder.artifactsCache != nil && len(config.Tag) == 0) {
+		log.V(3).Info("Skipping step: store builder artifacts")
+		return nil
+	}
+
+	log.V(3).Info("Executing step: store builder artifacts")
+	// the runtime image was built, failing to keep the artifacts only makes
+	// the next build a clean one
+	if err := step.storeArtifacts(ctx.builderContainerID); err != nil {
+		log.Warningf("Unable to store build artifacts for the next incremental build: %v", err)
+	}
+	return nil
+}
+
+// storeArtifacts commits the builder container to the cache image, or to a
+// temporary image whose artifacts are stored in the incremental artifacts
+// cache.
+func (step *storeBuilderArtifactsStep) storeArtifacts(containerID string) error {
+	builder := step.builder
+	config := builder.config
+
+	user, err := step.docker.GetImageUser(config.BuilderImage)
+	if err != nil {
+		return fmt.Errorf("could not get user of %q image: %v", config.BuilderImage, err)
+	}
+	entrypoint, err := step.docker.GetImageEntrypoint(config.BuilderImage)
+	if err != nil {
+		return fmt.Errorf("could not get entrypoint of %q image: %v", config.BuilderImage, err)
+	}
+	if entrypoint == nil {
+		entrypoint = []string{}
+	}
+
+	previousImageID := ""
+	if builder.artifactsCache == nil && config.RemovePreviousImage {
+		previousImageID, _ = step.docker.GetImageID(config.IncrementalCacheImage)
+	}
+
+	log.V(1).Infof("Committing builder container %s to %s", containerID, util.FirstNonEmpty(config.IncrementalCacheImage, "a temporary image"))
+	imageID, err := step.docker.CommitContainer(dockerpkg.CommitContainerOptions{
+		ContainerID: containerID,
+		Repository:  config.IncrementalCacheImage,
+		User:        user,
+		Entrypoint:  entrypoint,
+		Env:         builder.env,
+	})
+	if err != nil {
+		return s2ierr.NewCommitError(config.IncrementalCacheImage, err)
+	}
+
+	if builder.artifactsCache != nil {
+		defer func() {
+			if err := step.docker.RemoveImage(imageID); err != nil {
+				log.V(0).Infof("error: Unable to remove temporary image %s: %v", imageID, err)
+			}
+		}()
+		return builder.storeCachedArtifacts(config, imageID)
+	}
+
+	if len(previousImageID) > 0 && previousImageID != imageID {
+		log.V(1).Infof("Removing previous cache image %s", previousImageID)
+		if err := step.docker.RemoveImage(previousImageID); err != nil {
+			log.V(0).Infof("error: Unable to remove previous cache image: %v", err)
+		}
+	}
+	return nil
+}
+
 type downloadFilesFromBuilderImageStep struct {
 	builder *STI
 	docker  dockerpkg.Docker
@@ -240,6 +533,8 @@ type startRuntimeImageAndUploadFilesStep struct {
 
 func (step *startRuntimeImageAndUploadFilesStep) execute(ctx *postExecutorStepContext) error {
 	log.V(3).Info("Executing step: start runtime image and upload files")
+	// the steps of the runtime container replace the container of the context
+	ctx.builderContainerID = ctx.containerID
 
 	fd, err := ioutil.TempFile("", "s2i-upload-done")
 	if err != nil {
@@ -355,14 +650,29 @@ func (step *startRuntimeImageAndUploadFilesStep) execute(ctx *postExecutorStepCo
 	errOutput := ""
 	c := dockerpkg.StreamContainerIO(errReader, &errOutput, func(s string) { log.Info(s) })
 
+	usage := api.ResourceUsage{}
+	opts.ResourceUsage = &usage
+
 	// switch to the next stage of post executors steps
 	step.builder.postExecutorStage++
 
+	startTime := time.Now()
 	err = step.docker.RunContainer(opts)
+	step.builder.result.BuildInfo.Stages = api.RecordStageAndStepInfo(step.builder.result.BuildInfo.Stages, api.StageAssembleRuntime, api.StepAssembleRuntimeScript, startTime, time.Now())
+	step.builder.result.BuildInfo.Stages = api.RecordStageResourceUsage(step.builder.result.BuildInfo.Stages, api.StageAssembleRuntime, usage)
 	if e, ok := err.(s2ierr.ContainerError); ok {
 		// Must wait for StreamContainerIO goroutine above to exit before reading errOutput.
 		<-c
-		err = s2ierr.NewContainerError(image, e.ErrorCode, errOutput+e.Output)
+		err = s2ierr.NewContainerStateError(image, e.ExitCode, errOutput+e.Output, e.OOMKilled)
+	}
+	if err != nil {
+		step.builder.result.BuildInfo.FailureReason = utilstatus.NewScriptFailureReason(
+			constants.AssembleRuntime,
+			err,
+			step.builder.config.CGroupLimits,
+			utilstatus.ReasonAssembleRuntimeFailed,
+			utilstatus.ReasonMessageAssembleRuntimeFailed,
+		)
 	}
 
 	return err
@@ -421,7 +731,7 @@ func commitContainer(docker dockerpkg.Docker, containerID, cmd, user, tag string
 }
 
 func createLabelsForResultingImage(builder *STI, docker dockerpkg.Docker, baseImage string) map[string]string {
-	generatedLabels := util.GenerateOutputImageLabels(builder.sourceInfo, builder.config)
+	generatedLabels := util.GenerateOutputImageLabels(builder.sourceInfo, builder.result.Scripts, builder.config)
 
 	existingLabels, err := docker.GetLabels(baseImage)
 	if err != nil {
@@ -2,10 +2,21 @@ package sti
 
 import (
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/api/constants"
 	"github.com/openshift/source-to-image/pkg/docker"
+	s2ierr "github.com/openshift/source-to-image/pkg/errors"
+	s2itar "github.com/openshift/source-to-image/pkg/tar"
+	"github.com/openshift/source-to-image/pkg/util/fs"
+	utilstatus "github.com/openshift/source-to-image/pkg/util/status"
 )
 
 func TestStorePreviousImageStep(t *testing.T) {
@@ -97,6 +108,225 @@ func TestRemovePreviousImageStep(t *testing.T) {
 	}
 }
 
+func TestStoreBuilderArtifactsStep(t *testing.T) {
+	testCases := []struct {
+		cacheImage          string
+		saveArtifacts       bool
+		previousImageID     string
+		expectedCommit      bool
+		expectedRemoveImage string
+	}{
+		{cacheImage: "app-cache", saveArtifacts: true, expectedCommit: true},
+		{cacheImage: "app-cache", saveArtifacts: true, previousImageID: "12345", expectedCommit: true, expectedRemoveImage: "12345"},
+		{cacheImage: "app-cache", saveArtifacts: false},
+		{cacheImage: "", saveArtifacts: true},
+	}
+
+	for i, testCase := range testCases {
+		builder := newFakeBaseSTI()
+		builder.config.Incremental = true
+		builder.config.RemovePreviousImage = true
+		builder.config.BuilderImage = "builder-image"
+		builder.config.RuntimeImage = "runtime-image"
+		builder.config.IncrementalCacheImage = testCase.cacheImage
+		builder.installedScripts = map[string]bool{constants.SaveArtifacts: testCase.saveArtifacts}
+
+		fakeDocker := builder.docker.(*docker.FakeDocker)
+		fakeDocker.GetImageIDResult = testCase.previousImageID
+		fakeDocker.CommitContainerResult = "67890"
+
+		step := &storeBuilderArtifactsStep{builder: builder, docker: fakeDocker}
+		if err := step.execute(&postExecutorStepContext{containerID: "runtime-container", builderContainerID: "builder-container"}); err != nil {
+			t.Fatalf("(%d) should exit without error, but it returned %v", i, err)
+		}
+
+		committed := fakeDocker.CommitContainerOpts.ContainerID == "builder-container"
+		if committed != testCase.expectedCommit {
+			t.Errorf("(%d) expected the builder container to be committed: %v, got %#v", i, testCase.expectedCommit, fakeDocker.CommitContainerOpts)
+		}
+		if committed && fakeDocker.CommitContainerOpts.Repository != testCase.cacheImage {
+			t.Errorf("(%d) expected the builder container to be committed to %q, got %q", i, testCase.cacheImage, fakeDocker.CommitContainerOpts.Repository)
+		}
+		if fakeDocker.RemoveImageName != testCase.expectedRemoveImage {
+			t.Errorf("(%d) should invoke fakeDocker.RemoveImage(%q) but invoked with %q", i, testCase.expectedRemoveImage, fakeDocker.RemoveImageName)
+		}
+	}
+}
+
+func TestStoreBuilderArtifactsAfterCommit(t *testing.T) {
+	builder := newFakeBaseSTI()
+	builder.config.RuntimeImage = "runtime-image"
+	builder.initPostExecutorSteps()
+
+	for _, step := range builder.postExecutorFirstStageSteps {
+		if _, ok := step.(*storeBuilderArtifactsStep); ok {
+			t.Fatalf("expected the builder artifacts not to be stored before the runtime image is built")
+		}
+	}
+	committed := false
+	for _, step := range builder.postExecutorSecondStageSteps {
+		switch step.(type) {
+		case *commitImageStep:
+			committed = true
+		case *storeBuilderArtifactsStep:
+			if !committed {
+				t.Errorf("expected the builder artifacts to be stored after the image is committed")
+			}
+			return
+		}
+	}
+	t.Errorf("expected the builder artifacts to be stored")
+}
+
+// uploadingDocker uploads the runtime artifacts into the fake container.
+type uploadingDocker struct {
+	*docker.FakeDocker
+}
+
+func (d uploadingDocker) UploadToContainerWithTarWriter(fs fs.FileSystem, srcPath, destPath, container string, makeTarWriter func(io.Writer) s2itar.Writer) error {
+	return nil
+}
+
+func TestStartRuntimeImageResourceUsage(t *testing.T) {
+	builder := newFakeBaseSTI()
+	builder.config.RuntimeImage = "runtime-image"
+	builder.config.WorkingDir = "/tmp"
+	builder.result.BuildInfo.Stages = api.RecordStageResourceUsage(nil, api.StageAssemble, api.ResourceUsage{PeakMemoryBytes: 2048, CPUTimeNanoseconds: 1000})
+	fakeDocker := &docker.FakeDocker{
+		DefaultURLResult:          "image:///usr/libexec/s2i",
+		RunContainerResourceUsage: api.ResourceUsage{PeakMemoryBytes: 1024, CPUTimeNanoseconds: 500},
+	}
+
+	step := &startRuntimeImageAndUploadFilesStep{builder: builder, docker: uploadingDocker{fakeDocker}, fs: builder.fs}
+	if err := step.execute(&postExecutorStepContext{containerID: "builder-container"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	usage := map[api.StageName]api.ResourceUsage{}
+	for _, stage := range builder.result.BuildInfo.Stages {
+		if stage.ResourceUsage != nil {
+			usage[stage.Name] = *stage.ResourceUsage
+		}
+	}
+	expected := map[api.StageName]api.ResourceUsage{
+		api.StageAssemble:        {PeakMemoryBytes: 2048, CPUTimeNanoseconds: 1000},
+		api.StageAssembleRuntime: {PeakMemoryBytes: 1024, CPUTimeNanoseconds: 500},
+	}
+	if !reflect.DeepEqual(usage, expected) {
+		t.Errorf("expected the resource usage %#v, got %#v", expected, usage)
+	}
+}
+
+func TestVerifyImageStep(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+	address := func(server *httptest.Server) string {
+		u, _ := url.Parse(server.URL)
+		return u.Host
+	}
+
+	defer func(interval time.Duration) { verifyImagePollInterval = interval }(verifyImagePollInterval)
+	verifyImagePollInterval = time.Millisecond
+
+	testCases := []struct {
+		name              string
+		postCommitCommand string
+		verifyURL         string
+		runError          error
+		portAddress       string
+		portAddressError  error
+		removeImage       bool
+		expectedError     bool
+	}{
+		{
+			name: "no verification",
+		},
+		{
+			name:              "post-commit command succeeds",
+			postCommitCommand: "bundle exec rake test",
+		},
+		{
+			name:              "post-commit command fails",
+			postCommitCommand: "bundle exec rake test",
+			runError:          s2ierr.NewContainerError("container", 1, "tests failed"),
+			removeImage:       true,
+			expectedError:     true,
+		},
+		{
+			name:        "URL responds",
+			verifyURL:   "http://localhost:8080/healthz",
+			portAddress: address(healthy),
+		},
+		{
+			name:          "URL never responds successfully",
+			verifyURL:     "http://localhost:8080/healthz",
+			portAddress:   address(unhealthy),
+			expectedError: true,
+		},
+		{
+			name:             "container stopped",
+			verifyURL:        "http://localhost:8080/healthz",
+			portAddressError: fmt.Errorf("container is not running"),
+			removeImage:      true,
+			expectedError:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			builder := newFakeBaseSTI()
+			builder.config.PostCommitCommand = tc.postCommitCommand
+			builder.config.VerifyURL = tc.verifyURL
+			builder.config.VerifyTimeout = 50 * time.Millisecond
+			builder.config.RemoveUnverifiedImage = tc.removeImage
+
+			fakeDocker := builder.docker.(*docker.FakeDocker)
+			fakeDocker.RunContainerError = tc.runError
+			fakeDocker.PortAddressResult = tc.portAddress
+			fakeDocker.PortAddressError = tc.portAddressError
+
+			step := &verifyImageStep{builder: builder, docker: fakeDocker}
+			err := step.execute(&postExecutorStepContext{imageID: "12345"})
+			if tc.expectedError != (err != nil) {
+				t.Fatalf("expected error: %v, got %v", tc.expectedError, err)
+			}
+
+			if len(tc.postCommitCommand) > 0 {
+				expectedCmd := []string{"/bin/sh", "-c", tc.postCommitCommand}
+				if !reflect.DeepEqual(fakeDocker.RunContainerOpts.CommandExplicit, expectedCmd) || fakeDocker.RunContainerOpts.Image != "12345" {
+					t.Errorf("expected the command %v to run in the image 12345, got %#v", expectedCmd, fakeDocker.RunContainerOpts)
+				}
+			}
+			if len(tc.verifyURL) > 0 && !fakeDocker.RunContainerOpts.TargetImage {
+				t.Errorf("expected the image to run its default command, got %#v", fakeDocker.RunContainerOpts)
+			}
+
+			expectedReason := utilstatus.NewFailureReason("", "")
+			if tc.expectedError {
+				expectedReason = utilstatus.NewFailureReason(utilstatus.ReasonVerifyImageFailed, utilstatus.ReasonMessageVerifyImageFailed)
+			}
+			if builder.result.BuildInfo.FailureReason != expectedReason {
+				t.Errorf("expected failure reason %#v, got %#v", expectedReason, builder.result.BuildInfo.FailureReason)
+			}
+			expectedRemoved := ""
+			if tc.expectedError && tc.removeImage {
+				expectedRemoved = "12345"
+			}
+			if fakeDocker.RemoveImageName != expectedRemoved {
+				t.Errorf("should invoke fakeDocker.RemoveImage(%q) but invoked with %q", expectedRemoved, fakeDocker.RemoveImageName)
+			}
+		})
+	}
+}
+
 func TestCommitImageStep(t *testing.T) {
 
 	testCases := []struct {
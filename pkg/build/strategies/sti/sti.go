@@ -16,6 +16,7 @@ import (
 	"github.com/openshift/source-to-image/pkg/api/constants"
 	"github.com/openshift/source-to-image/pkg/build"
 	"github.com/openshift/source-to-image/pkg/build/strategies/layered"
+	"github.com/openshift/source-to-image/pkg/cache"
 	dockerpkg "github.com/openshift/source-to-image/pkg/docker"
 	s2ierr "github.com/openshift/source-to-image/pkg/errors"
 	"github.com/openshift/source-to-image/pkg/ignore"
@@ -62,6 +63,7 @@ type STI struct {
 	tar                    tar.Tar
 	docker                 dockerpkg.Docker
 	incrementalDocker      dockerpkg.Docker
+	artifactsCache         *cache.Cache
 	runtimeDocker          dockerpkg.Docker
 	callbackInvoker        util.CallbackInvoker
 	requiredScripts        []string
@@ -101,16 +103,24 @@ func New(client dockerpkg.Client, config *api.Config, fs fs.FileSystem, override
 		return nil, err
 	}
 
-	docker := dockerpkg.New(client, config.PullAuthentication)
+	if overrides.Session == nil {
+		overrides.Session = dockerpkg.NewSession()
+	}
+	docker := dockerpkg.NewForConfig(client, config.PullAuthentication, config, overrides.Session)
 	var incrementalDocker dockerpkg.Docker
 	if config.Incremental {
-		incrementalDocker = dockerpkg.New(client, config.IncrementalAuthentication)
+		incrementalDocker = dockerpkg.NewForConfig(client, config.IncrementalAuthentication, config, overrides.Session)
+	}
+	var artifactsCache *cache.Cache
+	if config.Incremental && len(config.IncrementalCacheDir) > 0 {
+		artifactsCache = cache.New(config.IncrementalCacheDir)
 	}
 
 	inst := scripts.NewInstaller(
 		config.BuilderImage,
 		config.ScriptsURL,
 		config.ScriptDownloadProxyConfig,
+		&config.ScriptDownload,
 		docker,
 		config.PullAuthentication,
 		fs,
@@ -123,6 +133,7 @@ func New(client dockerpkg.Client, config *api.Config, fs fs.FileSystem, override
 		config:                 config,
 		docker:                 docker,
 		incrementalDocker:      incrementalDocker,
+		artifactsCache:         artifactsCache,
 		git:                    git.New(fs, cmd.NewCommandRunner()),
 		fs:                     fs,
 		tar:                    tarHandler,
@@ -137,12 +148,13 @@ func New(client dockerpkg.Client, config *api.Config, fs fs.FileSystem, override
 	}
 
 	if len(config.RuntimeImage) > 0 {
-		builder.runtimeDocker = dockerpkg.New(client, config.RuntimeAuthentication)
+		builder.runtimeDocker = dockerpkg.NewForConfig(client, config.RuntimeAuthentication, config, overrides.Session)
 
 		builder.runtimeInstaller = scripts.NewInstaller(
 			config.RuntimeImage,
 			config.ScriptsURL,
 			config.ScriptDownloadProxyConfig,
+			&config.ScriptDownload,
 			builder.runtimeDocker,
 			config.RuntimeAuthentication,
 			builder.fs,
@@ -156,7 +168,7 @@ func New(client dockerpkg.Client, config *api.Config, fs fs.FileSystem, override
 	// which would lead to replacing this quick short circuit (so this change is tactical)
 	builder.source = overrides.Downloader
 	if builder.source == nil && !config.Usage {
-		downloader, err := scm.DownloaderForSource(builder.fs, config.Source, config.ForceCopy)
+		downloader, err := scm.DownloaderForSource(builder.fs, config.Source, config.ForceCopy, config.IncludeUncommitted, &config.GitAuthentication, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -191,6 +203,17 @@ func (builder *STI) Build(config *api.Config) (*api.Result, error) {
 
 	if len(builder.config.CallbackURL) > 0 {
 		defer func() {
+			// only the invokers supporting it post the resource usage of the stages
+			if invoker, ok := builder.callbackInvoker.(util.StagesCallbackInvoker); ok {
+				builder.result.Messages = invoker.ExecuteCallbackWithStages(
+					builder.config.CallbackURL,
+					builder.result.Success,
+					builder.postExecutorStepsContext.labels,
+					builder.result.BuildInfo.Stages,
+					builder.result.Messages,
+				)
+				return
+			}
 			builder.result.Messages = builder.callbackInvoker.ExecuteCallback(
 				builder.config.CallbackURL,
 				builder.result.Success,
@@ -207,13 +230,19 @@ func (builder *STI) Build(config *api.Config) (*api.Result, error) {
 	}
 
 	if builder.incremental = builder.artifacts.Exists(config); builder.incremental {
-		tag := util.FirstNonEmpty(config.IncrementalFromTag, config.Tag)
-		log.V(1).Infof("Existing image for tag %s detected for incremental build", tag)
+		if builder.artifactsCache != nil {
+			tag := util.FirstNonEmpty(config.IncrementalFromTag, config.Tag)
+			log.V(1).Infof("Cached artifacts for tag %s detected for incremental build", tag)
+		} else {
+			log.V(1).Infof("Existing image for tag %s detected for incremental build", previousArtifactsImage(config))
+		}
 	} else {
 		log.V(1).Info("Clean build will be performed")
 	}
 
-	log.V(2).Infof("Performing source build from %s", config.Source)
+	if config.Source != nil {
+		log.V(2).Infof("Performing source build from %s", config.Source.StringNoCredentials())
+	}
 	if builder.incremental {
 		if err := builder.artifacts.Save(config); err != nil {
 			log.Warning("Clean build will be performed because of error saving previous build artifacts")
@@ -227,14 +256,19 @@ func (builder *STI) Build(config *api.Config) (*api.Result, error) {
 		log.V(1).Infof("Running %q in %q", constants.Assemble, config.Tag)
 	}
 	startTime := time.Now()
-	if err := builder.scripts.Execute(constants.Assemble, config.AssembleUser, config); err != nil {
+	err := builder.scripts.Execute(constants.Assemble, config.AssembleUser, config)
+	builder.result.BuildInfo.Stages = api.RecordStageAndStepInfo(builder.result.BuildInfo.Stages, api.StageAssemble, api.StepAssembleBuildScripts, startTime, time.Now())
+	if err != nil {
 		if err == errMissingRequirements {
 			log.V(1).Info("Image is missing basic requirements (sh or tar), layered build will be performed")
 			return builder.layered.Build(config)
 		}
 		if e, ok := err.(s2ierr.ContainerError); ok {
 			if !isMissingRequirements(e.Output) {
-				builder.result.BuildInfo.FailureReason = utilstatus.NewFailureReason(
+				builder.result.BuildInfo.FailureReason = utilstatus.NewScriptFailureReason(
+					constants.Assemble,
+					err,
+					config.CGroupLimits,
 					utilstatus.ReasonAssembleFailed,
 					utilstatus.ReasonMessageAssembleFailed,
 				)
@@ -247,7 +281,6 @@ func (builder *STI) Build(config *api.Config) (*api.Result, error) {
 
 		return builder.result, err
 	}
-	builder.result.BuildInfo.Stages = api.RecordStageAndStepInfo(builder.result.BuildInfo.Stages, api.StageAssemble, api.StepAssembleBuildScripts, startTime, time.Now())
 	builder.result.Success = true
 
 	return builder.result, nil
@@ -276,7 +309,7 @@ func (builder *STI) Prepare(config *api.Config) error {
 
 	if len(config.RuntimeImage) > 0 {
 		startTime := time.Now()
-		dockerpkg.GetRuntimeImage(builder.runtimeDocker, config)
+		err = dockerpkg.GetRuntimeImage(builder.runtimeDocker, config)
 		builder.result.BuildInfo.Stages = api.RecordStageAndStepInfo(builder.result.BuildInfo.Stages, api.StagePullImages, api.StepPullRuntimeImage, startTime, time.Now())
 
 		if err != nil {
@@ -377,11 +410,13 @@ func (builder *STI) Prepare(config *api.Config) error {
 		if config.SourceInfo != nil {
 			builder.sourceInfo = config.SourceInfo
 		}
+		builder.result.SourceInfo = builder.sourceInfo
 	}
 
 	// get the scripts
 	required, err := builder.installer.InstallRequired(builder.requiredScripts, config.WorkingDir)
 	if err != nil {
+		builder.result.Scripts = required
 		builder.result.BuildInfo.FailureReason = utilstatus.NewFailureReason(
 			utilstatus.ReasonInstallScriptsFailed,
 			utilstatus.ReasonMessageInstallScriptsFailed,
@@ -396,6 +431,7 @@ func (builder *STI) Prepare(config *api.Config) error {
 		optionalRuntime := builder.runtimeInstaller.InstallOptional(builder.optionalRuntimeScripts, config.WorkingDir)
 		requiredAndOptional = append(requiredAndOptional, optionalRuntime...)
 	}
+	builder.result.Scripts = requiredAndOptional
 
 	// If a ScriptsURL was specified, but no scripts were downloaded from it, throw an error
 	if len(config.ScriptsURL) > 0 {
@@ -436,6 +472,12 @@ func (builder *STI) SetScripts(required, optional []string) {
 	builder.optionalScripts = optional
 }
 
+// InstalledScripts returns the results of installing the scripts, which
+// describe the source each script was installed from.
+func (builder *STI) InstalledScripts() []api.InstallResult {
+	return builder.result.Scripts
+}
+
 // PostExecute allows to execute post-build actions after the Docker
 // container execution finishes.
 func (builder *STI) PostExecute(containerID, destination string) error {
@@ -469,19 +511,29 @@ func CreateBuildEnvironment(sourcePath string, cfgEnv api.EnvironmentList) []str
 }
 
 // Exists determines if the current build supports incremental workflow.
-// It checks if the previous image exists in the system and if so, then it
-// verifies that the save-artifacts script is present.
+// It checks if the previous image exists in the system, or its artifacts in
+// the incremental artifacts cache, and if so, then it verifies that the
+// save-artifacts script is present.
 func (builder *STI) Exists(config *api.Config) bool {
 	if !config.Incremental {
 		return false
 	}
 
+	if builder.artifactsCache != nil {
+		tag := util.FirstNonEmpty(config.IncrementalFromTag, config.Tag)
+		return builder.installedScripts[constants.SaveArtifacts] && builder.artifactsCache.Exists(config.BuilderImage, tag)
+	}
+
 	policy := config.PreviousImagePullPolicy
 	if len(policy) == 0 {
 		policy = api.DefaultPreviousImagePullPolicy
 	}
 
-	tag := util.FirstNonEmpty(config.IncrementalFromTag, config.Tag)
+	tag := previousArtifactsImage(config)
+	if len(config.RuntimeImage) > 0 && len(tag) == 0 {
+		log.V(2).Info("No cache image to save the artifacts of the previous build from")
+		return false
+	}
 
 	startTime := time.Now()
 	result, err := dockerpkg.PullImage(tag, builder.incrementalDocker, policy)
@@ -515,21 +567,119 @@ func (builder *STI) Save(config *api.Config) (err error) {
 		return err
 	}
 
-	image := util.FirstNonEmpty(config.IncrementalFromTag, config.Tag)
+	if builder.artifactsCache != nil {
+		return builder.restoreCachedArtifacts(config, util.FirstNonEmpty(config.IncrementalFromTag, config.Tag), artifactTmpDir)
+	}
+
+	image := previousArtifactsImage(config)
 
-	outReader, outWriter := io.Pipe()
-	errReader, errWriter := io.Pipe()
 	log.V(1).Infof("Saving build artifacts from image %s to path %s", image, artifactTmpDir)
-	extractFunc := func(string) error {
+	err = builder.runSaveArtifacts(config, image, func(r io.Reader) error {
 		startTime := time.Now()
-		extractErr := builder.tar.ExtractTarStream(artifactTmpDir, outReader)
-		io.Copy(ioutil.Discard, outReader) // must ensure reader from container is drained
+		extractErr := builder.tar.ExtractTarStream(artifactTmpDir, r)
 		builder.result.BuildInfo.Stages = api.RecordStageAndStepInfo(builder.result.BuildInfo.Stages, api.StageRetrieve, api.StepRetrievePreviousArtifacts, startTime, time.Now())
 
 		if extractErr != nil {
 			builder.fs.RemoveDirectory(artifactTmpDir)
 		}
 
+		return extractErr
+	})
+
+	builder.result.BuildInfo.FailureReason = utilstatus.NewScriptFailureReason(
+		constants.SaveArtifacts,
+		err,
+		config.CGroupLimits,
+		utilstatus.ReasonGenericS2IBuildFailed,
+		utilstatus.ReasonMessageGenericS2iBuildFailed,
+	)
+	return err
+}
+
+// cacheMountScope returns the scope of the cache volumes, which is the builder
+// image unless a scope was set.
+func cacheMountScope(config *api.Config) string {
+	return util.FirstNonEmpty(config.CacheMountScope, config.BuilderImage)
+}
+
+// PreviousImage returns the image of the previous build an incremental build
+// saves the artifacts from, or an empty string if the artifacts are not saved
+// from an image.
+func PreviousImage(config *api.Config) string {
+	if !config.Incremental || len(config.IncrementalCacheDir) > 0 {
+		return ""
+	}
+	return previousArtifactsImage(config)
+}
+
+// previousArtifactsImage returns the image the artifacts of the previous build
+// are saved from. Images built with a runtime image don't contain the
+// artifacts, which are saved from the cache image committed from the builder
+// container instead.
+func previousArtifactsImage(config *api.Config) string {
+	if len(config.RuntimeImage) > 0 {
+		return config.IncrementalCacheImage
+	}
+	return util.FirstNonEmpty(config.IncrementalFromTag, config.Tag)
+}
+
+// restoreCachedArtifacts extracts the artifacts of the image cached by a
+// previous build into dir.
+func (builder *STI) restoreCachedArtifacts(config *api.Config, image, dir string) error {
+	log.V(1).Infof("Restoring build artifacts of image %s from %s to path %s", image, config.IncrementalCacheDir, dir)
+	r, err := builder.artifactsCache.Open(config.BuilderImage, image)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	startTime := time.Now()
+	err = builder.tar.ExtractTarStream(dir, r)
+	builder.result.BuildInfo.Stages = api.RecordStageAndStepInfo(builder.result.BuildInfo.Stages, api.StageRetrieve, api.StepRetrievePreviousArtifacts, startTime, time.Now())
+	if err != nil {
+		builder.fs.RemoveDirectory(dir)
+	}
+	return err
+}
+
+// storeCachedArtifacts runs the save-artifacts script in the newly committed
+// image and stores its output in the incremental artifacts cache, where the
+// next build of the tag restores it from.
+func (builder *STI) storeCachedArtifacts(config *api.Config, imageID string) error {
+	tmpFile, err := ioutil.TempFile(config.WorkingDir, "artifacts-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	log.V(1).Infof("Saving build artifacts from image %s to %s", util.FirstNonEmpty(config.Tag, imageID), config.IncrementalCacheDir)
+	startTime := time.Now()
+	err = builder.runSaveArtifacts(config, imageID, func(r io.Reader) error {
+		_, err := io.Copy(tmpFile, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// the artifacts are only stored once save-artifacts succeeded, so that a
+	// failing script doesn't replace the cached artifacts with partial ones
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	err = builder.artifactsCache.Store(config.BuilderImage, config.Tag, tmpFile)
+	builder.result.BuildInfo.Stages = api.RecordStageAndStepInfo(builder.result.BuildInfo.Stages, api.StageRetrieve, api.StepStoreArtifactsCache, startTime, time.Now())
+	return err
+}
+
+// runSaveArtifacts runs the save-artifacts script in the image and passes its
+// output to extract once the container started.
+func (builder *STI) runSaveArtifacts(config *api.Config, image string, extract func(io.Reader) error) (err error) {
+	outReader, outWriter := io.Pipe()
+	errReader, errWriter := io.Pipe()
+	extractFunc := func(string) error {
+		extractErr := extract(outReader)
+		io.Copy(ioutil.Discard, outReader) // must ensure reader from container is drained
 		return extractErr
 	}
 
@@ -537,10 +687,6 @@ func (builder *STI) Save(config *api.Config) (err error) {
 	if len(user) == 0 {
 		user, err = builder.docker.GetImageUser(image)
 		if err != nil {
-			builder.result.BuildInfo.FailureReason = utilstatus.NewFailureReason(
-				utilstatus.ReasonGenericS2IBuildFailed,
-				utilstatus.ReasonMessageGenericS2iBuildFailed,
-			)
 			return err
 		}
 		log.V(3).Infof("The assemble user is not set, defaulting to %q user", user)
@@ -567,16 +713,15 @@ func (builder *STI) Save(config *api.Config) (err error) {
 		AddHost:         config.AddHost,
 	}
 
+	usage := api.ResourceUsage{}
+	opts.ResourceUsage = &usage
+
 	dockerpkg.StreamContainerIO(errReader, nil, func(s string) { log.Info(s) })
 	err = builder.docker.RunContainer(opts)
+	builder.result.BuildInfo.Stages = api.RecordStageResourceUsage(builder.result.BuildInfo.Stages, api.StageRetrieve, usage)
 	if e, ok := err.(s2ierr.ContainerError); ok {
 		err = s2ierr.NewSaveArtifactsError(image, e.Output, err)
 	}
-
-	builder.result.BuildInfo.FailureReason = utilstatus.NewFailureReason(
-		utilstatus.ReasonGenericS2IBuildFailed,
-		utilstatus.ReasonMessageGenericS2iBuildFailed,
-	)
 	return err
 }
 
@@ -626,6 +771,21 @@ func (builder *STI) Execute(command string, user string, config *api.Config) err
 		AddHost:         config.AddHost,
 	}
 
+	// The cache volumes are only mounted into the container running assemble,
+	// docker doesn't commit their content with the container.
+	if len(config.CacheMounts) > 0 && command == constants.Assemble {
+		binds, release, err := cache.MountVolumes(builder.docker, cacheMountScope(config), config.CacheMounts)
+		if err != nil {
+			builder.result.BuildInfo.FailureReason = utilstatus.NewFailureReason(
+				utilstatus.ReasonGenericS2IBuildFailed,
+				utilstatus.ReasonMessageGenericS2iBuildFailed,
+			)
+			return err
+		}
+		defer release()
+		opts.Binds = append(append([]string{}, config.BuildVolumes...), binds...)
+	}
+
 	// If there are injections specified, override the original assemble script
 	// and wait till all injections are uploaded into the container that runs the
 	// assemble script.
@@ -714,15 +874,36 @@ func (builder *STI) Execute(command string, user string, config *api.Config) err
 
 	c := dockerpkg.StreamContainerIO(errReader, &errOutput, func(s string) { log.Info(s) })
 
+	usage := api.ResourceUsage{}
+	if command == constants.Assemble {
+		opts.ResourceUsage = &usage
+		opts.KeepFailedContainer = config.KeepFailedContainer || config.DebugShell
+	}
+
 	err := builder.docker.RunContainer(opts)
+	if command == constants.Assemble {
+		builder.result.BuildInfo.Stages = api.RecordStageResourceUsage(builder.result.BuildInfo.Stages, api.StageAssemble, usage)
+	}
 	if err != nil {
 		// Must wait for StreamContainerIO goroutine above to exit before reading errOutput.
 		<-c
 
+		e, ok := err.(s2ierr.ContainerError)
 		if isMissingRequirements(errOutput) {
+			// a layered build is performed instead
+			if ok && len(e.ContainerID) > 0 {
+				builder.docker.RemoveContainer(e.ContainerID)
+			}
 			err = errMissingRequirements
-		} else if e, ok := err.(s2ierr.ContainerError); ok {
-			err = s2ierr.NewContainerError(config.BuilderImage, e.ErrorCode, errOutput+e.Output)
+		} else if ok {
+			stateErr := s2ierr.NewContainerStateError(config.BuilderImage, e.ExitCode, errOutput+e.Output, e.OOMKilled).(s2ierr.ContainerError)
+			if len(e.ContainerID) > 0 {
+				builder.handleFailedContainer(config, opts, e.ContainerID)
+				if config.KeepFailedContainer {
+					stateErr.ContainerID = e.ContainerID
+				}
+			}
+			err = stateErr
 		}
 	}
 
@@ -759,6 +940,13 @@ func (builder *STI) initPostExecutorSteps() {
 				fs:      builder.fs,
 				tar:     builder.tar,
 			},
+			&verifyImageStep{
+				builder: builder,
+				docker:  builder.docker,
+			},
+			&storeArtifactsCacheStep{
+				builder: builder,
+			},
 			&reportSuccessStep{
 				builder: builder,
 			},
@@ -788,6 +976,16 @@ func (builder *STI) initPostExecutorSteps() {
 				docker:  builder.docker,
 				tar:     builder.tar,
 			},
+			&verifyImageStep{
+				builder: builder,
+				docker:  builder.docker,
+			},
+			// the builder container is committed to the cache image only once
+			// the image based on the runtime image was
+			&storeBuilderArtifactsStep{
+				builder: builder,
+				docker:  builder.docker,
+			},
 			&reportSuccessStep{
 				builder: builder,
 			},
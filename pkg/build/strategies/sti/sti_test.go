@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
 	"regexp/syntax"
@@ -13,6 +15,7 @@ import (
 	"github.com/openshift/source-to-image/pkg/api"
 	"github.com/openshift/source-to-image/pkg/api/constants"
 	"github.com/openshift/source-to-image/pkg/build"
+	"github.com/openshift/source-to-image/pkg/cache"
 	"github.com/openshift/source-to-image/pkg/docker"
 	s2ierr "github.com/openshift/source-to-image/pkg/errors"
 	"github.com/openshift/source-to-image/pkg/ignore"
@@ -460,6 +463,80 @@ func TestExists(t *testing.T) {
 	}
 }
 
+func TestArtifactsCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s2i-artifacts-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bh := testBuildHandler()
+	bh.config.WorkingDir = dir
+	bh.config.BuilderImage = "builder/image"
+	bh.config.Tag = "image/tag"
+	bh.config.Incremental = true
+	bh.config.IncrementalCacheDir = filepath.Join(dir, "cache")
+	bh.artifactsCache = cache.New(bh.config.IncrementalCacheDir)
+	bh.installedScripts = map[string]bool{constants.SaveArtifacts: true}
+	fd := bh.docker.(*docker.FakeDocker)
+	fd.CommitContainerResult = "new-image-id"
+
+	// the previous image is ignored when the artifacts are cached locally
+	bh.incrementalDocker.(*docker.FakeDocker).PullResult = true
+	if bh.Exists(bh.config) {
+		t.Errorf("Expected no cached artifacts before the first build")
+	}
+
+	// committing the image refreshes the cached artifacts from it
+	if err := bh.PostExecute("test-container-id", "cmd1"); err != nil {
+		t.Fatalf("Unexpected error from postExecute: %v", err)
+	}
+	if fd.RunContainerOpts.Image != "new-image-id" || fd.RunContainerOpts.Command != constants.SaveArtifacts {
+		t.Errorf("Expected save-artifacts to run in the new image, got %#v", fd.RunContainerOpts)
+	}
+	if !bh.Exists(bh.config) {
+		t.Fatalf("Expected the artifacts of the new image to be cached")
+	}
+
+	fd.RunContainerOpts = docker.RunContainerOptions{}
+	th := bh.tar.(*test.FakeTar)
+	if err := bh.Save(bh.config); err != nil {
+		t.Errorf("Unexpected error when restoring artifacts: %v", err)
+	}
+	if len(fd.RunContainerOpts.Image) > 0 {
+		t.Errorf("Expected the artifacts to be restored without running a container")
+	}
+	if th.ExtractTarDir != filepath.Join(dir, "upload", "artifacts") || th.ExtractTarReader == nil {
+		t.Errorf("ExtractTar was not called with the expected parameters.")
+	}
+}
+
+func TestExistsRuntimeImage(t *testing.T) {
+	for _, cacheImage := range []string{"", "app-cache"} {
+		bh := testBuildHandler()
+		bh.config.Incremental = true
+		bh.config.Tag = "app"
+		bh.config.RuntimeImage = "runtime-image"
+		bh.config.IncrementalCacheImage = cacheImage
+		bh.installedScripts = map[string]bool{constants.SaveArtifacts: true}
+		bh.incrementalDocker.(*docker.FakeDocker).PullResult = true
+		// the artifacts are saved from the cache image, as the previous
+		// application image was built from the runtime image
+		if incremental := bh.Exists(bh.config); incremental != (len(cacheImage) > 0) {
+			t.Errorf("%q: unexpected incremental result: %v", cacheImage, incremental)
+		}
+		if len(cacheImage) == 0 {
+			continue
+		}
+		if err := bh.Save(bh.config); err != nil {
+			t.Errorf("Unexpected error when saving artifacts: %v", err)
+		}
+		if image := bh.docker.(*docker.FakeDocker).RunContainerOpts.Image; image != cacheImage {
+			t.Errorf("Expected artifacts to be saved from %q, got %q", cacheImage, image)
+		}
+	}
+}
+
 func TestSaveArtifacts(t *testing.T) {
 	bh := testBuildHandler()
 	bh.config.WorkingDir = "/working-dir"
@@ -886,6 +963,34 @@ func TestExecuteOK(t *testing.T) {
 	}
 }
 
+func TestExecuteCacheMounts(t *testing.T) {
+	for _, command := range []string{constants.Assemble, constants.Usage} {
+		rh := newFakeBaseSTI()
+		rh.postExecutor = &FakeSTI{}
+		rh.config.WorkingDir = "/working-dir"
+		rh.config.BuilderImage = "test/image"
+		rh.config.BuildVolumes = []string{"/host:/container"}
+		rh.config.CacheMounts = api.CacheMountList{{Name: "maven", Destination: "/opt/app-root/src/.m2"}}
+		rh.config.CacheMountScope = fmt.Sprintf("sti-test-%d", os.Getpid())
+		fd := rh.docker.(*docker.FakeDocker)
+		defer os.Remove(filepath.Join(os.TempDir(), "s2i-cache-mounts", cache.VolumeName(rh.config.CacheMountScope, "maven")+".lock"))
+
+		if err := rh.Execute(command, "", rh.config); err != nil {
+			t.Fatalf("%s: unexpected error returned: %v", command, err)
+		}
+		expected := []string{"/host:/container"}
+		if command == constants.Assemble {
+			expected = append(expected, cache.VolumeName(rh.config.CacheMountScope, "maven")+":/opt/app-root/src/.m2")
+		}
+		if !reflect.DeepEqual(fd.RunContainerOpts.Binds, expected) {
+			t.Errorf("%s: expected binds %v, got %v", command, expected, fd.RunContainerOpts.Binds)
+		}
+		if !reflect.DeepEqual(rh.config.BuildVolumes, []string{"/host:/container"}) {
+			t.Errorf("%s: unexpected change of the build volumes %v", command, rh.config.BuildVolumes)
+		}
+	}
+}
+
 func TestExecuteRunContainerError(t *testing.T) {
 	rh := newFakeSTI(&FakeSTI{})
 	fd := rh.docker.(*docker.FakeDocker)
@@ -897,6 +1002,43 @@ func TestExecuteRunContainerError(t *testing.T) {
 	}
 }
 
+func TestExecuteKeepFailedContainer(t *testing.T) {
+	rh := newFakeSTI(&FakeSTI{})
+	rh.config.WorkingDir = "/working-dir"
+	rh.config.BuilderImage = "test/image"
+	rh.config.BuildVolumes = []string{"/host:/container"}
+	rh.config.KeepFailedContainer = true
+	fd := rh.docker.(*docker.FakeDocker)
+	fd.RunContainerError = s2ierr.ContainerError{ExitCode: 1, ContainerID: "0123456789abcdef"}
+
+	err := rh.Execute(constants.Assemble, "", rh.config)
+	e, ok := err.(s2ierr.ContainerError)
+	if !ok || e.ExitCode != 1 || !strings.Contains(e.Message, "test/image") {
+		t.Errorf("Expected a container error of the builder image, got %#v", err)
+	}
+	if !fd.RunContainerOpts.KeepFailedContainer {
+		t.Errorf("Expected the failed container to be kept")
+	}
+	if len(fd.RemoveContainerID) > 0 {
+		t.Errorf("Unexpected removal of the container %s", fd.RemoveContainerID)
+	}
+	if !rh.config.PreserveWorkingDir {
+		t.Errorf("Expected the working directory to be preserved")
+	}
+	expected := "docker run -it --rm --entrypoint /bin/sh -v /host:/container s2i-debug-0123456789ab"
+	if cmd := debugRunCommand(fd.RunContainerOpts, debugImageName(e.ContainerID)); cmd != expected {
+		t.Errorf("Expected the command %q, got %q", expected, cmd)
+	}
+
+	// the container of other scripts is never kept
+	fd.RunContainerOpts = docker.RunContainerOptions{}
+	fd.RunContainerError = nil
+	rh.Execute(constants.Usage, "", rh.config)
+	if fd.RunContainerOpts.KeepFailedContainer {
+		t.Errorf("Unexpected keeping of the container running %s", constants.Usage)
+	}
+}
+
 func TestExecuteErrorCreateTarFile(t *testing.T) {
 	rh := newFakeSTI(&FakeSTI{})
 	rh.tar.(*test.FakeTar).CreateTarError = errors.New("CreateTarError")
@@ -1,6 +1,7 @@
 package strategies
 
 import (
+	"sort"
 	"time"
 
 	"github.com/openshift/source-to-image/pkg/api"
@@ -13,6 +14,27 @@ import (
 	utilstatus "github.com/openshift/source-to-image/pkg/util/status"
 )
 
+// The names of the strategies a build can use.
+const (
+	strategyDockerfile = "dockerfile"
+	strategyOnBuild    = "onbuild"
+	strategySTI        = "sti"
+)
+
+// selectStrategy returns the name of the strategy building the config, with a
+// builder image which has ONBUILD instructions or not.
+func selectStrategy(config *api.Config, onBuild bool) string {
+	switch {
+	case len(config.AsDockerfile) != 0:
+		return strategyDockerfile
+	case onBuild && !config.BlockOnBuild:
+		// if we're blocking onbuild, just do a normal s2i build flow
+		// which won't do a docker build and invoke the onbuild commands
+		return strategyOnBuild
+	}
+	return strategySTI
+}
+
 // Strategy creates the appropriate build strategy for the provided config, using
 // the overrides provided. Not all strategies support all overrides.
 func Strategy(client docker.Client, config *api.Config, overrides build.Overrides) (build.Builder, api.BuildInfo, error) {
@@ -21,11 +43,16 @@ func Strategy(client docker.Client, config *api.Config, overrides build.Override
 	var err error
 
 	fileSystem := fs.NewFileSystem()
+	// the images are inspected and pulled once for the whole build
+	if overrides.Session == nil {
+		overrides.Session = docker.NewSession()
+	}
 
 	startTime := time.Now()
 
-	if len(config.AsDockerfile) != 0 {
-		builder, err = dockerfile.New(config, fileSystem)
+	// the builder image is not inspected by the dockerfile strategy
+	if selectStrategy(config, false) == strategyDockerfile {
+		builder, err = dockerfile.New(client, config, fileSystem, overrides)
 		if err != nil {
 			buildInfo.FailureReason = utilstatus.NewFailureReason(
 				utilstatus.ReasonGenericS2IBuildFailed,
@@ -33,12 +60,18 @@ func Strategy(client docker.Client, config *api.Config, overrides build.Override
 			)
 			return nil, buildInfo, err
 		}
-		return builder, buildInfo, nil
+		return sessionBuilder{builder, overrides.Session}, buildInfo, nil
 	}
 
-	dkr := docker.New(client, config.PullAuthentication)
+	dkr := docker.NewForConfig(client, config.PullAuthentication, config, overrides.Session)
+	pulledAhead, err := pullImages(client, dkr, config, overrides.Session, &buildInfo)
+	if err != nil {
+		return nil, buildInfo, err
+	}
 	image, err := docker.GetBuilderImage(dkr, config)
-	buildInfo.Stages = api.RecordStageAndStepInfo(buildInfo.Stages, api.StagePullImages, api.StepPullBuilderImage, startTime, time.Now())
+	if !pulledAhead {
+		buildInfo.Stages = api.RecordStageAndStepInfo(buildInfo.Stages, api.StagePullImages, api.StepPullBuilderImage, startTime, time.Now())
+	}
 	if err != nil {
 		buildInfo.FailureReason = utilstatus.NewFailureReason(
 			utilstatus.ReasonPullBuilderImageFailed,
@@ -56,9 +89,7 @@ func Strategy(client docker.Client, config *api.Config, overrides build.Override
 		return nil, buildInfo, err
 	}
 
-	// if we're blocking onbuild, just do a normal s2i build flow
-	// which won't do a docker build and invoke the onbuild commands
-	if image.OnBuild && !config.BlockOnBuild {
+	if selectStrategy(config, image.OnBuild) == strategyOnBuild {
 		builder, err = onbuild.New(client, config, fileSystem, overrides)
 		if err != nil {
 			buildInfo.FailureReason = utilstatus.NewFailureReason(
@@ -67,7 +98,7 @@ func Strategy(client docker.Client, config *api.Config, overrides build.Override
 			)
 			return nil, buildInfo, err
 		}
-		return builder, buildInfo, nil
+		return sessionBuilder{builder, overrides.Session}, buildInfo, nil
 	}
 
 	builder, err = sti.New(client, config, fileSystem, overrides)
@@ -78,5 +109,80 @@ func Strategy(client docker.Client, config *api.Config, overrides build.Override
 		)
 		return nil, buildInfo, err
 	}
-	return builder, buildInfo, err
+	return sessionBuilder{builder, overrides.Session}, buildInfo, nil
+}
+
+// pullImages pulls the builder image, along with the runtime image and the
+// image of the previous build if the build uses them, concurrently. The
+// images successfully pulled are only checked locally by the build afterwards.
+// It returns whether the images were pulled ahead, and the error of pulling the
+// builder or the runtime image, while a failed pull of the previous image is
+// retried once the build checks whether it can be incremental.
+func pullImages(client docker.Client, dkr docker.Docker, config *api.Config, session *docker.Session, buildInfo *api.BuildInfo) (bool, error) {
+	pulls := []docker.ImagePull{{Name: config.BuilderImage, Policy: config.BuilderPullPolicy, Docker: dkr}}
+	steps := []api.StepName{api.StepPullBuilderImage}
+	reasons := []api.StepFailureReason{utilstatus.ReasonPullBuilderImageFailed}
+	messages := []api.StepFailureMessage{utilstatus.ReasonMessagePullBuilderImageFailed}
+	if len(config.RuntimeImage) > 0 {
+		pulls = append(pulls, docker.ImagePull{Name: config.RuntimeImage, Policy: docker.RuntimeImagePullPolicy(config), Docker: docker.NewForConfig(client, config.RuntimeAuthentication, config, session)})
+		steps = append(steps, api.StepPullRuntimeImage)
+		reasons = append(reasons, utilstatus.ReasonPullRuntimeImageFailed)
+		messages = append(messages, utilstatus.ReasonMessagePullRuntimeImageFailed)
+	}
+	if previousImage := sti.PreviousImage(config); len(previousImage) > 0 {
+		policy := config.PreviousImagePullPolicy
+		if len(policy) == 0 {
+			policy = api.DefaultPreviousImagePullPolicy
+		}
+		pulls = append(pulls, docker.ImagePull{Name: previousImage, Policy: policy, Docker: docker.NewForConfig(client, config.IncrementalAuthentication, config, session)})
+		steps = append(steps, api.StepPullPreviousImage)
+		reasons = append(reasons, "")
+		messages = append(messages, "")
+	}
+	if len(config.BuilderPullPolicy) == 0 || len(pulls) == 1 {
+		// nothing to pull concurrently, or no policy to pull with
+		return false, nil
+	}
+
+	parallelism := config.PullParallelism
+	if parallelism == 0 {
+		parallelism = api.DefaultPullParallelism
+	}
+	results := docker.PullImages(pulls, parallelism)
+	// the duration of the stage ends with the step recorded last
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return results[order[a]].EndTime.Before(results[order[b]].EndTime)
+	})
+	for _, i := range order {
+		buildInfo.Stages = api.RecordStageAndStepInfo(buildInfo.Stages, api.StagePullImages, steps[i], results[i].StartTime, results[i].EndTime)
+	}
+	for i, r := range results {
+		if r.Error != nil && len(reasons[i]) > 0 {
+			buildInfo.FailureReason = utilstatus.NewFailureReason(reasons[i], messages[i])
+			return true, r.Error
+		}
+	}
+	return true, nil
+}
+
+// sessionBuilder adds what the Docker implementations recorded in the session
+// of the build to its result.
+type sessionBuilder struct {
+	build.Builder
+	session *docker.Session
+}
+
+// Build runs the build and records the retries of its Docker operations,
+// including the pulls ahead of it, and the images it pulled in its result.
+func (b sessionBuilder) Build(config *api.Config) (*api.Result, error) {
+	result, err := b.Builder.Build(config)
+	if result != nil {
+		result.Messages = append(result.Messages, b.session.RetryMessages()...)
+		result.PulledImages = b.session.PulledImages()
+	}
+	return result, err
 }
@@ -0,0 +1,135 @@
+package strategies
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	dockertypes "github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+
+	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/api/constants"
+	"github.com/openshift/source-to-image/pkg/build"
+	"github.com/openshift/source-to-image/pkg/docker"
+	dockertest "github.com/openshift/source-to-image/pkg/docker/test"
+	utilstatus "github.com/openshift/source-to-image/pkg/util/status"
+)
+
+func TestPullImages(t *testing.T) {
+	tests := map[string]struct {
+		config         *api.Config
+		expectedPulled []string
+		expectedSteps  []api.StepName
+		expectedReason api.StepFailureReason
+	}{
+		"builder only": {
+			config: &api.Config{BuilderImage: "builder", BuilderPullPolicy: api.PullIfNotPresent},
+		},
+		"runtime and previous": {
+			config: &api.Config{
+				BuilderImage:            "builder",
+				BuilderPullPolicy:       api.PullIfNotPresent,
+				RuntimeImage:            "runtime",
+				Incremental:             true,
+				IncrementalCacheImage:   "previous",
+				PreviousImagePullPolicy: api.PullAlways,
+			},
+			// the builder and runtime images are present locally
+			expectedPulled: []string{"previous:latest"},
+			expectedSteps:  []api.StepName{api.StepPullBuilderImage, api.StepPullRuntimeImage, api.StepPullPreviousImage},
+		},
+		"missing runtime": {
+			config: &api.Config{
+				BuilderImage:           "builder",
+				BuilderPullPolicy:      api.PullIfNotPresent,
+				RuntimeImage:           "missing",
+				RuntimeImagePullPolicy: api.PullNever,
+			},
+			expectedSteps:  []api.StepName{api.StepPullBuilderImage, api.StepPullRuntimeImage},
+			expectedReason: utilstatus.ReasonPullRuntimeImageFailed,
+		},
+		"missing previous": {
+			config: &api.Config{
+				BuilderImage:      "builder",
+				BuilderPullPolicy: api.PullIfNotPresent,
+				Incremental:       true,
+				Tag:               "missing",
+			},
+			// the failed pull is retried by the build
+			expectedSteps: []api.StepName{api.StepPullBuilderImage, api.StepPullPreviousImage},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := dockertest.NewFakeDockerClient()
+			for _, image := range []string{"builder", "runtime", "previous"} {
+				client.Images[image+":latest"] = dockertypes.ImageInspect{ID: "sha256:" + image, Config: &dockercontainer.Config{}}
+			}
+			// pull one image at a time, the fake client is not safe for concurrent use
+			tc.config.PullParallelism = 1
+			buildInfo := api.BuildInfo{}
+
+			session := docker.NewSession()
+			_, err := pullImages(client, docker.NewForConfig(client, api.AuthConfig{}, tc.config, session), tc.config, session, &buildInfo)
+			if (err != nil) != (len(tc.expectedReason) > 0) {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if buildInfo.FailureReason.Reason != tc.expectedReason {
+				t.Errorf("Expected the failure reason %q, got %q", tc.expectedReason, buildInfo.FailureReason.Reason)
+			}
+			if pulled := session.PulledImages(); !reflect.DeepEqual(pulled, tc.expectedPulled) {
+				t.Errorf("Expected the pulled images %v, got %v", tc.expectedPulled, pulled)
+			}
+			steps := []api.StepName{}
+			for _, stage := range buildInfo.Stages {
+				for _, step := range stage.Steps {
+					steps = append(steps, step.Name)
+				}
+			}
+			// the steps are recorded in the order the pulls ended
+			sort.Slice(steps, func(i, j int) bool { return steps[i] < steps[j] })
+			sort.Slice(tc.expectedSteps, func(i, j int) bool { return tc.expectedSteps[i] < tc.expectedSteps[j] })
+			if len(tc.expectedSteps) == 0 {
+				tc.expectedSteps = []api.StepName{}
+			}
+			if !reflect.DeepEqual(steps, tc.expectedSteps) {
+				t.Errorf("Expected the steps %v, got %v", tc.expectedSteps, steps)
+			}
+		})
+	}
+}
+
+func TestStrategyInspectsImagesOnce(t *testing.T) {
+	client := dockertest.NewFakeDockerClient()
+	client.Images["builder:latest"] = dockertypes.ImageInspect{
+		ID:     "sha256:builder",
+		Config: &dockercontainer.Config{Labels: map[string]string{constants.ScriptsURLLabel: "image:///usr/libexec/s2i"}},
+	}
+	config := &api.Config{
+		BuilderImage:      "builder",
+		BuilderPullPolicy: api.PullIfNotPresent,
+		Tag:               "app",
+		PullParallelism:   1,
+	}
+
+	builder, _, err := Strategy(client, config, build.Overrides{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// the fake connection of the container does not accept the uploaded
+	// scripts, so the build fails after it inspected the images
+	if _, err := builder.Build(config); err == nil {
+		t.Fatal("Expected the upload to the fake container to fail")
+	}
+	inspected := 0
+	for _, call := range client.Calls {
+		if call == "inspect_image" {
+			inspected++
+		}
+	}
+	if inspected != 1 {
+		t.Errorf("Expected the builder image to be inspected once, got the calls %v", client.Calls)
+	}
+}
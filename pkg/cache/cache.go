@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// artifactsFile is the name of the tarball produced by the save-artifacts
+	// script inside of a cache entry.
+	artifactsFile = "artifacts.tar"
+
+	// metadataFile is the name of the file describing a cache entry.
+	metadataFile = "entry.json"
+)
+
+// Entry describes the artifacts of an incremental build stored in the cache.
+type Entry struct {
+	// BuilderImage is the builder image the artifacts were saved with.
+	BuilderImage string `json:"builderImage"`
+
+	// Tag is the tag of the image the artifacts were saved from.
+	Tag string `json:"tag"`
+
+	// Created is the time the artifacts were stored.
+	Created time.Time `json:"created"`
+
+	// Size is the size of the artifacts tarball in bytes.
+	Size int64 `json:"-"`
+}
+
+// Cache stores the tarballs written by the save-artifacts script of
+// incremental builds in a local directory, keyed by builder image and tag.
+// It lets incremental builds run where the previous image is not available.
+type Cache struct {
+	dir string
+}
+
+// New returns a Cache storing the artifacts in dir.
+func New(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// path returns the directory of the cache entry for the builder image and tag.
+func (c *Cache) path(builderImage, tag string) string {
+	key := sha256.Sum256([]byte(builderImage + "\n" + tag))
+	return filepath.Join(c.dir, fmt.Sprintf("%x", key))
+}
+
+// Exists returns true if the cache holds artifacts for the builder image and
+// tag.
+func (c *Cache) Exists(builderImage, tag string) bool {
+	_, err := os.Stat(filepath.Join(c.path(builderImage, tag), artifactsFile))
+	return err == nil
+}
+
+// Open returns the artifacts tarball stored for the builder image and tag.
+func (c *Cache) Open(builderImage, tag string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(c.path(builderImage, tag), artifactsFile))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no artifacts cached for image %q built with %q", tag, builderImage)
+	}
+	return f, err
+}
+
+// Store replaces the artifacts stored for the builder image and tag with the
+// tarball read from r. The previous artifacts are kept if reading r fails.
+func (c *Cache) Store(builderImage, tag string, r io.Reader) error {
+	dir := c.path(builderImage, tag)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".artifacts-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	metadata, err := json.Marshal(Entry{BuilderImage: builderImage, Tag: tag, Created: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, metadataFile), metadata, 0600); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, artifactsFile))
+}
+
+// List returns the entries of the cache, most recently stored first.
+func (c *Cache) List() ([]Entry, error) {
+	dirs, err := ioutil.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		entry, err := readEntry(filepath.Join(c.dir, d.Name()))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Created.After(entries[j].Created) })
+	return entries, nil
+}
+
+// Prune removes the entries stored longer ago than maxAge, along with any
+// incomplete entry last modified longer ago than maxAge, and returns the
+// removed entries.
+func (c *Cache) Prune(maxAge time.Duration) ([]Entry, error) {
+	dirs, err := ioutil.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	removed := []Entry{}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		path := filepath.Join(c.dir, d.Name())
+		entry, readErr := readEntry(path)
+		if readErr == nil && time.Since(entry.Created) < maxAge {
+			continue
+		}
+		// leave the artifacts of a build which is still storing them
+		if readErr != nil && time.Since(d.ModTime()) < maxAge {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return removed, err
+		}
+		if readErr == nil {
+			removed = append(removed, entry)
+		}
+	}
+	return removed, nil
+}
+
+// readEntry reads the metadata of the cache entry in dir.
+func readEntry(dir string) (Entry, error) {
+	entry := Entry{}
+	data, err := ioutil.ReadFile(filepath.Join(dir, metadataFile))
+	if err != nil {
+		return entry, err
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, err
+	}
+	info, err := os.Stat(filepath.Join(dir, artifactsFile))
+	if err != nil {
+		return entry, err
+	}
+	entry.Size = info.Size()
+	return entry, nil
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreAndOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s2i-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := New(dir)
+	if c.Exists("builder", "app") {
+		t.Fatalf("Expected an empty cache")
+	}
+	if _, err := c.Open("builder", "app"); err == nil {
+		t.Errorf("Expected an error opening uncached artifacts")
+	}
+	for _, content := range []string{"first", "second"} {
+		if err := c.Store("builder", "app", strings.NewReader(content)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		r, err := c.Open("builder", "app")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || string(data) != content {
+			t.Errorf("Expected %q, got %q, %v", content, data, err)
+		}
+	}
+	if c.Exists("other-builder", "app") || c.Exists("builder", "other-app") {
+		t.Errorf("Expected the artifacts to be keyed by builder image and tag")
+	}
+
+	entries, err := c.List()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].BuilderImage != "builder" || entries[0].Tag != "app" || entries[0].Size != int64(len("second")) {
+		t.Errorf("Unexpected entries %#v", entries)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s2i-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := New(dir)
+	for _, tag := range []string{"a", "b"} {
+		if err := c.Store("builder", tag, strings.NewReader(tag)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	// an entry left behind by an interrupted build
+	incomplete := filepath.Join(dir, "incomplete")
+	if err := os.Mkdir(incomplete, 0700); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(incomplete, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := c.Prune(time.Hour)
+	if err != nil || len(removed) != 0 {
+		t.Errorf("Expected no entries to be removed, got %#v, %v", removed, err)
+	}
+	if _, err := os.Stat(incomplete); !os.IsNotExist(err) {
+		t.Errorf("Expected the incomplete entry to be removed")
+	}
+
+	removed, err = c.Prune(0)
+	if err != nil || len(removed) != 2 {
+		t.Errorf("Expected all entries to be removed, got %#v, %v", removed, err)
+	}
+	if c.Exists("builder", "a") || c.Exists("builder", "b") {
+		t.Errorf("Expected the cache to be empty")
+	}
+}
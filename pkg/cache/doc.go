@@ -0,0 +1,4 @@
+// Caches reused across builds: a local directory of the artifacts saved by
+// incremental builds, and the Docker volumes of cache mounts.
+
+package cache
@@ -0,0 +1,42 @@
+//go:build windows
+// +build windows
+
+package cache
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+// lockFileEx locks the first byte of the file with the flags.
+func lockFileEx(f *os.File, flags uint32) error {
+	overlapped := syscall.Overlapped{}
+	r, _, err := procLockFileEx.Call(f.Fd(), uintptr(flags), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// tryLockFile takes an exclusive lock on the file unless it is locked already.
+func tryLockFile(f *os.File) (bool, error) {
+	err := lockFileEx(f, lockfileExclusiveLock|lockfileFailImmediately)
+	if err == errorLockViolation {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// lockFile takes an exclusive lock on the file, waiting until it is released.
+func lockFile(f *os.File) error {
+	return lockFileEx(f, lockfileExclusiveLock)
+}
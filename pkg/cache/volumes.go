@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/api/constants"
+	"github.com/openshift/source-to-image/pkg/docker"
+	utillog "github.com/openshift/source-to-image/pkg/util/log"
+)
+
+var log = utillog.StderrLog
+
+// volumePrefix prefixes the names of the Docker volumes of cache mounts.
+const volumePrefix = "s2i-cache-"
+
+// VolumeName returns the name of the Docker volume of the named cache mount in
+// the scope.
+func VolumeName(scope, name string) string {
+	key := sha256.Sum256([]byte(scope))
+	return fmt.Sprintf("%s%x-%s", volumePrefix, key[:6], name)
+}
+
+// VolumeLabels returns the labels identifying the Docker volumes managed by
+// S2I, optionally restricted to the scope.
+func VolumeLabels(scope string) map[string]string {
+	labels := map[string]string{constants.CacheMountLabel: ""}
+	if len(scope) > 0 {
+		labels[constants.CacheMountScopeLabel] = scope
+	}
+	return labels
+}
+
+// MountVolumes creates the Docker volumes of the cache mounts in the scope if
+// they don't exist yet, and locks them so that concurrent builds on this host
+// don't use them at the same time. It returns the binds mounting the volumes
+// and a function releasing the locks, which must be called once the container
+// using the volumes exited.
+func MountVolumes(d docker.Docker, scope string, mounts api.CacheMountList) ([]string, func(), error) {
+	binds := []string{}
+	locks := []*os.File{}
+	release := func() {
+		for _, lock := range locks {
+			lock.Close()
+		}
+	}
+
+	// lock in a stable order, so that builds sharing several volumes don't
+	// deadlock
+	sorted := append(api.CacheMountList{}, mounts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	for _, m := range sorted {
+		name := VolumeName(scope, m.Name)
+		lock, err := LockVolume(name)
+		if err != nil {
+			release()
+			return nil, nil, fmt.Errorf("could not lock cache volume %s: %v", name, err)
+		}
+		locks = append(locks, lock)
+
+		labels := map[string]string{
+			constants.CacheMountLabel:      m.Name,
+			constants.CacheMountScopeLabel: scope,
+		}
+		if err := d.CreateVolume(name, labels); err != nil {
+			release()
+			return nil, nil, fmt.Errorf("could not create cache volume %s: %v", name, err)
+		}
+		log.V(2).Infof("Mounting cache volume %s at %s", name, m.Destination)
+		binds = append(binds, name+":"+m.Destination)
+	}
+	return binds, release, nil
+}
+
+// lockDir returns the directory of the files locking the volumes. It is
+// private to the user, as another user could otherwise hold the locks forever.
+// The locks only exclude the builds of the user on this host, even if the
+// Docker daemon is shared with other users or hosts.
+func lockDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "s2i", "cache-mounts")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("s2i-cache-mounts-%d", os.Getuid()))
+}
+
+// lockPath returns the path of the file locking the volume.
+func lockPath(volume string) string {
+	return filepath.Join(lockDir(), volume+".lock")
+}
+
+// openLock opens the file locking the volume.
+func openLock(volume string) (*os.File, error) {
+	path := lockPath(volume)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+}
+
+// LockVolume locks the volume, waiting for other builds using it to finish.
+// The lock is released by closing the returned file.
+func LockVolume(volume string) (*os.File, error) {
+	f, err := openLock(volume)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := tryLockFile(f)
+	if err == nil && !locked {
+		log.Infof("Waiting for another build using cache volume %s to finish", volume)
+		err = lockFile(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// TryLockVolume locks the volume unless another build uses it. The lock is
+// released by closing the returned file, which is nil if the volume is in use.
+func TryLockVolume(volume string) (*os.File, error) {
+	f, err := openLock(volume)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := tryLockFile(f)
+	if err != nil || !locked {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// PruneVolumes removes the Docker volumes of the cache mounts in the scope, or
+// in all scopes if it is empty. Volumes used by a build or a container are
+// skipped. It returns the names of the removed volumes.
+func PruneVolumes(d docker.Docker, scope string) ([]string, error) {
+	volumes, err := d.ListVolumes(VolumeLabels(scope))
+	if err != nil {
+		return nil, err
+	}
+	removed := []string{}
+	for _, v := range volumes {
+		lock, err := TryLockVolume(v.Name)
+		if err != nil {
+			return removed, err
+		}
+		if lock == nil {
+			log.V(1).Infof("Skipping cache volume %s used by a build", v.Name)
+			continue
+		}
+		err = d.RemoveVolume(v.Name)
+		lock.Close()
+		if err != nil {
+			// e.g. the volume is mounted into a container of another host
+			log.Warningf("Unable to remove cache volume %s: %v", v.Name, err)
+			continue
+		}
+		removed = append(removed, v.Name)
+	}
+	return removed, nil
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/api/constants"
+	"github.com/openshift/source-to-image/pkg/docker"
+)
+
+// removeLocks removes the lock files of the volumes.
+func removeLocks(d *docker.FakeDocker) {
+	for _, v := range d.Volumes {
+		os.Remove(lockPath(v.Name))
+	}
+}
+
+func TestMountVolumes(t *testing.T) {
+	scope := fmt.Sprintf("mount-test-%d", os.Getpid())
+	d := &docker.FakeDocker{}
+	defer removeLocks(d)
+	mounts := api.CacheMountList{{Name: "npm", Destination: "/opt/app-root/src/.npm"}, {Name: "m2", Destination: "/opt/app-root/src/.m2"}}
+
+	for i := 0; i < 2; i++ {
+		binds, release, err := MountVolumes(d, scope, mounts)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := []string{
+			VolumeName(scope, "m2") + ":/opt/app-root/src/.m2",
+			VolumeName(scope, "npm") + ":/opt/app-root/src/.npm",
+		}
+		if !reflect.DeepEqual(binds, expected) {
+			t.Errorf("Expected binds %v, got %v", expected, binds)
+		}
+
+		// the volumes are locked until released
+		if lock, err := TryLockVolume(VolumeName(scope, "m2")); lock != nil || err != nil {
+			t.Errorf("Expected the volume to be locked, got %v, %v", lock, err)
+		}
+		release()
+		lock, err := TryLockVolume(VolumeName(scope, "m2"))
+		if lock == nil || err != nil {
+			t.Fatalf("Expected the volume to be released, got %v", err)
+		}
+		lock.Close()
+	}
+
+	// the volumes are reused by the second build
+	if len(d.Volumes) != 2 {
+		t.Fatalf("Expected 2 volumes, got %#v", d.Volumes)
+	}
+	if labels := d.Volumes[0].Labels; labels[constants.CacheMountLabel] != "m2" || labels[constants.CacheMountScopeLabel] != scope {
+		t.Errorf("Unexpected volume labels %v", labels)
+	}
+	if VolumeName(scope, "m2") == VolumeName(scope+"-other", "m2") {
+		t.Errorf("Expected the volume names to depend on the scope")
+	}
+}
+
+func TestPruneVolumes(t *testing.T) {
+	scope := fmt.Sprintf("prune-test-%d", os.Getpid())
+	d := &docker.FakeDocker{}
+	defer func() {
+		for _, s := range []string{scope, scope + "-other", scope + "-busy"} {
+			os.Remove(lockPath(VolumeName(s, "pip")))
+		}
+	}()
+	mounts := api.CacheMountList{{Name: "pip", Destination: "/root/.cache/pip"}}
+	for _, s := range []string{scope, scope + "-other", scope + "-busy"} {
+		_, release, err := MountVolumes(d, s, mounts)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		release()
+	}
+	// a volume which is not managed by S2I
+	d.CreateVolume("unmanaged", nil)
+
+	removed, err := PruneVolumes(d, scope)
+	if err != nil || !reflect.DeepEqual(removed, []string{VolumeName(scope, "pip")}) {
+		t.Errorf("Expected the volume of the scope to be removed, got %v, %v", removed, err)
+	}
+
+	// volumes in use by a build are kept
+	lock, err := LockVolume(VolumeName(scope+"-busy", "pip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+	removed, err = PruneVolumes(d, "")
+	if err != nil || !reflect.DeepEqual(removed, []string{VolumeName(scope+"-other", "pip")}) {
+		t.Errorf("Expected the unused volumes to be removed, got %v, %v", removed, err)
+	}
+	if len(d.Volumes) != 2 {
+		t.Errorf("Expected the busy and unmanaged volumes to be kept, got %d volumes", len(d.Volumes))
+	}
+}
@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
+	units "github.com/docker/go-units"
 	"github.com/openshift/source-to-image/pkg/scm/git"
 	utillog "github.com/openshift/source-to-image/pkg/util/log"
 	"github.com/spf13/cobra"
@@ -20,6 +23,7 @@ import (
 	"github.com/openshift/source-to-image/pkg/run"
 	"github.com/openshift/source-to-image/pkg/tar"
 	"github.com/openshift/source-to-image/pkg/util"
+	"github.com/openshift/source-to-image/pkg/util/registries"
 	"github.com/openshift/source-to-image/pkg/version"
 )
 
@@ -33,6 +37,8 @@ func NewCmdBuild(cfg *api.Config) *cobra.Command {
 	useConfig := false
 	oldScriptsFlag := ""
 	oldDestination := ""
+	dryRun := ""
+	registriesConf := ""
 
 	var networkMode string
 
@@ -71,20 +77,82 @@ $ s2i build . centos/ruby-22-centos7 hello-world-app
 			}
 
 			if len(cfg.AsDockerfile) > 0 {
-				if cfg.RunImage {
-					fmt.Fprintln(os.Stderr, "ERROR: --run cannot be used with --as-dockerfile")
+				if cfg.RunImage && !cfg.BuildDockerfile {
+					fmt.Fprintln(os.Stderr, "ERROR: --run cannot be used with --as-dockerfile without --build-dockerfile")
 					return
 				}
-				if len(cfg.RuntimeImage) > 0 {
-					fmt.Fprintln(os.Stderr, "ERROR: --runtime-image cannot be used with --as-dockerfile")
+				if cfg.BuildDockerfile && len(cfg.Tag) == 0 {
+					fmt.Fprintln(os.Stderr, "ERROR: --build-dockerfile requires a tag")
+					return
+				}
+				if cfg.BuildDockerfile && cfg.BuildKit {
+					fmt.Fprintln(os.Stderr, "ERROR: --buildkit cannot be used with --build-dockerfile")
+					return
+				}
+			} else if cfg.BuildKit || cfg.BuildDockerfile || len(cfg.DockerfileContext) > 0 {
+				fmt.Fprintln(os.Stderr, "ERROR: --buildkit, --build-dockerfile and --dockerfile-context can only be used with --as-dockerfile")
+				return
+			}
+
+			if len(cfg.AsDockerfile) > 0 && (len(cfg.PostCommitCommand) > 0 || len(cfg.VerifyURL) > 0) {
+				fmt.Fprintln(os.Stderr, "ERROR: --post-commit-cmd and --verify-url cannot be used with --as-dockerfile")
+				return
+			}
+			if cfg.RemoveUnverifiedImage && len(cfg.PostCommitCommand) == 0 && len(cfg.VerifyURL) == 0 {
+				fmt.Fprintln(os.Stderr, "ERROR: --remove-unverified-image requires --post-commit-cmd or --verify-url")
+				return
+			}
+			if len(cfg.AsDockerfile) > 0 && (cfg.KeepFailedContainer || cfg.DebugShell) {
+				fmt.Fprintln(os.Stderr, "ERROR: --keep-failed-container and --debug-shell cannot be used with --as-dockerfile")
+				return
+			}
+
+			if len(dryRun) > 0 {
+				if dryRun != "text" && dryRun != "json" {
+					fmt.Fprintf(os.Stderr, "ERROR: invalid --dry-run output %q, must be text or json\n", dryRun)
+					return
+				}
+				if cfg.RunImage {
+					fmt.Fprintln(os.Stderr, "ERROR: --run cannot be used with --dry-run")
 					return
 				}
 			}
 
-			if cfg.Incremental && len(cfg.RuntimeImage) > 0 {
-				fmt.Fprintln(os.Stderr, "ERROR: Incremental build with runtime image isn't supported")
+			if cfg.PullParallelism < 1 {
+				fmt.Fprintln(os.Stderr, "ERROR: --pull-parallelism must be at least 1")
+				return
+			}
+
+			if cfg.DockerRetry.Retries < 0 {
+				fmt.Fprintln(os.Stderr, "ERROR: --docker-retries must not be negative")
+				return
+			}
+			if cfg.DockerRetry.Delay <= 0 {
+				fmt.Fprintln(os.Stderr, "ERROR: --docker-retry-delay must be positive")
+				return
+			}
+
+			if cfg.IgnoreSubmodules && !cfg.GitSubmodules.IsEmpty() {
+				fmt.Fprintln(os.Stderr, "ERROR: --submodule-include and --submodule-exclude cannot be used with --ignore-submodules")
+				return
+			}
+
+			if cfg.ScriptDownload.Offline && len(cfg.ScriptDownload.CacheDir) == 0 {
+				fmt.Fprintln(os.Stderr, "ERROR: --scripts-offline requires --scripts-cache-dir")
 				return
 			}
+
+			if cfg.IncludeUncommitted {
+				if cfg.ForceCopy {
+					fmt.Fprintln(os.Stderr, "ERROR: --include-uncommitted cannot be used with --copy")
+					return
+				}
+				if len(ref) > 0 {
+					fmt.Fprintln(os.Stderr, "ERROR: --include-uncommitted cannot be used with --ref")
+					return
+				}
+			}
+
 			//set default image pull policy
 			if len(cfg.BuilderPullPolicy) == 0 {
 				cfg.BuilderPullPolicy = api.DefaultBuilderPullPolicy
@@ -105,22 +173,36 @@ $ s2i build . centos/ruby-22-centos7 hello-world-app
 				return
 			}
 
-			// Persists the current command line options and config into .s2ifile
-			if useConfig {
+			// Persists the current command line options and config into .s2ifile,
+			// unless the build only prints its plan
+			if useConfig && len(dryRun) == 0 {
 				config.Save(cfg, cmd)
 			}
 
+			if len(registriesConf) > 0 {
+				rules, err := registries.Load(registriesConf)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+					return
+				}
+				cfg.RegistryRules = rules
+			}
+
 			// Attempt to read the .dockercfg and extract the authentication for
-			// docker pull
+			// docker pull, from the registries the images are pulled from
 			if r, err := os.Open(cfg.DockerCfgPath); err == nil {
 				defer r.Close()
 				auths := docker.LoadImageRegistryAuth(r)
-				cfg.PullAuthentication = docker.GetImageRegistryAuth(auths, cfg.BuilderImage)
+				authFor := func(image string) api.AuthConfig {
+					location, _ := registries.Resolve(cfg.RegistryRules, image)
+					return docker.GetImageRegistryAuth(auths, location)
+				}
+				cfg.PullAuthentication = authFor(cfg.BuilderImage)
 				if cfg.Incremental {
-					cfg.IncrementalAuthentication = docker.GetImageRegistryAuth(auths, cfg.Tag)
+					cfg.IncrementalAuthentication = authFor(util.FirstNonEmpty(cfg.IncrementalCacheImage, cfg.Tag))
 				}
 				if len(cfg.RuntimeImage) > 0 {
-					cfg.RuntimeAuthentication = docker.GetImageRegistryAuth(auths, cfg.RuntimeImage)
+					cfg.RuntimeAuthentication = authFor(cfg.RuntimeImage)
 				}
 			}
 
@@ -153,7 +235,7 @@ $ s2i build . centos/ruby-22-centos7 hello-world-app
 				log.Fatal(err)
 			}
 
-			if len(cfg.AsDockerfile) == 0 {
+			if len(cfg.AsDockerfile) == 0 || cfg.BuildDockerfile {
 				d := docker.New(client, cfg.PullAuthentication)
 				err := d.CheckReachable()
 				if err != nil {
@@ -161,20 +243,41 @@ $ s2i build . centos/ruby-22-centos7 hello-world-app
 				}
 			}
 
+			if len(dryRun) > 0 {
+				plan, err := strategies.Plan(client, cfg)
+				s2ierr.CheckError(err)
+				if dryRun == "json" {
+					data, err := json.MarshalIndent(plan, "", "  ")
+					s2ierr.CheckError(err)
+					fmt.Println(string(data))
+				} else {
+					fmt.Print(describe.Plan(plan))
+				}
+				return
+			}
+
 			log.V(2).Infof("\n%s\n", describe.Config(client, cfg))
 
 			builder, _, err := strategies.Strategy(client, cfg, build.Overrides{})
 			s2ierr.CheckError(err)
 			result, err := builder.Build(cfg)
+			// the sources of the scripts explain why a build failed to install them
+			if result != nil && len(result.Scripts) > 0 {
+				log.V(1).Infof("\n%s\n", describe.InstalledScripts(result.Scripts))
+			}
 			if err != nil {
 				log.V(0).Infof("Build failed")
 				s2ierr.CheckError(err)
 			} else {
-				if len(cfg.AsDockerfile) > 0 {
-					log.V(0).Infof("Application dockerfile generated in %s", cfg.AsDockerfile)
-				} else {
+				switch {
+				case len(cfg.AsDockerfile) == 0 || cfg.BuildDockerfile:
 					log.V(0).Infof("Build completed successfully")
-
+				case cfg.DockerfileContext == "-":
+					log.V(0).Infof("Application build context written to the standard output")
+				case len(cfg.DockerfileContext) > 0:
+					log.V(0).Infof("Application build context written to %s", cfg.DockerfileContext)
+				default:
+					log.V(0).Infof("Application dockerfile generated in %s", cfg.AsDockerfile)
 				}
 			}
 
@@ -182,6 +285,15 @@ $ s2i build . centos/ruby-22-centos7 hello-world-app
 				log.V(1).Infof(message)
 			}
 
+			for _, stage := range result.BuildInfo.Stages {
+				if u := stage.ResourceUsage; u != nil {
+					log.V(1).Infof("Stage %s used %s of memory at peak, %s of CPU time, %s read, %s written, %s received and %s sent",
+						stage.Name, units.BytesSize(float64(u.PeakMemoryBytes)), time.Duration(u.CPUTimeNanoseconds),
+						units.BytesSize(float64(u.BlockIOReadBytes)), units.BytesSize(float64(u.BlockIOWriteBytes)),
+						units.BytesSize(float64(u.NetworkReceivedBytes)), units.BytesSize(float64(u.NetworkSentBytes)))
+				}
+			}
+
 			if cfg.RunImage {
 				runner := run.New(client, cfg)
 				err = runner.Run(cfg)
@@ -191,9 +303,27 @@ $ s2i build . centos/ruby-22-centos7 hello-world-app
 	}
 
 	cmdutil.AddCommonFlags(buildCmd, cfg)
+	cmdutil.AddCGroupLimitsFlags(buildCmd, cfg)
+	cmdutil.AddGitAuthFlags(buildCmd, cfg)
 
 	buildCmd.Flags().BoolVar(&(cfg.RunImage), "run", false, "Run resulting image as part of invocation of this command")
+	buildCmd.Flags().StringVar(&(dryRun), "dry-run", "", "Print what the build would use, as text or json, without running it")
+	buildCmd.Flags().Lookup("dry-run").NoOptDefVal = "text"
+	buildCmd.Flags().BoolVar(&(cfg.KeepFailedContainer), "keep-failed-container", false, "Keep the container which ran a failed assemble script, along with the working directory with the sources and scripts it had")
+	buildCmd.Flags().BoolVar(&(cfg.DebugShell), "debug-shell", false, "Start an interactive shell in an image committed from the container which ran a failed assemble script")
+	buildCmd.Flags().IntVar(&(cfg.PullParallelism), "pull-parallelism", api.DefaultPullParallelism, "Specify how many of the builder, runtime and previous images are pulled concurrently")
+	if cfg.DockerRetry == nil {
+		cfg.DockerRetry = &api.RetryPolicy{}
+	}
+	buildCmd.Flags().IntVar(&(cfg.DockerRetry.Retries), "docker-retries", docker.DefaultPullRetryCount, "Specify how many times a Docker operation failing with a transient error is retried")
+	buildCmd.Flags().DurationVar(&(cfg.DockerRetry.Delay), "docker-retry-delay", docker.DefaultPullRetryDelay, "Specify the delay between the retries of a Docker operation")
+	buildCmd.Flags().BoolVar(&(cfg.DockerRetry.Backoff), "docker-retry-backoff", false, "Double the delay with each retry of a Docker operation, up to a minute")
+	buildCmd.Flags().StringVar(&(registriesConf), "registries-conf", "", "Specify a file of rules in the registries.conf format rewriting, blocking or marking insecure the registries the images are pulled from")
 	buildCmd.Flags().BoolVar(&(cfg.IgnoreSubmodules), "ignore-submodules", false, "Ignore all git submodules when cloning application repository")
+	buildCmd.Flags().StringSliceVar(&(cfg.GitSubmodules.Include), "submodule-include", []string{}, "Specify a comma-separated list of paths of the git submodules which are updated (default: all submodules)")
+	buildCmd.Flags().StringSliceVar(&(cfg.GitSubmodules.Exclude), "submodule-exclude", []string{}, "Specify a comma-separated list of paths of the git submodules which are not updated")
+	buildCmd.Flags().StringSliceVar(&(cfg.GitLFS.Include), "git-lfs-include", []string{}, "Specify a comma-separated list of paths whose Git LFS objects are fetched (default: all paths)")
+	buildCmd.Flags().StringSliceVar(&(cfg.GitLFS.Exclude), "git-lfs-exclude", []string{}, "Specify a comma-separated list of paths whose Git LFS objects are not fetched")
 	buildCmd.Flags().VarP(&(cfg.Environment), "env", "e", "Specify an single environment variable in NAME=VALUE format")
 	buildCmd.Flags().StringVarP(&(ref), "ref", "r", "", "Specify a ref to check-out")
 	buildCmd.Flags().StringVarP(&(cfg.AssembleUser), "assemble-user", "", "", "Specify the user to run assemble with")
@@ -202,6 +332,10 @@ $ s2i build . centos/ruby-22-centos7 hello-world-app
 	buildCmd.Flags().StringVarP(&(cfg.ExcludeRegExp), "exclude", "", tar.DefaultExclusionPattern.String(), "Regular expression for selecting files from the source tree to exclude from the build, where the default excludes the '.git' directory (see https://golang.org/pkg/regexp for syntax, but note that \"\" will be interpreted as allow all files and exclude no files)")
 	buildCmd.Flags().StringVar(&(cfg.ImageScriptsURL), "image-scripts-url", "image:///usr/libexec/s2i", "Specify a URL containing the default assemble and run scripts for the builder image")
 	buildCmd.Flags().StringVarP(&(cfg.ScriptsURL), "scripts-url", "s", "", "Specify a URL for the assemble, assemble-runtime and run scripts")
+	buildCmd.Flags().Var(&(cfg.ScriptDownload.Auth), "scripts-auth", "Specify the credentials for downloading scripts from a host in host=HOST,username=USER,password-file=PATH or host=HOST,token-file=PATH format")
+	buildCmd.Flags().StringVar(&(cfg.ScriptDownload.CacheDir), "scripts-cache-dir", "", "Specify a directory in which scripts downloaded over HTTP(S) are cached and revalidated")
+	buildCmd.Flags().IntVar(&(cfg.ScriptDownload.Retries), "scripts-retries", 0, "Specify how many times a failed scripts download is retried")
+	buildCmd.Flags().BoolVar(&(cfg.ScriptDownload.Offline), "scripts-offline", false, "Use only the scripts in --scripts-cache-dir instead of downloading them")
 	buildCmd.Flags().StringVar(&(oldScriptsFlag), "scripts", "", "DEPRECATED: Specify a URL for the assemble and run scripts")
 	buildCmd.Flags().BoolVar(&(useConfig), "use-config", false, "Store command line options to .s2ifile")
 	buildCmd.Flags().StringVarP(&(cfg.EnvironmentFile), "environment-file", "E", "", "Specify the path to the file with environment")
@@ -210,14 +344,20 @@ $ s2i build . centos/ruby-22-centos7 hello-world-app
 	buildCmd.Flags().VarP(&(cfg.AllowedUIDs), "allowed-uids", "u", "Specify a range of allowed user ids for the builder and runtime images")
 	buildCmd.Flags().VarP(&(cfg.Injections), "inject", "i", "Specify a directory to inject into the assemble container")
 	buildCmd.Flags().StringArrayVarP(&(cfg.BuildVolumes), "volume", "v", []string{}, "Specify a volume to mount into the assemble container")
+	buildCmd.Flags().Var(&(cfg.CacheMounts), "cache-mount", "Specify a cache volume to mount into the assemble container in name:/container/path format")
+	buildCmd.Flags().StringVar(&(cfg.CacheMountScope), "cache-mount-scope", "", "Specify the scope the cache volumes are shared in, e.g. a project name (default: the builder image)")
 	buildCmd.Flags().StringSliceVar(&(cfg.DropCapabilities), "cap-drop", []string{}, "Specify a comma-separated list of capabilities to drop when running Docker containers")
 	buildCmd.Flags().StringVarP(&(oldDestination), "location", "l", "",
 		"DEPRECATED: Specify a destination location for untar operation")
 	buildCmd.Flags().BoolVarP(&(cfg.ForceCopy), "copy", "c", false, "Use local file system copy instead of git cloning the source url")
+	buildCmd.Flags().BoolVar(&(cfg.IncludeUncommitted), "include-uncommitted", false, "Build the working tree of a local git repository, including uncommitted changes and untracked files which are not ignored, instead of its last commit")
 	buildCmd.Flags().StringVar(&(cfg.RuntimeImage), "runtime-image", "", "Image that will be used as the base for the runtime image")
 	buildCmd.Flags().VarP(&(cfg.RuntimeArtifacts), "runtime-artifact", "a", "Specify a file or directory to be copied from the builder to the runtime image")
 	buildCmd.Flags().StringVar(&(networkMode), "network", "", "Specify the default Docker Network name to be used in build process")
-	buildCmd.Flags().StringVarP(&(cfg.AsDockerfile), "as-dockerfile", "", "", "EXPERIMENTAL: Output a Dockerfile to this path instead of building a new image")
+	buildCmd.Flags().StringVarP(&(cfg.AsDockerfile), "as-dockerfile", "", "", "EXPERIMENTAL: Output a Dockerfile to this path instead of building a new image, or its build context to the standard output with '-'")
+	buildCmd.Flags().StringVar(&(cfg.DockerfileContext), "dockerfile-context", "", "Write the build context of the Dockerfile written with --as-dockerfile as a tar archive to this path, or to the standard output with '-'")
+	buildCmd.Flags().BoolVar(&(cfg.BuildDockerfile), "build-dockerfile", false, "Build the Dockerfile written with --as-dockerfile with the Docker daemon")
+	buildCmd.Flags().BoolVar(&(cfg.BuildKit), "buildkit", false, "Mount injections as secrets and cache mounts as caches with BuildKit in the Dockerfile written with --as-dockerfile")
 	buildCmd.Flags().BoolVarP(&(cfg.KeepSymlinks), "keep-symlinks", "", false, "When using '--copy', copy symlinks as symlinks. Default behavior is to follow symlinks and copy files by content")
 	buildCmd.Flags().StringArrayVar(&cfg.AddHost, "add-host", []string{}, "Specify additional entries to add to the /etc/hosts in the assemble container, multiple --add-host can be used to add multiple entries")
 	return buildCmd
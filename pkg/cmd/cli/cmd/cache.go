@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/cache"
+	"github.com/openshift/source-to-image/pkg/docker"
+	s2ierr "github.com/openshift/source-to-image/pkg/errors"
+)
+
+// NewCmdCache implements the S2I cli cache command, which manages the
+// directory used by --incremental-cache-dir and the volumes of --cache-mount.
+func NewCmdCache(cfg *api.Config) *cobra.Command {
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the build caches",
+		Long: "Manage the local directory in which incremental builds using --incremental-cache-dir " +
+			"store the artifacts saved by the save-artifacts script, and the cache volumes of --cache-mount.",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	cacheCmd.AddCommand(newCmdCacheExport())
+	cacheCmd.AddCommand(newCmdCacheImport())
+	cacheCmd.AddCommand(newCmdCachePrune(cfg))
+	return cacheCmd
+}
+
+// addCacheDirFlag adds the flag selecting the cache directory to c.
+func addCacheDirFlag(c *cobra.Command, dir *string) {
+	c.Flags().StringVar(dir, "cache-dir", "", "Specify the incremental artifacts cache directory")
+}
+
+// checkCacheDir reports an error if the cache directory was not specified.
+func checkCacheDir(dir string) bool {
+	if len(dir) == 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: --cache-dir is required")
+		return false
+	}
+	return true
+}
+
+func newCmdCacheExport() *cobra.Command {
+	var dir, output string
+	exportCmd := &cobra.Command{
+		Use:   "export <builder image> <tag>",
+		Short: "Export the cached artifacts of an image",
+		Long:  "Write the cached artifacts of the image built with the builder image to a tar archive.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 2 {
+				cmd.Help()
+				return
+			}
+			if !checkCacheDir(dir) {
+				return
+			}
+			r, err := cache.New(dir).Open(args[0], args[1])
+			s2ierr.CheckError(err)
+			defer r.Close()
+
+			var w io.Writer = os.Stdout
+			if len(output) > 0 && output != "-" {
+				f, err := os.Create(output)
+				s2ierr.CheckError(err)
+				defer f.Close()
+				w = f
+			}
+			_, err = io.Copy(w, r)
+			s2ierr.CheckError(err)
+		},
+	}
+	addCacheDirFlag(exportCmd, &dir)
+	exportCmd.Flags().StringVarP(&output, "output", "o", "", "Specify the file the artifacts are written to (default: standard output)")
+	return exportCmd
+}
+
+func newCmdCacheImport() *cobra.Command {
+	var dir string
+	importCmd := &cobra.Command{
+		Use:   "import <builder image> <tag> [<file>]",
+		Short: "Import the artifacts of an image into the cache",
+		Long: "Store a tar archive, as written by the save-artifacts script or 'cache export', as the " +
+			"cached artifacts of the image built with the builder image. The archive is read from the " +
+			"standard input if no file is given.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 2 && len(args) != 3 {
+				cmd.Help()
+				return
+			}
+			if !checkCacheDir(dir) {
+				return
+			}
+			var r io.Reader = os.Stdin
+			if len(args) == 3 && args[2] != "-" {
+				f, err := os.Open(args[2])
+				s2ierr.CheckError(err)
+				defer f.Close()
+				r = f
+			}
+			s2ierr.CheckError(cache.New(dir).Store(args[0], args[1], r))
+		},
+	}
+	addCacheDirFlag(importCmd, &dir)
+	return importCmd
+}
+
+func newCmdCachePrune(cfg *api.Config) *cobra.Command {
+	var dir, scope string
+	var maxAge time.Duration
+	var all, volumes bool
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove old artifacts and cache volumes",
+		Long: "Remove the cached artifacts which were stored longer ago than --older-than, " +
+			"and with --volumes the cache volumes of --cache-mount which are not in use.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(dir) == 0 && !volumes {
+				fmt.Fprintln(os.Stderr, "ERROR: --cache-dir or --volumes is required")
+				return
+			}
+			if len(dir) > 0 {
+				if all {
+					maxAge = 0
+				}
+				removed, err := cache.New(dir).Prune(maxAge)
+				for _, entry := range removed {
+					fmt.Printf("Removed artifacts of %s built with %s (%d bytes)\n", entry.Tag, entry.BuilderImage, entry.Size)
+				}
+				s2ierr.CheckError(err)
+			}
+			if volumes {
+				client, err := docker.NewEngineAPIClient(cfg.DockerConfig)
+				s2ierr.CheckError(err)
+				removed, err := cache.PruneVolumes(docker.New(client, api.AuthConfig{}), scope)
+				for _, name := range removed {
+					fmt.Printf("Removed cache volume %s\n", name)
+				}
+				s2ierr.CheckError(err)
+			}
+		},
+	}
+	addCacheDirFlag(pruneCmd, &dir)
+	pruneCmd.Flags().DurationVar(&maxAge, "older-than", 7*24*time.Hour, "Remove the artifacts stored longer ago than this duration")
+	pruneCmd.Flags().BoolVar(&all, "all", false, "Remove all cached artifacts")
+	pruneCmd.Flags().BoolVar(&volumes, "volumes", false, "Remove the cache volumes which are not in use")
+	pruneCmd.Flags().StringVar(&scope, "scope", "", "Only remove the cache volumes of this scope, e.g. a builder image or project name (default: all scopes)")
+	return pruneCmd
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -12,10 +13,12 @@ import (
 	cmdutil "github.com/openshift/source-to-image/pkg/cmd/cli/util"
 	"github.com/openshift/source-to-image/pkg/docker"
 	s2ierr "github.com/openshift/source-to-image/pkg/errors"
+	"github.com/openshift/source-to-image/pkg/util/registries"
 )
 
 // NewCmdRebuild implements the S2i cli rebuild command.
 func NewCmdRebuild(cfg *api.Config) *cobra.Command {
+	registriesConf := ""
 	buildCmd := &cobra.Command{
 		Use:   "rebuild <image> [<new-tag>]",
 		Short: "Rebuild an existing image",
@@ -29,14 +32,27 @@ func NewCmdRebuild(cfg *api.Config) *cobra.Command {
 				return
 			}
 
+			if len(registriesConf) > 0 {
+				rules, err := registries.Load(registriesConf)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+					return
+				}
+				cfg.RegistryRules = rules
+			}
+
 			var auths *docker.AuthConfigurations
 			r, err := os.Open(cfg.DockerCfgPath)
 			if err == nil {
 				defer r.Close()
 				auths = docker.LoadImageRegistryAuth(r)
 			}
+			authFor := func(image string) api.AuthConfig {
+				location, _ := registries.Resolve(cfg.RegistryRules, image)
+				return docker.GetImageRegistryAuth(auths, location)
+			}
 
-			cfg.PullAuthentication = docker.GetImageRegistryAuth(auths, cfg.Tag)
+			cfg.PullAuthentication = authFor(cfg.Tag)
 
 			if len(cfg.BuilderPullPolicy) == 0 {
 				cfg.BuilderPullPolicy = api.DefaultBuilderPullPolicy
@@ -47,7 +63,7 @@ func NewCmdRebuild(cfg *api.Config) *cobra.Command {
 
 			client, err := docker.NewEngineAPIClient(cfg.DockerConfig)
 			s2ierr.CheckError(err)
-			dkr := docker.New(client, cfg.PullAuthentication)
+			dkr := docker.NewForConfig(client, cfg.PullAuthentication, cfg, nil)
 			pr, err := docker.GetRebuildImage(dkr, cfg)
 			s2ierr.CheckError(err)
 			err = build.GenerateConfigFromLabels(cfg, pr)
@@ -57,13 +73,16 @@ func NewCmdRebuild(cfg *api.Config) *cobra.Command {
 				cfg.Tag = args[1]
 			}
 
-			cfg.PullAuthentication = docker.GetImageRegistryAuth(auths, cfg.BuilderImage)
+			cfg.PullAuthentication = authFor(cfg.BuilderImage)
 
 			log.V(2).Infof("\n%s\n", describe.Config(client, cfg))
 
 			builder, _, err := strategies.Strategy(client, cfg, build.Overrides{})
 			s2ierr.CheckError(err)
 			result, err := builder.Build(cfg)
+			if result != nil && len(result.Scripts) > 0 {
+				log.V(1).Infof("\n%s\n", describe.InstalledScripts(result.Scripts))
+			}
 			s2ierr.CheckError(err)
 
 			for _, message := range result.Messages {
@@ -73,5 +92,8 @@ func NewCmdRebuild(cfg *api.Config) *cobra.Command {
 	}
 
 	cmdutil.AddCommonFlags(buildCmd, cfg)
+	cmdutil.AddCGroupLimitsFlags(buildCmd, cfg)
+	cmdutil.AddGitAuthFlags(buildCmd, cfg)
+	buildCmd.Flags().StringVar(&(registriesConf), "registries-conf", "", "Specify a file of rules in the registries.conf format rewriting, blocking or marking insecure the registries the images are pulled from")
 	return buildCmd
 }
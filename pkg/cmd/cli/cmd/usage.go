@@ -51,5 +51,6 @@ func NewCmdUsage(cfg *api.Config) *cobra.Command {
 	usageCmd.Flags().StringVarP(&(oldDestination), "location", "l", "",
 		"Specify a destination location for untar operation")
 	cmdutil.AddCommonFlags(usageCmd, cfg)
+	cmdutil.AddCGroupLimitsFlags(usageCmd, cfg)
 	return usageCmd
 }
@@ -4,10 +4,14 @@ import (
 	"flag"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
+	units "github.com/docker/go-units"
 	log "k8s.io/klog"
 
 	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/util/cgroups"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 )
@@ -18,10 +22,22 @@ func AddCommonFlags(c *cobra.Command, cfg *api.Config) {
 		"Operate quietly. Suppress all non-error output.")
 	c.Flags().BoolVar(&(cfg.Incremental), "incremental", false,
 		"Perform an incremental build")
+	c.Flags().StringVar(&(cfg.IncrementalCacheDir), "incremental-cache-dir", "",
+		"Specify a directory in which the artifacts of incremental builds are cached instead of being saved from the previous image")
+	c.Flags().StringVar(&(cfg.IncrementalCacheImage), "incremental-cache-image", "",
+		"Specify the image to which the builder container of incremental builds with a runtime image is committed")
 	c.Flags().BoolVar(&(cfg.RemovePreviousImage), "rm", false,
 		"Remove the previous image during incremental builds")
 	c.Flags().StringVar(&(cfg.CallbackURL), "callback-url", "",
 		"Specify a URL to invoke via HTTP POST upon build completion")
+	c.Flags().StringVar(&(cfg.PostCommitCommand), "post-commit-cmd", "",
+		"Specify a shell command run in a container of the committed image to verify it")
+	c.Flags().StringVar(&(cfg.VerifyURL), "verify-url", "",
+		"Specify an HTTP URL polled while the committed image runs, to verify it")
+	c.Flags().DurationVar(&(cfg.VerifyTimeout), "verify-timeout", time.Minute,
+		"Specify how long the URL of --verify-url is polled for")
+	c.Flags().BoolVar(&(cfg.RemoveUnverifiedImage), "remove-unverified-image", false,
+		"Remove the committed image if its verification failed")
 	c.Flags().VarP(&(cfg.BuilderPullPolicy), "pull-policy", "p",
 		"Specify when to pull the builder image (always, never or if-not-present)")
 	c.Flags().Var(&(cfg.PreviousImagePullPolicy), "incremental-pull-policy",
@@ -36,6 +52,100 @@ func AddCommonFlags(c *cobra.Command, cfg *api.Config) {
 		"Specify a destination location for untar operation")
 }
 
+// AddGitAuthFlags adds the flags configuring the authentication used when
+// cloning the application repository and its submodules.
+func AddGitAuthFlags(c *cobra.Command, cfg *api.Config) {
+	c.Flags().StringVar(&(cfg.GitAuthentication.SSHPrivateKey), "git-ssh-key", "",
+		"Specify the path to the SSH private key used to clone the source repository")
+	c.Flags().StringVar(&(cfg.GitAuthentication.SSHKnownHosts), "git-known-hosts", "",
+		"Specify the path to the known_hosts file used to verify SSH git servers")
+	c.Flags().StringVar(&(cfg.GitAuthentication.SSHHostKeyPolicy), "git-host-key-policy", "",
+		"Specify how unknown SSH host keys are handled (yes, no or accept-new)")
+	c.Flags().StringVar(&(cfg.GitAuthentication.Username), "git-username", "",
+		"Specify the user name used to clone HTTP(S) git repositories")
+	c.Flags().StringVar(&(cfg.GitAuthentication.PasswordFile), "git-password-file", "",
+		"Specify the path to a file with the password or token used to clone HTTP(S) git repositories")
+	c.Flags().StringVar(&(cfg.GitAuthentication.NetrcFile), "git-netrc", "",
+		"Specify the path to a .netrc file with per-host credentials for HTTP(S) git repositories")
+	c.Flags().StringVar(&(cfg.GitAuthentication.CABundle), "git-ca-bundle", "",
+		"Specify the path to a PEM bundle of certificate authorities trusted for HTTPS git repositories")
+}
+
+// AddCGroupLimitsFlags adds the flags limiting the resources of the containers
+// run by S2I.
+func AddCGroupLimitsFlags(c *cobra.Command, cfg *api.Config) {
+	if cfg.CGroupLimits == nil {
+		cfg.CGroupLimits = &api.CGroupLimits{}
+	}
+	limits := cfg.CGroupLimits
+	c.Flags().Var((*bytesValue)(&limits.MemoryLimitBytes), "memory",
+		"Specify the memory limit of the containers, e.g. 512m")
+	c.Flags().Var((*bytesValue)(&limits.MemorySwap), "memory-swap",
+		"Specify the limit of the memory plus swap of the containers, or -1 for unlimited swap")
+	c.Flags().Int64Var(&limits.CPUShares, "cpu-shares", 0,
+		"Specify the relative CPU weight of the containers")
+	c.Flags().Int64Var(&limits.CPUPeriod, "cpu-period", 0,
+		"Specify the CPU period of the containers in microseconds")
+	c.Flags().Int64Var(&limits.CPUQuota, "cpu-quota", 0,
+		"Specify the CPU time the containers may use in each CPU period in microseconds")
+	c.Flags().Int64Var(&limits.PidsLimit, "pids-limit", 0,
+		"Specify the maximum number of processes in the containers, or -1 for no limit")
+	c.Flags().Var(&limits.Ulimits, "ulimit",
+		"Specify a resource limit of the containers in name=soft[:hard] format")
+	c.Flags().Var(&limits.Tmpfs, "tmpfs",
+		"Specify a tmpfs filesystem to mount into the containers in /container/path[:options] format")
+	c.Flags().Var((*bytesValue)(&limits.ShmSize), "shm-size",
+		"Specify the size of /dev/shm in the containers (default 64m)")
+	c.Flags().Uint16Var(&limits.BlkioWeight, "blkio-weight", 0,
+		"Specify the relative block IO weight of the containers, between 10 and 1000")
+	c.Flags().StringVar(&limits.Parent, "cgroup-parent", "",
+		"Specify the parent cgroup of the containers")
+
+	var inherit bool
+	c.Flags().BoolVar(&inherit, "inherit-cgroup-limits", false,
+		"Apply the limits of the cgroup S2I runs in to the containers, unless they are set by other flags")
+	preRun := c.PreRun
+	c.PreRun = func(cmd *cobra.Command, args []string) {
+		if preRun != nil {
+			preRun(cmd, args)
+		}
+		if !inherit {
+			return
+		}
+		if err := cgroups.Inherit(limits); err != nil {
+			log.Warningf("Unable to detect the cgroup limits: %v", err)
+		}
+	}
+}
+
+// bytesValue is a pflag.Value of a size in bytes, which may have a unit suffix
+// like 512m.
+type bytesValue int64
+
+// Set implements the Set() function of pflags.Value interface.
+func (b *bytesValue) Set(value string) error {
+	if value == "-1" {
+		*b = -1
+		return nil
+	}
+	size, err := units.RAMInBytes(value)
+	if err != nil {
+		return err
+	}
+	*b = bytesValue(size)
+	return nil
+}
+
+// String implements the String() function of pflags.Value interface.
+func (b *bytesValue) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+// Type implements the Type() function of pflags.Value interface.
+func (b *bytesValue) Type() string {
+	return "bytes"
+}
+
 // SetupLogger makes --loglevel reflect in klog's -v flag
 func SetupLogger(flags *pflag.FlagSet) {
 
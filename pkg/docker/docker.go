@@ -9,7 +9,9 @@ import (
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -20,11 +22,15 @@ import (
 
 	dockertypes "github.com/docker/docker/api/types"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerfilters "github.com/docker/docker/api/types/filters"
 	dockernetwork "github.com/docker/docker/api/types/network"
+	dockervolume "github.com/docker/docker/api/types/volume"
 	dockerapi "github.com/docker/docker/client"
 	dockermessage "github.com/docker/docker/pkg/jsonmessage"
 	dockerstdcopy "github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
 	"github.com/docker/go-connections/tlsconfig"
+	units "github.com/docker/go-units"
 	"golang.org/x/net/context"
 
 	"github.com/openshift/source-to-image/pkg/api"
@@ -34,6 +40,7 @@ import (
 	"github.com/openshift/source-to-image/pkg/util"
 	"github.com/openshift/source-to-image/pkg/util/fs"
 	"github.com/openshift/source-to-image/pkg/util/interrupt"
+	"github.com/openshift/source-to-image/pkg/util/registries"
 )
 
 const (
@@ -49,9 +56,11 @@ const (
 
 	// DefaultShmSize is the default shared memory size to use (in bytes) if not specified.
 	DefaultShmSize = int64(1024 * 1024 * 64)
-	// DefaultPullRetryDelay is the default pull image retry interval
+	// DefaultPullRetryDelay is the default delay between the retries of a
+	// Docker operation, e.g. a pull
 	DefaultPullRetryDelay = 5 * time.Second
-	// DefaultPullRetryCount is the default pull image retry times
+	// DefaultPullRetryCount is the default number of retries of a Docker
+	// operation, e.g. a pull
 	DefaultPullRetryCount = 6
 )
 
@@ -94,7 +103,10 @@ type Docker interface {
 	IsImageInLocalRegistry(name string) (bool, error)
 	IsImageOnBuild(string) bool
 	GetOnBuild(string) ([]string, error)
+	CreateContainer(image string) (string, error)
 	RemoveContainer(id string) error
+	KillContainer(id string) error
+	GetContainerPortAddress(id, port string) (string, error)
 	GetScriptsURL(name string) (string, error)
 	GetAssembleInputFiles(string) (string, error)
 	GetAssembleRuntimeUser(string) (string, error)
@@ -103,6 +115,9 @@ type Docker interface {
 	GetImageWorkdir(name string) (string, error)
 	CommitContainer(opts CommitContainerOptions) (string, error)
 	RemoveImage(name string) error
+	CreateVolume(name string, labels map[string]string) error
+	ListVolumes(labels map[string]string) ([]*dockertypes.Volume, error)
+	RemoveVolume(name string) error
 	CheckImage(name string) (*api.Image, error)
 	PullImage(name string) (*api.Image, error)
 	CheckAndPullImage(name string) (*api.Image, error)
@@ -125,6 +140,7 @@ type Client interface {
 	ContainerInspect(ctx context.Context, container string) (dockertypes.ContainerJSON, error)
 	ContainerRemove(ctx context.Context, container string, options dockertypes.ContainerRemoveOptions) error
 	ContainerStart(ctx context.Context, container string, options dockertypes.ContainerStartOptions) error
+	ContainerStats(ctx context.Context, container string, stream bool) (dockertypes.ContainerStats, error)
 	ContainerKill(ctx context.Context, container, signal string) error
 	ContainerWait(ctx context.Context, container string, condition dockercontainer.WaitCondition) (<-chan dockercontainer.ContainerWaitOKBody, <-chan error)
 	CopyToContainer(ctx context.Context, container, path string, content io.Reader, opts dockertypes.CopyToContainerOptions) error
@@ -133,25 +149,53 @@ type Client interface {
 	ImageInspectWithRaw(ctx context.Context, image string) (dockertypes.ImageInspect, []byte, error)
 	ImagePull(ctx context.Context, ref string, options dockertypes.ImagePullOptions) (io.ReadCloser, error)
 	ImageRemove(ctx context.Context, image string, options dockertypes.ImageRemoveOptions) ([]dockertypes.ImageDeleteResponseItem, error)
+	ImageTag(ctx context.Context, image, ref string) error
 	ServerVersion(ctx context.Context) (dockertypes.Version, error)
+	VolumeCreate(ctx context.Context, options dockervolume.VolumesCreateBody) (dockertypes.Volume, error)
+	VolumeList(ctx context.Context, filter dockerfilters.Args) (dockervolume.VolumesListOKBody, error)
+	VolumeRemove(ctx context.Context, volumeID string, force bool) error
+	DaemonHost() string
 }
 
 type stiDocker struct {
 	client   Client
 	pullAuth dockertypes.AuthConfig
+	session  *Session
+
+	retryPolicy api.RetryPolicy
+	sleep       func(time.Duration)
+
+	registryRules []api.RegistryRule
 }
 
-// InspectImage returns the image information and its raw representation.
-func (d stiDocker) InspectImage(name string) (*dockertypes.ImageInspect, error) {
+// InspectImage returns the image information and its raw representation. The
+// images are inspected once, the returned information must not be modified.
+func (d *stiDocker) InspectImage(name string) (*dockertypes.ImageInspect, error) {
+	if image, ok := d.session.images.get(name); ok {
+		return image, nil
+	}
 	ctx, cancel := getDefaultContext()
 	defer cancel()
-	resp, _, err := d.client.ImageInspectWithRaw(ctx, name)
+	resp, _, err := d.client.ImageInspectWithRaw(ctx, d.localReference(name))
 	if err != nil {
 		return nil, err
 	}
+	d.session.images.add(name, &resp)
 	return &resp, nil
 }
 
+// localReference returns the reference of the image of the name known to the
+// daemon. An image pulled by digest from a location the registry rules rewrote
+// the reference to cannot be tagged with its original name, it is referenced by
+// its location.
+func (d *stiDocker) localReference(name string) string {
+	if !strings.Contains(name, "@") {
+		return name
+	}
+	location, _ := registries.Resolve(d.registryRules, name)
+	return location
+}
+
 // PostExecutor is an interface which provides a PostExecute function
 type PostExecutor interface {
 	PostExecute(containerID, destination string) error
@@ -201,6 +245,22 @@ type RunContainerOptions struct {
 	CommandExplicit []string
 	// SecurityOpt is passed through as security options to the underlying container.
 	SecurityOpt []string
+	// SuppressPortsInfo skips reporting the port mappings of a TargetImage
+	// container, which is not run for the user to inspect.
+	SuppressPortsInfo bool
+	// PublishPorts lists the ports of a TargetImage container, e.g.
+	// "8080/tcp", published on random host ports even if the image does not
+	// expose them.
+	PublishPorts []string
+	// ResourceUsage receives the resources used by the container, sampled
+	// from its stats while it runs, if it is set.
+	ResourceUsage *api.ResourceUsage
+	// KeepFailedContainer keeps the container instead of removing it when it
+	// exits with a non-zero code. The ContainerError returned holds its ID.
+	KeepFailedContainer bool
+	// TTY allocates a pseudo-TTY for the container, for running an interactive
+	// shell. Stdin is streamed to the container until it exits.
+	TTY bool
 }
 
 // asDockerConfig converts a RunContainerOptions into a Config understood by the
@@ -214,9 +274,24 @@ func (rco RunContainerOptions) asDockerConfig() dockercontainer.Config {
 		OpenStdin:    rco.Stdin != nil,
 		StdinOnce:    rco.Stdin != nil,
 		AttachStdout: rco.Stdout != nil,
+		Tty:          rco.TTY,
+		ExposedPorts: rco.publishedPorts(),
 	}
 }
 
+// publishedPorts returns the set of ports to publish in addition to the ones
+// exposed by the image, or nil if there are none.
+func (rco RunContainerOptions) publishedPorts() nat.PortSet {
+	if !rco.TargetImage || len(rco.PublishPorts) == 0 {
+		return nil
+	}
+	ports := nat.PortSet{}
+	for _, port := range rco.PublishPorts {
+		ports[nat.Port(port)] = struct{}{}
+	}
+	return ports
+}
+
 // asDockerHostConfig converts a RunContainerOptions into a HostConfig
 // understood by the docker client
 func (rco RunContainerOptions) asDockerHostConfig() dockercontainer.HostConfig {
@@ -228,14 +303,47 @@ func (rco RunContainerOptions) asDockerHostConfig() dockercontainer.HostConfig {
 		ExtraHosts:      rco.AddHost,
 		SecurityOpt:     rco.SecurityOpt,
 	}
-	if rco.CGroupLimits != nil {
-		hostConfig.Resources.Memory = rco.CGroupLimits.MemoryLimitBytes
-		hostConfig.Resources.MemorySwap = rco.CGroupLimits.MemorySwap
-		hostConfig.Resources.CgroupParent = rco.CGroupLimits.Parent
+	if ports := rco.publishedPorts(); ports != nil {
+		// an empty binding publishes the port on a random host port
+		hostConfig.PortBindings = nat.PortMap{}
+		for port := range ports {
+			hostConfig.PortBindings[port] = []nat.PortBinding{{}}
+		}
+	}
+	if limits := rco.CGroupLimits; limits != nil {
+		hostConfig.Resources.Memory = limits.MemoryLimitBytes
+		hostConfig.Resources.MemorySwap = limits.MemorySwap
+		hostConfig.Resources.CgroupParent = limits.Parent
+		hostConfig.Resources.CPUShares = limits.CPUShares
+		hostConfig.Resources.CPUPeriod = limits.CPUPeriod
+		hostConfig.Resources.CPUQuota = limits.CPUQuota
+		hostConfig.Resources.PidsLimit = limits.PidsLimit
+		hostConfig.Resources.BlkioWeight = limits.BlkioWeight
+		hostConfig.Resources.Ulimits = asDockerUlimits(limits.Ulimits)
+		hostConfig.ShmSize = limits.ShmSize
+		if len(limits.Tmpfs) > 0 {
+			hostConfig.Tmpfs = map[string]string{}
+			for _, m := range limits.Tmpfs {
+				hostConfig.Tmpfs[m.Destination] = m.Options
+			}
+		}
 	}
 	return hostConfig
 }
 
+// asDockerUlimits converts the ulimits into the ones understood by the docker
+// client.
+func asDockerUlimits(ulimits api.UlimitList) []*units.Ulimit {
+	if len(ulimits) == 0 {
+		return nil
+	}
+	result := make([]*units.Ulimit, 0, len(ulimits))
+	for _, u := range ulimits {
+		result = append(result, &units.Ulimit{Name: u.Name, Soft: u.Soft, Hard: u.Hard})
+	}
+	return result
+}
+
 // asDockerCreateContainerOptions converts a RunContainerOptions into a
 // ContainerCreateConfig understood by the docker client
 func (rco RunContainerOptions) asDockerCreateContainerOptions() dockertypes.ContainerCreateConfig {
@@ -313,14 +421,39 @@ func NewEngineAPIClient(config *api.DockerConfig) (*dockerapi.Client, error) {
 
 // New creates a new implementation of the STI Docker interface
 func New(client Client, auth api.AuthConfig) Docker {
+	return newSTIDocker(client, auth, NewSession(), defaultRetryPolicy)
+}
+
+// NewForConfig creates a new implementation of the STI Docker interface for a
+// build, which retries the operations according to the retry policy of the
+// config and records the retries in the session. The images are pulled
+// according to the registry rules of the config. The implementations created
+// for the same build share its session, a new one is used if it is nil.
+func NewForConfig(client Client, auth api.AuthConfig, config *api.Config, session *Session) Docker {
+	policy := defaultRetryPolicy
+	if config.DockerRetry != nil {
+		policy = *config.DockerRetry
+	}
+	if session == nil {
+		session = NewSession()
+	}
+	d := newSTIDocker(client, auth, session, policy)
+	d.registryRules = config.RegistryRules
+	return d
+}
+
+func newSTIDocker(client Client, auth api.AuthConfig, session *Session, policy api.RetryPolicy) *stiDocker {
 	return &stiDocker{
-		client: client,
+		client:  client,
+		session: session,
 		pullAuth: dockertypes.AuthConfig{
 			Username:      auth.Username,
 			Password:      auth.Password,
 			Email:         auth.Email,
 			ServerAddress: auth.ServerAddress,
 		},
+		retryPolicy: policy,
+		sleep:       time.Sleep,
 	}
 }
 
@@ -371,24 +504,30 @@ func (d *stiDocker) UploadToContainer(fs fs.FileSystem, src, dest, container str
 // the destination (which has to be directory as well).
 // If the source is a single file, then the file copied into destination (which
 // has to be full path to a file inside the container).
+// The upload is retried on transient errors, with the archive created again,
+// since extracting it again overwrites the files extracted before.
 func (d *stiDocker) UploadToContainerWithTarWriter(fs fs.FileSystem, src, dest, container string, makeTarWriter func(io.Writer) s2itar.Writer) error {
 	destPath := filepath.Dir(dest)
-	r, w := io.Pipe()
-	go func() {
-		tarWriter := makeTarWriter(w)
-		tarWriter = s2itar.RenameAdapter{Writer: tarWriter, Old: filepath.Base(src), New: filepath.Base(dest)}
-
-		err := s2itar.New(fs).CreateTarStreamToTarWriter(src, true, tarWriter, nil)
-		if err == nil {
-			err = tarWriter.Close()
-		}
-
-		w.CloseWithError(err)
-	}()
 	log.V(3).Infof("Uploading %q to %q ...", src, destPath)
-	ctx, cancel := getDefaultContext()
-	defer cancel()
-	err := d.client.CopyToContainer(ctx, container, destPath, r, dockertypes.CopyToContainerOptions{})
+	err := d.retry(fmt.Sprintf("uploading %q to container %q", src, container), true, func() error {
+		r, w := io.Pipe()
+		// unblock the archive creation if the upload failed
+		defer r.Close()
+		go func() {
+			tarWriter := makeTarWriter(w)
+			tarWriter = s2itar.RenameAdapter{Writer: tarWriter, Old: filepath.Base(src), New: filepath.Base(dest)}
+
+			err := s2itar.New(fs).CreateTarStreamToTarWriter(src, true, tarWriter, nil)
+			if err == nil {
+				err = tarWriter.Close()
+			}
+
+			w.CloseWithError(err)
+		}()
+		ctx, cancel := getDefaultContext()
+		defer cancel()
+		return d.client.CopyToContainer(ctx, container, destPath, r, dockertypes.CopyToContainerOptions{})
+	})
 	if err != nil {
 		log.V(0).Infof("error: Uploading to container failed: %v", err)
 	}
@@ -516,25 +655,44 @@ func base64EncodeAuth(auth dockertypes.AuthConfig) (string, error) {
 	return base64.URLEncoding.EncodeToString(buf.Bytes()), nil
 }
 
-// PullImage pulls an image into the local registry
+// PullImage pulls an image into the local registry. An image whose reference
+// is rewritten by the registry rules is pulled from the rewritten reference
+// and tagged with its name.
 func (d *stiDocker) PullImage(name string) (*api.Image, error) {
 	name = getImageName(name)
+	if d.session.isPulled(name) {
+		// e.g. pulled ahead according to its policy
+		log.V(3).Infof("Image %q was already pulled by the build", name)
+		return d.CheckImage(name)
+	}
+	location, rule := registries.Resolve(d.registryRules, name)
+	if rule != nil && rule.Blocked {
+		return nil, s2ierr.NewPullImageError(name, fmt.Errorf("the registry %s is blocked", rule.Prefix))
+	}
+	if location != name {
+		log.V(1).Infof("Pulling image %q from %q", name, location)
+	}
 
 	// RegistryAuth is the base64 encoded credentials for the registry
 	base64Auth, err := base64EncodeAuth(d.pullAuth)
 	if err != nil {
 		return nil, s2ierr.NewPullImageError(name, err)
 	}
-	var retriableError = false
 
-	for retries := 0; retries <= DefaultPullRetryCount; retries++ {
-		err = util.TimeoutAfter(DefaultDockerTimeout, fmt.Sprintf("pulling image %q", name), func(timer *time.Timer) error {
-			resp, pullErr := d.client.ImagePull(context.Background(), name, dockertypes.ImagePullOptions{RegistryAuth: base64Auth})
+	// the name resolves to the pulled image from now on
+	d.session.images.invalidate(name)
+
+	startTime := time.Now()
+
+	err = d.retry(fmt.Sprintf("pulling image %q", name), true, func() error {
+		return util.TimeoutAfter(DefaultDockerTimeout, fmt.Sprintf("pulling image %q", name), func(timer *time.Timer) error {
+			resp, pullErr := d.client.ImagePull(context.Background(), location, dockertypes.ImagePullOptions{RegistryAuth: base64Auth})
 			if pullErr != nil {
 				return pullErr
 			}
 			defer resp.Close()
 
+			progress := newPullProgress()
 			decoder := json.NewDecoder(resp)
 			for {
 				if !timer.Stop() {
@@ -557,32 +715,36 @@ func (d *stiDocker) PullImage(name string) (*api.Image, error) {
 				if msg.Progress != nil {
 					log.V(4).Infof("pulling image %s: %s", name, msg.Progress.String())
 				}
+				progress.update(msg)
+				if progress.due() {
+					log.V(1).Infof("Pulling image %q: %s", name, progress)
+				}
 			}
 		})
-		if err == nil {
-			break
-		}
+	})
+	if err != nil {
 		log.V(0).Infof("pulling image error : %v", err)
-		errMsg := fmt.Sprintf("%s", err)
-		for _, errorString := range RetriableErrors {
-			if strings.Contains(errMsg, errorString) {
-				retriableError = true
-				break
-			}
+		if rule != nil && rule.Insecure {
+			err = fmt.Errorf("%v (the registry %s is insecure, which the Docker daemon only allows if it is listed in its insecure-registries)", err, util.FirstNonEmpty(rule.Location, rule.Prefix))
 		}
+		return nil, s2ierr.NewPullImageError(name, err)
+	}
+	log.V(1).Infof("Pulled image %q in %s", name, time.Since(startTime).Round(time.Millisecond))
 
-		if !retriableError {
+	// a reference by digest cannot be tagged, the image keeps its location
+	if location != name && !strings.Contains(name, "@") {
+		ctx, cancel := getDefaultContext()
+		defer cancel()
+		if err := d.client.ImageTag(ctx, location, name); err != nil {
 			return nil, s2ierr.NewPullImageError(name, err)
 		}
-
-		log.V(0).Infof("retrying in %s ...", DefaultPullRetryDelay)
-		time.Sleep(DefaultPullRetryDelay)
 	}
 
 	inspectResp, err := d.InspectImage(name)
 	if err != nil {
 		return nil, s2ierr.NewPullImageError(name, err)
 	}
+	d.session.addPulled(name)
 	if inspectResp != nil {
 		image := &api.Image{}
 		updateImageWithInspect(image, inspectResp)
@@ -607,6 +769,45 @@ func updateImageWithInspect(image *api.Image, inspect *dockertypes.ImageInspect)
 	}
 }
 
+// CreateContainer creates, but does not start, a container of the image, e.g.
+// to download files from it. The caller is responsible for removing it.
+func (d *stiDocker) CreateContainer(image string) (string, error) {
+	config := dockercontainer.Config{
+		Image: getImageName(image),
+		// the container never runs, but the daemon requires a command
+		Cmd: []string{"/bin/true"},
+	}
+	container, err := d.createContainer(&config, &dockercontainer.HostConfig{}, nil, containerName(image))
+	if err != nil {
+		return "", err
+	}
+	return container.ID, nil
+}
+
+// createContainer creates a container, retrying the transient errors. The
+// daemon might have created the container before failing, so a container of
+// the name is removed before each retry, which would conflict with it.
+func (d *stiDocker) createContainer(config *dockercontainer.Config, hostConfig *dockercontainer.HostConfig, networkingConfig *dockernetwork.NetworkingConfig, name string) (dockercontainer.ContainerCreateCreatedBody, error) {
+	var container dockercontainer.ContainerCreateCreatedBody
+	created := *config
+	created.Image = d.localReference(config.Image)
+	attempted := false
+	err := d.retry(fmt.Sprintf("creating container %q", name), true, func() error {
+		ctx, cancel := getDefaultContext()
+		defer cancel()
+		if attempted && len(name) > 0 {
+			if err := d.client.ContainerRemove(ctx, name, dockertypes.ContainerRemoveOptions{RemoveVolumes: true, Force: true}); err == nil {
+				log.V(2).Infof("Removed container %q created by the failed attempt", name)
+			}
+		}
+		attempted = true
+		var err error
+		container, err = d.client.ContainerCreate(ctx, &created, hostConfig, networkingConfig, name)
+		return err
+	})
+	return container, err
+}
+
 // RemoveContainer removes a container and its associated volumes.
 func (d *stiDocker) RemoveContainer(id string) error {
 	ctx, cancel := getDefaultContext()
@@ -624,6 +825,40 @@ func (d *stiDocker) KillContainer(id string) error {
 	return d.client.ContainerKill(ctx, id, "SIGKILL")
 }
 
+// GetContainerPortAddress returns the host address the port of the running
+// container, e.g. "8080/tcp", is published on.
+func (d *stiDocker) GetContainerPortAddress(id, port string) (string, error) {
+	ctx, cancel := getDefaultContext()
+	defer cancel()
+	container, err := d.client.ContainerInspect(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if container.ContainerJSONBase == nil || container.State == nil || !container.State.Running {
+		return "", fmt.Errorf("container %s is not running", id)
+	}
+	if container.NetworkSettings == nil || len(container.NetworkSettings.Ports[nat.Port(port)]) == 0 {
+		return "", fmt.Errorf("port %s of container %s is not published", port, id)
+	}
+	binding := container.NetworkSettings.Ports[nat.Port(port)][0]
+	host := binding.HostIP
+	if len(host) == 0 || host == "0.0.0.0" || host == "::" {
+		host = daemonHostname(d.client.DaemonHost())
+	}
+	return net.JoinHostPort(host, binding.HostPort), nil
+}
+
+// daemonHostname returns the host name of the Docker daemon at the address,
+// e.g. "tcp://192.168.99.100:2376", which is localhost unless the daemon is
+// reached over the network.
+func daemonHostname(daemonHost string) string {
+	u, err := url.Parse(daemonHost)
+	if err != nil || u.Scheme == "unix" || u.Scheme == "npipe" || len(u.Hostname()) == 0 {
+		return "localhost"
+	}
+	return u.Hostname()
+}
+
 // GetLabels retrieves the labels of the given image.
 func (d *stiDocker) GetLabels(name string) (map[string]string, error) {
 	name = getImageName(name)
@@ -875,6 +1110,13 @@ func (d *stiDocker) holdHijackedConnection(tty bool, opts *RunContainerOptions,
 		receiveStdout <- nil
 	}
 
+	if opts.Stdin != nil && tty {
+		// an interactive session ends when the shell exits, not when the input
+		// is closed
+		go io.Copy(resp.Conn, opts.Stdin)
+		return <-receiveStdout
+	}
+
 	if opts.Stdin != nil {
 		_, err := io.Copy(resp.Conn, opts.Stdin)
 		opts.Stdin.Close()
@@ -968,15 +1210,18 @@ func (d *stiDocker) RunContainer(opts RunContainerOptions) error {
 
 	// Create a new container.
 	log.V(2).Infof("Creating container with options {Name:%q Config:%+v HostConfig:%+v} ...", createOpts.Name, *util.SafeForLoggingContainerConfig(createOpts.Config), createOpts.HostConfig)
-	ctx, cancel := getDefaultContext()
-	defer cancel()
-	container, err := d.client.ContainerCreate(ctx, createOpts.Config, createOpts.HostConfig, createOpts.NetworkingConfig, createOpts.Name)
+	container, err := d.createContainer(createOpts.Config, createOpts.HostConfig, createOpts.NetworkingConfig, createOpts.Name)
 	if err != nil {
 		return err
 	}
 
 	// Container was created, so we defer its removal, and also remove it if we get a SIGINT/SIGTERM/SIGQUIT/SIGHUP.
+	keepContainer := false
 	removeContainer := func() {
+		if keepContainer {
+			log.V(2).Infof("Keeping failed container %q", container.ID)
+			return
+		}
 		log.V(4).Infof("Removing container %q ...", container.ID)
 
 		killErr := d.KillContainer(container.ID)
@@ -1018,6 +1263,33 @@ func (d *stiDocker) RunContainer(opts RunContainerOptions) error {
 			return err
 		}
 
+		// Sample the stats of the container until it exits.
+		waitStats := func() {}
+		if opts.ResourceUsage != nil {
+			statsCtx, statsCancel := context.WithCancel(context.Background())
+			statsDone := make(chan struct{})
+			go func() {
+				defer close(statsDone)
+				if err := d.sampleResourceUsage(statsCtx, container.ID, opts.ResourceUsage); err != nil {
+					log.V(2).Infof("Unable to sample the stats of container %q: %v", container.ID, err)
+				}
+			}()
+			// the resource usage must not be updated once we returned
+			defer func() {
+				statsCancel()
+				<-statsDone
+			}()
+			waitStats = func() {
+				// the daemon ends the stream once the container exited
+				select {
+				case <-statsDone:
+				case <-time.After(statsGracePeriod):
+					statsCancel()
+					<-statsDone
+				}
+			}
+		}
+
 		// Run OnStart hook if defined. OnStart might block, so we run it in a
 		// new goroutine, and wait for it to be done later on.
 		onStartDone := make(chan error, 1)
@@ -1027,14 +1299,14 @@ func (d *stiDocker) RunContainer(opts RunContainerOptions) error {
 			}()
 		}
 
-		if opts.TargetImage {
+		if opts.TargetImage && !opts.SuppressPortsInfo {
 			// When TargetImage is true, we're dealing with an invocation of `s2i build ... --run`
 			// so this will, e.g., run a web server and block until the user interrupts it (or
 			// the container exits normally).  dump port/etc information for the user.
 			dumpContainerInfo(container, d, image)
 		}
 
-		err = d.holdHijackedConnection(false, &opts, resp)
+		err = d.holdHijackedConnection(opts.TTY, &opts, resp)
 		if err != nil {
 			return err
 		}
@@ -1045,16 +1317,28 @@ func (d *stiDocker) RunContainer(opts RunContainerOptions) error {
 		waitC, errC := d.client.ContainerWait(context.Background(), container.ID, dockercontainer.WaitConditionNotRunning)
 		select {
 		case result := <-waitC:
+			waitStats()
 			if result.StatusCode != 0 {
 				var output string
-				jsonOutput, _ := d.client.ContainerInspect(ctx, container.ID)
-				if err == nil && jsonOutput.ContainerJSONBase != nil && jsonOutput.ContainerJSONBase.State != nil {
+				var oomKilled bool
+				// the context of the container start may have expired during a long build
+				inspectCtx, inspectCancel := getDefaultContext()
+				defer inspectCancel()
+				jsonOutput, inspectErr := d.client.ContainerInspect(inspectCtx, container.ID)
+				if inspectErr == nil && jsonOutput.ContainerJSONBase != nil && jsonOutput.ContainerJSONBase.State != nil {
 					state := jsonOutput.ContainerJSONBase.State
 					output = fmt.Sprintf("Status: %s, Error: %s, OOMKilled: %v, Dead: %v", state.Status, state.Error, state.OOMKilled, state.Dead)
+					oomKilled = state.OOMKilled
+				}
+				e := s2ierr.NewContainerStateError(container.ID, int(result.StatusCode), output, oomKilled).(s2ierr.ContainerError)
+				if opts.KeepFailedContainer {
+					keepContainer = true
+					e.ContainerID = container.ID
 				}
-				return s2ierr.NewContainerError(container.ID, int(result.StatusCode), output)
+				return e
 			}
 		case err := <-errC:
+			waitStats()
 			return fmt.Errorf("waiting for container %q to stop: %v", container.ID, err)
 		}
 
@@ -1104,6 +1388,9 @@ func (d *stiDocker) CommitContainer(opts CommitContainerOptions) (string, error)
 	}
 
 	resp, err := d.client.ContainerCommit(context.Background(), opts.ContainerID, dockerOpts)
+	if len(opts.Repository) > 0 {
+		d.session.images.invalidate(opts.Repository)
+	}
 	if err == nil {
 		return resp.ID, nil
 	}
@@ -1115,9 +1402,47 @@ func (d *stiDocker) RemoveImage(imageID string) error {
 	ctx, cancel := getDefaultContext()
 	defer cancel()
 	_, err := d.client.ImageRemove(ctx, imageID, dockertypes.ImageRemoveOptions{})
+	d.session.images.remove(imageID)
+	return err
+}
+
+// CreateVolume creates a local volume with the labels, unless a volume with
+// the name exists already.
+func (d *stiDocker) CreateVolume(name string, labels map[string]string) error {
+	ctx, cancel := getDefaultContext()
+	defer cancel()
+	_, err := d.client.VolumeCreate(ctx, dockervolume.VolumesCreateBody{Name: name, Driver: "local", Labels: labels})
 	return err
 }
 
+// ListVolumes returns the volumes which have all the labels. A label with an
+// empty value matches any value.
+func (d *stiDocker) ListVolumes(labels map[string]string) ([]*dockertypes.Volume, error) {
+	ctx, cancel := getDefaultContext()
+	defer cancel()
+	filter := dockerfilters.NewArgs()
+	for k, v := range labels {
+		if len(v) == 0 {
+			filter.Add("label", k)
+			continue
+		}
+		filter.Add("label", k+"="+v)
+	}
+	resp, err := d.client.VolumeList(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Volumes, nil
+}
+
+// RemoveVolume removes the volume. It fails if the volume is in use by a
+// container.
+func (d *stiDocker) RemoveVolume(name string) error {
+	ctx, cancel := getDefaultContext()
+	defer cancel()
+	return d.client.VolumeRemove(ctx, name, false)
+}
+
 // BuildImage builds the image according to specified options
 func (d *stiDocker) BuildImage(opts BuildImageOptions) error {
 	dockerOpts := dockertypes.ImageBuildOptions{
@@ -1127,13 +1452,29 @@ func (d *stiDocker) BuildImage(opts BuildImageOptions) error {
 		Remove:         true,
 		ForceRemove:    true,
 	}
-	if opts.CGroupLimits != nil {
-		dockerOpts.Memory = opts.CGroupLimits.MemoryLimitBytes
-		dockerOpts.MemorySwap = opts.CGroupLimits.MemorySwap
-		dockerOpts.CgroupParent = opts.CGroupLimits.Parent
+	if limits := opts.CGroupLimits; limits != nil {
+		dockerOpts.Memory = limits.MemoryLimitBytes
+		dockerOpts.MemorySwap = limits.MemorySwap
+		dockerOpts.CgroupParent = limits.Parent
+		dockerOpts.CPUShares = limits.CPUShares
+		dockerOpts.CPUPeriod = limits.CPUPeriod
+		dockerOpts.CPUQuota = limits.CPUQuota
+		dockerOpts.ShmSize = limits.ShmSize
+		dockerOpts.Ulimits = asDockerUlimits(limits.Ulimits)
+		if limits.PidsLimit != 0 || limits.BlkioWeight != 0 || len(limits.Tmpfs) > 0 {
+			log.Warning("The pids limit, block IO weight and tmpfs mounts are not applied to image builds")
+		}
 	}
 	log.V(2).Infof("Building container using config: %+v", dockerOpts)
-	resp, err := d.client.ImageBuild(context.Background(), opts.Stdin, dockerOpts)
+	d.session.images.invalidate(opts.Name)
+	// the build context is streamed, so the build is only retried if the
+	// daemon was not reached
+	var resp dockertypes.ImageBuildResponse
+	err := d.retry(fmt.Sprintf("building image %q", opts.Name), false, func() error {
+		var err error
+		resp, err = d.client.ImageBuild(context.Background(), opts.Stdin, dockerOpts)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -1146,3 +1487,67 @@ func (d *stiDocker) BuildImage(opts BuildImageOptions) error {
 	}
 	return err
 }
+
+// statsGracePeriod is how long RunContainer waits for the last stats of an
+// exited container.
+var statsGracePeriod = 2 * time.Second
+
+// sampleResourceUsage streams the stats of the container until the stream
+// ends or ctx is canceled, and stores the resources used by the container in
+// usage. The counters are cumulative, so the highest sampled values are kept,
+// as the stats of an exited container are empty.
+func (d *stiDocker) sampleResourceUsage(ctx context.Context, id string, usage *api.ResourceUsage) error {
+	resp, err := d.client.ContainerStats(ctx, id, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		stats := dockertypes.StatsJSON{}
+		if err := decoder.Decode(&stats); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		sample := resourceUsageOf(&stats)
+		usage.PeakMemoryBytes = maxUint64(usage.PeakMemoryBytes, sample.PeakMemoryBytes)
+		usage.CPUTimeNanoseconds = maxUint64(usage.CPUTimeNanoseconds, sample.CPUTimeNanoseconds)
+		usage.BlockIOReadBytes = maxUint64(usage.BlockIOReadBytes, sample.BlockIOReadBytes)
+		usage.BlockIOWriteBytes = maxUint64(usage.BlockIOWriteBytes, sample.BlockIOWriteBytes)
+		usage.NetworkReceivedBytes = maxUint64(usage.NetworkReceivedBytes, sample.NetworkReceivedBytes)
+		usage.NetworkSentBytes = maxUint64(usage.NetworkSentBytes, sample.NetworkSentBytes)
+	}
+}
+
+// resourceUsageOf returns the resources used by a container according to its
+// stats.
+func resourceUsageOf(stats *dockertypes.StatsJSON) api.ResourceUsage {
+	usage := api.ResourceUsage{
+		// the maximum usage is not reported on cgroup v2
+		PeakMemoryBytes:    maxUint64(stats.MemoryStats.MaxUsage, stats.MemoryStats.Usage),
+		CPUTimeNanoseconds: stats.CPUStats.CPUUsage.TotalUsage,
+	}
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			usage.BlockIOReadBytes += entry.Value
+		case "write":
+			usage.BlockIOWriteBytes += entry.Value
+		}
+	}
+	for _, network := range stats.Networks {
+		usage.NetworkReceivedBytes += network.RxBytes
+		usage.NetworkSentBytes += network.TxBytes
+	}
+	return usage
+}
+
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
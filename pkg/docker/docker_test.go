@@ -2,22 +2,31 @@ package docker
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/openshift/source-to-image/pkg/api"
 	"github.com/openshift/source-to-image/pkg/api/constants"
 	dockertest "github.com/openshift/source-to-image/pkg/docker/test"
 	"github.com/openshift/source-to-image/pkg/errors"
 	testfs "github.com/openshift/source-to-image/pkg/test/fs"
+	"github.com/openshift/source-to-image/pkg/util"
 
 	dockertypes "github.com/docker/docker/api/types"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	dockernetwork "github.com/docker/docker/api/types/network"
 	dockerstrslice "github.com/docker/docker/api/types/strslice"
+	"github.com/docker/go-connections/nat"
+	units "github.com/docker/go-units"
 )
 
 func TestContainerName(t *testing.T) {
@@ -32,6 +41,7 @@ func getDocker(client Client) *stiDocker {
 	return &stiDocker{
 		client:   client,
 		pullAuth: dockertypes.AuthConfig{},
+		session:  NewSession(),
 	}
 }
 
@@ -249,6 +259,82 @@ func TestImageBuild(t *testing.T) {
 	}
 }
 
+func TestAsDockerHostConfig(t *testing.T) {
+	rco := RunContainerOptions{
+		CGroupLimits: &api.CGroupLimits{
+			MemoryLimitBytes: 512 * 1024 * 1024,
+			CPUShares:        512,
+			CPUPeriod:        100000,
+			CPUQuota:         50000,
+			PidsLimit:        256,
+			Ulimits:          api.UlimitList{{Name: "nofile", Soft: 1024, Hard: 4096}},
+			Tmpfs:            api.TmpfsList{{Destination: "/tmp", Options: "size=64m"}},
+			ShmSize:          128 * 1024 * 1024,
+			BlkioWeight:      300,
+			Parent:           "/docker/abc",
+		},
+	}
+	hostConfig := rco.asDockerHostConfig()
+	resources := hostConfig.Resources
+	if resources.Memory != 512*1024*1024 || resources.CgroupParent != "/docker/abc" {
+		t.Errorf("Unexpected memory limit %d and parent %q", resources.Memory, resources.CgroupParent)
+	}
+	if resources.CPUShares != 512 || resources.CPUPeriod != 100000 || resources.CPUQuota != 50000 {
+		t.Errorf("Unexpected CPU limits %d, %d and %d", resources.CPUShares, resources.CPUPeriod, resources.CPUQuota)
+	}
+	if resources.PidsLimit != 256 || resources.BlkioWeight != 300 {
+		t.Errorf("Unexpected pids limit %d and block IO weight %d", resources.PidsLimit, resources.BlkioWeight)
+	}
+	if len(resources.Ulimits) != 1 || *resources.Ulimits[0] != (units.Ulimit{Name: "nofile", Soft: 1024, Hard: 4096}) {
+		t.Errorf("Unexpected ulimits %+v", resources.Ulimits)
+	}
+	if !reflect.DeepEqual(hostConfig.Tmpfs, map[string]string{"/tmp": "size=64m"}) {
+		t.Errorf("Unexpected tmpfs mounts %v", hostConfig.Tmpfs)
+	}
+	if hostConfig.ShmSize != 128*1024*1024 {
+		t.Errorf("Unexpected shm size %d", hostConfig.ShmSize)
+	}
+
+	rco = RunContainerOptions{TargetImage: true, PublishPorts: []string{"8080/tcp"}}
+	hostConfig = rco.asDockerHostConfig()
+	if !hostConfig.PublishAllPorts || !reflect.DeepEqual(hostConfig.PortBindings, nat.PortMap{"8080/tcp": {{}}}) {
+		t.Errorf("Expected all ports and 8080/tcp to be published, got %v and %v", hostConfig.PublishAllPorts, hostConfig.PortBindings)
+	}
+	if config := rco.asDockerConfig(); !reflect.DeepEqual(config.ExposedPorts, nat.PortSet{"8080/tcp": {}}) {
+		t.Errorf("Expected 8080/tcp to be exposed, got %v", config.ExposedPorts)
+	}
+}
+
+func TestGetContainerPortAddress(t *testing.T) {
+	tests := []struct {
+		daemonHost string
+		hostIP     string
+		expected   string
+	}{
+		{"", "0.0.0.0", "localhost:32768"},
+		{"npipe:////./pipe/docker_engine", "", "localhost:32768"},
+		{"tcp://192.168.99.100:2376", "0.0.0.0", "192.168.99.100:32768"},
+		{"ssh://user@docker.example.com", "::", "docker.example.com:32768"},
+		{"tcp://192.168.99.100:2376", "10.0.0.1", "10.0.0.1:32768"},
+	}
+	for _, tc := range tests {
+		fakeDocker := dockertest.NewFakeDockerClient()
+		fakeDocker.Host = tc.daemonHost
+		fakeDocker.WaitContainerErrInspectJSON = dockertypes.ContainerJSON{
+			ContainerJSONBase: &dockertypes.ContainerJSONBase{State: &dockertypes.ContainerState{Running: true}},
+			NetworkSettings: &dockertypes.NetworkSettings{
+				NetworkSettingsBase: dockertypes.NetworkSettingsBase{
+					Ports: nat.PortMap{"8080/tcp": {{HostIP: tc.hostIP, HostPort: "32768"}}},
+				},
+			},
+		}
+		address, err := New(fakeDocker, api.AuthConfig{}).GetContainerPortAddress("c1", "8080/tcp")
+		if err != nil || address != tc.expected {
+			t.Errorf("%q, %q: expected the address %q, got %q, %v", tc.daemonHost, tc.hostIP, tc.expected, address, err)
+		}
+	}
+}
+
 func TestGetScriptsURL(t *testing.T) {
 	type urltest struct {
 		image      dockertypes.ImageInspect
@@ -366,7 +452,7 @@ func TestRunContainer(t *testing.T) {
 
 	tests := map[string]runtest{
 		"default": {
-			calls: []string{"inspect_image", "inspect_image", "inspect_image", "create", "attach", "start", "remove"},
+			calls: []string{"inspect_image", "create", "attach", "start", "remove"},
 			image: dockertypes.ImageInspect{
 				ContainerConfig: &dockercontainer.Config{},
 				Config:          &dockercontainer.Config{},
@@ -376,7 +462,7 @@ func TestRunContainer(t *testing.T) {
 			cmdExpected:     []string{"/bin/sh", "-c", fmt.Sprintf("tar -C /tmp -xf - && /tmp/scripts/%s", constants.Assemble)},
 		},
 		"runerror": {
-			calls: []string{"inspect_image", "inspect_image", "inspect_image", "create", "attach", "start", "remove"},
+			calls: []string{"inspect_image", "create", "attach", "start", "remove"},
 			image: dockertypes.ImageInspect{
 				ContainerConfig: &dockercontainer.Config{},
 				Config:          &dockercontainer.Config{},
@@ -397,7 +483,7 @@ func TestRunContainer(t *testing.T) {
 			errMsg: "Error: Process was terminated, OOMKilled: true",
 		},
 		"paramDestination": {
-			calls: []string{"inspect_image", "inspect_image", "inspect_image", "create", "attach", "start", "remove"},
+			calls: []string{"inspect_image", "create", "attach", "start", "remove"},
 			image: dockertypes.ImageInspect{
 				ContainerConfig: &dockercontainer.Config{},
 				Config:          &dockercontainer.Config{},
@@ -408,7 +494,7 @@ func TestRunContainer(t *testing.T) {
 			cmdExpected:      []string{"/bin/sh", "-c", fmt.Sprintf("tar -C /opt/test -xf - && /opt/test/scripts/%s", constants.Assemble)},
 		},
 		"paramDestination&paramScripts": {
-			calls: []string{"inspect_image", "inspect_image", "inspect_image", "create", "attach", "start", "remove"},
+			calls: []string{"inspect_image", "create", "attach", "start", "remove"},
 			image: dockertypes.ImageInspect{
 				ContainerConfig: &dockercontainer.Config{},
 				Config:          &dockercontainer.Config{},
@@ -420,7 +506,7 @@ func TestRunContainer(t *testing.T) {
 			cmdExpected:      []string{"/bin/sh", "-c", fmt.Sprintf("tar -C /opt/test -xf - && /opt/test/scripts/%s", constants.Assemble)},
 		},
 		"scriptsInsideImageEnvironment": {
-			calls: []string{"inspect_image", "inspect_image", "inspect_image", "create", "attach", "start", "remove"},
+			calls: []string{"inspect_image", "create", "attach", "start", "remove"},
 			image: dockertypes.ImageInspect{
 				ContainerConfig: &dockercontainer.Config{},
 				Config: &dockercontainer.Config{
@@ -432,7 +518,7 @@ func TestRunContainer(t *testing.T) {
 			cmdExpected:     []string{"/bin/sh", "-c", fmt.Sprintf("tar -C /tmp -xf - && /opt/bin/%s", constants.Assemble)},
 		},
 		"scriptsInsideImageLabel": {
-			calls: []string{"inspect_image", "inspect_image", "inspect_image", "create", "attach", "start", "remove"},
+			calls: []string{"inspect_image", "create", "attach", "start", "remove"},
 			image: dockertypes.ImageInspect{
 				ContainerConfig: &dockercontainer.Config{},
 				Config: &dockercontainer.Config{
@@ -444,7 +530,7 @@ func TestRunContainer(t *testing.T) {
 			cmdExpected:     []string{"/bin/sh", "-c", fmt.Sprintf("tar -C /tmp -xf - && /opt/bin/%s", constants.Assemble)},
 		},
 		"scriptsInsideImageEnvironmentWithParamDestination": {
-			calls: []string{"inspect_image", "inspect_image", "inspect_image", "create", "attach", "start", "remove"},
+			calls: []string{"inspect_image", "create", "attach", "start", "remove"},
 			image: dockertypes.ImageInspect{
 				ContainerConfig: &dockercontainer.Config{},
 				Config: &dockercontainer.Config{
@@ -457,7 +543,7 @@ func TestRunContainer(t *testing.T) {
 			cmdExpected:      []string{"/bin/sh", "-c", fmt.Sprintf("tar -C /opt/sti -xf - && /opt/bin/%s", constants.Assemble)},
 		},
 		"scriptsInsideImageLabelWithParamDestination": {
-			calls: []string{"inspect_image", "inspect_image", "inspect_image", "create", "attach", "start", "remove"},
+			calls: []string{"inspect_image", "create", "attach", "start", "remove"},
 			image: dockertypes.ImageInspect{
 				ContainerConfig: &dockercontainer.Config{},
 				Config: &dockercontainer.Config{
@@ -470,7 +556,7 @@ func TestRunContainer(t *testing.T) {
 			cmdExpected:      []string{"/bin/sh", "-c", fmt.Sprintf("tar -C /opt/sti -xf - && /opt/bin/%s", constants.Assemble)},
 		},
 		"paramDestinationFromImageEnvironment": {
-			calls: []string{"inspect_image", "inspect_image", "inspect_image", "create", "attach", "start", "remove"},
+			calls: []string{"inspect_image", "create", "attach", "start", "remove"},
 			image: dockertypes.ImageInspect{
 				ContainerConfig: &dockercontainer.Config{},
 				Config: &dockercontainer.Config{
@@ -482,7 +568,7 @@ func TestRunContainer(t *testing.T) {
 			cmdExpected:     []string{"/bin/sh", "-c", fmt.Sprintf("tar -C /opt -xf - && /opt/scripts/%s", constants.Assemble)},
 		},
 		"paramDestinationFromImageLabel": {
-			calls: []string{"inspect_image", "inspect_image", "inspect_image", "create", "attach", "start", "remove"},
+			calls: []string{"inspect_image", "create", "attach", "start", "remove"},
 			image: dockertypes.ImageInspect{
 				ContainerConfig: &dockercontainer.Config{},
 				Config: &dockercontainer.Config{
@@ -494,7 +580,7 @@ func TestRunContainer(t *testing.T) {
 			cmdExpected:     []string{"/bin/sh", "-c", fmt.Sprintf("tar -C /opt -xf - && /opt/scripts/%s", constants.Assemble)},
 		},
 		"usageCommand": {
-			calls: []string{"inspect_image", "inspect_image", "inspect_image", "create", "attach", "start", "remove"},
+			calls: []string{"inspect_image", "create", "attach", "start", "remove"},
 			image: dockertypes.ImageInspect{
 				ContainerConfig: &dockercontainer.Config{},
 				Config:          &dockercontainer.Config{},
@@ -504,7 +590,7 @@ func TestRunContainer(t *testing.T) {
 			cmdExpected:     []string{"/bin/sh", "-c", fmt.Sprintf("tar -C /tmp -xf - && /tmp/scripts/%s", constants.Usage)},
 		},
 		"otherCommand": {
-			calls: []string{"inspect_image", "inspect_image", "inspect_image", "create", "attach", "start", "remove"},
+			calls: []string{"inspect_image", "create", "attach", "start", "remove"},
 			image: dockertypes.ImageInspect{
 				ContainerConfig: &dockercontainer.Config{},
 				Config:          &dockercontainer.Config{},
@@ -607,6 +693,98 @@ func TestRemoveImage(t *testing.T) {
 	}
 }
 
+func TestSampleResourceUsage(t *testing.T) {
+	fakeDocker := dockertest.NewFakeDockerClient()
+	dh := getDocker(fakeDocker)
+	first := dockertypes.StatsJSON{}
+	first.MemoryStats.Usage = 2048
+	first.CPUStats.CPUUsage.TotalUsage = 1000
+	first.BlkioStats.IoServiceBytesRecursive = []dockertypes.BlkioStatEntry{
+		{Op: "Read", Value: 10},
+		{Op: "Write", Value: 20},
+	}
+	first.Networks = map[string]dockertypes.NetworkStats{
+		"eth0": {RxBytes: 100, TxBytes: 200},
+		"eth1": {RxBytes: 1, TxBytes: 2},
+	}
+	second := dockertypes.StatsJSON{}
+	second.MemoryStats.MaxUsage = 4096
+	second.MemoryStats.Usage = 1024
+	second.CPUStats.CPUUsage.TotalUsage = 3000
+	second.BlkioStats.IoServiceBytesRecursive = []dockertypes.BlkioStatEntry{
+		{Op: "read", Value: 30},
+		{Op: "write", Value: 5},
+		{Op: "write", Value: 25},
+	}
+	// the stats of an exited container are empty
+	fakeDocker.Stats = []dockertypes.StatsJSON{first, second, {}}
+
+	usage := &api.ResourceUsage{}
+	if err := dh.sampleResourceUsage(context.Background(), "test", usage); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := &api.ResourceUsage{
+		PeakMemoryBytes:      4096,
+		CPUTimeNanoseconds:   3000,
+		BlockIOReadBytes:     30,
+		BlockIOWriteBytes:    30,
+		NetworkReceivedBytes: 101,
+		NetworkSentBytes:     202,
+	}
+	if !reflect.DeepEqual(usage, expected) {
+		t.Errorf("Expected usage %#v, got %#v", expected, usage)
+	}
+}
+
+// lateStatsClient streams a single stats sample once the sampling was
+// cancelled.
+type lateStatsClient struct {
+	*dockertest.FakeDockerClient
+}
+
+func (c lateStatsClient) ContainerStats(ctx context.Context, containerID string, stream bool) (dockertypes.ContainerStats, error) {
+	r, w := io.Pipe()
+	go func() {
+		<-ctx.Done()
+		stats := dockertypes.StatsJSON{}
+		stats.MemoryStats.Usage = 1024
+		w.CloseWithError(json.NewEncoder(w).Encode(stats))
+	}()
+	return dockertypes.ContainerStats{Body: r, OSType: "linux"}, nil
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("read failure")
+}
+
+func TestRunContainerResourceUsageOnError(t *testing.T) {
+	fakeDocker := dockertest.NewFakeDockerClient()
+	fakeDocker.Images = map[string]dockertypes.ImageInspect{"test/image:latest": {
+		ID:              "test/image:latest",
+		ContainerConfig: &dockercontainer.Config{},
+		Config:          &dockercontainer.Config{},
+	}}
+	dh := getDocker(lateStatsClient{fakeDocker})
+
+	usage := &api.ResourceUsage{}
+	err := dh.RunContainer(RunContainerOptions{
+		Image:           "test/image",
+		ExternalScripts: true,
+		Command:         constants.Assemble,
+		Stdin:           ioutil.NopCloser(failingReader{}),
+		ResourceUsage:   usage,
+	})
+	if err == nil {
+		t.Fatal("Expected an error from the failing input")
+	}
+	// the sampling must be done once RunContainer returned
+	if usage.PeakMemoryBytes != 1024 {
+		t.Errorf("Expected a peak memory of 1024 bytes, got %d", usage.PeakMemoryBytes)
+	}
+}
+
 func TestGetImageName(t *testing.T) {
 	type runtest struct {
 		name     string
@@ -627,3 +805,343 @@ func TestGetImageName(t *testing.T) {
 		}
 	}
 }
+
+func TestInspectImageCache(t *testing.T) {
+	fakeDocker := dockertest.NewFakeDockerClient()
+	dh := getDocker(fakeDocker)
+	fakeDocker.Images = map[string]dockertypes.ImageInspect{
+		"builder:latest": {ID: "sha256:1", Config: &dockercontainer.Config{WorkingDir: "/opt"}},
+	}
+
+	inspects := func() int {
+		count := 0
+		for _, call := range fakeDocker.Calls {
+			if call == "inspect_image" {
+				count++
+			}
+		}
+		return count
+	}
+
+	if _, err := dh.GetLabels("builder"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if workdir, err := dh.GetImageWorkdir("builder:latest"); err != nil || workdir != "/opt" {
+		t.Fatalf("Expected the workdir /opt, got %q: %v", workdir, err)
+	}
+	if _, err := dh.GetImageID("builder"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if inspects() != 1 {
+		t.Errorf("Expected the image to be inspected once, got calls %v", fakeDocker.Calls)
+	}
+
+	// a pull may change the image the name resolves to
+	fakeDocker.Images["builder:latest"] = dockertypes.ImageInspect{ID: "sha256:2", Config: &dockercontainer.Config{}}
+	if _, err := dh.PullImage("builder"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if id, err := dh.GetImageID("builder"); err != nil || id != "sha256:2" {
+		t.Errorf("Expected the pulled image sha256:2, got %q: %v", id, err)
+	}
+	if inspects() != 2 {
+		t.Errorf("Expected the pulled image to be inspected once, got calls %v", fakeDocker.Calls)
+	}
+
+	fakeDocker.Images["sha256:2"] = fakeDocker.Images["builder:latest"]
+	if err := dh.RemoveImage("sha256:2"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	delete(fakeDocker.Images, "builder:latest")
+	if _, err := dh.GetImageID("builder"); err == nil {
+		t.Errorf("Expected the removed image not to be found")
+	}
+}
+
+// flakyDockerClient fails the first calls of the operations with the errors.
+type flakyDockerClient struct {
+	*dockertest.FakeDockerClient
+	pullErrs   []error
+	createErrs []error
+	buildErrs  []error
+}
+
+func (c *flakyDockerClient) ImagePull(ctx context.Context, ref string, options dockertypes.ImagePullOptions) (io.ReadCloser, error) {
+	if len(c.pullErrs) > 0 {
+		c.Calls = append(c.Calls, "pull")
+		err := c.pullErrs[0]
+		c.pullErrs = c.pullErrs[1:]
+		return nil, err
+	}
+	return c.FakeDockerClient.ImagePull(ctx, ref, options)
+}
+
+func (c *flakyDockerClient) ContainerCreate(ctx context.Context, config *dockercontainer.Config, hostConfig *dockercontainer.HostConfig, networkingConfig *dockernetwork.NetworkingConfig, containerName string) (dockercontainer.ContainerCreateCreatedBody, error) {
+	if len(c.createErrs) > 0 {
+		// the daemon created the container before failing
+		c.FakeDockerClient.ContainerCreate(ctx, config, hostConfig, networkingConfig, containerName)
+		err := c.createErrs[0]
+		c.createErrs = c.createErrs[1:]
+		return dockercontainer.ContainerCreateCreatedBody{}, err
+	}
+	return c.FakeDockerClient.ContainerCreate(ctx, config, hostConfig, networkingConfig, containerName)
+}
+
+func (c *flakyDockerClient) ImageBuild(ctx context.Context, buildContext io.Reader, options dockertypes.ImageBuildOptions) (dockertypes.ImageBuildResponse, error) {
+	c.Calls = append(c.Calls, "build")
+	if len(c.buildErrs) > 0 {
+		err := c.buildErrs[0]
+		c.buildErrs = c.buildErrs[1:]
+		return dockertypes.ImageBuildResponse{}, err
+	}
+	return c.FakeDockerClient.ImageBuild(ctx, buildContext, options)
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := map[string]struct {
+		err      error
+		expected errorClass
+	}{
+		"daemon down":        {err: fmt.Errorf("Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?"), expected: unreachedError},
+		"connection refused": {err: fmt.Errorf("dial tcp 127.0.0.1:2375: connect: connection refused"), expected: unreachedError},
+		"EOF":                {err: io.EOF, expected: transientError},
+		"EOF in a message":   {err: fmt.Errorf("error during connect: %v", io.ErrUnexpectedEOF), expected: transientError},
+		"registry 503":       {err: fmt.Errorf("received unexpected HTTP status: 503 Service Unavailable"), expected: transientError},
+		"TLS timeout":        {err: fmt.Errorf("Get https://registry/v2/: net/http: TLS handshake timeout"), expected: transientError},
+		"pull timeout":       {err: &util.TimeoutError{}, expected: permanentError},
+		"not found":          {err: fmt.Errorf("manifest for ruby:missing not found"), expected: permanentError},
+		"unauthorized":       {err: fmt.Errorf("unauthorized: authentication required"), expected: permanentError},
+		"canceled":           {err: context.Canceled, expected: permanentError},
+	}
+	for name, tc := range tests {
+		if class := classifyError(tc.err); class != tc.expected {
+			t.Errorf("%s: expected the class %d, got %d", name, tc.expected, class)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	unreached := fmt.Errorf("connection refused")
+	transient := fmt.Errorf("received unexpected HTTP status: 502 Bad Gateway")
+	permanent := fmt.Errorf("no such image")
+
+	tests := map[string]struct {
+		errs             []error
+		idempotent       bool
+		backoff          bool
+		expectedAttempts int
+		expectedErr      error
+		expectedDelays   []time.Duration
+	}{
+		"success": {
+			idempotent:       true,
+			expectedAttempts: 1,
+		},
+		"transient": {
+			errs:             []error{transient, transient},
+			idempotent:       true,
+			expectedAttempts: 3,
+			expectedDelays:   []time.Duration{time.Second, time.Second},
+		},
+		"retries used up": {
+			errs:             []error{transient, transient, transient, transient},
+			idempotent:       true,
+			expectedAttempts: 4,
+			expectedErr:      transient,
+			expectedDelays:   []time.Duration{time.Second, time.Second, time.Second},
+		},
+		"backoff": {
+			errs:             []error{transient, transient, transient},
+			idempotent:       true,
+			backoff:          true,
+			expectedAttempts: 4,
+			expectedDelays:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		"permanent": {
+			errs:             []error{permanent},
+			idempotent:       true,
+			expectedAttempts: 1,
+			expectedErr:      permanent,
+		},
+		"transient not idempotent": {
+			errs:             []error{transient},
+			expectedAttempts: 1,
+			expectedErr:      transient,
+		},
+		"unreached not idempotent": {
+			errs:             []error{unreached},
+			expectedAttempts: 2,
+			expectedDelays:   []time.Duration{time.Second},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			delays := []time.Duration{}
+			dh := getDocker(dockertest.NewFakeDockerClient())
+			dh.retryPolicy = api.RetryPolicy{Retries: 3, Delay: time.Second, Backoff: tc.backoff}
+			dh.sleep = func(d time.Duration) { delays = append(delays, d) }
+
+			attempts := 0
+			err := dh.retry("testing", tc.idempotent, func() error {
+				attempts++
+				if attempts <= len(tc.errs) {
+					return tc.errs[attempts-1]
+				}
+				return nil
+			})
+			if err != tc.expectedErr {
+				t.Errorf("Expected the error %v, got %v", tc.expectedErr, err)
+			}
+			if attempts != tc.expectedAttempts {
+				t.Errorf("Expected %d attempts, got %d", tc.expectedAttempts, attempts)
+			}
+			if len(tc.expectedDelays) == 0 {
+				tc.expectedDelays = []time.Duration{}
+			}
+			if !reflect.DeepEqual(delays, tc.expectedDelays) {
+				t.Errorf("Expected the delays %v, got %v", tc.expectedDelays, delays)
+			}
+			if messages := dh.session.RetryMessages(); len(messages) != len(tc.expectedDelays) {
+				t.Errorf("Expected a message for each retry, got %v", messages)
+			}
+		})
+	}
+}
+
+func TestRetryDockerOperations(t *testing.T) {
+	fakeDocker := dockertest.NewFakeDockerClient()
+	fakeDocker.Images["builder:latest"] = dockertypes.ImageInspect{ID: "sha256:1", Config: &dockercontainer.Config{}}
+	client := &flakyDockerClient{
+		FakeDockerClient: fakeDocker,
+		pullErrs:         []error{fmt.Errorf("received unexpected HTTP status: 503 Service Unavailable"), io.EOF},
+		createErrs:       []error{io.ErrUnexpectedEOF},
+		buildErrs:        []error{io.EOF},
+	}
+	config := &api.Config{DockerRetry: &api.RetryPolicy{Retries: 2, Delay: time.Millisecond}}
+	session := NewSession()
+	dh := NewForConfig(client, api.AuthConfig{}, config, session).(*stiDocker)
+	dh.sleep = func(time.Duration) {}
+
+	if _, err := dh.PullImage("builder"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := dh.CreateContainer("builder"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// the build context was streamed, so the build is not retried
+	if err := dh.BuildImage(BuildImageOptions{Name: "app", Stdin: bytes.NewReader(nil)}); err != io.EOF {
+		t.Errorf("Expected the build to fail with EOF, got %v", err)
+	}
+
+	// the container created by the failed attempt was removed before the retry
+	expectedCalls := []string{"pull", "pull", "pull", "inspect_image", "create", "remove", "create", "build"}
+	if !reflect.DeepEqual(fakeDocker.Calls, expectedCalls) {
+		t.Errorf("Expected the calls %v, got %v", expectedCalls, fakeDocker.Calls)
+	}
+	messages := session.RetryMessages()
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 retry messages, got %q", messages)
+	}
+	for i, prefix := range []string{`Retrying pulling image "builder:latest"`, `Retrying pulling image "builder:latest"`, `Retrying creating container "s2i_builder_`} {
+		if !strings.HasPrefix(messages[i], prefix) {
+			t.Errorf("Expected the message %q to start with %q", messages[i], prefix)
+		}
+	}
+}
+
+func TestRetryDisabled(t *testing.T) {
+	fakeDocker := dockertest.NewFakeDockerClient()
+	fakeDocker.Images["builder:latest"] = dockertypes.ImageInspect{ID: "sha256:1", Config: &dockercontainer.Config{}}
+	client := &flakyDockerClient{
+		FakeDockerClient: fakeDocker,
+		pullErrs:         []error{io.EOF},
+	}
+	config := &api.Config{DockerRetry: &api.RetryPolicy{Retries: 0, Delay: time.Millisecond}}
+	session := NewSession()
+	dh := NewForConfig(client, api.AuthConfig{}, config, session).(*stiDocker)
+	dh.sleep = func(time.Duration) { t.Error("Unexpected retry") }
+
+	if _, err := dh.PullImage("builder"); err == nil {
+		t.Fatal("Expected the pull to fail without being retried")
+	}
+	if messages := session.RetryMessages(); len(messages) != 0 {
+		t.Errorf("Expected no retry messages, got %q", messages)
+	}
+}
+
+func TestPullImageOncePerSession(t *testing.T) {
+	fakeDocker := dockertest.NewFakeDockerClient()
+	fakeDocker.Images["builder:latest"] = dockertypes.ImageInspect{ID: "sha256:1", Config: &dockercontainer.Config{}}
+	session := NewSession()
+	builder := NewForConfig(fakeDocker, api.AuthConfig{}, &api.Config{}, session)
+	runtime := NewForConfig(fakeDocker, api.AuthConfig{}, &api.Config{}, session)
+
+	if _, err := runtime.PullImage("missing"); err == nil {
+		t.Fatal("Expected the pull of a missing image to fail")
+	}
+	for _, d := range []Docker{builder, runtime} {
+		if _, err := d.PullImage("builder"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	expectedCalls := []string{"pull", "inspect_image", "pull", "inspect_image"}
+	if !reflect.DeepEqual(fakeDocker.Calls, expectedCalls) {
+		t.Errorf("Expected the calls %v, got %v", expectedCalls, fakeDocker.Calls)
+	}
+	if pulled := session.PulledImages(); !reflect.DeepEqual(pulled, []string{"builder:latest"}) {
+		t.Errorf("Expected only the successful pull to be recorded, got %v", pulled)
+	}
+}
+
+func TestPullImageRegistryRules(t *testing.T) {
+	fakeDocker := dockertest.NewFakeDockerClient()
+	fakeDocker.Images["mirror.example.com/dockerhub/library/ruby:latest"] = dockertypes.ImageInspect{ID: "sha256:1", Config: &dockercontainer.Config{}}
+	config := &api.Config{
+		RegistryRules: []api.RegistryRule{
+			{Prefix: "docker.io", Location: "mirror.example.com/dockerhub"},
+			{Prefix: "quay.io/untrusted", Blocked: true},
+		},
+	}
+	dh := NewForConfig(fakeDocker, api.AuthConfig{}, config, nil)
+
+	image, err := dh.PullImage("ruby")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if image.ID != "sha256:1" {
+		t.Errorf("Expected the image pulled from the mirror, got %#v", image)
+	}
+	// the pulled image is tagged with its original name
+	if _, ok := fakeDocker.Images["ruby:latest"]; !ok {
+		t.Errorf("Expected the image to be tagged ruby:latest, got %v", fakeDocker.Images)
+	}
+	expectedCalls := []string{"pull", "tag", "inspect_image"}
+	if !reflect.DeepEqual(fakeDocker.Calls, expectedCalls) {
+		t.Errorf("Expected the calls %v, got %v", expectedCalls, fakeDocker.Calls)
+	}
+
+	if _, err := dh.PullImage("quay.io/untrusted/image"); err == nil || !strings.Contains(err.(errors.Error).Details.Error(), "the registry quay.io/untrusted is blocked") {
+		t.Errorf("Expected the pull to be blocked, got %v", err)
+	}
+
+	// a reference by digest is pulled and used by its location
+	digest := "@sha256:0123456789012345678901234567890123456789012345678901234567890123"
+	fakeDocker.Images["mirror.example.com/dockerhub/library/ruby"+digest] = dockertypes.ImageInspect{ID: "sha256:2", Config: &dockercontainer.Config{}}
+	fakeDocker.Calls = []string{}
+	if image, err := dh.PullImage("ruby" + digest); err != nil || image.ID != "sha256:2" {
+		t.Fatalf("Expected the image pulled by digest from the mirror, got %#v, %v", image, err)
+	}
+	if _, err := dh.CreateContainer("ruby" + digest); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedCalls = []string{"pull", "inspect_image", "create"}
+	if !reflect.DeepEqual(fakeDocker.Calls, expectedCalls) {
+		t.Errorf("Expected the calls %v, got %v", expectedCalls, fakeDocker.Calls)
+	}
+	for _, container := range fakeDocker.Containers {
+		if container.Image == "mirror.example.com/dockerhub/library/ruby"+digest {
+			return
+		}
+	}
+	t.Errorf("Expected a container of the image pulled by digest, got %v", fakeDocker.Containers)
+}
@@ -17,8 +17,14 @@ type FakeDocker struct {
 	LocalRegistryImage           string
 	LocalRegistryResult          bool
 	LocalRegistryError           error
+	CreateContainerImage         string
+	CreateContainerID            string
+	CreateContainerError         error
 	RemoveContainerID            string
 	RemoveContainerError         error
+	KillContainerID              string
+	PortAddressResult            string
+	PortAddressError             error
 	DefaultURLImage              string
 	DefaultURLResult             string
 	DefaultURLError              error
@@ -29,6 +35,7 @@ type FakeDocker struct {
 	RunContainerOpts             RunContainerOptions
 	RunContainerError            error
 	RunContainerErrorBeforeStart bool
+	RunContainerResourceUsage    api.ResourceUsage
 	RunContainerContainerID      string
 	RunContainerCmd              []string
 	GetImageIDImage              string
@@ -55,6 +62,15 @@ type FakeDocker struct {
 	IsOnBuildImage               string
 	Labels                       map[string]string
 	LabelsError                  error
+	DownloadContainerPath        string
+	DownloadContainerID          string
+	DownloadContainerContent     []byte
+	DownloadContainerError       error
+	Volumes                      []*dockertypes.Volume
+	CreateVolumeError            error
+	ListVolumesError             error
+	RemoveVolumeNames            []string
+	RemoveVolumeError            error
 }
 
 // IsImageInLocalRegistry checks if the image exists in the fake local registry
@@ -85,6 +101,12 @@ func (f *FakeDocker) GetOnBuild(imageName string) ([]string, error) {
 	return f.OnBuildResult, f.OnBuildError
 }
 
+// CreateContainer creates a fake Docker container
+func (f *FakeDocker) CreateContainer(image string) (string, error) {
+	f.CreateContainerImage = image
+	return f.CreateContainerID, f.CreateContainerError
+}
+
 // RemoveContainer removes a fake Docker container
 func (f *FakeDocker) RemoveContainer(id string) error {
 	f.RemoveContainerID = id
@@ -93,9 +115,15 @@ func (f *FakeDocker) RemoveContainer(id string) error {
 
 // KillContainer kills a fake container
 func (f *FakeDocker) KillContainer(id string) error {
+	f.KillContainerID = id
 	return nil
 }
 
+// GetContainerPortAddress returns the host address of a port of a fake container
+func (f *FakeDocker) GetContainerPortAddress(id, port string) (string, error) {
+	return f.PortAddressResult, f.PortAddressError
+}
+
 // GetScriptsURL returns a default STI scripts URL
 func (f *FakeDocker) GetScriptsURL(image string) (string, error) {
 	f.DefaultURLImage = image
@@ -138,6 +166,9 @@ func (f *FakeDocker) RunContainer(opts RunContainerOptions) error {
 	if opts.PostExec != nil {
 		opts.PostExec.PostExecute(f.RunContainerContainerID, string(opts.Command))
 	}
+	if opts.ResourceUsage != nil {
+		*opts.ResourceUsage = f.RunContainerResourceUsage
+	}
 	return f.RunContainerError
 }
 
@@ -153,7 +184,15 @@ func (f *FakeDocker) UploadToContainerWithTarWriter(fs fs.FileSystem, srcPath, d
 
 // DownloadFromContainer downloads file (or directory) from the container.
 func (f *FakeDocker) DownloadFromContainer(containerPath string, w io.Writer, container string) error {
-	return errors.New("not implemented")
+	f.DownloadContainerPath = containerPath
+	f.DownloadContainerID = container
+	if f.DownloadContainerContent == nil && f.DownloadContainerError == nil {
+		return errors.New("not implemented")
+	}
+	if _, err := w.Write(f.DownloadContainerContent); err != nil {
+		return err
+	}
+	return f.DownloadContainerError
 }
 
 // GetImageID returns a fake Docker image ID
@@ -227,3 +266,49 @@ func (f *FakeDocker) GetLabels(name string) (map[string]string, error) {
 func (f *FakeDocker) CheckReachable() error {
 	return nil
 }
+
+// CreateVolume adds a fake volume, unless a volume with the name exists.
+func (f *FakeDocker) CreateVolume(name string, labels map[string]string) error {
+	if f.CreateVolumeError != nil {
+		return f.CreateVolumeError
+	}
+	for _, v := range f.Volumes {
+		if v.Name == name {
+			return nil
+		}
+	}
+	f.Volumes = append(f.Volumes, &dockertypes.Volume{Name: name, Driver: "local", Labels: labels})
+	return nil
+}
+
+// ListVolumes returns the fake volumes which have all the labels.
+func (f *FakeDocker) ListVolumes(labels map[string]string) ([]*dockertypes.Volume, error) {
+	result := []*dockertypes.Volume{}
+	for _, v := range f.Volumes {
+		matches := true
+		for k, value := range labels {
+			if actual, ok := v.Labels[k]; !ok || (len(value) > 0 && actual != value) {
+				matches = false
+			}
+		}
+		if matches {
+			result = append(result, v)
+		}
+	}
+	return result, f.ListVolumesError
+}
+
+// RemoveVolume removes a fake volume.
+func (f *FakeDocker) RemoveVolume(name string) error {
+	f.RemoveVolumeNames = append(f.RemoveVolumeNames, name)
+	if f.RemoveVolumeError != nil {
+		return f.RemoveVolumeError
+	}
+	for i, v := range f.Volumes {
+		if v.Name == name {
+			f.Volumes = append(f.Volumes[:i], f.Volumes[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"sync"
+
+	dockertypes "github.com/docker/docker/api/types"
+)
+
+// imageCache holds the inspected images for the lifetime of a Session,
+// which is a single build, so that the metadata of an image is read from the
+// daemon only once. The images are keyed by ID, since the content of an image
+// never changes, and the names resolve to IDs until an image is pulled, built,
+// committed or removed under the name.
+type imageCache struct {
+	mu     sync.Mutex
+	ids    map[string]string
+	images map[string]*dockertypes.ImageInspect
+}
+
+// get returns the inspected image of the name or ID, if it is cached. The
+// names are cached with the default tag, like they are pulled.
+func (c *imageCache) get(name string) (*dockertypes.ImageInspect, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.ids[getImageName(name)]
+	if !ok {
+		id = name
+	}
+	image, ok := c.images[id]
+	return image, ok
+}
+
+// add caches the inspected image of the name.
+func (c *imageCache) add(name string, image *dockertypes.ImageInspect) {
+	if len(image.ID) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.images == nil {
+		c.ids = map[string]string{}
+		c.images = map[string]*dockertypes.ImageInspect{}
+	}
+	c.ids[getImageName(name)] = image.ID
+	c.images[image.ID] = image
+}
+
+// invalidate forgets the image the name resolved to, since another image was
+// pulled, built or committed under the name.
+func (c *imageCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.ids, getImageName(name))
+}
+
+// remove forgets the image of the name or ID, along with all the names
+// resolving to it.
+func (c *imageCache) remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := getImageName(name)
+	id, ok := c.ids[key]
+	if !ok {
+		id = name
+	}
+	delete(c.images, id)
+	for n, i := range c.ids {
+		if n == key || i == id {
+			delete(c.ids, n)
+		}
+	}
+}
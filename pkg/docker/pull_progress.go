@@ -0,0 +1,87 @@
+package docker
+
+import (
+	"fmt"
+	"time"
+
+	dockermessage "github.com/docker/docker/pkg/jsonmessage"
+	units "github.com/docker/go-units"
+)
+
+// pullProgressInterval is how often the progress of a pull is logged, which
+// tells the concurrent pulls apart.
+var pullProgressInterval = 10 * time.Second
+
+// pullProgress sums up the progress of the layers of a pulled image from the
+// messages of the daemon.
+type pullProgress struct {
+	layers     []string
+	current    map[string]int64
+	total      map[string]int64
+	complete   map[string]bool
+	lastReport time.Time
+}
+
+func newPullProgress() *pullProgress {
+	return &pullProgress{
+		current:    map[string]int64{},
+		total:      map[string]int64{},
+		complete:   map[string]bool{},
+		lastReport: time.Now(),
+	}
+}
+
+// update records the progress of the layer the message is about.
+func (p *pullProgress) update(msg dockermessage.JSONMessage) {
+	// the messages about the image itself, e.g. "Pulling from library/ruby",
+	// have the tag as ID and no progress
+	if len(msg.ID) == 0 || msg.Progress == nil && !isLayerStatus(msg.Status) {
+		return
+	}
+	if _, ok := p.current[msg.ID]; !ok {
+		p.layers = append(p.layers, msg.ID)
+		p.current[msg.ID] = 0
+	}
+	switch msg.Status {
+	case "Downloading":
+		if msg.Progress != nil {
+			p.current[msg.ID] = msg.Progress.Current
+			p.total[msg.ID] = msg.Progress.Total
+		}
+	case "Download complete":
+		p.current[msg.ID] = p.total[msg.ID]
+	case "Already exists", "Pull complete":
+		p.current[msg.ID] = p.total[msg.ID]
+		p.complete[msg.ID] = true
+	}
+}
+
+// isLayerStatus returns true if the status describes a step of pulling a layer.
+func isLayerStatus(status string) bool {
+	switch status {
+	case "Pulling fs layer", "Waiting", "Downloading", "Verifying Checksum", "Download complete", "Extracting", "Pull complete", "Already exists":
+		return true
+	}
+	return false
+}
+
+// due returns true, and restarts the interval, if the progress should be
+// logged.
+func (p *pullProgress) due() bool {
+	if time.Since(p.lastReport) < pullProgressInterval {
+		return false
+	}
+	p.lastReport = time.Now()
+	return true
+}
+
+// String describes how many layers were pulled and how much was downloaded.
+func (p *pullProgress) String() string {
+	var current, total int64
+	for _, layer := range p.layers {
+		current += p.current[layer]
+		total += p.total[layer]
+	}
+	return fmt.Sprintf("%d of %d layers pulled, %s of %s downloaded", len(p.complete), len(p.layers),
+		units.HumanSize(float64(current)), units.HumanSize(float64(total)))
+}
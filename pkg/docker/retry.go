@@ -0,0 +1,122 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/util"
+)
+
+// maxRetryDelay caps the delay between the retries of an operation backing
+// off.
+var maxRetryDelay = time.Minute
+
+// errorClass tells whether an operation failing with an error is retried.
+type errorClass int
+
+const (
+	// permanentError fails the operation, e.g. a missing image or denied
+	// access.
+	permanentError errorClass = iota
+	// unreachedError means the daemon was not reached, so the request had no
+	// effect and any operation is retried, even one streaming its content.
+	unreachedError
+	// transientError means the daemon or the registry failed while handling
+	// the request, e.g. it restarted or responded with a 5xx status. The
+	// request might have had an effect, so only idempotent operations are
+	// retried.
+	transientError
+)
+
+var (
+	// unreachedErrors indicate the daemon could not be connected to.
+	unreachedErrors = []string{
+		"connection refused",
+		"Cannot connect to the Docker daemon",
+		"ping attempt failed with error",
+	}
+	// transientErrors indicate a failure of the daemon or the registry, in
+	// addition to RetriableErrors.
+	transientErrors = []string{
+		"EOF",
+		"broken pipe",
+		"TLS handshake timeout",
+		"i/o timeout",
+		"500 Internal Server Error",
+		"502 Bad Gateway",
+		"503 Service Unavailable",
+		"504 Gateway Timeout",
+		"received unexpected HTTP status: 5",
+		"toomanyrequests",
+	}
+)
+
+// classifyError returns whether an operation failing with the error is
+// retried.
+func classifyError(err error) errorClass {
+	if err == nil || err == context.Canceled {
+		return permanentError
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return transientError
+	}
+	// the operation already made no progress for DefaultDockerTimeout
+	if _, ok := err.(*util.TimeoutError); ok {
+		return permanentError
+	}
+	msg := err.Error()
+	for _, s := range unreachedErrors {
+		if strings.Contains(msg, s) {
+			return unreachedError
+		}
+	}
+	for _, list := range [][]string{RetriableErrors, transientErrors} {
+		for _, s := range list {
+			if strings.Contains(msg, s) {
+				return transientError
+			}
+		}
+	}
+	return permanentError
+}
+
+// defaultRetryPolicy retries the operations DefaultPullRetryCount times,
+// DefaultPullRetryDelay apart.
+var defaultRetryPolicy = api.RetryPolicy{Retries: DefaultPullRetryCount, Delay: DefaultPullRetryDelay}
+
+// retry runs the operation, described by what, e.g. `pulling image "ruby"`,
+// until it succeeds, fails with an error it is not retried on or the retries
+// of the policy are used up. An operation which is not idempotent, e.g. one
+// streaming its content, is only retried if the daemon was not reached. Each
+// retry is logged and recorded in the retry messages of the session.
+func (d *stiDocker) retry(what string, idempotent bool, operation func() error) error {
+	sleep := d.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	delay := d.retryPolicy.Delay
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil {
+			return nil
+		}
+		class := classifyError(err)
+		if attempt > d.retryPolicy.Retries || class == permanentError || class == transientError && !idempotent {
+			return err
+		}
+		msg := fmt.Sprintf("Retrying %s in %s (retry %d of %d) after error: %v", what, delay, attempt, d.retryPolicy.Retries, err)
+		log.V(0).Info(msg)
+		d.session.addRetryMessage(msg)
+		sleep(delay)
+		if !d.retryPolicy.Backoff {
+			continue
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
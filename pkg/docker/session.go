@@ -0,0 +1,65 @@
+package docker
+
+import (
+	"sync"
+)
+
+// Session holds the state shared by the Docker implementations created for a
+// single build, e.g. for the builder, runtime and previous images, so that they
+// inspect and pull each image only once and their retries are reported once.
+type Session struct {
+	images imageCache
+
+	mu            sync.Mutex
+	pulled        []string
+	retryMessages []string
+}
+
+// NewSession returns the state shared by the Docker implementations of a new
+// build.
+func NewSession() *Session {
+	return &Session{}
+}
+
+// PulledImages returns the images successfully pulled by the build, in the
+// order they were pulled.
+func (s *Session) PulledImages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.pulled...)
+}
+
+// addPulled records that the image was successfully pulled.
+func (s *Session) addPulled(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pulled = append(s.pulled, name)
+}
+
+// RetryMessages returns a message for each retry of a Docker operation of the
+// build.
+func (s *Session) RetryMessages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.retryMessages...)
+}
+
+// addRetryMessage records the message of a retry, the concurrent pulls retry
+// their operations concurrently.
+func (s *Session) addRetryMessage(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retryMessages = append(s.retryMessages, msg)
+}
+
+// isPulled returns whether the image was already pulled by the build.
+func (s *Session) isPulled(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pulled := range s.pulled {
+		if pulled == name {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package test
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -12,7 +13,9 @@ import (
 
 	dockertypes "github.com/docker/docker/api/types"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerfilters "github.com/docker/docker/api/types/filters"
 	dockernetwork "github.com/docker/docker/api/types/network"
+	dockervolume "github.com/docker/docker/api/types/volume"
 	"golang.org/x/net/context"
 )
 
@@ -91,6 +94,12 @@ type FakeDockerClient struct {
 	PullFail error
 
 	Calls []string
+
+	// Stats are streamed by ContainerStats.
+	Stats []dockertypes.StatsJSON
+
+	// Host is the address of the daemon, a local socket if it is empty.
+	Host string
 }
 
 // NewFakeDockerClient returns a new FakeDockerClient
@@ -197,6 +206,19 @@ func (d *FakeDockerClient) ContainerKill(ctx context.Context, containerID, signa
 	return nil
 }
 
+// ContainerStats returns a stream of the container stats.
+func (d *FakeDockerClient) ContainerStats(ctx context.Context, containerID string, stream bool) (dockertypes.ContainerStats, error) {
+	d.Calls = append(d.Calls, "stats")
+	body := &bytes.Buffer{}
+	encoder := json.NewEncoder(body)
+	for _, stats := range d.Stats {
+		if err := encoder.Encode(stats); err != nil {
+			return dockertypes.ContainerStats{}, err
+		}
+	}
+	return dockertypes.ContainerStats{Body: ioutil.NopCloser(body), OSType: "linux"}, nil
+}
+
 // ContainerStart sends a request to the docker daemon to start a container.
 func (d *FakeDockerClient) ContainerStart(ctx context.Context, containerID string, options dockertypes.ContainerStartOptions) error {
 	d.Calls = append(d.Calls, "start")
@@ -225,7 +247,45 @@ func (d *FakeDockerClient) ImageRemove(ctx context.Context, imageID string, opti
 	return []dockertypes.ImageDeleteResponseItem{}, errors.New("image does not exist")
 }
 
+// ImageTag tags an image with a reference.
+func (d *FakeDockerClient) ImageTag(ctx context.Context, image, ref string) error {
+	d.Calls = append(d.Calls, "tag")
+
+	inspect, exists := d.Images[image]
+	if !exists {
+		return fmt.Errorf("No such image: %q", image)
+	}
+	d.Images[ref] = inspect
+	return nil
+}
+
 // ServerVersion returns information of the docker client and server host.
 func (d *FakeDockerClient) ServerVersion(ctx context.Context) (dockertypes.Version, error) {
 	return dockertypes.Version{}, nil
 }
+
+// DaemonHost returns the address of the daemon.
+func (d *FakeDockerClient) DaemonHost() string {
+	if len(d.Host) == 0 {
+		return "unix:///var/run/docker.sock"
+	}
+	return d.Host
+}
+
+// VolumeCreate creates a volume in the docker host.
+func (d *FakeDockerClient) VolumeCreate(ctx context.Context, options dockervolume.VolumesCreateBody) (dockertypes.Volume, error) {
+	d.Calls = append(d.Calls, "create_volume")
+	return dockertypes.Volume{Name: options.Name, Driver: options.Driver, Labels: options.Labels}, nil
+}
+
+// VolumeList returns the volumes configured in the docker host.
+func (d *FakeDockerClient) VolumeList(ctx context.Context, filter dockerfilters.Args) (dockervolume.VolumesListOKBody, error) {
+	d.Calls = append(d.Calls, "list_volumes")
+	return dockervolume.VolumesListOKBody{}, nil
+}
+
+// VolumeRemove removes a volume from the docker host.
+func (d *FakeDockerClient) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	d.Calls = append(d.Calls, "remove_volume")
+	return nil
+}
@@ -12,6 +12,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/distribution/reference"
 	cliconfig "github.com/docker/docker/cli/config"
@@ -273,6 +275,45 @@ func PullImage(name string, d Docker, policy api.PullPolicy) (*PullResult, error
 	return &PullResult{Image: image, OnBuild: d.IsImageOnBuild(name)}, err
 }
 
+// ImagePull is an image to pull with a pull policy.
+type ImagePull struct {
+	Name   string
+	Policy api.PullPolicy
+	Docker Docker
+}
+
+// ImagePullResult is the result of pulling an ImagePull.
+type ImagePullResult struct {
+	Result    *PullResult
+	Error     error
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// PullImages pulls the images according to their pull policies, at most
+// parallelism of them at a time. The results are in the order of the images.
+func PullImages(pulls []ImagePull, parallelism int) []ImagePullResult {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	results := make([]ImagePullResult, len(pulls))
+	slots := make(chan struct{}, parallelism)
+	wg := sync.WaitGroup{}
+	for i := range pulls {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i].StartTime = time.Now()
+			results[i].Result, results[i].Error = PullImage(pulls[i].Name, pulls[i].Docker, pulls[i].Policy)
+			results[i].EndTime = time.Now()
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
 // CheckAllowedUser retrieves the execution users for a Docker image and
 // checks that user against an allowed range of uids.
 // - If the range of users is not empty, then the user on the Docker image
@@ -412,10 +453,19 @@ func GetRebuildImage(docker Docker, config *api.Config) (*PullResult, error) {
 // GetRuntimeImage processes the config and performs operations necessary to
 // make the Docker image specified as RuntimeImage available locally.
 func GetRuntimeImage(docker Docker, config *api.Config) error {
-	_, err := pullAndCheck(config.RuntimeImage, docker, config.RuntimeImagePullPolicy, config)
+	_, err := pullAndCheck(config.RuntimeImage, docker, RuntimeImagePullPolicy(config), config)
 	return err
 }
 
+// RuntimeImagePullPolicy returns the policy the runtime image is pulled with,
+// which defaults to DefaultRuntimeImagePullPolicy.
+func RuntimeImagePullPolicy(config *api.Config) api.PullPolicy {
+	if len(config.RuntimeImagePullPolicy) == 0 {
+		return api.DefaultRuntimeImagePullPolicy
+	}
+	return config.RuntimeImagePullPolicy
+}
+
 // GetDefaultDockerConfig checks relevant Docker environment variables to
 // provide defaults for our command line flags
 func GetDefaultDockerConfig() *api.DockerConfig {
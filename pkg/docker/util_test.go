@@ -1,8 +1,14 @@
 package docker
 
 import (
+	"errors"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	dockermessage "github.com/docker/docker/pkg/jsonmessage"
+
+	"github.com/openshift/source-to-image/pkg/api"
 	"github.com/openshift/source-to-image/pkg/api/constants"
 	"github.com/openshift/source-to-image/pkg/util/user"
 )
@@ -195,3 +201,76 @@ func TestCheckAllowedUser(t *testing.T) {
 		}
 	}
 }
+
+// slowPullDocker counts the pulls running at the same time.
+type slowPullDocker struct {
+	*FakeDocker
+	running    *int32
+	maxRunning *int32
+}
+
+func (d slowPullDocker) PullImage(name string) (*api.Image, error) {
+	running := atomic.AddInt32(d.running, 1)
+	defer atomic.AddInt32(d.running, -1)
+	for {
+		max := atomic.LoadInt32(d.maxRunning)
+		if running <= max || atomic.CompareAndSwapInt32(d.maxRunning, max, running) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	return d.FakeDocker.PullImage(name)
+}
+
+func TestPullImages(t *testing.T) {
+	var running, maxRunning int32
+	newDocker := func(pullErr error) Docker {
+		return slowPullDocker{
+			FakeDocker: &FakeDocker{PullResult: pullErr == nil, PullError: pullErr},
+			running:    &running,
+			maxRunning: &maxRunning,
+		}
+	}
+	pullErr := errors.New("pull failed")
+	pulls := []ImagePull{
+		{Name: "builder", Policy: api.PullAlways, Docker: newDocker(nil)},
+		{Name: "runtime", Policy: api.PullAlways, Docker: newDocker(pullErr)},
+		{Name: "previous", Policy: api.PullAlways, Docker: newDocker(nil)},
+	}
+
+	results := PullImages(pulls, 2)
+	if len(results) != len(pulls) {
+		t.Fatalf("Expected %d results, got %d", len(pulls), len(results))
+	}
+	for i, r := range results {
+		if expected := pulls[i].Name == "runtime"; (r.Error != nil) != expected {
+			t.Errorf("Unexpected error of pulling %s: %v", pulls[i].Name, r.Error)
+		}
+		if r.StartTime.IsZero() || r.EndTime.Before(r.StartTime) {
+			t.Errorf("Unexpected times of pulling %s: %v - %v", pulls[i].Name, r.StartTime, r.EndTime)
+		}
+	}
+	if maxRunning != 2 {
+		t.Errorf("Expected 2 pulls at a time, got %d", maxRunning)
+	}
+}
+
+func TestPullProgress(t *testing.T) {
+	p := newPullProgress()
+	for _, msg := range []dockermessage.JSONMessage{
+		{ID: "latest", Status: "Pulling from library/ruby"},
+		{ID: "a", Status: "Already exists"},
+		{ID: "b", Status: "Pulling fs layer"},
+		{ID: "c", Status: "Pulling fs layer"},
+		{ID: "b", Status: "Downloading", Progress: &dockermessage.JSONProgress{Current: 1000, Total: 2000}},
+		{ID: "c", Status: "Downloading", Progress: &dockermessage.JSONProgress{Current: 500, Total: 1000}},
+		{ID: "c", Status: "Download complete"},
+		{ID: "c", Status: "Pull complete"},
+		{Status: "Digest: sha256:abc"},
+	} {
+		p.update(msg)
+	}
+	if expected := "2 of 3 layers pulled, 2kB of 3kB downloaded"; p.String() != expected {
+		t.Errorf("Expected progress %q, got %q", expected, p.String())
+	}
+}
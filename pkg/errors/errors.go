@@ -3,6 +3,8 @@ package errors
 import (
 	"fmt"
 	"os"
+	"strings"
+	"syscall"
 
 	"github.com/openshift/source-to-image/pkg/api/constants"
 	utillog "github.com/openshift/source-to-image/pkg/util/log"
@@ -26,6 +28,8 @@ const (
 	SourcePathError
 	UserNotAllowedError
 	EmptyGitRepositoryError
+	GitLFSError
+	ScriptDigestError
 )
 
 // Error represents an error thrown during S2I execution
@@ -44,6 +48,13 @@ type ContainerError struct {
 	ErrorCode  int
 	Suggestion string
 	ExitCode   int
+	// OOMKilled is true if the kernel killed the container because it ran
+	// out of memory.
+	OOMKilled bool
+	// Signal is the signal which killed the container, or 0.
+	Signal int
+	// ContainerID is the ID of the failed container if it was kept.
+	ContainerID string
 }
 
 // Error returns a string for a given error
@@ -211,6 +222,37 @@ func NewContainerError(name string, code int, output string) error {
 	}
 }
 
+// NewContainerStateError returns a new error which indicates that a container
+// exited with a non-zero code, telling apart containers killed by the kernel
+// because they ran out of memory and containers killed by a signal, whose exit
+// code is 128 plus the signal number.
+func NewContainerStateError(name string, code int, output string, oomKilled bool) error {
+	e := NewContainerError(name, code, output).(ContainerError)
+	e.OOMKilled = oomKilled
+	if code > 128 && code <= 128+64 {
+		e.Signal = code - 128
+	}
+	switch {
+	case oomKilled:
+		e.Message = fmt.Sprintf("%s was killed because it ran out of memory", name)
+		e.Suggestion = "increase the memory limit of the build, or reduce the memory used by the script"
+	case e.Signal > 0:
+		e.Message = fmt.Sprintf("%s was killed by signal %d (%s)", name, e.Signal, syscall.Signal(e.Signal))
+	}
+	return e
+}
+
+// AsContainerError returns the ContainerError err is or wraps, if any.
+func AsContainerError(err error) (ContainerError, bool) {
+	switch e := err.(type) {
+	case ContainerError:
+		return e, true
+	case Error:
+		return AsContainerError(e.Details)
+	}
+	return ContainerError{}, false
+}
+
 // NewSourcePathError returns a new error which indicates there was a problem
 // when accessing the source code from the local filesystem
 func NewSourcePathError(path string) error {
@@ -268,6 +310,43 @@ func NewEmptyGitRepositoryError(source string) error {
 	}
 }
 
+// NewGitLFSError returns a new error which indicates that the Git LFS objects
+// of the source repository could not be fetched, leaving pointer files in
+// place of their content
+func NewGitLFSError(pointers []string, err error) error {
+	message := "Failed to fetch the Git LFS objects of the source repository"
+	if len(pointers) > 0 {
+		message = fmt.Sprintf("The source contains Git LFS pointer files instead of their content: %s", strings.Join(pointers, ", "))
+	}
+	return Error{
+		Message:    message,
+		Details:    err,
+		ErrorCode:  GitLFSError,
+		Suggestion: "Install git-lfs on the host running s2i, check the Git credentials have access to the LFS objects, or exclude the paths from the Git LFS fetch.",
+	}
+}
+
+// NewScriptDigestMismatchError returns a new error which indicates that the
+// content downloaded for a script does not match its pinned digest
+func NewScriptDigestMismatchError(url, expected, actual string) error {
+	return Error{
+		Message:    fmt.Sprintf("the content of %s has the digest sha256:%s instead of the pinned sha256:%s", url, actual, expected),
+		ErrorCode:  ScriptDigestError,
+		Suggestion: "check that the scripts were not modified, or update the pinned digests",
+	}
+}
+
+// NewInvalidScriptDigestError returns a new error which indicates that the
+// digests pinned for the scripts could not be read
+func NewInvalidScriptDigestError(url string, err error) error {
+	return Error{
+		Message:    fmt.Sprintf("unable to read the script digests pinned by %s", url),
+		Details:    err,
+		ErrorCode:  ScriptDigestError,
+		Suggestion: "pin the scripts with a #sha256:<script>=<digest>,... fragment or a SHA256SUMS file next to the scripts",
+	}
+}
+
 // log is a placeholder until the builders pass an output stream down
 // client facing libraries should not be using log
 var log = utillog.StderrLog
@@ -292,6 +371,10 @@ func CheckError(err error) {
 			"Eventually reach us on freenode #openshift or file an issue at https://github.com/openshift/source-to-image/issues " +
 			"providing us with a log from your build using log output level 3.")
 		os.Exit(e.ErrorCode)
+	} else if e, ok := err.(ContainerError); ok {
+		log.Errorf("An error occurred: %v", e)
+		log.Errorf("Suggested solution: %v", e.Suggestion)
+		os.Exit(1)
 	} else {
 		log.Errorf("An error occurred: %v", err)
 		os.Exit(1)
@@ -2,6 +2,7 @@ package git
 
 import (
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
 
@@ -9,6 +10,7 @@ import (
 
 	"github.com/openshift/source-to-image/pkg/api"
 	"github.com/openshift/source-to-image/pkg/api/constants"
+	s2ierr "github.com/openshift/source-to-image/pkg/errors"
 	"github.com/openshift/source-to-image/pkg/scm/git"
 	"github.com/openshift/source-to-image/pkg/util/fs"
 )
@@ -32,9 +34,9 @@ func (c *Clone) Download(config *api.Config) (*git.SourceInfo, error) {
 
 	if len(config.ContextDir) > 0 {
 		targetSourceDir = filepath.Join(config.WorkingDir, constants.ContextTmp)
-		klog.V(1).Infof("Downloading %q (%q) ...", config.Source, config.ContextDir)
+		klog.V(1).Infof("Downloading %q (%q) ...", config.Source.StringNoCredentials(), config.ContextDir)
 	} else {
-		klog.V(1).Infof("Downloading %q ...", config.Source)
+		klog.V(1).Infof("Downloading %q ...", config.Source.StringNoCredentials())
 	}
 
 	if !config.IgnoreSubmodules {
@@ -56,13 +58,17 @@ func (c *Clone) Download(config *api.Config) (*git.SourceInfo, error) {
 	}
 	klog.V(1).Infof("Checked out %q", ref)
 	if !config.IgnoreSubmodules {
-		err = c.SubmoduleUpdate(targetSourceDir, true, true)
+		err = c.SubmoduleUpdate(targetSourceDir, true, true, config.GitSubmodules)
 		if err != nil {
 			return nil, err
 		}
 		klog.V(1).Infof("Updated submodules for %q", ref)
 	}
 
+	if err = c.pullLFS(targetSourceDir, config); err != nil {
+		return nil, err
+	}
+
 	// Record Git's knowledge about file permissions
 	if runtime.GOOS == "windows" {
 		filemodes, err := c.LsTree(filepath.Join(targetSourceDir, config.ContextDir), ref, true)
@@ -92,3 +98,52 @@ func (c *Clone) Download(config *api.Config) (*git.SourceInfo, error) {
 
 	return info, nil
 }
+
+// pullLFS fetches the Git LFS objects of the checked out ref, if the
+// repository uses Git LFS and the context directory has pointer files selected
+// by the configuration, and makes sure no such pointer file is left in place.
+func (c *Clone) pullLFS(dir string, config *api.Config) error {
+	usesLFS, err := git.UsesLFS(c.FileSystem, dir)
+	if err != nil || !usesLFS {
+		return err
+	}
+	missing, err := c.selectedLFSPointers(dir, config)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+
+	klog.V(1).Infof("Fetching Git LFS objects ...")
+	if err := c.LFSPull(dir, !config.IgnoreSubmodules, config.GitLFS); err != nil {
+		return s2ierr.NewGitLFSError(nil, err)
+	}
+
+	missing, err = c.selectedLFSPointers(dir, config)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return s2ierr.NewGitLFSError(missing, nil)
+	}
+	return nil
+}
+
+// selectedLFSPointers returns the slash separated paths, relative to dir, of
+// the Git LFS pointer files in the context directory whose objects are
+// fetched according to the configuration.
+func (c *Clone) selectedLFSPointers(dir string, config *api.Config) ([]string, error) {
+	contextDir := filepath.ToSlash(filepath.Clean(config.ContextDir))
+	pointers, err := git.FindLFSPointers(c.FileSystem, filepath.Join(dir, config.ContextDir))
+	if err != nil {
+		return nil, err
+	}
+	selected := []string{}
+	for _, pointer := range pointers {
+		pointer = path.Join(contextDir, pointer)
+		if config.GitLFS.Fetches(pointer) {
+			selected = append(selected, pointer)
+		} else {
+			klog.V(2).Infof("Keeping Git LFS pointer file %q excluded from fetching", pointer)
+		}
+	}
+	return selected, nil
+}
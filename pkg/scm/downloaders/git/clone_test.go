@@ -1,14 +1,22 @@
 package git
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/api/constants"
+	s2ierr "github.com/openshift/source-to-image/pkg/errors"
 	"github.com/openshift/source-to-image/pkg/scm/git"
+	"github.com/openshift/source-to-image/pkg/test"
 	testcmd "github.com/openshift/source-to-image/pkg/test/cmd"
 	testfs "github.com/openshift/source-to-image/pkg/test/fs"
+	"github.com/openshift/source-to-image/pkg/util/fs"
 )
 
 func TestCloneWithContext(t *testing.T) {
@@ -42,3 +50,105 @@ func TestCloneWithContext(t *testing.T) {
 		t.Errorf("Unexpected command arguments: %#v", cr.Args)
 	}
 }
+
+func TestCloneWithLFS(t *testing.T) {
+	workingDir, err := ioutil.TempDir("", "s2i-clone-lfs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workingDir)
+	sourceDir := filepath.Join(workingDir, constants.Source)
+	if err := os.MkdirAll(sourceDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		".gitattributes": "*.bin filter=lfs diff=lfs merge=lfs -text\n",
+		"model.bin":      "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 12345\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(sourceDir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gh := &test.FakeGit{}
+	c := &Clone{gh, fs.NewFileSystem()}
+	config := &api.Config{
+		Source:     git.MustParse("https://foo/bar.git"),
+		WorkingDir: workingDir,
+		GitLFS:     git.LFSConfig{Include: []string{"*.bin"}},
+	}
+	_, err = c.Download(config)
+	if e, ok := err.(s2ierr.Error); !ok || e.ErrorCode != s2ierr.GitLFSError || !strings.Contains(e.Message, "model.bin") {
+		t.Errorf("Expected a Git LFS error for model.bin, got %v", err)
+	}
+	if gh.LFSPullRepo != sourceDir || !gh.LFSPullRecursive || !reflect.DeepEqual(gh.LFSPullConfig, config.GitLFS) {
+		t.Errorf("Unexpected LFS pull of %q (recursive %v, config %#v)", gh.LFSPullRepo, gh.LFSPullRecursive, gh.LFSPullConfig)
+	}
+
+	config.GitLFS = git.LFSConfig{Exclude: []string{"model.bin"}}
+	if _, err = c.Download(config); err != nil {
+		t.Errorf("Unexpected error with the pointer file excluded: %v", err)
+	}
+
+	config.GitLFS = git.LFSConfig{}
+	gh.LFSPullError = errors.New("git: 'lfs' is not a git command")
+	_, err = c.Download(config)
+	if e, ok := err.(s2ierr.Error); !ok || e.ErrorCode != s2ierr.GitLFSError || e.Details != gh.LFSPullError {
+		t.Errorf("Expected a Git LFS error, got %v", err)
+	}
+}
+
+func TestPullLFSInSubmoduleOfContextDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s2i-clone-lfs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pointer := "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 12345\n"
+	files := map[string]string{
+		"app/main.txt":                "main",
+		"docs/manual.bin":             pointer,
+		"lib/models/.git":             "gitdir: ../../.git/modules/lib/models\n",
+		"lib/models/.gitattributes":   "*.bin filter=lfs diff=lfs merge=lfs -text\n",
+		"lib/models/model.bin":        pointer,
+		"lib/models/fixtures/big.bin": pointer,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gh := &test.FakeGit{}
+	c := &Clone{gh, fs.NewFileSystem()}
+	config := &api.Config{
+		ContextDir: "lib",
+		GitLFS:     git.LFSConfig{Exclude: []string{"lib/models/fixtures"}},
+	}
+	err = c.pullLFS(dir, config)
+	e, ok := err.(s2ierr.Error)
+	if !ok || e.ErrorCode != s2ierr.GitLFSError || !strings.Contains(e.Message, "lib/models/model.bin") {
+		t.Fatalf("Expected a Git LFS error for lib/models/model.bin, got %v", err)
+	}
+	if strings.Contains(e.Message, "manual.bin") || strings.Contains(e.Message, "big.bin") {
+		t.Errorf("Expected no error for the pointer files outside the context directory or excluded, got %v", err)
+	}
+	if gh.LFSPullRepo != dir || !gh.LFSPullRecursive {
+		t.Errorf("Unexpected LFS pull of %q (recursive %v)", gh.LFSPullRepo, gh.LFSPullRecursive)
+	}
+
+	gh = &test.FakeGit{}
+	c = &Clone{gh, fs.NewFileSystem()}
+	config.ContextDir = "app"
+	if err := c.pullLFS(dir, config); err != nil {
+		t.Errorf("Unexpected error without pointer files in the context directory: %v", err)
+	}
+	if len(gh.LFSPullRepo) > 0 {
+		t.Errorf("Expected no LFS pull without pointer files in the context directory, got one of %q", gh.LFSPullRepo)
+	}
+}
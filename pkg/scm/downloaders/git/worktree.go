@@ -0,0 +1,110 @@
+package git
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog"
+
+	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/api/constants"
+	"github.com/openshift/source-to-image/pkg/scm/git"
+	"github.com/openshift/source-to-image/pkg/util/fs"
+)
+
+// WorkingTree knows how to copy the working tree of a local Git repository,
+// including uncommitted changes and untracked files which are not ignored.
+type WorkingTree struct {
+	git.Git
+	fs.FileSystem
+}
+
+// Download copies the working tree of the local Git repository into the
+// working directory. The source information describes the checked out commit
+// and whether the copied files differ from it.
+func (w *WorkingTree) Download(config *api.Config) (*git.SourceInfo, error) {
+	repo := config.Source.LocalPath()
+	config.WorkingSourceDir = filepath.Join(config.WorkingDir, constants.Source)
+
+	files, err := w.ListWorkingTree(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := ""
+	if dir := path.Clean(filepath.ToSlash(config.ContextDir)); len(config.ContextDir) > 0 && dir != "." {
+		prefix = dir + "/"
+	}
+
+	klog.V(1).Infof("Copying the working tree of %q including uncommitted changes ...", repo)
+	w.KeepSymlinks(config.KeepSymlinks)
+	diffHash := sha256.New()
+	dirty := false
+	for _, file := range files {
+		source := filepath.Join(repo, filepath.FromSlash(file.Path))
+		if len(file.Status) > 0 {
+			dirty = true
+			if err := w.hashChange(diffHash, file, source); err != nil {
+				return nil, err
+			}
+		}
+		if !strings.HasPrefix(file.Path, prefix) {
+			continue
+		}
+		if _, err := w.Lstat(source); os.IsNotExist(err) {
+			// deleted, but not yet committed
+			continue
+		}
+		target := filepath.Join(config.WorkingSourceDir, filepath.FromSlash(strings.TrimPrefix(file.Path, prefix)))
+		if err := w.MkdirAll(filepath.Dir(target)); err != nil {
+			return nil, err
+		}
+		if err := w.Copy(source, target, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	info := w.GetInfo(repo)
+	if len(info.Location) == 0 {
+		info.Location = repo
+	}
+	info.ContextDir = config.ContextDir
+	if dirty {
+		info.Dirty = true
+		info.DiffHash = fmt.Sprintf("sha256:%x", diffHash.Sum(nil))
+		klog.V(1).Infof("The working tree has uncommitted changes (%s)", info.DiffHash)
+	}
+	return info, nil
+}
+
+// hashChange adds the status, path and current content of a changed file to
+// the hash.
+func (w *WorkingTree) hashChange(h hash.Hash, file git.WorkingTreeFile, source string) error {
+	fmt.Fprintf(h, "%s %s\x00", file.Status, file.Path)
+	fi, err := w.Lstat(source)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+	r, err := w.Open(source)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	_, err = h.Write([]byte{0})
+	return err
+}
@@ -0,0 +1,112 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/scm/git"
+	"github.com/openshift/source-to-image/pkg/test"
+	"github.com/openshift/source-to-image/pkg/util/fs"
+)
+
+func TestWorkingTreeDownload(t *testing.T) {
+	repo, err := ioutil.TempDir("", "s2i-worktree-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+	for name, content := range map[string]string{
+		"app/main.go":   "package main",
+		"app/new.go":    "package main // new",
+		"README":        "readme",
+		"app/ignored.o": "not listed",
+	} {
+		path := filepath.Join(repo, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gh := &test.FakeGit{
+		WorkingTreeFiles: []git.WorkingTreeFile{
+			{Path: "README"},
+			{Path: "app/deleted.go", Status: " D"},
+			{Path: "app/main.go", Status: " M"},
+			{Path: "app/new.go", Status: "??"},
+		},
+	}
+	download := func(contextDir string) (*git.SourceInfo, string) {
+		workingDir, err := ioutil.TempDir("", "s2i-worktree-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := &WorkingTree{gh, fs.NewFileSystem()}
+		config := &api.Config{
+			Source:     git.MustParse(repo),
+			WorkingDir: workingDir,
+			ContextDir: contextDir,
+		}
+		info, err := w.Download(config)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return info, config.WorkingSourceDir
+	}
+
+	info, sourceDir := download("app")
+	defer os.RemoveAll(filepath.Dir(sourceDir))
+	if gh.WorkingTreeRepo != repo {
+		t.Errorf("Expected the working tree of %q to be listed, got %q", repo, gh.WorkingTreeRepo)
+	}
+	for name, expected := range map[string]bool{"main.go": true, "new.go": true, "ignored.o": false, "deleted.go": false, "README": false} {
+		_, err := os.Stat(filepath.Join(sourceDir, name))
+		if exists := err == nil; exists != expected {
+			t.Errorf("Expected %s to exist: %v, got error %v", name, expected, err)
+		}
+	}
+	if !info.Dirty || len(info.DiffHash) == 0 || info.CommitID != "1bf4f04" || info.ContextDir != "app" {
+		t.Errorf("Unexpected source info %#v", info)
+	}
+
+	again, sourceDir := download("")
+	defer os.RemoveAll(filepath.Dir(sourceDir))
+	if again.DiffHash != info.DiffHash {
+		t.Errorf("Expected the diff hash to be stable, got %s and %s", info.DiffHash, again.DiffHash)
+	}
+	if _, err := os.Stat(filepath.Join(sourceDir, "README")); err != nil {
+		t.Errorf("Expected README to be copied: %v", err)
+	}
+
+	// the root of the repository as context directory
+	for _, contextDir := range []string{".", "./"} {
+		_, sourceDir := download(contextDir)
+		defer os.RemoveAll(filepath.Dir(sourceDir))
+		for _, name := range []string{"README", filepath.Join("app", "main.go")} {
+			if _, err := os.Stat(filepath.Join(sourceDir, name)); err != nil {
+				t.Errorf("Expected %s to be copied with the context directory %q: %v", name, contextDir, err)
+			}
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(repo, "app", "new.go"), []byte("package main // changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	changed, sourceDir := download("")
+	defer os.RemoveAll(filepath.Dir(sourceDir))
+	if changed.DiffHash == info.DiffHash {
+		t.Errorf("Expected the diff hash to change with the content of the changed files")
+	}
+
+	gh.WorkingTreeFiles = []git.WorkingTreeFile{{Path: "README"}}
+	clean, sourceDir := download("")
+	defer os.RemoveAll(filepath.Dir(sourceDir))
+	if clean.Dirty || len(clean.DiffHash) > 0 {
+		t.Errorf("Expected a clean working tree, got %#v", clean)
+	}
+}
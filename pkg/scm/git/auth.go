@@ -0,0 +1,332 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/openshift/source-to-image/pkg/util/cmd"
+)
+
+const (
+	// SSHHostKeyPolicyYes refuses to connect to hosts whose key is not present in
+	// the known_hosts file.
+	SSHHostKeyPolicyYes = "yes"
+
+	// SSHHostKeyPolicyNo connects to any host without verifying its key.
+	SSHHostKeyPolicyNo = "no"
+
+	// SSHHostKeyPolicyAcceptNew adds keys of previously unseen hosts to the
+	// known_hosts file, but refuses to connect to hosts whose key has changed.
+	SSHHostKeyPolicyAcceptNew = "accept-new"
+
+	// TokenUsername is the user name handed to git when a password (usually an
+	// access token) is configured without a user name.
+	TokenUsername = "x-access-token"
+)
+
+// netrcHostRegexp matches the machine names we are willing to put into the
+// generated askpass script.
+var netrcHostRegexp = regexp.MustCompile(`^[A-Za-z0-9][-A-Za-z0-9.]*$`)
+
+// AuthConfig holds the credentials and transport settings applied to every git
+// invocation, including submodule updates. The secrets are passed to git
+// through the environment, never on the command line or in the repository URL.
+type AuthConfig struct {
+	// SSHPrivateKey is the path to the private key used for SSH remotes.
+	SSHPrivateKey string
+
+	// SSHKnownHosts is the path to the known_hosts file used to verify the keys
+	// of SSH remotes.
+	SSHKnownHosts string
+
+	// SSHHostKeyPolicy sets the ssh StrictHostKeyChecking option. Valid values
+	// are "yes", "no" and "accept-new".
+	SSHHostKeyPolicy string
+
+	// Username is the user name used for HTTP(S) remotes.
+	Username string
+
+	// Password is the password or access token used for HTTP(S) remotes. It is
+	// handed to git through GIT_ASKPASS.
+	Password string
+
+	// PasswordFile is the path to a file holding the password or access token.
+	// It is read before every git invocation and takes precedence over Password.
+	PasswordFile string
+
+	// NetrcFile is the path to a .netrc file with per-host HTTP(S) credentials.
+	// Its entries take precedence over Username and Password for matching hosts.
+	NetrcFile string
+
+	// CABundle is the path to a PEM file with the certificate authorities used
+	// to verify HTTPS remotes.
+	CABundle string
+}
+
+// IsEmpty returns true if no authentication setting was provided.
+func (a *AuthConfig) IsEmpty() bool {
+	return a == nil || *a == AuthConfig{}
+}
+
+// IsValidSSHHostKeyPolicy returns true if policy is empty or one of the
+// supported ssh StrictHostKeyChecking values.
+func IsValidSSHHostKeyPolicy(policy string) bool {
+	switch policy {
+	case "", SSHHostKeyPolicyYes, SSHHostKeyPolicyNo, SSHHostKeyPolicyAcceptNew:
+		return true
+	}
+	return false
+}
+
+// hasPassword returns true if a password or a password file is set.
+func (a *AuthConfig) hasPassword() bool {
+	return len(a.Password) > 0 || len(a.PasswordFile) > 0
+}
+
+// credentials returns the explicitly configured user name and password,
+// reading the password file if one is set.
+func (a *AuthConfig) credentials() (string, string, error) {
+	username := a.Username
+	if len(username) == 0 {
+		username = TokenUsername
+	}
+	password := a.Password
+	if len(a.PasswordFile) > 0 {
+		data, err := ioutil.ReadFile(a.PasswordFile)
+		if err != nil {
+			return "", "", fmt.Errorf("unable to read password file %q: %v", a.PasswordFile, err)
+		}
+		password = strings.TrimSpace(string(data))
+	}
+	return username, password, nil
+}
+
+// credentialsForHost returns the user name and password to use for the HTTP(S)
+// server host, following the same precedence as the askpass script: a
+// matching netrc machine, then the explicit credentials, then the netrc
+// default entry. It returns false if no credentials apply to host.
+func (a *AuthConfig) credentialsForHost(host string) (string, string, bool, error) {
+	var defaultEntry *netrcEntry
+	if len(a.NetrcFile) > 0 {
+		entries, err := parseNetrc(a.NetrcFile)
+		if err != nil {
+			return "", "", false, fmt.Errorf("unable to read netrc file %q: %v", a.NetrcFile, err)
+		}
+		for i, e := range entries {
+			if len(e.machine) == 0 {
+				defaultEntry = &entries[i]
+				continue
+			}
+			if strings.EqualFold(e.machine, host) {
+				return e.login, e.password, true, nil
+			}
+		}
+	}
+	if len(a.Username) > 0 || a.hasPassword() {
+		username, password, err := a.credentials()
+		return username, password, err == nil, err
+	}
+	if defaultEntry != nil {
+		return defaultEntry.login, defaultEntry.password, true, nil
+	}
+	return "", "", false, nil
+}
+
+// netrcEntry is a single machine (or default) entry of a .netrc file.
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+// parseNetrc returns the entries of the .netrc file at path. Macro definitions
+// are skipped.
+func parseNetrc(path string) ([]netrcEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []netrcEntry{}
+	var current *netrcEntry
+	inMacro := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// a macro definition ends with an empty line
+			inMacro = len(strings.TrimSpace(line)) > 0
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			if strings.HasPrefix(fields[i], "#") {
+				break
+			}
+			value := ""
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				entries = append(entries, netrcEntry{machine: value})
+				current = &entries[len(entries)-1]
+				i++
+			case "default":
+				entries = append(entries, netrcEntry{})
+				current = &entries[len(entries)-1]
+			case "login":
+				if current != nil {
+					current.login = value
+				}
+				i++
+			case "password":
+				if current != nil {
+					current.password = value
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// shellQuote quotes s so that it is passed as a single word to the shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// sshCommand returns the value of GIT_SSH_COMMAND for the SSH settings, or an
+// empty string if none are set.
+func (a *AuthConfig) sshCommand() string {
+	args := []string{}
+	if len(a.SSHPrivateKey) > 0 {
+		args = append(args, "-i", shellQuote(a.SSHPrivateKey), "-o", "IdentitiesOnly=yes")
+	}
+	if len(a.SSHKnownHosts) > 0 {
+		args = append(args, "-o", "UserKnownHostsFile="+shellQuote(a.SSHKnownHosts))
+	}
+	if len(a.SSHHostKeyPolicy) > 0 {
+		args = append(args, "-o", "StrictHostKeyChecking="+a.SSHHostKeyPolicy)
+	}
+	if len(args) == 0 {
+		return ""
+	}
+	return "ssh " + strings.Join(args, " ")
+}
+
+// askpassScript returns a GIT_ASKPASS script answering the git credential
+// prompts with values read from the environment, and the environment it needs.
+// git prompts with "Username for 'https://host': " and
+// "Password for 'https://user@host': ", so the host is matched in the prompt.
+func (a *AuthConfig) askpassScript() (string, []string, error) {
+	env := []string{}
+	hosts := []string{}
+	if len(a.NetrcFile) > 0 {
+		entries, err := parseNetrc(a.NetrcFile)
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to read netrc file %q: %v", a.NetrcFile, err)
+		}
+		for _, e := range entries {
+			if len(e.machine) == 0 {
+				// the default entry is used when no explicit credentials are set
+				if len(a.Username) == 0 && !a.hasPassword() {
+					env = append(env, "S2I_GIT_USERNAME="+e.login, "S2I_GIT_PASSWORD="+e.password)
+				}
+				continue
+			}
+			if !netrcHostRegexp.MatchString(e.machine) {
+				log.Warningf("Ignoring netrc entry for unsupported machine name %q", e.machine)
+				continue
+			}
+			i := len(hosts)
+			hosts = append(hosts, e.machine)
+			env = append(env,
+				fmt.Sprintf("S2I_GIT_NETRC_%d_USERNAME=%s", i, e.login),
+				fmt.Sprintf("S2I_GIT_NETRC_%d_PASSWORD=%s", i, e.password),
+			)
+		}
+	}
+	if len(a.Username) > 0 || a.hasPassword() {
+		username, password, err := a.credentials()
+		if err != nil {
+			return "", nil, err
+		}
+		env = append(env, "S2I_GIT_USERNAME="+username, "S2I_GIT_PASSWORD="+password)
+	}
+
+	script := &bytes.Buffer{}
+	script.WriteString("#!/bin/sh\n")
+	script.WriteString("case \"$1\" in\n")
+	for _, field := range []string{"USERNAME", "PASSWORD"} {
+		if field == "USERNAME" {
+			script.WriteString("Username*)\n")
+		} else {
+			script.WriteString("*)\n")
+		}
+		script.WriteString("\tcase \"$1\" in\n")
+		for i, host := range hosts {
+			fmt.Fprintf(script, "\t*[/@]%s[:\\']*) printf '%%s\\n' \"$S2I_GIT_NETRC_%d_%s\" ;;\n", host, i, field)
+		}
+		fmt.Fprintf(script, "\t*) printf '%%s\\n' \"$S2I_GIT_%s\" ;;\n", field)
+		script.WriteString("\tesac ;;\n")
+	}
+	script.WriteString("esac\n")
+	return script.String(), env, nil
+}
+
+// apply returns opts with the environment needed by git to authenticate
+// appended, and a function removing the temporary files created for it. The
+// function must be called once the git process has exited.
+func (a *AuthConfig) apply(opts cmd.CommandOpts) (cmd.CommandOpts, func(), error) {
+	cleanup := func() {}
+	if a.IsEmpty() {
+		return opts, cleanup, nil
+	}
+
+	// never let git block on an interactive prompt
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	if sshCommand := a.sshCommand(); len(sshCommand) > 0 {
+		env = append(env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+	if len(a.CABundle) > 0 {
+		env = append(env, "GIT_SSL_CAINFO="+a.CABundle)
+	}
+	if len(a.Username) > 0 || a.hasPassword() || len(a.NetrcFile) > 0 {
+		script, scriptEnv, err := a.askpassScript()
+		if err != nil {
+			return opts, cleanup, err
+		}
+		f, err := ioutil.TempFile("", "s2i-git-askpass")
+		if err != nil {
+			return opts, cleanup, err
+		}
+		cleanup = func() { os.Remove(f.Name()) }
+		_, err = f.WriteString(script)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chmod(f.Name(), 0700)
+		}
+		if err != nil {
+			cleanup()
+			return opts, func() {}, err
+		}
+		env = append(env, "GIT_ASKPASS="+f.Name())
+		env = append(env, scriptEnv...)
+	}
+
+	opts.EnvAppend = append(append([]string{}, opts.EnvAppend...), env...)
+	return opts, cleanup, nil
+}
@@ -0,0 +1,134 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/util/cmd"
+)
+
+func envValue(env []string, name string) (string, bool) {
+	for _, e := range env {
+		if strings.HasPrefix(e, name+"=") {
+			return strings.TrimPrefix(e, name+"="), true
+		}
+	}
+	return "", false
+}
+
+func TestAuthApplyEmpty(t *testing.T) {
+	var auth *AuthConfig
+	opts, cleanup, err := auth.apply(cmd.CommandOpts{Dir: "repo"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer cleanup()
+	if !reflect.DeepEqual(opts, cmd.CommandOpts{Dir: "repo"}) {
+		t.Errorf("Unexpected options: %#v", opts)
+	}
+}
+
+func TestAuthApplySSH(t *testing.T) {
+	auth := &AuthConfig{
+		SSHPrivateKey:    "/keys/id_rsa",
+		SSHKnownHosts:    "/keys/known hosts",
+		SSHHostKeyPolicy: SSHHostKeyPolicyYes,
+		CABundle:         "/certs/ca.pem",
+	}
+	opts, cleanup, err := auth.apply(cmd.CommandOpts{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer cleanup()
+
+	expected := "ssh -i '/keys/id_rsa' -o IdentitiesOnly=yes -o UserKnownHostsFile='/keys/known hosts' -o StrictHostKeyChecking=yes"
+	if v, _ := envValue(opts.EnvAppend, "GIT_SSH_COMMAND"); v != expected {
+		t.Errorf("Expected GIT_SSH_COMMAND %q, got %q", expected, v)
+	}
+	if v, _ := envValue(opts.EnvAppend, "GIT_SSL_CAINFO"); v != "/certs/ca.pem" {
+		t.Errorf("Expected GIT_SSL_CAINFO to be set, got %q", v)
+	}
+	if _, ok := envValue(opts.EnvAppend, "GIT_ASKPASS"); ok {
+		t.Errorf("Expected GIT_ASKPASS not to be set")
+	}
+}
+
+func TestAuthApplyAskpass(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("askpass script requires a POSIX shell")
+	}
+	dir, err := ioutil.TempDir("", "s2i-git-auth-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	netrc := filepath.Join(dir, "netrc")
+	err = ioutil.WriteFile(netrc, []byte("machine gitlab.example.com login netrcuser password netrcpass\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passwordFile := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(passwordFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := &AuthConfig{PasswordFile: passwordFile, NetrcFile: netrc}
+	opts, cleanup, err := auth.apply(cmd.CommandOpts{EnvAppend: []string{"FOO=bar"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	askpass, ok := envValue(opts.EnvAppend, "GIT_ASKPASS")
+	if !ok {
+		t.Fatalf("Expected GIT_ASKPASS to be set")
+	}
+	if opts.EnvAppend[0] != "FOO=bar" {
+		t.Errorf("Expected the original environment to be kept, got %v", opts.EnvAppend)
+	}
+	for _, e := range opts.EnvAppend {
+		if strings.Contains(e, "s3cret") && !strings.HasPrefix(e, "S2I_GIT_PASSWORD=") {
+			t.Errorf("Password leaked into %q", e)
+		}
+	}
+
+	tests := map[string]string{
+		"Username for 'https://github.com': ":                        TokenUsername,
+		"Password for 'https://x-access-token@github.com': ":         "s3cret",
+		"Username for 'https://gitlab.example.com': ":                "netrcuser",
+		"Password for 'https://netrcuser@gitlab.example.com:8443': ": "netrcpass",
+	}
+	for prompt, expected := range tests {
+		c := exec.Command(askpass, prompt)
+		c.Env = append(os.Environ(), opts.EnvAppend...)
+		out, err := c.Output()
+		if err != nil {
+			t.Fatalf("Unexpected error running askpass: %v", err)
+		}
+		if strings.TrimSpace(string(out)) != expected {
+			t.Errorf("%s: expected %q, got %q", prompt, expected, out)
+		}
+	}
+
+	cleanup()
+	if _, err := os.Stat(askpass); !os.IsNotExist(err) {
+		t.Errorf("Expected the askpass script to be removed, got %v", err)
+	}
+}
+
+func TestGitCloneWithAuth(t *testing.T) {
+	gh, ch := getGit()
+	gh.(*stiGit).auth = &AuthConfig{SSHPrivateKey: "/keys/id_rsa"}
+	err := gh.SubmoduleUpdate("repo1", true, true, SubmoduleConfig{})
+	if err != nil {
+		t.Errorf("Unexpected error returned from submodule update: %v", err)
+	}
+	if _, ok := envValue(ch.Opts.EnvAppend, "GIT_SSH_COMMAND"); !ok {
+		t.Errorf("Expected GIT_SSH_COMMAND in the environment, got %v", ch.Opts.EnvAppend)
+	}
+}
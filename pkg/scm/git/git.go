@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -24,22 +25,62 @@ var lsTreeRegexp = regexp.MustCompile("([0-7]{6}) [^ ]+ [0-9a-f]{40}\t(.*)")
 type Git interface {
 	Clone(source *URL, target string, opts CloneConfig) error
 	Checkout(repo, ref string) error
-	SubmoduleUpdate(repo string, init, recursive bool) error
+	SubmoduleUpdate(repo string, init, recursive bool, c SubmoduleConfig) error
+	LFSPull(repo string, recursive bool, c LFSConfig) error
 	LsTree(repo, ref string, recursive bool) ([]os.FileInfo, error)
+	ListWorkingTree(repo string) ([]WorkingTreeFile, error)
 	GetInfo(string) *SourceInfo
 }
 
 // New returns a new instance of the default implementation of the Git interface
 func New(fs fs.FileSystem, runner cmd.CommandRunner) Git {
+	return NewWithAuth(fs, runner, nil)
+}
+
+// NewWithAuth returns a new instance of the default implementation of the Git
+// interface which authenticates every git invocation using the provided
+// configuration.
+func NewWithAuth(fs fs.FileSystem, runner cmd.CommandRunner, auth *AuthConfig) Git {
+	return NewWithOutput(fs, runner, auth, nil)
+}
+
+// NewWithOutput returns a new instance of the default implementation of the
+// Git interface like NewWithAuth, which writes the standard output of git to
+// stdout instead of the standard output of the process, e.g. to keep the
+// latter free for a build context streamed to it.
+func NewWithOutput(fs fs.FileSystem, runner cmd.CommandRunner, auth *AuthConfig, stdout io.Writer) Git {
 	return &stiGit{
 		FileSystem:    fs,
 		CommandRunner: runner,
+		auth:          auth,
+		out:           stdout,
 	}
 }
 
 type stiGit struct {
 	fs.FileSystem
 	cmd.CommandRunner
+	auth *AuthConfig
+	out  io.Writer
+}
+
+// stdout returns the writer of the standard output of git.
+func (h *stiGit) stdout() io.Writer {
+	if h.out == nil {
+		return os.Stdout
+	}
+	return h.out
+}
+
+// run executes git with the provided options extended by the authentication
+// environment.
+func (h *stiGit) run(opts cmd.CommandOpts, args ...string) error {
+	opts, cleanup, err := h.auth.apply(opts)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return h.RunWithOptions(opts, "git", args...)
 }
 
 func cloneConfigToArgs(opts CloneConfig) []string {
@@ -183,9 +224,9 @@ func (h *stiGit) Clone(src *URL, target string, c CloneConfig) error {
 	cloneArgs = append(cloneArgs, []string{source.StringNoFragment(), target}...)
 	stderr := &bytes.Buffer{}
 	opts := cmd.CommandOpts{Stderr: stderr}
-	err = h.RunWithOptions(opts, "git", cloneArgs...)
+	err = h.run(opts, cloneArgs...)
 	if err != nil {
-		log.Errorf("Clone failed: source %s, target %s, with output %q", source, target, stderr.String())
+		log.Errorf("Clone failed: source %s, target %s, with output %q", source.StringNoCredentials(), target, stderr.String())
 		return err
 	}
 	return nil
@@ -194,29 +235,33 @@ func (h *stiGit) Clone(src *URL, target string, c CloneConfig) error {
 // Checkout checks out a specific branch reference of a given git repository
 func (h *stiGit) Checkout(repo, ref string) error {
 	opts := cmd.CommandOpts{
-		Stdout: os.Stdout,
+		Stdout: h.stdout(),
 		Stderr: os.Stderr,
 		Dir:    repo,
 	}
 	if log.Is(1) {
-		return h.RunWithOptions(opts, "git", "checkout", "--quiet", ref)
+		return h.run(opts, "checkout", "--quiet", ref)
 	}
-	return h.RunWithOptions(opts, "git", "checkout", ref)
+	return h.run(opts, "checkout", ref)
 }
 
 // SubmoduleInit initializes/clones submodules
 func (h *stiGit) SubmoduleInit(repo string) error {
 	opts := cmd.CommandOpts{
-		Stdout: os.Stdout,
+		Stdout: h.stdout(),
 		Stderr: os.Stderr,
 		Dir:    repo,
 	}
-	return h.RunWithOptions(opts, "git", "submodule", "init")
+	return h.run(opts, "submodule", "init")
 }
 
 // SubmoduleUpdate checks out submodules to their correct version.
 // Optionally also inits submodules, optionally operates recursively.
-func (h *stiGit) SubmoduleUpdate(repo string, init, recursive bool) error {
+func (h *stiGit) SubmoduleUpdate(repo string, init, recursive bool, c SubmoduleConfig) error {
+	if !c.IsEmpty() {
+		return h.submoduleUpdate(repo, "", init, recursive, c)
+	}
+
 	updateArgs := []string{"submodule", "update"}
 	if init {
 		updateArgs = append(updateArgs, "--init")
@@ -226,11 +271,11 @@ func (h *stiGit) SubmoduleUpdate(repo string, init, recursive bool) error {
 	}
 
 	opts := cmd.CommandOpts{
-		Stdout: os.Stdout,
+		Stdout: h.stdout(),
 		Stderr: os.Stderr,
 		Dir:    repo,
 	}
-	return h.RunWithOptions(opts, "git", updateArgs...)
+	return h.run(opts, updateArgs...)
 }
 
 // LsTree returns a slice of os.FileInfo objects populated with the paths and
@@ -242,9 +287,13 @@ func (h *stiGit) LsTree(repo, ref string, recursive bool) ([]os.FileInfo, error)
 		args = append(args, "-r")
 	}
 
-	opts := cmd.CommandOpts{
+	opts, cleanup, err := h.auth.apply(cmd.CommandOpts{
 		Dir: repo,
+	})
+	if err != nil {
+		return nil, err
 	}
+	defer cleanup()
 
 	r, err := h.StartWithStdoutPipe(opts, "git", args...)
 	if err != nil {
@@ -292,9 +341,9 @@ func (h *stiGit) LsTree(repo, ref string, recursive bool) ([]os.FileInfo, error)
 
 // GetInfo retrieves the information about the source code and commit
 func (h *stiGit) GetInfo(repo string) *SourceInfo {
-	git := func(arg ...string) string {
+	gitIn := func(dir string, arg ...string) string {
 		command := exec.Command("git", arg...)
-		command.Dir = repo
+		command.Dir = dir
 		out, err := command.CombinedOutput()
 		if err != nil {
 			log.V(1).Infof("Error executing 'git %#v': %s (%v)", arg, out, err)
@@ -302,8 +351,11 @@ func (h *stiGit) GetInfo(repo string) *SourceInfo {
 		}
 		return strings.TrimSpace(string(out))
 	}
+	git := func(arg ...string) string {
+		return gitIn(repo, arg...)
+	}
 	return &SourceInfo{
-		Location:       git("config", "--get", "remote.origin.url"),
+		Location:       RedactCredentials(git("config", "--get", "remote.origin.url")),
 		Ref:            git("rev-parse", "--abbrev-ref", "HEAD"),
 		CommitID:       git("rev-parse", "--verify", "HEAD"),
 		AuthorName:     git("--no-pager", "show", "-s", "--format=%an", "HEAD"),
@@ -312,5 +364,6 @@ func (h *stiGit) GetInfo(repo string) *SourceInfo {
 		CommitterEmail: git("--no-pager", "show", "-s", "--format=%ce", "HEAD"),
 		Date:           git("--no-pager", "show", "-s", "--format=%ad", "HEAD"),
 		Message:        git("--no-pager", "show", "-s", "--format=%<(80,trunc)%s", "HEAD"),
+		Submodules:     checkedOutSubmodules(h.FileSystem, repo, "", gitIn),
 	}
 }
@@ -1,6 +1,7 @@
 package git
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -120,6 +121,18 @@ func TestGitCheckout(t *testing.T) {
 	}
 }
 
+func TestGitOutput(t *testing.T) {
+	cr := &testcmd.FakeCmdRunner{}
+	stdout := &bytes.Buffer{}
+	gh := NewWithOutput(&testfs.FakeFileSystem{}, cr, nil, stdout)
+	if err := gh.SubmoduleUpdate("repo1", true, true, SubmoduleConfig{}); err != nil {
+		t.Errorf("Unexpected error returned from submodule update: %v", err)
+	}
+	if cr.Opts.Stdout != stdout {
+		t.Errorf("Expected the output of git to be written to the given writer, got %v", cr.Opts.Stdout)
+	}
+}
+
 func TestGitCheckoutError(t *testing.T) {
 	gh, ch := getGit()
 	runErr := fmt.Errorf("Run Error")
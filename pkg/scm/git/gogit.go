@@ -0,0 +1,498 @@
+package git
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/openshift/source-to-image/pkg/util/fs"
+)
+
+// goGitDateFormat matches the default date format of 'git show'.
+const goGitDateFormat = "Mon Jan 2 15:04:05 2006 -0700"
+
+var installLocalTransport sync.Once
+
+// NewGoGit returns an implementation of the Git interface which does not
+// require the git binary. It is used when git is not installed on the system.
+func NewGoGit(auth *AuthConfig) Git {
+	// The go-git file transport runs git-upload-pack, so local repositories
+	// are served in-process instead.
+	installLocalTransport.Do(func() {
+		client.InstallProtocol("file", server.NewClient(localLoader{}))
+	})
+	return &goGit{auth: auth}
+}
+
+type goGit struct {
+	auth *AuthConfig
+}
+
+// localLoader loads local repositories for the in-process file transport. In
+// contrast to the go-git filesystem loader it supports non-bare repositories.
+type localLoader struct{}
+
+// Load opens the repository at the endpoint path.
+func (localLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	r, err := gogit.PlainOpen(ep.Path)
+	if err == gogit.ErrRepositoryNotExists {
+		return nil, transport.ErrRepositoryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.Storer, nil
+}
+
+// Clone clones a git repository to a specific target directory.
+func (h *goGit) Clone(source *URL, target string, c CloneConfig) error {
+	rawurl := source.StringNoFragment()
+	if source.IsLocal() {
+		path, err := filepath.Abs(source.LocalPath())
+		if err != nil {
+			return err
+		}
+		rawurl = path
+	}
+	auth, err := h.auth.goGitAuth(rawurl)
+	if err != nil {
+		return err
+	}
+	if err := h.auth.installHTTPSTransport(); err != nil {
+		return err
+	}
+	opts := &gogit.CloneOptions{
+		URL:  rawurl,
+		Auth: auth,
+	}
+	if !c.Quiet {
+		opts.Progress = os.Stderr
+	}
+	if _, err = gogit.PlainClone(target, false, opts); err != nil {
+		log.Errorf("Clone failed: source %s, target %s: %v", source.StringNoCredentials(), target, err)
+		return err
+	}
+	if c.Recursive {
+		return h.SubmoduleUpdate(target, true, true, SubmoduleConfig{})
+	}
+	return nil
+}
+
+// Checkout checks out a specific branch reference of a given git repository.
+// Like 'git checkout', a branch only known to the origin remote is checked out
+// as a new local branch, anything else results in a detached HEAD.
+func (h *goGit) Checkout(repo, ref string) error {
+	if ref == "HEAD" {
+		return nil
+	}
+	r, err := gogit.PlainOpen(repo)
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	branch := plumbing.NewBranchReferenceName(ref)
+	if _, err := r.Reference(branch, false); err == nil {
+		return w.Checkout(&gogit.CheckoutOptions{Branch: branch})
+	}
+	if remote, err := r.Reference(plumbing.NewRemoteReferenceName(gogit.DefaultRemoteName, ref), true); err == nil {
+		return w.Checkout(&gogit.CheckoutOptions{Branch: branch, Hash: remote.Hash(), Create: true})
+	}
+	hash, err := r.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return fmt.Errorf("unable to resolve reference %q: %v", ref, err)
+	}
+	return w.Checkout(&gogit.CheckoutOptions{Hash: *hash})
+}
+
+// SubmoduleUpdate checks out submodules to their correct version.
+// Optionally also inits submodules, optionally operates recursively.
+func (h *goGit) SubmoduleUpdate(repo string, init, recursive bool, c SubmoduleConfig) error {
+	return h.submoduleUpdate(repo, "", init, recursive, c)
+}
+
+// submoduleUpdate checks out the submodules of repo selected by the
+// configuration. prefix is the path of repo relative to the source repository.
+func (h *goGit) submoduleUpdate(repo, prefix string, init, recursive bool, c SubmoduleConfig) error {
+	r, err := gogit.PlainOpen(repo)
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	submodules, err := w.Submodules()
+	if err != nil {
+		return err
+	}
+	if err := h.auth.installHTTPSTransport(); err != nil {
+		return err
+	}
+
+	for _, submodule := range submodules {
+		cfg, err := r.Config()
+		if err != nil {
+			return err
+		}
+		name := submodule.Config().Name
+		if !c.Selects(path.Join(prefix, submodule.Config().Path)) {
+			log.V(2).Infof("Skipping submodule %q", path.Join(prefix, submodule.Config().Path))
+			continue
+		}
+		if _, initialized := cfg.Submodules[name]; !initialized {
+			if !init {
+				continue
+			}
+			if err := submodule.Init(); err != nil && err != gogit.ErrSubmoduleAlreadyInitialized {
+				return err
+			}
+		}
+
+		// Fetch with the authentication matching the submodule remote, as
+		// SubmoduleUpdateOptions carries a single AuthMethod.
+		sr, err := submodule.Repository()
+		if err != nil {
+			return err
+		}
+		remote, err := sr.Remote(gogit.DefaultRemoteName)
+		if err != nil {
+			return err
+		}
+		auth, err := h.auth.goGitAuth(remote.Config().URLs[0])
+		if err != nil {
+			return err
+		}
+		err = sr.Fetch(&gogit.FetchOptions{Auth: auth})
+		if err != nil && err != gogit.NoErrAlreadyUpToDate {
+			return fmt.Errorf("unable to fetch submodule %q: %v", name, err)
+		}
+		if err := fetchPinnedCommit(submodule, sr, auth); err != nil {
+			return fmt.Errorf("unable to fetch submodule %q: %v", name, err)
+		}
+		if err := submodule.Update(&gogit.SubmoduleUpdateOptions{NoFetch: true}); err != nil {
+			return fmt.Errorf("unable to update submodule %q: %v", name, err)
+		}
+
+		if recursive {
+			if err := h.submoduleUpdate(filepath.Join(repo, submodule.Config().Path), path.Join(prefix, submodule.Config().Path), init, recursive, c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fetchPinnedCommit fetches the commit the submodule is pinned to if the
+// fetch of the default refspec did not, e.g. as it is not on a branch. The
+// commit is fetched by its SHA, or with all the references of the remote if
+// the server does not allow fetching a commit by its SHA.
+func fetchPinnedCommit(submodule *gogit.Submodule, sr *gogit.Repository, auth transport.AuthMethod) error {
+	status, err := submodule.Status()
+	if err != nil {
+		return err
+	}
+	if _, err := sr.CommitObject(status.Expected); err != plumbing.ErrObjectNotFound {
+		return err
+	}
+	log.V(2).Infof("Fetching commit %s of submodule %q", status.Expected, submodule.Config().Name)
+	sha := status.Expected.String()
+	err = sr.Fetch(&gogit.FetchOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(sha + ":refs/s2i/pinned/" + sha)},
+		Auth:     auth,
+	})
+	if err == gogit.ErrExactSHA1NotSupported {
+		err = sr.Fetch(&gogit.FetchOptions{
+			RefSpecs: []config.RefSpec{"+refs/*:refs/s2i/all/*"},
+			Auth:     auth,
+		})
+	}
+	if err != nil && err != gogit.NoErrAlreadyUpToDate {
+		return err
+	}
+	return nil
+}
+
+// LFSPull fails if the configuration selects a Git LFS pointer file of the
+// working tree at repo, as Git LFS is not supported without the git binary.
+func (h *goGit) LFSPull(repo string, recursive bool, c LFSConfig) error {
+	pointers, err := FindLFSPointers(fs.NewFileSystem(), repo)
+	if err != nil {
+		return err
+	}
+	for _, pointer := range pointers {
+		if c.Fetches(pointer) {
+			return fmt.Errorf("Git LFS is not supported by the built-in git implementation, install git and git-lfs")
+		}
+	}
+	return nil
+}
+
+// LsTree returns a slice of os.FileInfo objects populated with the paths and
+// file modes of files known to Git.  This is used on Windows systems where the
+// executable mode metadata is lost on git checkout.
+func (h *goGit) LsTree(repo, ref string, recursive bool) ([]os.FileInfo, error) {
+	r, err := gogit.PlainOpen(repo)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := r.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve reference %q: %v", ref, err)
+	}
+	commit, err := r.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	submodules := []string{}
+	rv := []os.FileInfo{}
+	walker := object.NewTreeWalker(tree, recursive, nil)
+	defer walker.Close()
+	for {
+		path, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if recursive && entry.Mode == filemode.Dir {
+			continue
+		}
+		if recursive && entry.Mode == filemode.Submodule {
+			submodules = append(submodules, filepath.Join(repo, path))
+			continue
+		}
+		rv = append(rv, &fs.FileInfo{FileMode: os.FileMode(entry.Mode), FileName: path})
+	}
+
+	for _, submodule := range submodules {
+		rrv, err := h.LsTree(submodule, "HEAD", recursive)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, rrv...)
+	}
+
+	return rv, nil
+}
+
+// GetInfo retrieves the information about the source code and commit
+func (h *goGit) GetInfo(repo string) *SourceInfo {
+	info := &SourceInfo{}
+	r, err := gogit.PlainOpen(repo)
+	if err != nil {
+		log.V(1).Infof("Error opening git repository %q: %v", repo, err)
+		return info
+	}
+	if remote, err := r.Remote(gogit.DefaultRemoteName); err == nil && len(remote.Config().URLs) > 0 {
+		info.Location = RedactCredentials(remote.Config().URLs[0])
+	}
+	head, err := r.Head()
+	if err != nil {
+		log.V(1).Infof("Error resolving HEAD of %q: %v", repo, err)
+		return info
+	}
+	info.Ref = "HEAD"
+	if head.Name().IsBranch() {
+		info.Ref = head.Name().Short()
+	}
+	info.CommitID = head.Hash().String()
+
+	commit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		log.V(1).Infof("Error reading commit %s: %v", head.Hash(), err)
+		return info
+	}
+	info.AuthorName = commit.Author.Name
+	info.AuthorEmail = commit.Author.Email
+	info.CommitterName = commit.Committer.Name
+	info.CommitterEmail = commit.Committer.Email
+	info.Date = commit.Author.When.Format(goGitDateFormat)
+	info.Message = commitSubject(commit.Message)
+	info.Submodules = goGitSubmodules(r, "")
+	return info
+}
+
+// commitSubject returns the subject of a commit message the way
+// 'git show --format=%<(80,trunc)%s' prints it: the first paragraph joined
+// into a single line and truncated to 80 characters.
+func commitSubject(message string) string {
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimLeft(message, "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			break
+		}
+		lines = append(lines, line)
+	}
+	subject := []rune(strings.Join(lines, " "))
+	if len(subject) > 80 {
+		return string(subject[:78]) + ".."
+	}
+	return string(subject)
+}
+
+// installHTTPSTransport installs the go-git HTTPS transport trusting the
+// configured CA bundle in addition to the system certificate authorities, or
+// the default transport if there is no CA bundle.
+func (a *AuthConfig) installHTTPSTransport() error {
+	if a == nil || len(a.CABundle) == 0 {
+		client.InstallProtocol("https", githttp.DefaultClient)
+		return nil
+	}
+	data, err := ioutil.ReadFile(a.CABundle)
+	if err != nil {
+		return fmt.Errorf("unable to read CA bundle %q: %v", a.CABundle, err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in CA bundle %q", a.CABundle)
+	}
+	client.InstallProtocol("https", githttp.NewClient(&http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}))
+	return nil
+}
+
+// goGitAuth returns the go-git authentication method for the remote rawurl,
+// or nil to use the go-git defaults.
+func (a *AuthConfig) goGitAuth(rawurl string) (transport.AuthMethod, error) {
+	if a.IsEmpty() {
+		return nil, nil
+	}
+	ep, err := transport.NewEndpoint(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ep.Protocol {
+	case "ssh":
+		user := ep.User
+		if len(user) == 0 {
+			user = "git"
+		}
+		callback, err := a.hostKeyCallback()
+		if err != nil {
+			return nil, err
+		}
+		if len(a.SSHPrivateKey) > 0 {
+			auth, err := gitssh.NewPublicKeysFromFile(user, a.SSHPrivateKey, "")
+			if err != nil {
+				return nil, fmt.Errorf("unable to load SSH key %q: %v", a.SSHPrivateKey, err)
+			}
+			auth.HostKeyCallback = callback
+			return auth, nil
+		}
+		if callback != nil {
+			auth, err := gitssh.NewSSHAgentAuth(user)
+			if err != nil {
+				return nil, err
+			}
+			auth.HostKeyCallback = callback
+			return auth, nil
+		}
+	case "http", "https":
+		username, password, ok, err := a.credentialsForHost(ep.Host)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &githttp.BasicAuth{Username: username, Password: password}, nil
+		}
+	}
+	return nil, nil
+}
+
+// hostKeyCallback returns the SSH host key verification implementing the
+// configured known_hosts file and host key policy, or nil to use the go-git
+// default (the user's known_hosts file).
+func (a *AuthConfig) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	switch a.SSHHostKeyPolicy {
+	case SSHHostKeyPolicyNo:
+		return ssh.InsecureIgnoreHostKey(), nil
+	case SSHHostKeyPolicyAcceptNew:
+		return acceptNewHostKeyCallback(a.SSHKnownHosts)
+	}
+	if len(a.SSHKnownHosts) > 0 {
+		return gitssh.NewKnownHostsCallback(a.SSHKnownHosts)
+	}
+	return nil, nil
+}
+
+// acceptNewHostKeyCallback verifies host keys against the known_hosts file
+// and records the keys of previously unseen hosts in it.
+func acceptNewHostKeyCallback(file string) (ssh.HostKeyCallback, error) {
+	if len(file) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	callback, err := knownhosts.New(file)
+	if err != nil {
+		return nil, err
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		keyErr, ok := err.(*knownhosts.KeyError)
+		if !ok || len(keyErr.Want) > 0 {
+			return err
+		}
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		log.V(1).Infof("Adding host key of %s to %s", hostname, file)
+		_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+		return err
+	}, nil
+}
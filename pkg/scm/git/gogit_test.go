@@ -0,0 +1,220 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+
+	"github.com/openshift/source-to-image/pkg/util/cmd"
+)
+
+func TestGoGitCloneLocalRepository(t *testing.T) {
+	source, err := CreateLocalGitDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(source)
+	target, err := ioutil.TempDir("", "s2i-gogit-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+	target = filepath.Join(target, "clone")
+
+	g := NewGoGit(nil)
+	if err := g.Clone(MustParse(source), target, CloneConfig{Quiet: true}); err != nil {
+		t.Fatalf("Unexpected error cloning %s: %v", source, err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "testfile")); err != nil {
+		t.Errorf("Expected testfile to be checked out: %v", err)
+	}
+
+	expected := New(nil, nil).GetInfo(source)
+	info := g.GetInfo(target)
+	expected.Location = source
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("Expected source info\n\t%#v\ngot\n\t%#v", expected, info)
+	}
+
+	if err := g.Checkout(target, info.CommitID); err != nil {
+		t.Fatalf("Unexpected error checking out %s: %v", info.CommitID, err)
+	}
+	if ref := g.GetInfo(target).Ref; ref != "HEAD" {
+		t.Errorf("Expected a detached HEAD, got %q", ref)
+	}
+	if err := g.Checkout(target, "does-not-exist"); err == nil {
+		t.Errorf("Expected an error checking out an unknown reference")
+	}
+
+	files, err := g.LsTree(target, "HEAD", true)
+	if err != nil {
+		t.Fatalf("Unexpected error listing the tree: %v", err)
+	}
+	if len(files) != 1 || files[0].Name() != "testfile" || files[0].Mode() != 0100644 {
+		t.Errorf("Unexpected tree %#v", files)
+	}
+}
+
+// allowFileProtocol allows the git commands of the test to use local
+// submodules, and returns a function restoring the environment.
+func allowFileProtocol() func() {
+	env := map[string]string{
+		"GIT_CONFIG_COUNT":   "1",
+		"GIT_CONFIG_KEY_0":   "protocol.file.allow",
+		"GIT_CONFIG_VALUE_0": "always",
+	}
+	previous := map[string]*string{}
+	for name, value := range env {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, old := range previous {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
+			}
+		}
+	}
+}
+
+func TestGoGitSubmodulePinnedOutsideBranches(t *testing.T) {
+	// allow git to add the local submodule
+	defer allowFileProtocol()()
+
+	cr := cmd.NewCommandRunner()
+	commitEnv := []string{"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@test", "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@test"}
+	submodule, err := CreateLocalGitDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(submodule)
+	// the pinned commit is only reachable from a reference outside the
+	// branches, e.g. the head of a pull request
+	for _, args := range [][]string{
+		{"checkout", "--quiet", "-b", "change"},
+		{"commit", "--quiet", "--allow-empty", "-m", "change"},
+		{"update-ref", "refs/changes/1", "HEAD"},
+		{"checkout", "--quiet", "-"},
+		{"branch", "--quiet", "-D", "change"},
+	} {
+		if err := cr.RunWithOptions(cmd.CommandOpts{Dir: submodule, EnvAppend: commitEnv}, "git", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := &bytes.Buffer{}
+	if err := cr.RunWithOptions(cmd.CommandOpts{Dir: submodule, Stdout: out}, "git", "rev-parse", "refs/changes/1"); err != nil {
+		t.Fatal(err)
+	}
+	pinned := strings.TrimSpace(out.String())
+
+	repo, err := CreateLocalGitDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+	for _, args := range [][]string{
+		{"submodule", "--quiet", "add", submodule, "lib"},
+		{"-C", "lib", "checkout", "--quiet", pinned},
+		{"add", "lib"},
+		{"commit", "--quiet", "-m", "submodule"},
+	} {
+		if err := cr.RunWithOptions(cmd.CommandOpts{Dir: repo, EnvAppend: commitEnv}, "git", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	target, err := ioutil.TempDir("", "s2i-gogit-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+	g := NewGoGit(nil)
+	if err := g.Clone(MustParse(repo), target, CloneConfig{Recursive: true, Quiet: true}); err != nil {
+		t.Fatalf("Unexpected error cloning %s: %v", repo, err)
+	}
+	if info := g.GetInfo(target); len(info.Submodules) != 1 || info.Submodules[0].CommitID != pinned {
+		t.Errorf("Expected the submodule to be checked out at %s, got %#v", pinned, info.Submodules)
+	}
+}
+
+func TestCommitSubject(t *testing.T) {
+	tests := map[string]string{
+		"subject\n":                         "subject",
+		"first line\nsecond line\n\nbody\n": "first line second line",
+		strings.Repeat("a", 81):             strings.Repeat("a", 78) + "..",
+		strings.Repeat("a", 80):             strings.Repeat("a", 80),
+	}
+	for message, expected := range tests {
+		if got := commitSubject(message); got != expected {
+			t.Errorf("%q: expected %q, got %q", message, expected, got)
+		}
+	}
+}
+
+func TestGoGitAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s2i-gogit-auth-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	netrc := filepath.Join(dir, "netrc")
+	err = ioutil.WriteFile(netrc, []byte("machine gitlab.example.com login netrcuser password netrcpass\ndefault login anonymous password none\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := &AuthConfig{Password: "s3cret", NetrcFile: netrc}
+	tests := map[string]*githttp.BasicAuth{
+		"https://github.com/openshift/source-to-image":        {Username: TokenUsername, Password: "s3cret"},
+		"https://gitlab.example.com:8443/openshift/s2i.git":   {Username: "netrcuser", Password: "netrcpass"},
+		"http://user@gitlab.example.com/openshift/s2i.git":    {Username: "netrcuser", Password: "netrcpass"},
+		"https://bitbucket.example.com/openshift/s2i.git#dev": {Username: TokenUsername, Password: "s3cret"},
+	}
+	for rawurl, expected := range tests {
+		method, err := auth.goGitAuth(rawurl)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", rawurl, err)
+		}
+		if !reflect.DeepEqual(method, expected) {
+			t.Errorf("%s: expected %#v, got %#v", rawurl, expected, method)
+		}
+	}
+
+	auth = &AuthConfig{NetrcFile: netrc}
+	method, err := auth.goGitAuth("https://github.com/openshift/source-to-image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (&githttp.BasicAuth{Username: "anonymous", Password: "none"}); !reflect.DeepEqual(method, expected) {
+		t.Errorf("Expected the netrc default entry, got %#v", method)
+	}
+
+	var empty *AuthConfig
+	if method, err := empty.goGitAuth("https://github.com/openshift/source-to-image"); method != nil || err != nil {
+		t.Errorf("Expected no authentication, got %#v, %v", method, err)
+	}
+
+	auth = &AuthConfig{SSHHostKeyPolicy: SSHHostKeyPolicyNo, SSHPrivateKey: filepath.Join(dir, "missing")}
+	if _, err := auth.goGitAuth("git@github.com:openshift/source-to-image.git"); err == nil {
+		t.Errorf("Expected an error loading a missing SSH key")
+	}
+	auth = &AuthConfig{SSHKnownHosts: filepath.Join(dir, "known_hosts"), SSHHostKeyPolicy: SSHHostKeyPolicyAcceptNew}
+	if callback, err := auth.hostKeyCallback(); err != nil || callback == nil {
+		t.Errorf("Expected a host key callback, got %v", err)
+	}
+	if _, err := os.Stat(auth.SSHKnownHosts); err != nil {
+		t.Errorf("Expected the known_hosts file to be created: %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/openshift/source-to-image/pkg/util/cmd"
+	"github.com/openshift/source-to-image/pkg/util/fs"
+)
+
+const (
+	// lfsPointerPrefix is the first line of every Git LFS pointer file.
+	lfsPointerPrefix = "version https://git-lfs.github.com/spec/v1"
+
+	// lfsPointerMaxSize is the maximum size of a Git LFS pointer file.
+	lfsPointerMaxSize = 1024
+)
+
+// walkWorkingTree calls walkFn for every regular file in the working tree at
+// dir, including checked out submodules, skipping the git metadata. A missing
+// dir is treated as empty.
+func walkWorkingTree(fs fs.FileSystem, dir string, walkFn func(path string, info os.FileInfo) error) error {
+	err := fs.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == ".git" {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return walkFn(path, info)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// UsesLFS returns true if a .gitattributes file in the working tree at dir,
+// including those of checked out submodules, assigns the Git LFS filter.
+func UsesLFS(fs fs.FileSystem, dir string) (bool, error) {
+	found := false
+	err := walkWorkingTree(fs, dir, func(path string, info os.FileInfo) error {
+		if found || info.Name() != ".gitattributes" {
+			return nil
+		}
+		r, err := fs.Open(path)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			for _, attr := range fields[1:] {
+				if attr == "filter=lfs" {
+					found = true
+					return nil
+				}
+			}
+		}
+		return scanner.Err()
+	})
+	return found, err
+}
+
+// FindLFSPointers returns the slash separated paths, relative to dir, of the
+// Git LFS pointer files in the working tree at dir, including checked out
+// submodules.
+func FindLFSPointers(fs fs.FileSystem, dir string) ([]string, error) {
+	pointers := []string{}
+	err := walkWorkingTree(fs, dir, func(path string, info os.FileInfo) error {
+		if info.Size() > lfsPointerMaxSize {
+			return nil
+		}
+		r, err := fs.Open(path)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		header := make([]byte, len(lfsPointerPrefix))
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		if bytes.Equal(header, []byte(lfsPointerPrefix)) {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			pointers = append(pointers, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return pointers, err
+}
+
+// pathPatternMatches returns true if the slash separated path matches the
+// git-lfs pattern, i.e. the pattern matches the path, a leading directory of
+// the path or, if it contains no slash, the file name.
+func pathPatternMatches(pattern, p string) bool {
+	pattern = strings.Trim(pattern, "/")
+	if ok, _ := path.Match(pattern, p); ok {
+		return true
+	}
+	if !strings.Contains(pattern, "/") {
+		if ok, _ := path.Match(pattern, path.Base(p)); ok {
+			return true
+		}
+	}
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if ok, _ := path.Match(pattern, dir); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pathSelected returns true if the slash separated path matches one of the
+// include patterns, or there are none, and none of the exclude patterns.
+func pathSelected(include, exclude []string, p string) bool {
+	included := len(include) == 0
+	for _, pattern := range include {
+		if pathPatternMatches(pattern, p) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, pattern := range exclude {
+		if pathPatternMatches(pattern, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fetches returns true if the LFS object of the slash separated path is
+// fetched according to the include and exclude patterns.
+func (c LFSConfig) Fetches(p string) bool {
+	return pathSelected(c.Include, c.Exclude, p)
+}
+
+// lfsPullArgs returns the git arguments fetching and checking out the LFS
+// objects selected by the configuration.
+func (c LFSConfig) lfsPullArgs() []string {
+	args := []string{"lfs", "pull"}
+	if len(c.Include) > 0 {
+		args = append(args, "--include="+strings.Join(c.Include, ","))
+	}
+	if len(c.Exclude) > 0 {
+		args = append(args, "--exclude="+strings.Join(c.Exclude, ","))
+	}
+	return args
+}
+
+// forSubmodule returns the configuration relative to the submodule at the
+// slash separated path p, and whether any Git LFS object of the submodule is
+// fetched.
+func (c LFSConfig) forSubmodule(p string) (LFSConfig, bool) {
+	rv := LFSConfig{}
+	all := len(c.Include) == 0
+	for _, pattern := range c.Include {
+		rel, whole, ok := relativePattern(pattern, p)
+		switch {
+		case whole:
+			all = true
+		case ok:
+			rv.Include = append(rv.Include, rel)
+		}
+	}
+	if all {
+		rv.Include = nil
+	} else if len(rv.Include) == 0 {
+		return rv, false
+	}
+	for _, pattern := range c.Exclude {
+		rel, whole, ok := relativePattern(pattern, p)
+		switch {
+		case whole:
+			return rv, false
+		case ok:
+			rv.Exclude = append(rv.Exclude, rel)
+		}
+	}
+	return rv, true
+}
+
+// relativePattern returns the pattern relative to the directory at the slash
+// separated path p. whole is true if the pattern matches the directory itself
+// or one of its leading directories, hence all the files in it, and ok is
+// false if the pattern matches no file in it.
+func relativePattern(pattern, p string) (rel string, whole, ok bool) {
+	pattern = strings.Trim(pattern, "/")
+	patternElems := strings.Split(pattern, "/")
+	pathElems := strings.Split(p, "/")
+	matched := true
+	for i := 0; i < len(pathElems) && i < len(patternElems); i++ {
+		if m, _ := path.Match(patternElems[i], pathElems[i]); !m {
+			matched = false
+			break
+		}
+	}
+	switch {
+	case matched && len(patternElems) <= len(pathElems):
+		return "", true, true
+	case !strings.Contains(pattern, "/"):
+		// the pattern matches the file names in any directory
+		return pattern, false, true
+	case matched:
+		return strings.Join(patternElems[len(pathElems):], "/"), false, true
+	}
+	return "", false, false
+}
+
+// LFSPull fetches and checks out the Git LFS objects of the current ref,
+// optionally also in all checked out submodules, recursively. It requires
+// git-lfs.
+func (h *stiGit) LFSPull(repo string, recursive bool, c LFSConfig) error {
+	return h.lfsPull(repo, "", recursive, c)
+}
+
+// lfsPull fetches the Git LFS objects of repo, and of its checked out
+// submodules if recursive, selected by the configuration, whose patterns are
+// relative to repo. prefix is the path of repo relative to the source
+// repository.
+func (h *stiGit) lfsPull(repo, prefix string, recursive bool, c LFSConfig) error {
+	opts := cmd.CommandOpts{
+		Stdout: h.stdout(),
+		Stderr: os.Stderr,
+		Dir:    repo,
+	}
+	if err := h.run(opts, c.lfsPullArgs()...); err != nil {
+		return err
+	}
+	if !recursive {
+		return nil
+	}
+
+	out := &bytes.Buffer{}
+	opts = cmd.CommandOpts{Stdout: out, Stderr: os.Stderr, Dir: repo}
+	if err := h.run(opts, "ls-files", "-z", "--stage"); err != nil {
+		return err
+	}
+	for _, p := range parseGitlinks(out.String()) {
+		dir := filepath.Join(repo, filepath.FromSlash(p))
+		if !h.Exists(filepath.Join(dir, ".git")) {
+			continue
+		}
+		sc, fetches := c.forSubmodule(p)
+		if !fetches {
+			log.V(2).Infof("Skipping the Git LFS objects of submodule %q", path.Join(prefix, p))
+			continue
+		}
+		if err := h.lfsPull(dir, path.Join(prefix, p), recursive, sc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
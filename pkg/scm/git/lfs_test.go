@@ -0,0 +1,177 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/util/cmd"
+	"github.com/openshift/source-to-image/pkg/util/fs"
+)
+
+const testLFSPointer = `version https://git-lfs.github.com/spec/v1
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 12345
+`
+
+func TestLFSDetection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s2i-lfs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		".gitattributes":            "# comment filter=lfs\n\n*.txt text\n",
+		"models/model.bin":          testLFSPointer,
+		"sub/.gitattributes":        "*.bin filter=lfs diff=lfs merge=lfs -text\n",
+		"sub/fixtures/data.bin":     testLFSPointer,
+		"sub/fixtures/fetched.bin":  "binary content",
+		".git/lfs/objects/ab/cd/ef": testLFSPointer,
+		"short":                     "version",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fileSystem := fs.NewFileSystem()
+	if usesLFS, err := UsesLFS(fileSystem, filepath.Join(dir, "models")); err != nil || usesLFS {
+		t.Errorf("Expected no Git LFS usage, got %v, %v", usesLFS, err)
+	}
+	if usesLFS, err := UsesLFS(fileSystem, dir); err != nil || !usesLFS {
+		t.Errorf("Expected Git LFS usage in a submodule to be detected, got %v, %v", usesLFS, err)
+	}
+	if usesLFS, err := UsesLFS(fileSystem, filepath.Join(dir, "missing")); err != nil || usesLFS {
+		t.Errorf("Expected no Git LFS usage in a missing directory, got %v, %v", usesLFS, err)
+	}
+
+	pointers, err := FindLFSPointers(fileSystem, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"models/model.bin", "sub/fixtures/data.bin"}
+	if !reflect.DeepEqual(pointers, expected) {
+		t.Errorf("Expected pointers %v, got %v", expected, pointers)
+	}
+}
+
+func TestLFSConfigFetches(t *testing.T) {
+	tests := []struct {
+		config   LFSConfig
+		path     string
+		expected bool
+	}{
+		{LFSConfig{}, "models/model.bin", true},
+		{LFSConfig{Include: []string{"models"}}, "models/model.bin", true},
+		{LFSConfig{Include: []string{"models/"}}, "models/v1/model.bin", true},
+		{LFSConfig{Include: []string{"*.bin"}}, "models/model.bin", true},
+		{LFSConfig{Include: []string{"fixtures"}}, "models/model.bin", false},
+		{LFSConfig{Exclude: []string{"models/*.bin"}}, "models/model.bin", false},
+		{LFSConfig{Include: []string{"*.bin"}, Exclude: []string{"fixtures"}}, "fixtures/data.bin", false},
+	}
+	for _, test := range tests {
+		if got := test.config.Fetches(test.path); got != test.expected {
+			t.Errorf("%#v: expected Fetches(%q) to be %v", test.config, test.path, test.expected)
+		}
+	}
+}
+
+func TestLFSConfigForSubmodule(t *testing.T) {
+	tests := []struct {
+		config   LFSConfig
+		path     string
+		expected LFSConfig
+		fetches  bool
+	}{
+		{LFSConfig{}, "lib/a", LFSConfig{}, true},
+		{LFSConfig{Include: []string{"*.bin"}}, "lib/a", LFSConfig{Include: []string{"*.bin"}}, true},
+		{LFSConfig{Include: []string{"lib/a/models/*.bin"}}, "lib/a", LFSConfig{Include: []string{"models/*.bin"}}, true},
+		{LFSConfig{Include: []string{"lib/*/models"}}, "lib/a", LFSConfig{Include: []string{"models"}}, true},
+		{LFSConfig{Include: []string{"lib", "lib/b/*.bin"}}, "lib/a", LFSConfig{}, true},
+		{LFSConfig{Include: []string{"lib/a/"}}, "lib/a", LFSConfig{}, true},
+		{LFSConfig{Include: []string{"lib/b/*.bin"}}, "lib/a", LFSConfig{}, false},
+		{LFSConfig{Exclude: []string{"lib/*"}}, "lib/a", LFSConfig{}, false},
+		{LFSConfig{Exclude: []string{"lib/a/fixtures", "docs"}}, "lib/a", LFSConfig{Exclude: []string{"fixtures", "docs"}}, true},
+		{LFSConfig{Include: []string{"*.bin", "docs/*.pdf"}, Exclude: []string{"lib/b"}}, "lib/a", LFSConfig{Include: []string{"*.bin"}}, true},
+	}
+	for _, test := range tests {
+		config, fetches := test.config.forSubmodule(test.path)
+		if fetches != test.fetches || fetches && !reflect.DeepEqual(config, test.expected) {
+			t.Errorf("%#v: expected the configuration of %q to be %#v (fetches %v), got %#v (fetches %v)", test.config, test.path, test.expected, test.fetches, config, fetches)
+		}
+	}
+}
+
+func TestLFSPull(t *testing.T) {
+	gh, ch := getGit()
+	err := gh.LFSPull("repo1", false, LFSConfig{Include: []string{"*.bin", "models"}, Exclude: []string{"it's"}})
+	if err != nil {
+		t.Errorf("Unexpected error returned from LFS pull: %v", err)
+	}
+	expected := []string{"lfs", "pull", "--include=*.bin,models", "--exclude=it's"}
+	if !reflect.DeepEqual(ch.Args, expected) {
+		t.Errorf("Unexpected command arguments: %#v", ch.Args)
+	}
+	if ch.Opts.Dir != "repo1" {
+		t.Errorf("Unexpected command directory: %q", ch.Opts.Dir)
+	}
+}
+
+// lfsRecorder runs the git commands, except for the git-lfs ones, which it
+// records by directory.
+type lfsRecorder struct {
+	cmd.CommandRunner
+	pulls map[string][]string
+}
+
+func (r *lfsRecorder) RunWithOptions(opts cmd.CommandOpts, name string, args ...string) error {
+	if len(args) > 0 && args[0] == "lfs" {
+		r.pulls[opts.Dir] = args
+		return nil
+	}
+	return r.CommandRunner.RunWithOptions(opts, name, args...)
+}
+
+func TestLFSPullSubmodules(t *testing.T) {
+	// allow git to add the local submodules
+	defer allowFileProtocol()()
+
+	cr := cmd.NewCommandRunner()
+	repo, err := CreateLocalGitDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+	for _, p := range []string{"lib/a", "lib/b"} {
+		submodule, err := CreateLocalGitDirectory()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(submodule)
+		if err := cr.RunWithOptions(cmd.CommandOpts{Dir: repo}, "git", "submodule", "--quiet", "add", submodule, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &lfsRecorder{CommandRunner: cr, pulls: map[string][]string{}}
+	gh := New(fs.NewFileSystem(), r)
+	c := LFSConfig{Include: []string{"lib/a/models/*.bin", "*.txt"}, Exclude: []string{"lib/b"}}
+	if err := gh.LFSPull(repo, true, c); err != nil {
+		t.Fatalf("Unexpected error returned from LFS pull: %v", err)
+	}
+	expected := map[string][]string{
+		repo:                            {"lfs", "pull", "--include=lib/a/models/*.bin,*.txt", "--exclude=lib/b"},
+		filepath.Join(repo, "lib", "a"): {"lfs", "pull", "--include=models/*.bin,*.txt"},
+	}
+	if !reflect.DeepEqual(r.pulls, expected) {
+		t.Errorf("Expected the LFS pulls %#v, got %#v", expected, r.pulls)
+	}
+}
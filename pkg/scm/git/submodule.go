@@ -0,0 +1,144 @@
+package git
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	gogit "github.com/go-git/go-git/v5"
+
+	"github.com/openshift/source-to-image/pkg/util/cmd"
+	"github.com/openshift/source-to-image/pkg/util/fs"
+)
+
+// IsEmpty returns true if the configuration selects all submodules.
+func (c SubmoduleConfig) IsEmpty() bool {
+	return len(c.Include) == 0 && len(c.Exclude) == 0
+}
+
+// Selects returns true if the submodule at the slash separated path is updated
+// according to the include and exclude patterns.
+func (c SubmoduleConfig) Selects(p string) bool {
+	return pathSelected(c.Include, c.Exclude, p)
+}
+
+// parseGitlinks returns the paths of the submodules recorded in the output of
+// 'git ls-files -z --stage'.
+func parseGitlinks(out string) []string {
+	paths := []string{}
+	for _, entry := range strings.Split(out, "\x00") {
+		m := strings.SplitN(entry, "\t", 2)
+		if len(m) == 2 && strings.HasPrefix(m[0], "160000 ") { // S_IFGITLINK
+			paths = append(paths, m[1])
+		}
+	}
+	return paths
+}
+
+// submoduleUpdate checks out the submodules of repo selected by the
+// configuration, one level at a time. prefix is the path of repo relative to
+// the source repository.
+func (h *stiGit) submoduleUpdate(repo, prefix string, init, recursive bool, c SubmoduleConfig) error {
+	out := &bytes.Buffer{}
+	opts := cmd.CommandOpts{Stdout: out, Stderr: os.Stderr, Dir: repo}
+	if err := h.run(opts, "ls-files", "-z", "--stage"); err != nil {
+		return err
+	}
+
+	paths := []string{}
+	for _, p := range parseGitlinks(out.String()) {
+		if c.Selects(path.Join(prefix, p)) {
+			paths = append(paths, p)
+		} else {
+			log.V(2).Infof("Skipping submodule %q", path.Join(prefix, p))
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	args := []string{"submodule", "update"}
+	if init {
+		args = append(args, "--init")
+	}
+	args = append(args, "--")
+	opts = cmd.CommandOpts{Stdout: h.stdout(), Stderr: os.Stderr, Dir: repo}
+	if err := h.run(opts, append(args, paths...)...); err != nil {
+		return err
+	}
+
+	if !recursive {
+		return nil
+	}
+	for _, p := range paths {
+		dir := filepath.Join(repo, filepath.FromSlash(p))
+		// an uninitialized submodule is an empty directory of repo
+		if !h.Exists(filepath.Join(dir, ".git")) {
+			continue
+		}
+		if err := h.submoduleUpdate(dir, path.Join(prefix, p), init, recursive, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkedOutSubmodules returns the revisions of the checked out submodules of
+// repo, recursively, using git to query the repositories. prefix is the path
+// of repo relative to the source repository.
+func checkedOutSubmodules(fs fs.FileSystem, repo, prefix string, git func(dir string, arg ...string) string) []SubmoduleInfo {
+	var rv []SubmoduleInfo
+	for _, p := range parseGitlinks(git(repo, "ls-files", "-z", "--stage")) {
+		dir := filepath.Join(repo, filepath.FromSlash(p))
+		if !fs.Exists(filepath.Join(dir, ".git")) {
+			continue
+		}
+		rv = append(rv, SubmoduleInfo{
+			Path:     path.Join(prefix, p),
+			URL:      RedactCredentials(git(dir, "config", "--get", "remote.origin.url")),
+			CommitID: git(dir, "rev-parse", "--verify", "HEAD"),
+		})
+		rv = append(rv, checkedOutSubmodules(fs, dir, path.Join(prefix, p), git)...)
+	}
+	return rv
+}
+
+// goGitSubmodules returns the revisions of the checked out submodules of r,
+// recursively. prefix is the path of r relative to the source repository.
+func goGitSubmodules(r *gogit.Repository, prefix string) []SubmoduleInfo {
+	w, err := r.Worktree()
+	if err != nil {
+		return nil
+	}
+	submodules, err := w.Submodules()
+	if err != nil {
+		log.V(1).Infof("Error reading the submodules of %q: %v", prefix, err)
+		return nil
+	}
+
+	var rv []SubmoduleInfo
+	for _, submodule := range submodules {
+		status, err := submodule.Status()
+		if err != nil || status.Current.IsZero() {
+			continue
+		}
+		sr, err := submodule.Repository()
+		if err != nil {
+			continue
+		}
+		info := SubmoduleInfo{
+			Path:     path.Join(prefix, submodule.Config().Path),
+			URL:      submodule.Config().URL,
+			CommitID: status.Current.String(),
+		}
+		if remote, err := sr.Remote(gogit.DefaultRemoteName); err == nil && len(remote.Config().URLs) > 0 {
+			info.URL = remote.Config().URLs[0]
+		}
+		info.URL = RedactCredentials(info.URL)
+		rv = append(rv, info)
+		rv = append(rv, goGitSubmodules(sr, info.Path)...)
+	}
+	return rv
+}
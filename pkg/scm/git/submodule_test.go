@@ -0,0 +1,96 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/util/cmd"
+	"github.com/openshift/source-to-image/pkg/util/fs"
+)
+
+func TestParseGitlinks(t *testing.T) {
+	out := "100644 4d7a214614ab2935c943f9e0ff69d22eadbb8f32 0\tREADME\x00" +
+		"160000 1bf4f04e2bd8e2ef5bd4b2e2d3cd5ee2c8f2a1d2 0\tlib/a b\x00" +
+		"120000 5c2f6ab3b2d0d18ab8a2a8ba7e4f2b5a0d1dbbbb 0\tlink\x00"
+	if paths := parseGitlinks(out); !reflect.DeepEqual(paths, []string{"lib/a b"}) {
+		t.Errorf("Unexpected submodule paths %#v", paths)
+	}
+}
+
+func TestSubmoduleConfigSelects(t *testing.T) {
+	tests := []struct {
+		config   SubmoduleConfig
+		path     string
+		expected bool
+	}{
+		{SubmoduleConfig{}, "vendor/lib", true},
+		{SubmoduleConfig{Include: []string{"vendor"}}, "vendor/lib", true},
+		{SubmoduleConfig{Include: []string{"vendor/lib"}}, "vendor/lib/nested", true},
+		{SubmoduleConfig{Include: []string{"docs"}}, "vendor/lib", false},
+		{SubmoduleConfig{Exclude: []string{"vendor/*"}}, "vendor/lib", false},
+		{SubmoduleConfig{Include: []string{"vendor"}, Exclude: []string{"lib"}}, "vendor/lib", false},
+	}
+	for _, test := range tests {
+		if got := test.config.Selects(test.path); got != test.expected {
+			t.Errorf("%#v: expected Selects(%q) to be %v", test.config, test.path, test.expected)
+		}
+	}
+}
+
+func TestSubmoduleRevisions(t *testing.T) {
+	// allow git to clone the local submodules
+	defer allowFileProtocol()()
+
+	cr := cmd.NewCommandRunner()
+	submodules := map[string]string{}
+	repo, err := CreateLocalGitDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+	for _, path := range []string{"lib/a", "lib/b"} {
+		submodule, err := CreateLocalGitDirectory()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(submodule)
+		submodules[path] = submodule
+		if err := cr.RunWithOptions(cmd.CommandOpts{Dir: repo}, "git", "submodule", "--quiet", "add", submodule, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = cr.RunWithOptions(cmd.CommandOpts{Dir: repo, EnvAppend: []string{"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@test", "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@test"}}, "git", "commit", "-m", "submodules")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, g := range map[string]Git{
+		"git":    New(fs.NewFileSystem(), cr),
+		"go-git": NewGoGit(nil),
+	} {
+		for _, c := range []SubmoduleConfig{{Exclude: []string{"lib/b"}}, {Include: []string{"a"}}} {
+			target, err := ioutil.TempDir("", "s2i-submodule-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(target)
+			if err := g.Clone(MustParse(repo), target, CloneConfig{Quiet: true}); err != nil {
+				t.Fatalf("%s: unexpected clone error: %v", name, err)
+			}
+			if err := g.SubmoduleUpdate(target, true, true, c); err != nil {
+				t.Fatalf("%s: unexpected submodule update error: %v", name, err)
+			}
+
+			expected := []SubmoduleInfo{{
+				Path:     "lib/a",
+				URL:      submodules["lib/a"],
+				CommitID: NewGoGit(nil).GetInfo(submodules["lib/a"]).CommitID,
+			}}
+			if info := g.GetInfo(target); !reflect.DeepEqual(info.Submodules, expected) {
+				t.Errorf("%s, %#v: expected submodules %#v, got %#v", name, c, expected, info.Submodules)
+			}
+		}
+	}
+}
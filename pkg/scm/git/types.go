@@ -7,6 +7,55 @@ type CloneConfig struct {
 	Quiet     bool
 }
 
+// LFSConfig specifies which Git LFS objects are fetched after checkout. The
+// paths use the git-lfs --include and --exclude pattern syntax.
+type LFSConfig struct {
+	// Include lists the paths whose LFS objects are fetched. All paths are
+	// fetched if it is empty.
+	Include []string
+
+	// Exclude lists the paths whose LFS objects are not fetched.
+	Exclude []string
+}
+
+// SubmoduleConfig selects the submodules which are initialized and updated
+// after the source repository is checked out. The patterns match the slash
+// separated submodule paths relative to the source repository and use the same
+// syntax as LFSConfig. A nested submodule is only updated if its parent is.
+type SubmoduleConfig struct {
+	// Include lists the submodules which are updated. All submodules are
+	// updated if it is empty.
+	Include []string
+
+	// Exclude lists the submodules which are not updated.
+	Exclude []string
+}
+
+// SubmoduleInfo stores the revision of a checked out submodule.
+type SubmoduleInfo struct {
+	// Path is the slash separated path of the submodule relative to the source
+	// repository.
+	Path string `json:"path"`
+
+	// URL is the location of the submodule repository.
+	URL string `json:"url"`
+
+	// CommitID is the SHA-1 of the checked out commit.
+	CommitID string `json:"commit"`
+}
+
+// WorkingTreeFile is a file of a git working tree which is either tracked or
+// untracked but not ignored.
+type WorkingTreeFile struct {
+	// Path is the slash separated path of the file relative to the repository.
+	Path string
+
+	// Status is the two letter status code of the file as printed by
+	// 'git status --porcelain', e.g. " M" or "??". It is empty for unmodified
+	// files.
+	Status string
+}
+
 // SourceInfo stores information about the source code
 type SourceInfo struct {
 	// Ref represents a commit SHA-1, valid Git branch name or a Git tag
@@ -39,6 +88,19 @@ type SourceInfo struct {
 	// The output image will contain this information as 'io.openshift.build.commit.message' label.
 	Message string
 
+	// Dirty is true if the source contains changes which are not committed.
+	// The output image will contain this information as 'io.openshift.build.commit.dirty' label.
+	Dirty bool
+
+	// DiffHash identifies the uncommitted changes of a dirty source.
+	// The output image will contain this information as 'io.openshift.build.commit.diff-hash' label.
+	DiffHash string
+
+	// Submodules contains the revisions of the checked out submodules,
+	// including nested ones.
+	// The output image will contain this information as 'io.openshift.build.commit.submodules' label.
+	Submodules []SubmoduleInfo
+
 	// Location contains a valid URL to the original repository.
 	// The output image will contain this information as 'io.openshift.build.source-location' label.
 	Location string
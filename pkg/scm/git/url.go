@@ -173,6 +173,36 @@ func (u URL) StringNoFragment() string {
 	return u.String()
 }
 
+// StringNoCredentials returns a string representation of the URL without any
+// embedded password. The user name of HTTP(S) URLs is removed as well, as it
+// commonly carries an access token.
+func (u URL) StringNoCredentials() string {
+	if u.URL.User == nil {
+		return u.String()
+	}
+	switch strings.ToLower(u.URL.Scheme) {
+	case "http", "https":
+		u.URL.User = nil
+	default:
+		u.URL.User = url.User(u.URL.User.Username())
+	}
+	return u.String()
+}
+
+// RedactCredentials parses location as a "Git URL" and returns it without any
+// credentials (see StringNoCredentials). Locations that cannot be parsed are
+// dropped.
+func RedactCredentials(location string) string {
+	if len(location) == 0 {
+		return ""
+	}
+	u, err := Parse(location)
+	if err != nil {
+		return ""
+	}
+	return u.StringNoCredentials()
+}
+
 // IsLocal returns true if the Git URL refers to a local repository
 func (u URL) IsLocal() bool {
 	return u.Type == URLTypeLocal || (u.Type == URLTypeURL && u.URL.Scheme == "file" && u.URL.Opaque == "")
@@ -0,0 +1,106 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	gogit "github.com/go-git/go-git/v5"
+
+	"github.com/openshift/source-to-image/pkg/util/cmd"
+)
+
+// sortedWorkingTreeFiles returns the files of the path to status map sorted by
+// path.
+func sortedWorkingTreeFiles(files map[string]string) []WorkingTreeFile {
+	rv := make([]WorkingTreeFile, 0, len(files))
+	for path, status := range files {
+		rv = append(rv, WorkingTreeFile{Path: path, Status: status})
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Path < rv[j].Path })
+	return rv
+}
+
+// parsePorcelainStatus parses the output of 'git status --porcelain -z' into
+// a path to status map.
+func parsePorcelainStatus(out string) (map[string]string, error) {
+	status := map[string]string{}
+	entries := strings.Split(out, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) == 0 {
+			continue
+		}
+		if len(entry) < 4 || entry[2] != ' ' {
+			return nil, fmt.Errorf("unparsable response %q from git status", entry)
+		}
+		code := entry[:2]
+		status[entry[3:]] = code
+		if code[0] == 'R' || code[0] == 'C' {
+			// renames and copies are followed by the original path
+			i++
+		}
+	}
+	return status, nil
+}
+
+// ListWorkingTree returns the tracked files and the untracked files which are
+// not ignored of the working tree at repo, together with their status.
+func (h *stiGit) ListWorkingTree(repo string) ([]WorkingTreeFile, error) {
+	lsFiles := &bytes.Buffer{}
+	opts := cmd.CommandOpts{Stdout: lsFiles, Stderr: os.Stderr, Dir: repo}
+	if err := h.run(opts, "ls-files", "-z", "--cached", "--others", "--exclude-standard"); err != nil {
+		return nil, err
+	}
+	statusOut := &bytes.Buffer{}
+	opts = cmd.CommandOpts{Stdout: statusOut, Stderr: os.Stderr, Dir: repo}
+	if err := h.run(opts, "status", "--porcelain", "-z", "--untracked-files=all"); err != nil {
+		return nil, err
+	}
+
+	files, err := parsePorcelainStatus(statusOut.String())
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range strings.Split(lsFiles.String(), "\x00") {
+		if _, ok := files[path]; len(path) > 0 && !ok {
+			files[path] = ""
+		}
+	}
+	return sortedWorkingTreeFiles(files), nil
+}
+
+// ListWorkingTree returns the tracked files and the untracked files which are
+// not ignored of the working tree at repo, together with their status.
+func (h *goGit) ListWorkingTree(repo string) ([]WorkingTreeFile, error) {
+	r, err := gogit.PlainOpen(repo)
+	if err != nil {
+		return nil, err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]string{}
+	for _, entry := range idx.Entries {
+		files[entry.Name] = ""
+	}
+	for path, s := range status {
+		code := string([]byte{byte(s.Staging), byte(s.Worktree)})
+		if code != "  " {
+			files[path] = code
+		}
+	}
+	return sortedWorkingTreeFiles(files), nil
+}
@@ -0,0 +1,70 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/util/cmd"
+	"github.com/openshift/source-to-image/pkg/util/fs"
+)
+
+func TestParsePorcelainStatus(t *testing.T) {
+	status, err := parsePorcelainStatus(" M a b\x00R  new\x00old\x00?? dir/untracked\x00D  deleted\x00")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]string{"a b": " M", "new": "R ", "dir/untracked": "??", "deleted": "D "}
+	if !reflect.DeepEqual(status, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, status)
+	}
+	if _, err := parsePorcelainStatus("garbage\x00"); err == nil {
+		t.Errorf("Expected an error parsing an invalid status")
+	}
+}
+
+func TestListWorkingTree(t *testing.T) {
+	repo, err := CreateLocalGitDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+	files := map[string]string{
+		"testfile":       "modified",
+		"untracked/new":  "new",
+		".gitignore":     "ignored\n",
+		"ignored":        "ignored",
+		"untracked/.tmp": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(repo, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []WorkingTreeFile{
+		{Path: ".gitignore", Status: "??"},
+		{Path: "testfile", Status: " M"},
+		{Path: "untracked/.tmp", Status: "??"},
+		{Path: "untracked/new", Status: "??"},
+	}
+	for name, g := range map[string]Git{
+		"git":    New(fs.NewFileSystem(), cmd.NewCommandRunner()),
+		"go-git": NewGoGit(nil),
+	} {
+		list, err := g.ListWorkingTree(repo)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(list, expected) {
+			t.Errorf("%s: expected %#v, got %#v", name, expected, list)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package scm
 
 import (
+	"fmt"
+	"io"
+
 	"github.com/openshift/source-to-image/pkg/build"
 	"github.com/openshift/source-to-image/pkg/errors"
 	"github.com/openshift/source-to-image/pkg/scm/downloaders/empty"
@@ -15,13 +18,17 @@ import (
 var log = utillog.StderrLog
 
 // DownloaderForSource determines what SCM plugin should be used for downloading
-// the sources from the repository.
-func DownloaderForSource(fs fs.FileSystem, s *git.URL, forceCopy bool) (build.Downloader, error) {
-	log.V(4).Infof("DownloadForSource %s", s)
-
+// the sources from the repository. If includeUncommitted is true, the working
+// tree of a local repository is used instead of its committed HEAD. auth, if
+// not nil, is applied to every git invocation made by the returned downloader.
+// The standard output of git is written to stdout, or to the standard output
+// of the process if it is nil.
+func DownloaderForSource(fs fs.FileSystem, s *git.URL, forceCopy, includeUncommitted bool, auth *git.AuthConfig, stdout io.Writer) (build.Downloader, error) {
 	if s == nil {
+		log.V(4).Infof("DownloadForSource <nil>")
 		return &empty.Noop{}, nil
 	}
+	log.V(4).Infof("DownloadForSource %s", s.StringNoCredentials())
 
 	if s.IsLocal() {
 		if forceCopy {
@@ -33,6 +40,9 @@ func DownloaderForSource(fs fs.FileSystem, s *git.URL, forceCopy bool) (build.Do
 			return nil, err
 		}
 		if !isLocalNonBareGitRepo {
+			if includeUncommitted {
+				return nil, fmt.Errorf("uncommitted changes can only be included for local git repositories, %q is not one", s.LocalPath())
+			}
 			return &file.File{FileSystem: fs}, nil
 		}
 
@@ -43,11 +53,19 @@ func DownloaderForSource(fs fs.FileSystem, s *git.URL, forceCopy bool) (build.Do
 		if isEmpty {
 			return nil, errors.NewEmptyGitRepositoryError(s.LocalPath())
 		}
-
-		if !git.HasGitBinary() {
-			return &file.File{FileSystem: fs}, nil
-		}
+	} else if includeUncommitted {
+		return nil, fmt.Errorf("uncommitted changes can only be included for local git repositories, not %q", s.StringNoCredentials())
 	}
 
-	return &gitdownloader.Clone{Git: git.New(fs, cmd.NewCommandRunner()), FileSystem: fs}, nil
+	var g git.Git
+	if git.HasGitBinary() {
+		g = git.NewWithOutput(fs, cmd.NewCommandRunner(), auth, stdout)
+	} else {
+		log.V(2).Infof("The git binary was not found, using the built-in git implementation")
+		g = git.NewGoGit(auth)
+	}
+	if includeUncommitted {
+		return &gitdownloader.WorkingTree{Git: g, FileSystem: fs}, nil
+	}
+	return &gitdownloader.Clone{Git: g, FileSystem: fs}, nil
 }
@@ -36,7 +36,7 @@ func TestDownloaderForSource(t *testing.T) {
 	}
 
 	for s, expected := range tc {
-		r, err := DownloaderForSource(fs.NewFileSystem(), s, false)
+		r, err := DownloaderForSource(fs.NewFileSystem(), s, false, false, nil, nil)
 		if err != nil {
 			t.Errorf("Unexpected error %q for %q, expected %q", err, s, expected)
 			continue
@@ -56,7 +56,7 @@ func TestDownloaderForSourceOnRelativeGit(t *testing.T) {
 	}
 	defer os.RemoveAll(gitLocalDir)
 	os.Chdir(gitLocalDir)
-	r, err := DownloaderForSource(fs.NewFileSystem(), git.MustParse("."), false)
+	r, err := DownloaderForSource(fs.NewFileSystem(), git.MustParse("."), false, false, nil, nil)
 	if err != nil {
 		t.Errorf("Unexpected error %q for %q, expected %q", err, ".", "git.Clone")
 	}
@@ -72,7 +72,7 @@ func TestDownloaderForceCopy(t *testing.T) {
 	}
 	defer os.RemoveAll(gitLocalDir)
 	os.Chdir(gitLocalDir)
-	r, err := DownloaderForSource(fs.NewFileSystem(), git.MustParse("."), true)
+	r, err := DownloaderForSource(fs.NewFileSystem(), git.MustParse("."), true, false, nil, nil)
 	if err != nil {
 		t.Errorf("Unexpected error %q for %q, expected %q", err, ".", "*file.File")
 	}
@@ -80,3 +80,27 @@ func TestDownloaderForceCopy(t *testing.T) {
 		t.Errorf("Expected %q for %q, got %q", "*file.File", ".", reflect.TypeOf(r).String())
 	}
 }
+
+func TestDownloaderForSourceIncludeUncommitted(t *testing.T) {
+	gitLocalDir, err := git.CreateLocalGitDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gitLocalDir)
+	localDir, _ := ioutil.TempDir(os.TempDir(), "localdir-s2i-test")
+	defer os.RemoveAll(localDir)
+
+	r, err := DownloaderForSource(fs.NewFileSystem(), git.MustParse(gitLocalDir), false, true, nil, nil)
+	if err != nil {
+		t.Errorf("Unexpected error %q for %q", err, gitLocalDir)
+	}
+	if reflect.TypeOf(r).String() != "*git.WorkingTree" {
+		t.Errorf("Expected %q for %q, got %q", "*git.WorkingTree", gitLocalDir, reflect.TypeOf(r).String())
+	}
+
+	for _, s := range []string{localDir, "https://github.com/bar"} {
+		if _, err := DownloaderForSource(fs.NewFileSystem(), git.MustParse(s), false, true, nil, nil); err == nil {
+			t.Errorf("Expected an error including uncommitted changes of %q", s)
+		}
+	}
+}
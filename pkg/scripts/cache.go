@@ -0,0 +1,110 @@
+package scripts
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// scriptCache is the cache entry of a script downloaded from a URL. The
+// content is stored in a file named after the SHA-256 of the URL, next to a
+// JSON file with the response headers used to revalidate it.
+type scriptCache struct {
+	path string
+	url  string
+}
+
+// scriptCacheMetadata holds the response headers of a cached script.
+type scriptCacheMetadata struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// newScriptCache returns the cache entry of the URL in dir, or nil if dir is
+// empty.
+func newScriptCache(dir string, url *url.URL) *scriptCache {
+	if len(dir) == 0 {
+		return nil
+	}
+	key := sha256.Sum256([]byte(url.String()))
+	return &scriptCache{
+		path: filepath.Join(dir, fmt.Sprintf("%x", key)),
+		url:  url.String(),
+	}
+}
+
+// open returns the cached content.
+func (c *scriptCache) open() (io.ReadCloser, error) {
+	f, err := os.Open(c.path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s is not cached", c.url)
+	}
+	return f, err
+}
+
+// addValidators adds the conditional request headers which let the server
+// confirm that the cached content is still valid.
+func (c *scriptCache) addValidators(header http.Header) {
+	if _, err := os.Stat(c.path); err != nil {
+		return
+	}
+	data, err := ioutil.ReadFile(c.path + ".json")
+	if err != nil {
+		return
+	}
+	metadata := scriptCacheMetadata{}
+	if err := json.Unmarshal(data, &metadata); err != nil || metadata.URL != c.url {
+		return
+	}
+	if len(metadata.ETag) > 0 {
+		header.Set("If-None-Match", metadata.ETag)
+	}
+	if len(metadata.LastModified) > 0 {
+		header.Set("If-Modified-Since", metadata.LastModified)
+	}
+}
+
+// store replaces the cached content and metadata with the response.
+func (c *scriptCache) store(resp *http.Response) error {
+	metadata, err := json.Marshal(scriptCacheMetadata{
+		URL:          c.url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(c.path, resp.Body); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path+".json", metadata, 0600)
+}
+
+// writeFileAtomically writes the content of r to path through a temporary
+// file, so that concurrent readers never see a partial file.
+func writeFileAtomically(path string, r io.Reader) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
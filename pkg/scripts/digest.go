@@ -0,0 +1,66 @@
+package scripts
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	// digestManifest is the name of the file next to the scripts which lists
+	// their SHA-256 digests in the format of the sha256sum utility.
+	digestManifest = "SHA256SUMS"
+
+	// digestPrefix prefixes the pinned digests of scripts.
+	digestPrefix = "sha256:"
+)
+
+// isSHA256 returns true if the digest is a hex encoded SHA-256 sum.
+func isSHA256(digest string) bool {
+	b, err := hex.DecodeString(digest)
+	return err == nil && len(b) == 32
+}
+
+// splitScriptDigests splits the pinned script digests off the scripts URL.
+// They are given as a fragment of comma separated sha256:<script>=<digest>
+// entries, e.g. https://example.com/s2i#sha256:assemble=<digest>. The
+// digests are nil if the URL pins none.
+func splitScriptDigests(scriptsURL string) (string, map[string]string, error) {
+	i := strings.LastIndex(scriptsURL, "#")
+	if i < 0 || !strings.HasPrefix(scriptsURL[i+1:], digestPrefix) {
+		return scriptsURL, nil, nil
+	}
+	digests := map[string]string{}
+	for _, entry := range strings.Split(scriptsURL[i+1:], ",") {
+		parts := strings.SplitN(strings.TrimPrefix(entry, digestPrefix), "=", 2)
+		if !strings.HasPrefix(entry, digestPrefix) || len(parts) != 2 || len(parts[0]) == 0 || !isSHA256(parts[1]) {
+			return "", nil, fmt.Errorf("invalid script digest %q, expected %s<script>=<hex encoded SHA-256>", entry, digestPrefix)
+		}
+		digests[parts[0]] = strings.ToLower(parts[1])
+	}
+	return scriptsURL[:i], digests, nil
+}
+
+// parseDigestManifest parses the script digests listed by a sha256sum style
+// manifest.
+func parseDigestManifest(r io.Reader) (map[string]string, error) {
+	digests := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !isSHA256(fields[0]) {
+			return nil, fmt.Errorf("invalid line %q in %s", line, digestManifest)
+		}
+		// sha256sum marks files read in binary mode with an asterisk
+		name := path.Base(strings.TrimPrefix(fields[1], "*"))
+		digests[name] = strings.ToLower(fields[0])
+	}
+	return digests, scanner.Err()
+}
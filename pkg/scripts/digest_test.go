@@ -0,0 +1,63 @@
+package scripts
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testDigest      = "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393"
+	testOtherDigest = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+)
+
+func TestSplitScriptDigests(t *testing.T) {
+	tests := []struct {
+		url      string
+		base     string
+		digests  map[string]string
+		hasError bool
+	}{
+		{url: "https://example.com/s2i", base: "https://example.com/s2i"},
+		{url: "docker://quay.io/scripts#/usr/libexec/s2i", base: "docker://quay.io/scripts#/usr/libexec/s2i"},
+		{
+			url:     "https://example.com/s2i#sha256:assemble=" + strings.ToUpper(testDigest) + ",sha256:run=" + testOtherDigest,
+			base:    "https://example.com/s2i",
+			digests: map[string]string{"assemble": testDigest, "run": testOtherDigest},
+		},
+		{
+			url:     "docker://quay.io/scripts#/usr/libexec/s2i#sha256:assemble=" + testDigest,
+			base:    "docker://quay.io/scripts#/usr/libexec/s2i",
+			digests: map[string]string{"assemble": testDigest},
+		},
+		{url: "https://example.com/s2i#sha256:assemble=abc", hasError: true},
+		{url: "https://example.com/s2i#sha256:assemble=" + testDigest + ",run=" + testDigest, hasError: true},
+	}
+	for _, test := range tests {
+		base, digests, err := splitScriptDigests(test.url)
+		if test.hasError {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.url)
+			}
+			continue
+		}
+		if err != nil || base != test.base || !reflect.DeepEqual(digests, test.digests) {
+			t.Errorf("%s: unexpected result %q, %#v, %v", test.url, base, digests, err)
+		}
+	}
+}
+
+func TestParseDigestManifest(t *testing.T) {
+	manifest := "# generated by sha256sum\n" + testDigest + "  assemble\n" + testOtherDigest + " *bin/run\n\n"
+	digests, err := parseDigestManifest(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]string{"assemble": testDigest, "run": testOtherDigest}
+	if !reflect.DeepEqual(digests, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, digests)
+	}
+	if _, err := parseDigestManifest(strings.NewReader("not-a-digest  assemble\n")); err == nil {
+		t.Errorf("Expected an error parsing an invalid manifest")
+	}
+}
@@ -1,14 +1,23 @@
 package scripts
 
 import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/docker"
 	s2ierr "github.com/openshift/source-to-image/pkg/errors"
 	"github.com/openshift/source-to-image/pkg/scm/git"
 	utillog "github.com/openshift/source-to-image/pkg/util/log"
@@ -32,8 +41,15 @@ type downloader struct {
 
 // NewDownloader creates an instance of the default Downloader implementation
 func NewDownloader(proxyConfig *api.ProxyConfig) Downloader {
-	httpReader := NewHTTPURLReader(proxyConfig)
-	return &downloader{
+	return newDownloader(proxyConfig, nil, nil)
+}
+
+// newDownloader creates an instance of the default Downloader implementation
+// which, if docker is not nil, also reads scripts from separate container
+// images.
+func newDownloader(proxyConfig *api.ProxyConfig, downloadConfig *api.ScriptDownloadConfig, docker docker.Docker) *downloader {
+	httpReader := NewHTTPURLReader(proxyConfig, downloadConfig)
+	d := &downloader{
 		schemeReaders: map[string]schemeReader{
 			"http":  httpReader,
 			"https": httpReader,
@@ -41,12 +57,27 @@ func NewDownloader(proxyConfig *api.ProxyConfig) Downloader {
 			"image": &ImageReader{},
 		},
 	}
+	if docker != nil {
+		d.schemeReaders["docker"] = NewContainerImageReader(docker)
+	}
+	return d
 }
 
 // Download downloads the file pointed to by URL into local targetFile
 // Returns information a boolean flag informing whether any download/copy operation
-// happened and an error if there was a problem during that operation
+// happened and an error if there was a problem during that operation.
+// A #sha256:<digest> fragment, which follows any other fragment of the URL,
+// pins the content of the file, which is removed if it does not match.
 func (d *downloader) Download(url *url.URL, targetFile string) (*git.SourceInfo, error) {
+	expected := ""
+	fragment := "#" + url.Fragment
+	if i := strings.LastIndex(fragment, "#"+digestPrefix); i >= 0 {
+		expected = strings.ToLower(fragment[i+1+len(digestPrefix):])
+		unpinned := *url
+		unpinned.Fragment = strings.TrimPrefix(fragment[:i], "#")
+		url = &unpinned
+	}
+
 	r := d.schemeReaders[url.Scheme]
 	info := &git.SourceInfo{}
 	if r == nil {
@@ -68,11 +99,16 @@ func (d *downloader) Download(url *url.URL, targetFile string) (*git.SourceInfo,
 		return nil, err
 	}
 
-	if _, err = io.Copy(out, reader); err != nil {
+	h := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(out, h), reader); err != nil {
 		os.Remove(targetFile)
 		log.Warningf("Skipping file %s due to error copying from source: %v", targetFile, err)
 		return nil, err
 	}
+	if actual := fmt.Sprintf("%x", h.Sum(nil)); len(expected) > 0 && actual != expected {
+		os.Remove(targetFile)
+		return nil, s2ierr.NewScriptDigestMismatchError(url.String(), expected, actual)
+	}
 
 	log.V(2).Infof("Downloaded '%s'", url.String())
 	info.Location = url.String()
@@ -81,7 +117,11 @@ func (d *downloader) Download(url *url.URL, targetFile string) (*git.SourceInfo,
 
 // HTTPURLReader retrieves a response from a given HTTP(S) URL.
 type HTTPURLReader struct {
-	Get func(url string) (*http.Response, error)
+	Do     func(req *http.Request) (*http.Response, error)
+	Config api.ScriptDownloadConfig
+
+	// sleep waits before a failed download is retried.
+	sleep func(time.Duration)
 }
 
 var transportMap map[api.ProxyConfig]*http.Transport
@@ -92,8 +132,8 @@ func init() {
 }
 
 // NewHTTPURLReader returns a new HTTPURLReader.
-func NewHTTPURLReader(proxyConfig *api.ProxyConfig) *HTTPURLReader {
-	getFunc := http.Get
+func NewHTTPURLReader(proxyConfig *api.ProxyConfig, config *api.ScriptDownloadConfig) *HTTPURLReader {
+	doFunc := http.DefaultClient.Do
 	if proxyConfig != nil {
 		transportMapMutex.Lock()
 		transport, ok := transportMap[*proxyConfig]
@@ -112,26 +152,120 @@ func NewHTTPURLReader(proxyConfig *api.ProxyConfig) *HTTPURLReader {
 		client := &http.Client{
 			Transport: transport,
 		}
-		getFunc = client.Get
+		doFunc = client.Do
+	}
+	h := &HTTPURLReader{Do: doFunc, sleep: time.Sleep}
+	if config != nil {
+		h.Config = *config
 	}
-	return &HTTPURLReader{Get: getFunc}
+	return h
 }
 
 // Read produces an io.Reader from an http(s) URL.
 func (h *HTTPURLReader) Read(url *url.URL) (io.ReadCloser, error) {
-	resp, err := h.Get(url.String())
-	if err != nil {
-		if resp != nil {
-			defer resp.Body.Close()
+	cache := newScriptCache(h.Config.CacheDir, url)
+	if h.Config.Offline {
+		if cache == nil {
+			return nil, fmt.Errorf("unable to download %s in offline mode without a scripts cache directory", url.String())
 		}
+		log.V(2).Infof("Using the cached %s in offline mode", url.String())
+		return cache.open()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.authorize(req); err != nil {
 		return nil, err
 	}
-	if resp.StatusCode == 200 || resp.StatusCode == 201 {
-		return resp.Body, nil
+	if cache != nil {
+		cache.addValidators(req.Header)
 	}
+	resp, err := h.get(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cache != nil:
+		resp.Body.Close()
+		log.V(2).Infof("Using the cached %s", url.String())
+		return cache.open()
+	case resp.StatusCode == 200 || resp.StatusCode == 201:
+		if cache == nil {
+			return resp.Body, nil
+		}
+		defer resp.Body.Close()
+		if err := cache.store(resp); err != nil {
+			return nil, err
+		}
+		return cache.open()
+	}
+	resp.Body.Close()
 	return nil, s2ierr.NewDownloadError(url.String(), resp.StatusCode)
 }
 
+// get sends the request, retrying it if it fails with a network error or a
+// status indicating a transient server problem.
+func (h *HTTPURLReader) get(req *http.Request) (*http.Response, error) {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		resp, err := h.Do(req)
+		transient := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if !transient || attempt >= h.Config.Retries {
+			return resp, err
+		}
+		if err != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			log.V(1).Infof("Download of %s failed: %v, retrying in %v ...", req.URL, err, backoff)
+		} else {
+			resp.Body.Close()
+			log.V(1).Infof("Download of %s failed with status %d, retrying in %v ...", req.URL, resp.StatusCode, backoff)
+		}
+		h.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// authorize adds the credentials configured for the host of the request to
+// it.
+func (h *HTTPURLReader) authorize(req *http.Request) error {
+	for _, auth := range h.Config.Auth {
+		if auth.Host != req.URL.Host && auth.Host != req.URL.Hostname() {
+			continue
+		}
+		if len(auth.TokenFile) > 0 {
+			token, err := readSecret(auth.TokenFile)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			return nil
+		}
+		password, err := readSecret(auth.PasswordFile)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(auth.Username, password)
+		return nil
+	}
+	return nil
+}
+
+// readSecret returns the trimmed content of the file at path.
+func readSecret(path string) (string, error) {
+	if len(path) == 0 {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the scripts server credentials: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
 // FileURLReader opens a specified file and returns its stream
 type FileURLReader struct{}
 
@@ -149,3 +283,163 @@ type ImageReader struct{}
 func (*ImageReader) Read(url *url.URL) (io.ReadCloser, error) {
 	return nil, s2ierr.NewScriptsInsideImageError(url.String())
 }
+
+// ContainerImageReader reads scripts from a directory of a separate container
+// image, referenced as docker://registry/image:tag#/path/to/scripts. Without
+// the path, the directory is taken from the io.openshift.s2i.scripts-url label
+// of the image. The image is pulled if it is not present and every scripts
+// directory is extracted only once.
+type ContainerImageReader struct {
+	docker docker.Docker
+
+	mutex   sync.Mutex
+	scripts map[string]map[string][]byte
+}
+
+// NewContainerImageReader returns a new ContainerImageReader.
+func NewContainerImageReader(docker docker.Docker) *ContainerImageReader {
+	return &ContainerImageReader{
+		docker:  docker,
+		scripts: map[string]map[string][]byte{},
+	}
+}
+
+// scriptsImageURL is the URL of a script inside a container image,
+// docker://<image>[#<dir>]/<script>[#sha256:<digest>].
+type scriptsImageURL struct {
+	// Image is the reference of the image, e.g. quay.io/org/scripts:1.0.
+	Image string
+	// Dir is the absolute path of the scripts directory inside the image, or
+	// empty to take it from the io.openshift.s2i.scripts-url label of the image.
+	Dir string
+	// Script is the name of the script.
+	Script string
+	// Digest is the sha256:<digest> the content of the script is pinned to, or
+	// empty if it is not pinned.
+	Digest string
+}
+
+// parseScriptsImageURL parses the raw URL of a script inside a container
+// image. The separators of the scripts directory and of the digest are found
+// in the raw URL, as url.Parse moves the first one into the fragment, while
+// url.ParseRequestURI keeps it in the path.
+func parseScriptsImageURL(rawurl string) (*scriptsImageURL, error) {
+	if !strings.HasPrefix(rawurl, "docker://") {
+		return nil, fmt.Errorf("invalid scripts image URL %q", rawurl)
+	}
+	u := &scriptsImageURL{}
+	ref := strings.TrimPrefix(rawurl, "docker://")
+	if i := strings.LastIndex(ref, "#"+digestPrefix); i >= 0 {
+		ref, u.Digest = ref[:i], ref[i+1:]
+	}
+	scriptPath := ref
+	u.Image = path.Dir(ref)
+	if i := strings.Index(ref, "#"); i >= 0 {
+		u.Image, scriptPath = ref[:i], ref[i+1:]
+		if !path.IsAbs(scriptPath) {
+			return nil, fmt.Errorf("the scripts directory of %q must be an absolute path", rawurl)
+		}
+		u.Dir = path.Dir(scriptPath)
+	}
+	u.Script = path.Base(scriptPath)
+	if len(u.Image) == 0 || u.Image == "." || u.Image == "/" || u.Script == "/" || u.Script == "." {
+		return nil, fmt.Errorf("invalid scripts image URL %q", rawurl)
+	}
+	return u, nil
+}
+
+// rawScriptsImageURL returns the URL of a script inside a container image as
+// it was written, whether it was parsed by url.Parse or url.ParseRequestURI.
+func rawScriptsImageURL(url *url.URL) string {
+	raw := url.Scheme + "://"
+	if url.User != nil {
+		raw += url.User.String() + "@"
+	}
+	raw += url.Host + url.Path
+	if len(url.Fragment) > 0 {
+		raw += "#" + url.Fragment
+	}
+	return raw
+}
+
+// Read produces an io.Reader for a script inside a container image. A pinned
+// digest is verified by the downloader.
+func (r *ContainerImageReader) Read(url *url.URL) (io.ReadCloser, error) {
+	u, err := parseScriptsImageURL(rawScriptsImageURL(url))
+	if err != nil {
+		return nil, err
+	}
+	scripts, err := r.extract(u.Image, u.Dir)
+	if err != nil {
+		return nil, err
+	}
+	content, ok := scripts[u.Script]
+	if !ok {
+		return nil, fmt.Errorf("script %q not found in image %q", u.Script, u.Image)
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+// extract returns the regular files of the scripts directory of the image by
+// their name.
+func (r *ContainerImageReader) extract(image, dir string) (map[string][]byte, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	key := image + "#" + dir
+	if scripts, ok := r.scripts[key]; ok {
+		return scripts, nil
+	}
+
+	if _, err := r.docker.CheckAndPullImage(image); err != nil {
+		return nil, err
+	}
+	if len(dir) == 0 {
+		scriptsURL, err := r.docker.GetScriptsURL(image)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(scriptsURL, "image://") {
+			return nil, fmt.Errorf("image %q does not specify a scripts directory, use docker://%s#/path/to/scripts", image, image)
+		}
+		dir = strings.TrimPrefix(scriptsURL, "image://")
+	}
+
+	log.V(2).Infof("Extracting scripts from %q of image %q", dir, image)
+	container, err := r.docker.CreateContainer(image)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := r.docker.RemoveContainer(container); err != nil {
+			log.V(0).Infof("warning: Failed to remove container %q: %v", container, err)
+		}
+	}()
+	buffer := &bytes.Buffer{}
+	if err := r.docker.DownloadFromContainer(dir, buffer, container); err != nil {
+		return nil, fmt.Errorf("unable to extract %q from image %q: %v", dir, image, err)
+	}
+
+	scripts := map[string][]byte{}
+	tr := tar.NewReader(buffer)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// the entries are prefixed with the name of the scripts directory
+		parts := strings.Split(path.Clean(header.Name), "/")
+		if header.Typeflag != tar.TypeReg || len(parts) != 2 {
+			continue
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		scripts[parts[1]] = content
+	}
+	r.scripts[key] = scripts
+	return scripts, nil
+}
@@ -1,15 +1,24 @@
 package scripts
 
 import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/docker"
 	s2ierr "github.com/openshift/source-to-image/pkg/errors"
 )
 
@@ -21,8 +30,8 @@ type FakeHTTPGet struct {
 	statusCode int
 }
 
-func (f *FakeHTTPGet) get(url string) (*http.Response, error) {
-	f.url = url
+func (f *FakeHTTPGet) do(req *http.Request) (*http.Response, error) {
+	f.url = req.URL.String()
 	f.body = ioutil.NopCloser(strings.NewReader(f.content))
 	return &http.Response{
 		Body:       f.body,
@@ -33,7 +42,7 @@ func (f *FakeHTTPGet) get(url string) (*http.Response, error) {
 func getHTTPReader() (*HTTPURLReader, *FakeHTTPGet) {
 	sr := &HTTPURLReader{}
 	g := &FakeHTTPGet{content: "test content", statusCode: 200}
-	sr.Get = g.get
+	sr.Do = g.do
 	return sr, g
 }
 
@@ -75,6 +84,135 @@ func TestHTTPReadErrorCode(t *testing.T) {
 	}
 }
 
+func readAll(t *testing.T, r *HTTPURLReader, rawurl string) (string, error) {
+	u, _ := url.Parse(rawurl)
+	rc, err := r.Read(u)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	content, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content), nil
+}
+
+func TestHTTPReadAuthenticated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s2i-scripts-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	authorization := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authorization = req.Header.Get("Authorization")
+		w.Write([]byte("assemble"))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	r := NewHTTPURLReader(nil, &api.ScriptDownloadConfig{
+		Auth: api.ScriptDownloadAuthList{{Host: u.Host, TokenFile: tokenFile}},
+	})
+	if _, err := readAll(t, r, server.URL+"/assemble"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if authorization != "Bearer s3cret" {
+		t.Errorf("Unexpected Authorization header %q", authorization)
+	}
+
+	r.Config.Auth = api.ScriptDownloadAuthList{{Host: u.Hostname(), Username: "user", PasswordFile: tokenFile}}
+	if _, err := readAll(t, r, server.URL+"/assemble"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if authorization != "Basic dXNlcjpzM2NyZXQ=" {
+		t.Errorf("Unexpected Authorization header %q", authorization)
+	}
+
+	r.Config.Auth = api.ScriptDownloadAuthList{{Host: "other.example.com", TokenFile: tokenFile}}
+	if _, err := readAll(t, r, server.URL+"/assemble"); err != nil || len(authorization) > 0 {
+		t.Errorf("Expected no credentials for another host, got %q, %v", authorization, err)
+	}
+}
+
+func TestHTTPReadRetries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		if requests%3 != 0 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("assemble"))
+	}))
+	defer server.Close()
+
+	waits := []time.Duration{}
+	r := NewHTTPURLReader(nil, &api.ScriptDownloadConfig{Retries: 2})
+	r.sleep = func(d time.Duration) { waits = append(waits, d) }
+	if content, err := readAll(t, r, server.URL+"/assemble"); err != nil || content != "assemble" {
+		t.Fatalf("Unexpected result %q, %v", content, err)
+	}
+	if len(waits) != 2 || waits[1] != 2*waits[0] {
+		t.Errorf("Unexpected waits between retries %v", waits)
+	}
+
+	r.Config.Retries = 1
+	_, err := readAll(t, r, server.URL+"/assemble")
+	if e, ok := err.(s2ierr.Error); !ok || e.ErrorCode != s2ierr.DownloadError {
+		t.Errorf("Expected a download error, got %v", err)
+	}
+}
+
+func TestHTTPReadCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s2i-scripts-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	requests := 0
+	revalidated := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			revalidated++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("assemble v1"))
+	}))
+	defer server.Close()
+
+	r := NewHTTPURLReader(nil, &api.ScriptDownloadConfig{CacheDir: dir})
+	for i := 0; i < 2; i++ {
+		if content, err := readAll(t, r, server.URL+"/assemble"); err != nil || content != "assemble v1" {
+			t.Fatalf("Unexpected result %q, %v", content, err)
+		}
+	}
+	if requests != 2 || revalidated != 1 {
+		t.Errorf("Expected the cached script to be revalidated, got %d requests, %d revalidated", requests, revalidated)
+	}
+
+	r.Config.Offline = true
+	if content, err := readAll(t, r, server.URL+"/assemble"); err != nil || content != "assemble v1" {
+		t.Errorf("Unexpected offline result %q, %v", content, err)
+	}
+	if _, err := readAll(t, r, server.URL+"/run"); err == nil {
+		t.Errorf("Expected an error reading an uncached script offline")
+	}
+	if requests != 2 {
+		t.Errorf("Expected no requests in offline mode, got %d", requests-2)
+	}
+}
+
 type FakeSchemeReader struct {
 	content string
 	err     error
@@ -118,6 +256,32 @@ func TestDownload(t *testing.T) {
 	}
 }
 
+func TestDownloadPinned(t *testing.T) {
+	dl, fr := getDownloader()
+	fr.content = "foo"
+	dir, err := ioutil.TempDir("", "testdownload")
+	if err != nil {
+		t.Fatalf("Cannot create temp directory for test: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	target := dir + "/assemble"
+
+	// testOtherDigest is the SHA-256 of "foo"
+	u, _ := url.Parse("http://www.test.url/a/assemble#sha256:" + testOtherDigest)
+	if _, err := dl.Download(u, target); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	u, _ = url.Parse("http://www.test.url/a/assemble#sha256:" + testDigest)
+	_, err = dl.Download(u, target)
+	if e, ok := err.(s2ierr.Error); !ok || e.ErrorCode != s2ierr.ScriptDigestError {
+		t.Errorf("Expected a digest mismatch error, got %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("Expected the mismatching download to be removed, got %v", err)
+	}
+}
+
 func TestNoDownload(t *testing.T) {
 	dl := &downloader{
 		schemeReaders: map[string]schemeReader{
@@ -144,3 +308,113 @@ func TestNoDownloader(t *testing.T) {
 		t.Errorf("Expected error, got nil!")
 	}
 }
+
+func TestParseScriptsImageURL(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		url      string
+		expected *scriptsImageURL
+	}{
+		{url: "docker://quay.io/org/scripts:1.0#/usr/libexec/s2i/assemble", expected: &scriptsImageURL{Image: "quay.io/org/scripts:1.0", Dir: "/usr/libexec/s2i", Script: "assemble"}},
+		{url: "docker://localhost:5000/scripts/assemble", expected: &scriptsImageURL{Image: "localhost:5000/scripts", Script: "assemble"}},
+		{url: "docker://quay.io/scripts/assemble#" + digest, expected: &scriptsImageURL{Image: "quay.io/scripts", Script: "assemble", Digest: digest}},
+		{url: "docker://quay.io/scripts#/s2i/assemble#" + digest, expected: &scriptsImageURL{Image: "quay.io/scripts", Dir: "/s2i", Script: "assemble", Digest: digest}},
+		{url: "docker://quay.io/scripts#usr/libexec/s2i/assemble"},
+		{url: "docker://assemble"},
+	}
+	for _, test := range tests {
+		parsers := map[string]func(string) (*url.URL, error){
+			"url.Parse": url.Parse,
+			// the script handler passes on the URL it parsed as a string
+			"url.ParseRequestURI": func(rawurl string) (*url.URL, error) {
+				u, err := url.ParseRequestURI(rawurl)
+				if err != nil {
+					return nil, err
+				}
+				return url.Parse(u.String())
+			},
+		}
+		for name, parse := range parsers {
+			u, err := parse(test.url)
+			if err != nil {
+				t.Fatalf("%s: %s: %v", test.url, name, err)
+			}
+			parsed, err := parseScriptsImageURL(rawScriptsImageURL(u))
+			if test.expected == nil {
+				if err == nil {
+					t.Errorf("%s: %s: expected an error", test.url, name)
+				}
+				continue
+			}
+			if err != nil || !reflect.DeepEqual(parsed, test.expected) {
+				t.Errorf("%s: %s: expected %#v, got %#v, %v", test.url, name, test.expected, parsed, err)
+			}
+		}
+	}
+}
+
+func TestContainerImageRead(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	tw := tar.NewWriter(buffer)
+	for name, content := range map[string]string{"s2i/": "", "s2i/assemble": "assemble from image", "s2i/lib/helper": "helper"} {
+		header := &tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(name, "/") {
+			header.Typeflag = tar.TypeDir
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+
+	fd := &docker.FakeDocker{
+		PullResult:               true,
+		CreateContainerID:        "scripts-container",
+		DefaultURLResult:         "image:///usr/libexec/s2i",
+		DownloadContainerContent: buffer.Bytes(),
+	}
+	r := NewContainerImageReader(fd)
+	u, _ := url.Parse("docker://quay.io/org/scripts:1.0/assemble")
+	rc, err := r.Read(u)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	content, _ := ioutil.ReadAll(rc)
+	if string(content) != "assemble from image" {
+		t.Errorf("Unexpected script content %q", content)
+	}
+	if fd.CreateContainerImage != "quay.io/org/scripts:1.0" || fd.DownloadContainerPath != "/usr/libexec/s2i" || fd.RemoveContainerID != "scripts-container" {
+		t.Errorf("Unexpected extraction of %q from %q, removed %q", fd.DownloadContainerPath, fd.CreateContainerImage, fd.RemoveContainerID)
+	}
+
+	// the extracted scripts are reused
+	fd.DownloadContainerContent, fd.DownloadContainerError = nil, fmt.Errorf("extracted twice")
+	u, _ = url.Parse("docker://quay.io/org/scripts:1.0/helper")
+	if _, err := r.Read(u); err == nil || strings.Contains(err.Error(), "extracted twice") {
+		t.Errorf("Expected a missing script error, got %v", err)
+	}
+
+	// the pinned digest follows the scripts directory
+	dir, err := ioutil.TempDir("", "s2i-scripts-image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dl := &downloader{schemeReaders: map[string]schemeReader{"docker": r}}
+	fd.DownloadContainerContent, fd.DownloadContainerError = buffer.Bytes(), nil
+	digest := fmt.Sprintf("%x", sha256.Sum256([]byte("assemble from image")))
+	u, _ = url.Parse("docker://quay.io/org/scripts:2.0#/usr/libexec/s2i/assemble#sha256:" + digest)
+	if _, err := dl.Download(u, filepath.Join(dir, "assemble")); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if fd.DownloadContainerPath != "/usr/libexec/s2i" {
+		t.Errorf("Expected the scripts directory of the URL to be extracted, got %q", fd.DownloadContainerPath)
+	}
+	u, _ = url.Parse("docker://quay.io/org/scripts:2.0#/usr/libexec/s2i/assemble#sha256:" + strings.Repeat("0", 64))
+	if _, err := dl.Download(u, filepath.Join(dir, "assemble")); err == nil {
+		t.Errorf("Expected a digest mismatch")
+	}
+}
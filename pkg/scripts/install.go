@@ -2,7 +2,9 @@ package scripts
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -18,6 +20,7 @@ import (
 type Installer interface {
 	InstallRequired(scripts []string, dstDir string) ([]api.InstallResult, error)
 	InstallOptional(scripts []string, dstDir string) []api.InstallResult
+	Resolve(scripts []string, dstDir string) []api.InstallResult
 }
 
 // ScriptHandler provides an interface for various scripts source handlers.
@@ -35,6 +38,12 @@ type URLScriptHandler struct {
 	Download       Downloader
 	FS             fs.FileSystem
 	Name           string
+
+	// digests caches the pinned script digests, read from the URL or the
+	// manifest next to the scripts.
+	digests      map[string]string
+	digestsError error
+	digestsRead  bool
 }
 
 const (
@@ -74,23 +83,92 @@ func (s *URLScriptHandler) Get(script string) *api.InstallResult {
 	if len(s.URL) == 0 {
 		return nil
 	}
-	scriptURL, err := url.ParseRequestURI(s.URL + "/" + script)
+	baseURL, digests, err := s.scriptDigests()
+	if err != nil {
+		return &api.InstallResult{Script: script, URL: s.URL, Error: err}
+	}
+	scriptURL, err := url.ParseRequestURI(baseURL + "/" + script)
 	if err != nil {
 		log.Infof("invalid script url %q: %v", s.URL, err)
 		return nil
 	}
-	return &api.InstallResult{
+	r := &api.InstallResult{
 		Script: script,
 		URL:    scriptURL.String(),
 	}
+	if digest, ok := digests[script]; ok {
+		r.Digest = digestPrefix + digest
+	}
+	return r
+}
+
+// scriptDigests returns the scripts URL without pinned digests and the
+// digests, either pinned by the URL or listed by the manifest next to the
+// scripts, if there is one.
+func (s *URLScriptHandler) scriptDigests() (string, map[string]string, error) {
+	baseURL, digests, err := splitScriptDigests(s.URL)
+	if err != nil {
+		return "", nil, s2ierr.NewInvalidScriptDigestError(s.URL, err)
+	}
+	if digests != nil || strings.HasPrefix(baseURL, "image://") {
+		return baseURL, digests, nil
+	}
+	if !s.digestsRead {
+		s.digestsRead = true
+		s.digests, s.digestsError = s.readDigestManifest(baseURL)
+	}
+	return baseURL, s.digests, s.digestsError
+}
+
+// readDigestManifest reads the script digests from the manifest next to the
+// scripts. The scripts are not pinned, with a warning, if there is no
+// manifest.
+func (s *URLScriptHandler) readDigestManifest(baseURL string) (map[string]string, error) {
+	manifestURL, err := url.ParseRequestURI(baseURL + "/" + digestManifest)
+	if err != nil {
+		return nil, nil
+	}
+	f, err := ioutil.TempFile("", "s2i-script-digests")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if _, err := s.Download.Download(manifestURL, f.Name()); err != nil {
+		redacted := *manifestURL
+		redacted.User = nil
+		log.Warningf("The scripts are not verified as %s could not be downloaded: %v", redacted.String(), err)
+		return nil, nil
+	}
+	f, err = os.Open(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	digests, err := parseDigestManifest(f)
+	if err != nil {
+		return nil, s2ierr.NewInvalidScriptDigestError(manifestURL.String(), err)
+	}
+	log.V(2).Infof("Verifying the scripts with the digests of %s", manifestURL)
+	return digests, nil
 }
 
 // Install downloads the script and fix its permissions.
 func (s *URLScriptHandler) Install(r *api.InstallResult) error {
+	if r.Error != nil {
+		return r.Error
+	}
 	downloadURL, err := url.Parse(r.URL)
 	if err != nil {
 		return err
 	}
+	// the digest follows the fragment of a script inside a container image,
+	// which is its scripts directory
+	if len(r.Digest) > 0 && len(downloadURL.Fragment) > 0 {
+		downloadURL.Fragment += "#" + r.Digest
+	} else if len(r.Digest) > 0 {
+		downloadURL.Fragment = r.Digest
+	}
 	dst := filepath.Join(s.DestinationDir, constants.UploadScripts, r.Script)
 	if _, err := s.Download.Download(downloadURL, dst); err != nil {
 		if e, ok := err.(s2ierr.Error); ok {
@@ -147,16 +225,21 @@ func (s *SourceScriptHandler) Install(r *api.InstallResult) error {
 	if err := s.fs.Chmod(dst, 0755); err != nil {
 		return err
 	}
-	// Make the path to scripts nicer in logs
-	parts := strings.Split(filepath.ToSlash(r.URL), "/")
-	if len(parts) > 3 {
-		r.URL = filepath.FromSlash(sourcesRootAbbrev + "/" + strings.Join(parts[len(parts)-3:], "/"))
-	}
+	r.URL = abbreviateSourcePath(r.URL)
 	r.Installed = true
 	r.Downloaded = true
 	return nil
 }
 
+// abbreviateSourcePath makes the path to a script in the sources nicer in logs.
+func abbreviateSourcePath(location string) string {
+	parts := strings.Split(filepath.ToSlash(location), "/")
+	if len(parts) > 3 {
+		return filepath.FromSlash(sourcesRootAbbrev + "/" + strings.Join(parts[len(parts)-3:], "/"))
+	}
+	return location
+}
+
 // SetDestinationDir sets the directory where the scripts should be uploaded.
 // In case of SourceScriptHandler this is a source directory root.
 func (s *SourceScriptHandler) SetDestinationDir(baseDir string) {
@@ -191,14 +274,14 @@ func (m *DefaultScriptSourceManager) Add(s ScriptHandler) {
 }
 
 // NewInstaller returns a new instance of the default Installer implementation
-func NewInstaller(image string, scriptsURL string, proxyConfig *api.ProxyConfig, docker docker.Docker, auth api.AuthConfig, fs fs.FileSystem) Installer {
+func NewInstaller(image string, scriptsURL string, proxyConfig *api.ProxyConfig, downloadConfig *api.ScriptDownloadConfig, docker docker.Docker, auth api.AuthConfig, fs fs.FileSystem) Installer {
 	m := DefaultScriptSourceManager{
 		Image:      image,
 		ScriptsURL: scriptsURL,
 		dockerAuth: auth,
 		docker:     docker,
 		fs:         fs,
-		download:   NewDownloader(proxyConfig),
+		download:   newDownloader(proxyConfig, downloadConfig, docker),
 	}
 	// Order is important here, first we try to get the scripts from provided URL,
 	// then we look into sources and check for .s2i/bin scripts.
@@ -229,9 +312,12 @@ func (m *DefaultScriptSourceManager) InstallRequired(scripts []string, dstDir st
 	for _, r := range result {
 		if r.Error != nil {
 			failedScripts = append(failedScripts, r.Script)
+			if isScriptDigestError(r.Error) && err == nil {
+				err = r.Error
+			}
 		}
 	}
-	if len(failedScripts) > 0 {
+	if len(failedScripts) > 0 && err == nil {
 		err = s2ierr.NewInstallRequiredError(failedScripts, constants.ScriptsURLLabel)
 	}
 	return result, err
@@ -243,7 +329,9 @@ func (m *DefaultScriptSourceManager) InstallOptional(scripts []string, dstDir st
 	result := []api.InstallResult{}
 	for _, script := range scripts {
 		installed := false
+		rejected := false
 		failedSources := []string{}
+		skippedSources := []api.SkippedScriptSource{}
 		for _, e := range m.sources {
 			detected := false
 			h := e.(ScriptHandler)
@@ -251,27 +339,87 @@ func (m *DefaultScriptSourceManager) InstallOptional(scripts []string, dstDir st
 			if r := h.Get(script); r != nil {
 				if err := h.Install(r); err != nil {
 					failedSources = append(failedSources, h.String())
+					skippedSources = append(skippedSources, api.SkippedScriptSource{Source: h.String(), Reason: fmt.Sprintf("failed to install: %v", err)})
+					if isScriptDigestError(err) {
+						// never fall back to another source of a pinned script
+						log.Errorf("script %q found by the %s, but failed verification: %v", script, h, err)
+						r.Error = err
+						r.FailedSources = failedSources
+						r.SkippedSources = skippedSources
+						result = append(result, *r)
+						rejected = true
+						break
+					}
 					// all this means is this source didn't have this particular script
 					log.V(4).Infof("script %q found by the %s, but failed to install: %v", script, h, err)
 				} else {
 					r.FailedSources = failedSources
+					r.Source = h.String()
+					r.SkippedSources = skippedSources
 					result = append(result, *r)
 					installed = true
 					detected = true
 					log.V(4).Infof("Using %q installed from %q", script, r.URL)
 				}
+			} else {
+				skippedSources = append(skippedSources, api.SkippedScriptSource{Source: h.String(), Reason: notFoundReason(h, script)})
 			}
 			if detected {
 				break
 			}
 		}
-		if !installed {
+		if !installed && !rejected {
 			result = append(result, api.InstallResult{
-				FailedSources: failedSources,
-				Script:        script,
-				Error:         fmt.Errorf("script %q not installed", script),
+				FailedSources:  failedSources,
+				SkippedSources: skippedSources,
+				Script:         script,
+				Error:          fmt.Errorf("script %q not installed", script),
 			})
 		}
 	}
 	return result
 }
+
+// notFoundReason describes why the handler found no script.
+func notFoundReason(h ScriptHandler, script string) string {
+	if s, ok := h.(*SourceScriptHandler); ok {
+		return fmt.Sprintf("not found at %s", abbreviateSourcePath(filepath.Join(s.DestinationDir, constants.SourceScripts, script)))
+	}
+	return "invalid script URL"
+}
+
+// Resolve returns where each of the scripts would be installed from into
+// dstDir, without downloading or installing them. The script URLs are not
+// checked to exist, so the URL of a script is the first one it would be
+// downloaded from.
+func (m *DefaultScriptSourceManager) Resolve(scripts []string, dstDir string) []api.InstallResult {
+	result := []api.InstallResult{}
+	for _, script := range scripts {
+		resolved := false
+		for _, h := range m.sources {
+			h.SetDestinationDir(dstDir)
+			if r := h.Get(script); r != nil {
+				if _, ok := h.(*SourceScriptHandler); ok {
+					r.URL = abbreviateSourcePath(r.URL)
+				}
+				result = append(result, *r)
+				resolved = true
+				break
+			}
+		}
+		if !resolved {
+			result = append(result, api.InstallResult{
+				Script: script,
+				Error:  fmt.Errorf("script %q not found", script),
+			})
+		}
+	}
+	return result
+}
+
+// isScriptDigestError returns true if the error indicates that a script could
+// not be verified against its pinned digest.
+func isScriptDigestError(err error) bool {
+	e, ok := err.(s2ierr.Error)
+	return ok && e.ErrorCode == s2ierr.ScriptDigestError
+}
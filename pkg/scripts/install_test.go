@@ -10,6 +10,7 @@ import (
 	"github.com/openshift/source-to-image/pkg/api"
 	"github.com/openshift/source-to-image/pkg/api/constants"
 	dockerpkg "github.com/openshift/source-to-image/pkg/docker"
+	s2ierr "github.com/openshift/source-to-image/pkg/errors"
 	"github.com/openshift/source-to-image/pkg/test"
 	testfs "github.com/openshift/source-to-image/pkg/test/fs"
 	"github.com/openshift/source-to-image/pkg/util/fs"
@@ -111,6 +112,34 @@ func TestInstallRequiredFromURL(t *testing.T) {
 	}
 }
 
+func TestInstallRequiredPinnedDigestMismatch(t *testing.T) {
+	config := newFakeConfig()
+	pinned := config.url + "/" + constants.Assemble + "#sha256:" + testDigest
+	config.url += "#sha256:" + constants.Assemble + "=" + testDigest
+	config.download.(*test.FakeDownloader).Err = map[string]error{
+		pinned: s2ierr.NewScriptDigestMismatchError(pinned, testDigest, testOtherDigest),
+	}
+	config.docker.(*dockerpkg.FakeDocker).DefaultURLResult = "http://image.scripts.url/s2i/bin"
+	inst := newFakeInstaller(config)
+	results, err := inst.InstallRequired([]string{constants.Assemble, constants.Run}, "/output")
+	if e, ok := err.(s2ierr.Error); !ok || e.ErrorCode != s2ierr.ScriptDigestError {
+		t.Errorf("Expected a digest error, got %v", err)
+	}
+	for _, r := range results {
+		if r.Script == constants.Assemble && (r.Error == nil || r.Installed || r.Digest != "sha256:"+testDigest) {
+			t.Errorf("Expected the pinned assemble script to be rejected, got %#v", r)
+		}
+		if r.Script == constants.Run && r.Error != nil {
+			t.Errorf("Unexpected error installing the run script: %v", r.Error)
+		}
+	}
+	for _, u := range config.download.(*test.FakeDownloader).URL {
+		if u.Host == "image.scripts.url" {
+			t.Errorf("Expected no fall back to the image scripts URL, downloaded %s", u.String())
+		}
+	}
+}
+
 func TestInstallRequiredFromDocker(t *testing.T) {
 	config := newFakeConfig()
 	// We fail the download for assemble, which means the Docker image default URL
@@ -267,7 +296,7 @@ func TestInstallRequiredFromInvalidURL(t *testing.T) {
 
 func TestNewInstaller(t *testing.T) {
 	docker := &dockerpkg.FakeDocker{DefaultURLResult: "image://docker"}
-	inst := NewInstaller("test-image", "http://foo.bar", nil, docker, api.AuthConfig{}, &testfs.FakeFileSystem{})
+	inst := NewInstaller("test-image", "http://foo.bar", nil, nil, docker, api.AuthConfig{}, &testfs.FakeFileSystem{})
 	sources := inst.(*DefaultScriptSourceManager).sources
 	firstHandler, ok := sources[0].(*URLScriptHandler)
 	if !ok {
@@ -326,3 +355,32 @@ func TestInstallOptionalFailedSources(t *testing.T) {
 		}
 	}
 }
+
+func TestInstallOptionalSkippedSources(t *testing.T) {
+	m := DefaultScriptSourceManager{}
+	m.Add(&fakeSource{name: "failing", failOn: map[string]struct{}{"one": {}}})
+	m.Add(&SourceScriptHandler{fs: &testfs.FakeFileSystem{}})
+	m.Add(&fakeSource{name: "image"})
+
+	results := m.InstallOptional([]string{"one", "two"}, "/tmp")
+	expected := []api.InstallResult{
+		{
+			Script:        "one",
+			FailedSources: []string{"failing"},
+			Source:        "image",
+			SkippedSources: []api.SkippedScriptSource{
+				{Source: "failing", Reason: "failed to install: error"},
+				{Source: SourceHandler, Reason: "not found at " + filepath.FromSlash("<source-dir>/.s2i/bin/one")},
+			},
+		},
+		{
+			Script:         "two",
+			FailedSources:  []string{},
+			Source:         "failing",
+			SkippedSources: []api.SkippedScriptSource{},
+		},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Expected results %#v, got %#v", expected, results)
+	}
+}
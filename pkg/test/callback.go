@@ -1,19 +1,28 @@
 package test
 
+import "github.com/openshift/source-to-image/pkg/api"
+
 // FakeCallbackInvoker provides the fake callback invoker
 type FakeCallbackInvoker struct {
 	CallbackURL string
 	Success     bool
 	Messages    []string
 	Labels      map[string]string
+	Stages      []api.StageInfo
 	Result      []string
 }
 
 // ExecuteCallback executes the fake callback
 func (f *FakeCallbackInvoker) ExecuteCallback(callbackURL string, success bool, labels map[string]string, messages []string) []string {
+	return f.ExecuteCallbackWithStages(callbackURL, success, labels, nil, messages)
+}
+
+// ExecuteCallbackWithStages executes the fake callback with the stages
+func (f *FakeCallbackInvoker) ExecuteCallbackWithStages(callbackURL string, success bool, labels map[string]string, stages []api.StageInfo, messages []string) []string {
 	f.CallbackURL = callbackURL
 	f.Success = success
 	f.Labels = labels
+	f.Stages = stages
 	f.Messages = messages
 	return f.Result
 }
@@ -22,7 +22,17 @@ type FakeGit struct {
 	SubmoduleUpdateRepo      string
 	SubmoduleUpdateInit      bool
 	SubmoduleUpdateRecursive bool
+	SubmoduleUpdateConfig    git.SubmoduleConfig
 	SubmoduleUpdateError     error
+
+	LFSPullRepo      string
+	LFSPullRecursive bool
+	LFSPullConfig    git.LFSConfig
+	LFSPullError     error
+
+	WorkingTreeRepo  string
+	WorkingTreeFiles []git.WorkingTreeFile
+	WorkingTreeError error
 }
 
 // Clone clones the fake source Git repository to target directory
@@ -46,13 +56,22 @@ func (f *FakeGit) SubmoduleInit(repo string) error {
 }
 
 // SubmoduleUpdate checks out submodules to their correct version
-func (f *FakeGit) SubmoduleUpdate(repo string, init, recursive bool) error {
+func (f *FakeGit) SubmoduleUpdate(repo string, init, recursive bool, c git.SubmoduleConfig) error {
 	f.SubmoduleUpdateRepo = repo
 	f.SubmoduleUpdateRecursive = recursive
 	f.SubmoduleUpdateInit = init
+	f.SubmoduleUpdateConfig = c
 	return f.SubmoduleUpdateError
 }
 
+// LFSPull fetches the Git LFS objects of the fake Git repository
+func (f *FakeGit) LFSPull(repo string, recursive bool, c git.LFSConfig) error {
+	f.LFSPullRepo = repo
+	f.LFSPullRecursive = recursive
+	f.LFSPullConfig = c
+	return f.LFSPullError
+}
+
 // LsTree returns a slice of os.FileInfo objects populated with the paths and
 // file modes of files known to Git.  This is used on Windows systems where the
 // executable mode metadata is lost on git checkout.
@@ -60,6 +79,12 @@ func (f *FakeGit) LsTree(repo, ref string, recursive bool) ([]os.FileInfo, error
 	return []os.FileInfo{}, nil
 }
 
+// ListWorkingTree returns the files of the fake Git working tree
+func (f *FakeGit) ListWorkingTree(repo string) ([]git.WorkingTreeFile, error) {
+	f.WorkingTreeRepo = repo
+	return f.WorkingTreeFiles, f.WorkingTreeError
+}
+
 // GetInfo retrieves the information about the source code and commit
 func (f *FakeGit) GetInfo(repo string) *git.SourceInfo {
 	return &git.SourceInfo{
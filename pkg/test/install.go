@@ -27,3 +27,8 @@ func (f *FakeInstaller) InstallRequired(scripts []string, dstDir string) ([]api.
 func (f *FakeInstaller) InstallOptional(scripts []string, dstDir string) []api.InstallResult {
 	return f.run(scripts, dstDir)
 }
+
+// Resolve returns where the scripts would be installed from
+func (f *FakeInstaller) Resolve(scripts []string, dstDir string) []api.InstallResult {
+	return f.run(scripts, dstDir)
+}
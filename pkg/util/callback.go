@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+
+	"github.com/openshift/source-to-image/pkg/api"
 )
 
 // CallbackInvoker posts results to a callback URL when a STI build is done.
@@ -14,7 +16,15 @@ type CallbackInvoker interface {
 	ExecuteCallback(callbackURL string, success bool, labels map[string]string, messages []string) []string
 }
 
-// NewCallbackInvoker creates an instance of the default CallbackInvoker implementation
+// StagesCallbackInvoker is a CallbackInvoker which also posts the resource
+// usage of the build stages.
+type StagesCallbackInvoker interface {
+	CallbackInvoker
+	ExecuteCallbackWithStages(callbackURL string, success bool, labels map[string]string, stages []api.StageInfo, messages []string) []string
+}
+
+// NewCallbackInvoker creates an instance of the default CallbackInvoker
+// implementation, which is a StagesCallbackInvoker.
 func NewCallbackInvoker() CallbackInvoker {
 	invoker := &callbackInvoker{}
 	invoker.postFunc = invoker.httpPost
@@ -27,6 +37,12 @@ type callbackInvoker struct {
 
 // ExecuteCallback prepares a JSON payload and posts it to the specified callback URL
 func (c *callbackInvoker) ExecuteCallback(callbackURL string, success bool, labels map[string]string, messages []string) []string {
+	return c.ExecuteCallbackWithStages(callbackURL, success, labels, nil, messages)
+}
+
+// ExecuteCallbackWithStages prepares a JSON payload including the resource
+// usage of the stages and posts it to the specified callback URL
+func (c *callbackInvoker) ExecuteCallbackWithStages(callbackURL string, success bool, labels map[string]string, stages []api.StageInfo, messages []string) []string {
 	buf := new(bytes.Buffer)
 	writer := bufio.NewWriter(buf)
 
@@ -38,6 +54,10 @@ func (c *callbackInvoker) ExecuteCallback(callbackURL string, success bool, labe
 		data["labels"] = labels
 	}
 
+	if usage := resourceUsagePayload(stages); len(usage) > 0 {
+		data["resourceUsage"] = usage
+	}
+
 	jsonBuffer := new(bytes.Buffer)
 	writer = bufio.NewWriter(jsonBuffer)
 	jsonWriter := json.NewEncoder(writer)
@@ -65,6 +85,26 @@ func (c *callbackInvoker) ExecuteCallback(callbackURL string, success bool, labe
 	return messages
 }
 
+// resourceUsagePayload returns the resource usage of the stages by stage name.
+func resourceUsagePayload(stages []api.StageInfo) map[api.StageName]map[string]uint64 {
+	payload := map[api.StageName]map[string]uint64{}
+	for _, stage := range stages {
+		if stage.ResourceUsage == nil {
+			continue
+		}
+		u := stage.ResourceUsage
+		payload[stage.Name] = map[string]uint64{
+			"peakMemoryBytes":      u.PeakMemoryBytes,
+			"cpuTimeNanoseconds":   u.CPUTimeNanoseconds,
+			"blockIOReadBytes":     u.BlockIOReadBytes,
+			"blockIOWriteBytes":    u.BlockIOWriteBytes,
+			"networkReceivedBytes": u.NetworkReceivedBytes,
+			"networkSentBytes":     u.NetworkSentBytes,
+		}
+	}
+	return payload
+}
+
 func (*callbackInvoker) httpPost(url, contentType string, body io.Reader) (resp *http.Response, err error) {
 	return http.Post(url, contentType, body)
 }
@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"testing"
+
+	"github.com/openshift/source-to-image/pkg/api"
 )
 
 type FakePost struct {
@@ -30,11 +32,16 @@ func TestExecuteCallback(t *testing.T) {
 	labels := map[string]string{
 		"foo": "bar",
 	}
-	cb.ExecuteCallback("http://the.callback.url/test", true, labels, []string{"msg1", "msg2"})
+	stages := []api.StageInfo{
+		{Name: api.StagePullImages},
+		{Name: api.StageAssemble, ResourceUsage: &api.ResourceUsage{PeakMemoryBytes: 1024, CPUTimeNanoseconds: 2000}},
+	}
+	cb.ExecuteCallbackWithStages("http://the.callback.url/test", true, labels, stages, []string{"msg1", "msg2"})
 
 	type postBody struct {
-		Labels  map[string]string
-		Success bool
+		Labels        map[string]string
+		Success       bool
+		ResourceUsage map[string]map[string]uint64
 	}
 	var pb postBody
 	json.Unmarshal(fp.body, &pb)
@@ -47,4 +54,25 @@ func TestExecuteCallback(t *testing.T) {
 	if pb.Success != true {
 		t.Errorf("Unexpected success flag: %v", pb.Success)
 	}
+	if len(pb.ResourceUsage) != 1 || pb.ResourceUsage["Assemble"]["peakMemoryBytes"] != 1024 || pb.ResourceUsage["Assemble"]["cpuTimeNanoseconds"] != 2000 {
+		t.Errorf("Unexpected resource usage: %v", pb.ResourceUsage)
+	}
+}
+
+func TestExecuteCallbackWithoutStages(t *testing.T) {
+	if _, ok := NewCallbackInvoker().(StagesCallbackInvoker); !ok {
+		t.Fatalf("Expected the default callback invoker to post the resource usage of the stages")
+	}
+
+	fp := FakePost{}
+	cb := callbackInvoker{
+		postFunc: fp.post,
+	}
+	cb.ExecuteCallback("http://the.callback.url/test", true, nil, nil)
+
+	var pb map[string]interface{}
+	json.Unmarshal(fp.body, &pb)
+	if _, ok := pb["resourceUsage"]; ok || pb["success"] != true {
+		t.Errorf("Unexpected payload: %v", pb)
+	}
 }
@@ -0,0 +1,199 @@
+package cgroups
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/openshift/source-to-image/pkg/api"
+)
+
+const (
+	// cgroupRoot is where the cgroup hierarchies are mounted.
+	cgroupRoot = "/sys/fs/cgroup"
+
+	// procCgroup lists the cgroups of the current process.
+	procCgroup = "/proc/self/cgroup"
+
+	// unlimitedMemory is the lowest value of memory.limit_in_bytes of cgroup v1
+	// considered unlimited. The kernel reports the largest page aligned int64.
+	unlimitedMemory = int64(1) << 62
+)
+
+// Detect returns the limits of the cgroup the current process runs in, e.g.
+// the limits of the container s2i runs in, from the cgroup v1 or v2 hierarchy.
+// Limits which are not set are left 0. The parent cgroup is never set, as the
+// cgroup path of the process is not a cgroup of the Docker daemon, e.g. when
+// s2i runs in a container or with a remote daemon.
+func Detect() (*api.CGroupLimits, error) {
+	return detect(cgroupRoot, procCgroup)
+}
+
+// Inherit sets the limits which are 0 to the ones of the cgroup the current
+// process runs in, so that the containers run by s2i don't get more resources
+// than s2i itself.
+func Inherit(limits *api.CGroupLimits) error {
+	detected, err := Detect()
+	if err != nil {
+		return err
+	}
+	merge(limits, detected)
+	return nil
+}
+
+// merge sets the limits which are 0 to the detected ones.
+func merge(limits, detected *api.CGroupLimits) {
+	if limits.MemoryLimitBytes == 0 {
+		limits.MemoryLimitBytes = detected.MemoryLimitBytes
+		if limits.MemorySwap == 0 {
+			limits.MemorySwap = detected.MemorySwap
+		}
+	}
+	if limits.CPUShares == 0 {
+		limits.CPUShares = detected.CPUShares
+	}
+	if limits.CPUQuota == 0 {
+		limits.CPUQuota = detected.CPUQuota
+		if limits.CPUPeriod == 0 {
+			limits.CPUPeriod = detected.CPUPeriod
+		}
+	}
+	if limits.PidsLimit == 0 {
+		limits.PidsLimit = detected.PidsLimit
+	}
+	if limits.BlkioWeight == 0 {
+		limits.BlkioWeight = detected.BlkioWeight
+	}
+}
+
+// detect reads the limits from the hierarchies mounted at root, using the
+// cgroups of the current process listed in the procCgroup file.
+func detect(root, procCgroup string) (*api.CGroupLimits, error) {
+	paths, err := readProcCgroup(procCgroup)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return detectV2(cgroupDir(root, paths[""])), nil
+	}
+	dir := func(controller string) string {
+		return cgroupDir(filepath.Join(root, controller), paths[controller])
+	}
+	return detectV1(dir), nil
+}
+
+// detectV2 reads the limits from the cgroup v2 directory.
+func detectV2(dir string) *api.CGroupLimits {
+	limits := &api.CGroupLimits{}
+	if memory, ok := readInt(dir, "memory.max"); ok {
+		limits.MemoryLimitBytes = memory
+		// cgroup v2 limits the swap alone, docker the memory and the swap
+		if swap, ok := readInt(dir, "memory.swap.max"); ok {
+			limits.MemorySwap = memory + swap
+		} else if readValue(dir, "memory.swap.max") == "max" {
+			limits.MemorySwap = -1
+		}
+	}
+	if fields := strings.Fields(readValue(dir, "cpu.max")); len(fields) == 2 {
+		quota, quotaErr := strconv.ParseInt(fields[0], 10, 64)
+		period, periodErr := strconv.ParseInt(fields[1], 10, 64)
+		if quotaErr == nil && periodErr == nil {
+			limits.CPUQuota = quota
+			limits.CPUPeriod = period
+		}
+	}
+	// convert the weights to their cgroup v1 equivalents, which docker expects
+	if weight, ok := readInt(dir, "cpu.weight"); ok && weight != 100 {
+		limits.CPUShares = 2 + (weight-1)*262142/9999
+	}
+	if fields := strings.Fields(readValue(dir, "io.weight")); len(fields) == 2 && fields[0] == "default" {
+		if weight, err := strconv.ParseInt(fields[1], 10, 64); err == nil && weight != 100 {
+			limits.BlkioWeight = uint16(10 + (weight-1)*990/9999)
+		}
+	}
+	if pids, ok := readInt(dir, "pids.max"); ok {
+		limits.PidsLimit = pids
+	}
+	return limits
+}
+
+// detectV1 reads the limits from the cgroup v1 controller directories returned
+// by dir.
+func detectV1(dir func(controller string) string) *api.CGroupLimits {
+	limits := &api.CGroupLimits{}
+	if memory, ok := readInt(dir("memory"), "memory.limit_in_bytes"); ok && memory < unlimitedMemory {
+		limits.MemoryLimitBytes = memory
+		if swap, ok := readInt(dir("memory"), "memory.memsw.limit_in_bytes"); ok && swap < unlimitedMemory {
+			limits.MemorySwap = swap
+		}
+	}
+	if shares, ok := readInt(dir("cpu"), "cpu.shares"); ok && shares != 1024 {
+		limits.CPUShares = shares
+	}
+	if quota, ok := readInt(dir("cpu"), "cpu.cfs_quota_us"); ok && quota > 0 {
+		limits.CPUQuota = quota
+		limits.CPUPeriod, _ = readInt(dir("cpu"), "cpu.cfs_period_us")
+	}
+	if weight, ok := readInt(dir("blkio"), "blkio.weight"); ok && weight != 500 && weight > 0 {
+		limits.BlkioWeight = uint16(weight)
+	}
+	if pids, ok := readInt(dir("pids"), "pids.max"); ok {
+		limits.PidsLimit = pids
+	}
+	return limits
+}
+
+// readProcCgroup returns the cgroup paths of the current process by
+// controller, where the cgroup v2 path has the empty controller.
+func readProcCgroup(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	paths := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid line %q in %s", scanner.Text(), file)
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// cgroupDir returns the directory of the cgroup path in the hierarchy mounted
+// at root. In a private cgroup namespace the path is relative to the cgroup of
+// the container, which is mounted at root itself.
+func cgroupDir(root, path string) string {
+	dir := filepath.Join(root, path)
+	if _, err := os.Stat(dir); err != nil {
+		return root
+	}
+	return dir
+}
+
+// readValue returns the trimmed content of the file in dir, or an empty string
+// if it can't be read.
+func readValue(dir, file string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// readInt returns the integer in the file in dir. It returns false if the file
+// can't be read or holds no integer, e.g. "max".
+func readInt(dir, file string) (int64, bool) {
+	value, err := strconv.ParseInt(readValue(dir, file), 10, 64)
+	return value, err == nil
+}
@@ -0,0 +1,142 @@
+package cgroups
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/api"
+)
+
+// writeFiles creates the files with the given content below dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := map[string]struct {
+		files    map[string]string
+		expected *api.CGroupLimits
+	}{
+		"v2 private namespace": {
+			files: map[string]string{
+				"proc":                    "0::/",
+				"root/cgroup.controllers": "cpu io memory pids",
+				"root/memory.max":         "536870912",
+				"root/memory.swap.max":    "0",
+				"root/cpu.max":            "50000 100000",
+				"root/cpu.weight":         "100",
+				"root/io.weight":          "default 100",
+				"root/pids.max":           "1024",
+			},
+			expected: &api.CGroupLimits{
+				MemoryLimitBytes: 536870912,
+				MemorySwap:       536870912,
+				CPUQuota:         50000,
+				CPUPeriod:        100000,
+				PidsLimit:        1024,
+			},
+		},
+		"v2 host namespace": {
+			files: map[string]string{
+				"proc":                                  "0::/kubepods/pod1/builder",
+				"root/cgroup.controllers":               "cpu io memory pids",
+				"root/memory.max":                       "max",
+				"root/kubepods/pod1/builder/memory.max": "max",
+				"root/kubepods/pod1/builder/memory.swap.max": "max",
+				"root/kubepods/pod1/builder/cpu.max":         "max 100000",
+				"root/kubepods/pod1/builder/cpu.weight":      "10000",
+				"root/kubepods/pod1/builder/io.weight":       "default 10000",
+				"root/kubepods/pod1/builder/pids.max":        "max",
+			},
+			expected: &api.CGroupLimits{
+				CPUShares:   262144,
+				BlkioWeight: 1000,
+			},
+		},
+		"v1": {
+			files: map[string]string{
+				"proc": "12:pids:/docker/abc\n" +
+					"4:cpu,cpuacct:/docker/abc\n" +
+					"3:memory:/docker/abc\n" +
+					"2:blkio:/docker/abc\n" +
+					"1:name=systemd:/docker/abc",
+				"root/memory/docker/abc/memory.limit_in_bytes":       "1073741824",
+				"root/memory/docker/abc/memory.memsw.limit_in_bytes": "2147483648",
+				"root/cpu/docker/abc/cpu.shares":                     "512",
+				"root/cpu/docker/abc/cpu.cfs_quota_us":               "200000",
+				"root/cpu/docker/abc/cpu.cfs_period_us":              "100000",
+				"root/blkio/docker/abc/blkio.weight":                 "300",
+				"root/pids/docker/abc/pids.max":                      "max",
+			},
+			expected: &api.CGroupLimits{
+				MemoryLimitBytes: 1073741824,
+				MemorySwap:       2147483648,
+				CPUShares:        512,
+				CPUQuota:         200000,
+				CPUPeriod:        100000,
+				BlkioWeight:      300,
+			},
+		},
+		"v1 unlimited": {
+			files: map[string]string{
+				"proc":                              "4:cpu,cpuacct:/\n3:memory:/",
+				"root/memory/memory.limit_in_bytes": "9223372036854771712",
+				"root/cpu/cpu.shares":               "1024",
+				"root/cpu/cpu.cfs_quota_us":         "-1",
+				"root/cpu/cpu.cfs_period_us":        "100000",
+			},
+			expected: &api.CGroupLimits{},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "s2i-cgroups-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			writeFiles(t, dir, tc.files)
+
+			limits, err := detect(filepath.Join(dir, "root"), filepath.Join(dir, "proc"))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(limits, tc.expected) {
+				t.Errorf("Expected limits %#v, got %#v", tc.expected, limits)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	limits := &api.CGroupLimits{MemoryLimitBytes: 1024, CPUQuota: 50000}
+	merge(limits, &api.CGroupLimits{
+		MemoryLimitBytes: 2048,
+		MemorySwap:       4096,
+		CPUQuota:         100000,
+		CPUPeriod:        200000,
+		CPUShares:        512,
+		PidsLimit:        100,
+	})
+	expected := &api.CGroupLimits{
+		MemoryLimitBytes: 1024,
+		CPUQuota:         50000,
+		CPUShares:        512,
+		PidsLimit:        100,
+	}
+	if !reflect.DeepEqual(limits, expected) {
+		t.Errorf("Expected limits %#v, got %#v", expected, limits)
+	}
+}
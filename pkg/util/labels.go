@@ -1,15 +1,18 @@
 package util
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/openshift/source-to-image/pkg/api"
 	"github.com/openshift/source-to-image/pkg/api/constants"
 	"github.com/openshift/source-to-image/pkg/scm/git"
+	"github.com/openshift/source-to-image/pkg/util/registries"
 )
 
 const (
@@ -17,9 +20,9 @@ const (
 	MetadataFilename = "image_metadata.json"
 )
 
-// GenerateOutputImageLabels generate the labels based on the s2i Config
-// and source repository informations.
-func GenerateOutputImageLabels(info *git.SourceInfo, config *api.Config) map[string]string {
+// GenerateOutputImageLabels generate the labels based on the s2i Config,
+// source repository informations and installed scripts.
+func GenerateOutputImageLabels(info *git.SourceInfo, scripts []api.InstallResult, config *api.Config) map[string]string {
 	labels := map[string]string{}
 	namespace := constants.DefaultNamespace
 	if len(config.LabelNamespace) > 0 {
@@ -28,6 +31,7 @@ func GenerateOutputImageLabels(info *git.SourceInfo, config *api.Config) map[str
 
 	labels = GenerateLabelsFromConfig(labels, config, namespace)
 	labels = GenerateLabelsFromSourceInfo(labels, info, namespace)
+	addBuildLabel(labels, "scripts", scriptsLabel(scripts), namespace)
 
 	if data, err := ProcessImageMetadataFile(filepath.Join(config.WorkingDir, constants.SourceConfig)); err == nil {
 		ll := data["labels"]
@@ -53,9 +57,50 @@ func GenerateLabelsFromConfig(labels map[string]string, config *api.Config, name
 	}
 
 	addBuildLabel(labels, "image", config.BuilderImage, namespace)
+	// the image label keeps the original reference of a builder image pulled
+	// from a location the registry rules rewrote it to
+	if location, _ := registries.Resolve(config.RegistryRules, config.BuilderImage); location != config.BuilderImage {
+		addBuildLabel(labels, "image-location", location, namespace)
+	}
 	return labels
 }
 
+// scriptProvenance is how the source of a script is encoded in the scripts
+// label.
+type scriptProvenance struct {
+	Source  string   `json:"source"`
+	URL     string   `json:"url"`
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// scriptsLabel returns the JSON encoded source of each installed script, or
+// an empty string if no script was installed.
+func scriptsLabel(results []api.InstallResult) string {
+	scripts := map[string]scriptProvenance{}
+	for _, r := range results {
+		if r.Error != nil || len(r.Source) == 0 {
+			continue
+		}
+		p := scriptProvenance{Source: r.Source, URL: r.URL}
+		for _, skipped := range r.SkippedSources {
+			p.Skipped = append(p.Skipped, fmt.Sprintf("%s: %s", skipped.Source, skipped.Reason))
+		}
+		scripts[r.Script] = p
+	}
+	if len(scripts) == 0 {
+		return ""
+	}
+	// keep the <source-dir> of the paths in the sources readable
+	data := &bytes.Buffer{}
+	encoder := json.NewEncoder(data)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(scripts); err != nil {
+		log.V(1).Infof("Unable to encode the sources of the scripts: %v", err)
+		return ""
+	}
+	return strings.TrimSpace(data.String())
+}
+
 // GenerateLabelsFromSourceInfo generate the labels based on the source repository
 // informations.
 func GenerateLabelsFromSourceInfo(labels map[string]string, info *git.SourceInfo, namespace string) map[string]string {
@@ -73,7 +118,19 @@ func GenerateLabelsFromSourceInfo(labels map[string]string, info *git.SourceInfo
 	addBuildLabel(labels, "commit.id", info.CommitID, namespace)
 	addBuildLabel(labels, "commit.ref", info.Ref, namespace)
 	addBuildLabel(labels, "commit.message", info.Message, namespace)
-	addBuildLabel(labels, "source-location", info.Location, namespace)
+	if info.Dirty {
+		addBuildLabel(labels, "commit.dirty", "true", namespace)
+		addBuildLabel(labels, "commit.diff-hash", info.DiffHash, namespace)
+	}
+	if len(info.Submodules) > 0 {
+		submodules, err := json.Marshal(info.Submodules)
+		if err != nil {
+			log.V(1).Infof("Unable to encode the submodule revisions: %v", err)
+		} else {
+			addBuildLabel(labels, "commit.submodules", string(submodules), namespace)
+		}
+	}
+	addBuildLabel(labels, "source-location", git.RedactCredentials(info.Location), namespace)
 	addBuildLabel(labels, "source-context-dir", info.ContextDir, namespace)
 	return labels
 }
@@ -0,0 +1,121 @@
+package registries
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/docker/distribution/reference"
+
+	"github.com/openshift/source-to-image/pkg/api"
+)
+
+// config is the subset of registries.conf(5) read by Load, e.g.
+//
+//	[[registry]]
+//	prefix = "docker.io"
+//	location = "mirror.example.com/dockerhub"
+//
+//	[[registry]]
+//	prefix = "quay.io/untrusted"
+//	blocked = true
+type config struct {
+	Registries []registry `toml:"registry"`
+}
+
+type registry struct {
+	Prefix   string `toml:"prefix"`
+	Location string `toml:"location"`
+	Insecure bool   `toml:"insecure"`
+	Blocked  bool   `toml:"blocked"`
+}
+
+// Load reads the registry rules from a file in the format of
+// registries.conf(5). The other settings of the file, e.g. the mirrors or the
+// unqualified search registries, are ignored.
+func Load(path string) ([]api.RegistryRule, error) {
+	c := config{}
+	if _, err := toml.DecodeFile(path, &c); err != nil {
+		return nil, fmt.Errorf("unable to read the registries configuration %q: %v", path, err)
+	}
+	rules := []api.RegistryRule{}
+	for _, r := range c.Registries {
+		rule := api.RegistryRule{Prefix: r.Prefix, Location: r.Location, Insecure: r.Insecure, Blocked: r.Blocked}
+		if len(rule.Prefix) == 0 {
+			rule.Prefix = rule.Location
+		}
+		if err := validate(rule); err != nil {
+			return nil, fmt.Errorf("invalid registry %q in %q: %v", rule.Prefix, path, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// validate checks that the prefix and location of the rule are references
+// without a tag or digest.
+func validate(rule api.RegistryRule) error {
+	if len(rule.Prefix) == 0 {
+		return fmt.Errorf("the prefix or the location must be set")
+	}
+	if strings.HasPrefix(rule.Prefix, "*.") {
+		if len(rule.Location) > 0 {
+			return fmt.Errorf("the location of a wildcard prefix must be empty")
+		}
+		return nil
+	}
+	for _, s := range []string{rule.Prefix, rule.Location} {
+		if len(s) == 0 {
+			continue
+		}
+		// a registry host alone is not a valid reference
+		named, err := reference.ParseNormalizedNamed(s + "/image")
+		if err != nil || strings.Contains(s, "@") || !strings.HasPrefix(s+"/", reference.Domain(named)+"/") {
+			return fmt.Errorf("%q is not a fully qualified repository or registry", s)
+		}
+	}
+	return nil
+}
+
+// Resolve returns the reference the image of the name is pulled from, along
+// with the rule applying to it, which is nil if there is none. The reference
+// is the name if the rule does not rewrite it. The rule with the longest
+// matching prefix applies.
+func Resolve(rules []api.RegistryRule, name string) (string, *api.RegistryRule) {
+	if len(rules) == 0 {
+		return name, nil
+	}
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return name, nil
+	}
+	ref := reference.TagNameOnly(named).String()
+
+	var match *api.RegistryRule
+	for i := range rules {
+		if matches(rules[i].Prefix, ref) && (match == nil || len(rules[i].Prefix) > len(match.Prefix)) {
+			match = &rules[i]
+		}
+	}
+	if match == nil || len(match.Location) == 0 || match.Location == match.Prefix {
+		return name, match
+	}
+	return match.Location + strings.TrimPrefix(ref, match.Prefix), match
+}
+
+// matches returns true if the prefix matches the fully qualified reference,
+// either a whole path of it or its domain for a wildcard prefix.
+func matches(prefix, ref string) bool {
+	if strings.HasPrefix(prefix, "*.") {
+		domain := ref
+		if i := strings.IndexRune(ref, '/'); i >= 0 {
+			domain = ref[:i]
+		}
+		return strings.HasSuffix(domain, prefix[1:])
+	}
+	if !strings.HasPrefix(ref, prefix) {
+		return false
+	}
+	rest := ref[len(prefix):]
+	return len(rest) == 0 || strings.ContainsAny(rest[:1], "/:@")
+}
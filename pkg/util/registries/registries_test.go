@@ -0,0 +1,133 @@
+package registries
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openshift/source-to-image/pkg/api"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s2i-registries-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := map[string]struct {
+		content       string
+		expected      []api.RegistryRule
+		expectedError string
+	}{
+		"rules": {
+			content: `
+unqualified-search-registries = ["docker.io"]
+
+[[registry]]
+prefix = "docker.io"
+location = "mirror.example.com/dockerhub"
+
+[[registry]]
+prefix = "quay.io/untrusted"
+blocked = true
+
+[[registry]]
+location = "registry.internal:5000"
+insecure = true
+
+[[registry.mirror]]
+location = "ignored.example.com"
+`,
+			expected: []api.RegistryRule{
+				{Prefix: "docker.io", Location: "mirror.example.com/dockerhub"},
+				{Prefix: "quay.io/untrusted", Blocked: true},
+				{Prefix: "registry.internal:5000", Location: "registry.internal:5000", Insecure: true},
+			},
+		},
+		"empty": {
+			expected: []api.RegistryRule{},
+		},
+		"no prefix": {
+			content:       "[[registry]]\nblocked = true\n",
+			expectedError: "the prefix or the location must be set",
+		},
+		"wildcard location": {
+			content:       "[[registry]]\nprefix = \"*.example.com\"\nlocation = \"mirror.example.com\"\n",
+			expectedError: "the location of a wildcard prefix must be empty",
+		},
+		"unqualified prefix": {
+			content:       "[[registry]]\nprefix = \"ruby\"\nblocked = true\n",
+			expectedError: "is not a fully qualified repository or registry",
+		},
+		"tagged location": {
+			content:       "[[registry]]\nprefix = \"docker.io\"\nlocation = \"mirror.example.com/ruby:latest\"\n",
+			expectedError: "is not a fully qualified repository or registry",
+		},
+		"invalid TOML": {
+			content:       "[[registry]\n",
+			expectedError: "unable to read the registries configuration",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.Replace(name, " ", "-", -1)+".conf")
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			rules, err := Load(path)
+			if len(tc.expectedError) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Errorf("Expected an error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(rules, tc.expected) {
+				t.Errorf("Expected the rules %#v, got %#v", tc.expected, rules)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	rules := []api.RegistryRule{
+		{Prefix: "docker.io", Location: "mirror.example.com/dockerhub"},
+		{Prefix: "docker.io/library/ruby", Location: "mirror.example.com/ruby"},
+		{Prefix: "quay.io/untrusted", Blocked: true},
+		{Prefix: "*.internal", Insecure: true},
+	}
+	tests := []struct {
+		name             string
+		expectedLocation string
+		expectedRule     *api.RegistryRule
+	}{
+		{name: "centos/ruby-25-centos7", expectedLocation: "mirror.example.com/dockerhub/centos/ruby-25-centos7:latest", expectedRule: &rules[0]},
+		{name: "docker.io/centos/ruby-25-centos7:2.5", expectedLocation: "mirror.example.com/dockerhub/centos/ruby-25-centos7:2.5", expectedRule: &rules[0]},
+		{name: "ruby:2.7", expectedLocation: "mirror.example.com/ruby:2.7", expectedRule: &rules[1]},
+		{name: "ruby-extra", expectedLocation: "mirror.example.com/dockerhub/library/ruby-extra:latest", expectedRule: &rules[0]},
+		{name: "quay.io/untrusted/image", expectedLocation: "quay.io/untrusted/image", expectedRule: &rules[2]},
+		{name: "quay.io/untrusted-not/image", expectedLocation: "quay.io/untrusted-not/image"},
+		{name: "registry.internal/app", expectedLocation: "registry.internal/app", expectedRule: &rules[3]},
+		{name: "registry.internal:5000/app", expectedLocation: "registry.internal:5000/app"},
+		{name: "example.com/app", expectedLocation: "example.com/app"},
+	}
+	for _, tc := range tests {
+		location, rule := Resolve(rules, tc.name)
+		if location != tc.expectedLocation {
+			t.Errorf("%s: expected the location %q, got %q", tc.name, tc.expectedLocation, location)
+		}
+		if rule != tc.expectedRule {
+			t.Errorf("%s: expected the rule %v, got %v", tc.name, tc.expectedRule, rule)
+		}
+	}
+
+	if location, rule := Resolve(nil, "ruby"); location != "ruby" || rule != nil {
+		t.Errorf("Expected no rewrite without rules, got %q and %v", location, rule)
+	}
+}
@@ -1,7 +1,15 @@
 package status
 
 import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	units "github.com/docker/go-units"
+
 	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/api/constants"
+	s2ierr "github.com/openshift/source-to-image/pkg/errors"
 )
 
 const (
@@ -12,6 +20,58 @@ const (
 	// script failing.
 	ReasonMessageAssembleFailed api.StepFailureMessage = "Assemble script failed."
 
+	// ReasonAssembleOOMKilled is the reason associated with the kernel killing
+	// the Assemble script because its container ran out of memory.
+	ReasonAssembleOOMKilled api.StepFailureReason = "AssembleOOMKilled"
+	// ReasonMessageAssembleOOMKilled is the message associated with the kernel
+	// killing the Assemble script because its container ran out of memory.
+	ReasonMessageAssembleOOMKilled api.StepFailureMessage = "Assemble script was killed because it ran out of memory."
+
+	// ReasonAssembleKilledBySignal is the reason associated with a signal
+	// killing the Assemble script.
+	ReasonAssembleKilledBySignal api.StepFailureReason = "AssembleKilledBySignal"
+	// ReasonMessageAssembleKilledBySignal is the message associated with a
+	// signal killing the Assemble script.
+	ReasonMessageAssembleKilledBySignal api.StepFailureMessage = "Assemble script was killed by a signal."
+
+	// ReasonSaveArtifactsOOMKilled is the reason associated with the kernel
+	// killing the save-artifacts script because its container ran out of memory.
+	ReasonSaveArtifactsOOMKilled api.StepFailureReason = "SaveArtifactsOOMKilled"
+	// ReasonMessageSaveArtifactsOOMKilled is the message associated with the
+	// kernel killing the save-artifacts script because its container ran out
+	// of memory.
+	ReasonMessageSaveArtifactsOOMKilled api.StepFailureMessage = "Save-artifacts script was killed because it ran out of memory."
+
+	// ReasonSaveArtifactsKilledBySignal is the reason associated with a signal
+	// killing the save-artifacts script.
+	ReasonSaveArtifactsKilledBySignal api.StepFailureReason = "SaveArtifactsKilledBySignal"
+	// ReasonMessageSaveArtifactsKilledBySignal is the message associated with a
+	// signal killing the save-artifacts script.
+	ReasonMessageSaveArtifactsKilledBySignal api.StepFailureMessage = "Save-artifacts script was killed by a signal."
+
+	// ReasonAssembleRuntimeFailed is the reason associated with the
+	// assemble-runtime script failing.
+	ReasonAssembleRuntimeFailed api.StepFailureReason = "AssembleRuntimeFailed"
+	// ReasonMessageAssembleRuntimeFailed is the message associated with the
+	// assemble-runtime script failing.
+	ReasonMessageAssembleRuntimeFailed api.StepFailureMessage = "Assemble-runtime script failed."
+
+	// ReasonAssembleRuntimeOOMKilled is the reason associated with the kernel
+	// killing the assemble-runtime script because its container ran out of
+	// memory.
+	ReasonAssembleRuntimeOOMKilled api.StepFailureReason = "AssembleRuntimeOOMKilled"
+	// ReasonMessageAssembleRuntimeOOMKilled is the message associated with the
+	// kernel killing the assemble-runtime script because its container ran out
+	// of memory.
+	ReasonMessageAssembleRuntimeOOMKilled api.StepFailureMessage = "Assemble-runtime script was killed because it ran out of memory."
+
+	// ReasonAssembleRuntimeKilledBySignal is the reason associated with a
+	// signal killing the assemble-runtime script.
+	ReasonAssembleRuntimeKilledBySignal api.StepFailureReason = "AssembleRuntimeKilledBySignal"
+	// ReasonMessageAssembleRuntimeKilledBySignal is the message associated with
+	// a signal killing the assemble-runtime script.
+	ReasonMessageAssembleRuntimeKilledBySignal api.StepFailureMessage = "Assemble-runtime script was killed by a signal."
+
 	// ReasonPullBuilderImageFailed is the reason associated with failing to pull
 	// the builder image.
 	ReasonPullBuilderImageFailed api.StepFailureReason = "PullBuilderImageFailed"
@@ -41,6 +101,13 @@ const (
 	// commit the container to the final image.
 	ReasonMessageCommitContainerFailed api.StepFailureMessage = "Failed to commit container."
 
+	// ReasonVerifyImageFailed is the reason associated with the post-commit
+	// command or the verification URL of the committed image failing.
+	ReasonVerifyImageFailed api.StepFailureReason = "VerifyImageFailed"
+	// ReasonMessageVerifyImageFailed is the message associated with the post-commit
+	// command or the verification URL of the committed image failing.
+	ReasonMessageVerifyImageFailed api.StepFailureMessage = "Verification of the committed image failed."
+
 	// ReasonFetchSourceFailed is the reason associated with failing to download
 	// the source of the build.
 	ReasonFetchSourceFailed api.StepFailureReason = "FetchSourceFailed"
@@ -129,3 +196,48 @@ func NewFailureReason(reason api.StepFailureReason, message api.StepFailureMessa
 		Message: message,
 	}
 }
+
+// scriptKilledReasons holds the failure reasons of the scripts whose container
+// was killed because it ran out of memory, or by a signal.
+var scriptKilledReasons = map[string]struct {
+	oomKilled        api.StepFailureReason
+	oomKilledMessage api.StepFailureMessage
+	bySignal         api.StepFailureReason
+	bySignalMessage  api.StepFailureMessage
+}{
+	constants.Assemble: {
+		ReasonAssembleOOMKilled, ReasonMessageAssembleOOMKilled,
+		ReasonAssembleKilledBySignal, ReasonMessageAssembleKilledBySignal,
+	},
+	constants.SaveArtifacts: {
+		ReasonSaveArtifactsOOMKilled, ReasonMessageSaveArtifactsOOMKilled,
+		ReasonSaveArtifactsKilledBySignal, ReasonMessageSaveArtifactsKilledBySignal,
+	},
+	constants.AssembleRuntime: {
+		ReasonAssembleRuntimeOOMKilled, ReasonMessageAssembleRuntimeOOMKilled,
+		ReasonAssembleRuntimeKilledBySignal, ReasonMessageAssembleRuntimeKilledBySignal,
+	},
+}
+
+// NewScriptFailureReason returns the failure reason of the script whose
+// container failed with err. If the kernel killed the container because it ran
+// out of memory, or a signal killed it, the reason tells so and the message
+// includes the memory limit or the signal. Otherwise the reason and message
+// are returned.
+func NewScriptFailureReason(script string, err error, limits *api.CGroupLimits, reason api.StepFailureReason, message api.StepFailureMessage) api.FailureReason {
+	e, ok := s2ierr.AsContainerError(err)
+	killed, known := scriptKilledReasons[script]
+	switch {
+	case !ok || !known:
+		return NewFailureReason(reason, message)
+	case e.OOMKilled:
+		limit := "no memory limit was set"
+		if limits != nil && limits.MemoryLimitBytes > 0 {
+			limit = "the memory limit is " + units.BytesSize(float64(limits.MemoryLimitBytes))
+		}
+		return NewFailureReason(killed.oomKilled, api.StepFailureMessage(fmt.Sprintf("%s (%s).", strings.TrimSuffix(string(killed.oomKilledMessage), "."), limit)))
+	case e.Signal > 0:
+		return NewFailureReason(killed.bySignal, api.StepFailureMessage(fmt.Sprintf("%s (%d, %s).", strings.TrimSuffix(string(killed.bySignalMessage), "."), e.Signal, syscall.Signal(e.Signal))))
+	}
+	return NewFailureReason(reason, message)
+}
@@ -1,10 +1,13 @@
 package status
 
 import (
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/openshift/source-to-image/pkg/api"
+	"github.com/openshift/source-to-image/pkg/api/constants"
+	s2ierr "github.com/openshift/source-to-image/pkg/errors"
 )
 
 func TestNewFailureReason(t *testing.T) {
@@ -19,6 +22,65 @@ func TestNewFailureReason(t *testing.T) {
 	}
 }
 
+func TestNewScriptFailureReason(t *testing.T) {
+	limits := &api.CGroupLimits{MemoryLimitBytes: 512 * 1024 * 1024}
+	tests := []struct {
+		name     string
+		script   string
+		err      error
+		limits   *api.CGroupLimits
+		expected api.FailureReason
+	}{
+		{
+			name:     "exit code",
+			script:   constants.Assemble,
+			err:      s2ierr.NewContainerStateError("builder", 1, "", false),
+			limits:   limits,
+			expected: NewFailureReason(ReasonAssembleFailed, ReasonMessageAssembleFailed),
+		},
+		{
+			name:     "oom killed",
+			script:   constants.Assemble,
+			err:      s2ierr.NewContainerStateError("builder", 137, "", true),
+			limits:   limits,
+			expected: NewFailureReason(ReasonAssembleOOMKilled, "Assemble script was killed because it ran out of memory (the memory limit is 512MiB)."),
+		},
+		{
+			name:     "oom killed without limit",
+			script:   constants.AssembleRuntime,
+			err:      s2ierr.NewContainerStateError("runtime", 137, "", true),
+			expected: NewFailureReason(ReasonAssembleRuntimeOOMKilled, "Assemble-runtime script was killed because it ran out of memory (no memory limit was set)."),
+		},
+		{
+			name:     "killed by signal",
+			script:   constants.Assemble,
+			err:      s2ierr.NewContainerStateError("builder", 143, "", false),
+			limits:   limits,
+			expected: NewFailureReason(ReasonAssembleKilledBySignal, "Assemble script was killed by a signal (15, terminated)."),
+		},
+		{
+			name:     "wrapped save-artifacts error",
+			script:   constants.SaveArtifacts,
+			err:      s2ierr.NewSaveArtifactsError("image", "", s2ierr.NewContainerStateError("image", 137, "", true)),
+			limits:   limits,
+			expected: NewFailureReason(ReasonSaveArtifactsOOMKilled, "Save-artifacts script was killed because it ran out of memory (the memory limit is 512MiB)."),
+		},
+		{
+			name:     "other error",
+			script:   constants.Assemble,
+			err:      errors.New("connection refused"),
+			limits:   limits,
+			expected: NewFailureReason(ReasonAssembleFailed, ReasonMessageAssembleFailed),
+		},
+	}
+	for _, tc := range tests {
+		got := NewScriptFailureReason(tc.script, tc.err, tc.limits, ReasonAssembleFailed, ReasonMessageAssembleFailed)
+		if got != tc.expected {
+			t.Errorf("%s: expected %#v, got %#v", tc.name, tc.expected, got)
+		}
+	}
+}
+
 func TestAddNewStage(t *testing.T) {
 	buildInfo := new(api.BuildInfo)
 
@@ -75,3 +137,27 @@ func TestUpdateStageDuration(t *testing.T) {
 	}
 
 }
+
+func TestRecordStageResourceUsage(t *testing.T) {
+	buildInfo := new(api.BuildInfo)
+
+	buildInfo.Stages = api.RecordStageResourceUsage(buildInfo.Stages, api.StageAssemble, api.ResourceUsage{PeakMemoryBytes: 2048, CPUTimeNanoseconds: 1000})
+	if len(buildInfo.Stages) != 1 || buildInfo.Stages[0].ResourceUsage == nil {
+		t.Fatalf("Stage with resource usage not added, got %#v", buildInfo.Stages)
+	}
+
+	startTime := time.Now()
+	buildInfo.Stages = api.RecordStageAndStepInfo(buildInfo.Stages, api.StageAssemble, api.StepAssembleBuildScripts, startTime, time.Now())
+	buildInfo.Stages = api.RecordStageResourceUsage(buildInfo.Stages, api.StageAssemble, api.ResourceUsage{PeakMemoryBytes: 1024, CPUTimeNanoseconds: 500})
+
+	if len(buildInfo.Stages) != 1 {
+		t.Fatalf("Stages should be 1 but was %v instead.", len(buildInfo.Stages))
+	}
+	if !buildInfo.Stages[0].StartTime.Equal(startTime) {
+		t.Errorf("Stage start time was not set, expected %v, got %v", startTime, buildInfo.Stages[0].StartTime)
+	}
+	expected := api.ResourceUsage{PeakMemoryBytes: 2048, CPUTimeNanoseconds: 1500}
+	if *buildInfo.Stages[0].ResourceUsage != expected {
+		t.Errorf("Resource usage was not added, expected %#v, got %#v", expected, *buildInfo.Stages[0].ResourceUsage)
+	}
+}